@@ -0,0 +1,215 @@
+package sonic
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/talostrading/sonic/internal"
+)
+
+// Cmd is a child process whose standard streams are driven by an IO.
+//
+// The streams are non-blocking pipes. Stdin, Stdout and Stderr return their
+// ends once the process is started, unless the corresponding field of the
+// underlying exec.Cmd was set before Start, in which case the child uses
+// that instead.
+//
+// The exit of the process is reported by AsyncWait. On Linux it is detected
+// by waiting on a pidfd with the IO. On other platforms, or on Linux kernels
+// older than 5.3, a goroutine waits for the process and posts its exit to the
+// IO.
+type Cmd struct {
+	// Cmd is the underlying command. Fields like Env and Dir can be set
+	// before Start. Its Wait method must not be used.
+	Cmd *exec.Cmd
+
+	ioc    *IO
+	stdin  *file
+	stdout *file
+	stderr *file
+
+	pidfd     int
+	pidfdSlot *internal.Slot // set while AsyncWait waits on the pidfd
+	waitCb    func(*os.ProcessState, error)
+}
+
+// Command returns a Cmd to execute the named program with the given
+// arguments, like exec.Command.
+func Command(ioc *IO, name string, args ...string) *Cmd {
+	return &Cmd{
+		Cmd:   exec.Command(name, args...),
+		ioc:   ioc,
+		pidfd: -1,
+	}
+}
+
+// Start starts the process.
+func (c *Cmd) Start() error {
+	if c.Cmd.Process != nil {
+		return errors.New("sonic: process already started")
+	}
+
+	// The ends of the pipes used by the child. They are closed in the parent
+	// once the child is started.
+	var childFiles []*os.File
+	defer func() {
+		for _, f := range childFiles {
+			_ = f.Close()
+		}
+	}()
+
+	fail := func(err error) error {
+		c.closeStreams()
+		return err
+	}
+
+	if c.Cmd.Stdin == nil {
+		r, w, err := nonblockingPipe(false, true)
+		if err != nil {
+			return fail(err)
+		}
+		f := os.NewFile(uintptr(r), "|0")
+		childFiles = append(childFiles, f)
+		c.Cmd.Stdin = f
+		c.stdin = newFile(c.ioc, w)
+	}
+	if c.Cmd.Stdout == nil {
+		r, w, err := nonblockingPipe(true, false)
+		if err != nil {
+			return fail(err)
+		}
+		f := os.NewFile(uintptr(w), "|1")
+		childFiles = append(childFiles, f)
+		c.Cmd.Stdout = f
+		c.stdout = newFile(c.ioc, r)
+	}
+	if c.Cmd.Stderr == nil {
+		r, w, err := nonblockingPipe(true, false)
+		if err != nil {
+			return fail(err)
+		}
+		f := os.NewFile(uintptr(w), "|2")
+		childFiles = append(childFiles, f)
+		c.Cmd.Stderr = f
+		c.stderr = newFile(c.ioc, r)
+	}
+
+	if err := c.Cmd.Start(); err != nil {
+		return fail(err)
+	}
+
+	// If this fails, exits are waited for with a goroutine.
+	c.pidfd, _ = pidfdOpen(c.Cmd.Process.Pid)
+	return nil
+}
+
+// Stdin returns the stream connected to the standard input of the process.
+// Closing it signals the end of the input to the process.
+func (c *Cmd) Stdin() AsyncWriteStream {
+	if c.stdin == nil {
+		return nil
+	}
+	return c.stdin
+}
+
+// Stdout returns the stream connected to the standard output of the process.
+// It reads io.EOF once the process closed its output.
+func (c *Cmd) Stdout() AsyncReadStream {
+	if c.stdout == nil {
+		return nil
+	}
+	return c.stdout
+}
+
+// Stderr returns the stream connected to the standard error of the process.
+// It reads io.EOF once the process closed its standard error.
+func (c *Cmd) Stderr() AsyncReadStream {
+	if c.stderr == nil {
+		return nil
+	}
+	return c.stderr
+}
+
+// AsyncWait invokes the callback once the process exits, with its state. The
+// error is an *exec.ExitError if the process did not exit successfully, as
+// with exec.Cmd.Wait. The streams are left open, so that what the process
+// wrote before exiting can still be read; Close closes them.
+func (c *Cmd) AsyncWait(cb func(state *os.ProcessState, err error)) {
+	if c.Cmd.Process == nil {
+		cb(nil, errors.New("sonic: process not started"))
+		return
+	}
+	if c.waitCb != nil {
+		cb(nil, errors.New("sonic: AsyncWait already called"))
+		return
+	}
+	c.waitCb = cb
+
+	if c.pidfd >= 0 {
+		if err := c.waitPidfd(); err == nil {
+			return
+		}
+		_ = syscall.Close(c.pidfd)
+		c.pidfd = -1
+	}
+	c.waitInGoroutine()
+}
+
+// waitInGoroutine waits for the process in a goroutine which posts its exit to
+// the IO.
+func (c *Cmd) waitInGoroutine() {
+	go func() {
+		err := c.Cmd.Wait()
+		_ = c.ioc.Post(func() {
+			c.exited(err)
+		})
+	}()
+}
+
+func (c *Cmd) exited(err error) {
+	cb := c.waitCb
+	c.waitCb = nil
+	cb(c.Cmd.ProcessState, err)
+}
+
+// Signal sends a signal to the process.
+func (c *Cmd) Signal(sig os.Signal) error {
+	if c.Cmd.Process == nil {
+		return errors.New("sonic: process not started")
+	}
+	return c.Cmd.Process.Signal(sig)
+}
+
+// Close closes the streams of the process. It does not kill the process, whose
+// exit is still reported by a pending AsyncWait.
+func (c *Cmd) Close() error {
+	c.closeStreams()
+	c.closePidfd()
+	return nil
+}
+
+// closePidfd closes the pidfd. If AsyncWait waits on it, the process is waited
+// for by a goroutine instead.
+func (c *Cmd) closePidfd() {
+	if c.pidfd < 0 {
+		return
+	}
+	if slot := c.pidfdSlot; slot != nil {
+		c.pidfdSlot = nil
+		_ = c.ioc.UnsetRead(slot)
+		c.ioc.Deregister(slot)
+		c.waitInGoroutine()
+	}
+	_ = syscall.Close(c.pidfd)
+	c.pidfd = -1
+}
+
+func (c *Cmd) closeStreams() {
+	for _, f := range []*file{c.stdin, c.stdout, c.stderr} {
+		if f != nil {
+			_ = f.Close()
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package sonic
+
+import "errors"
+
+var errPidfdNotSupported = errors.New("pidfd is not supported on BSD")
+
+func pidfdOpen(pid int) (int, error) {
+	return -1, errPidfdNotSupported
+}
+
+func (c *Cmd) waitPidfd() error {
+	return errPidfdNotSupported
+}
@@ -0,0 +1,42 @@
+//go:build linux
+
+package sonic
+
+import (
+	"syscall"
+
+	"github.com/talostrading/sonic/internal"
+	"golang.org/x/sys/unix"
+)
+
+func pidfdOpen(pid int) (int, error) {
+	return unix.PidfdOpen(pid, 0)
+}
+
+// waitPidfd waits for the pidfd to become readable, which it does once the
+// process exits, and then reaps the process. If waiting fails, the process is
+// waited for by a goroutine instead.
+func (c *Cmd) waitPidfd() error {
+	slot := &internal.Slot{Fd: c.pidfd}
+	slot.Set(internal.ReadEvent, func(err error) {
+		c.ioc.Deregister(slot)
+		c.pidfdSlot = nil
+		_ = syscall.Close(c.pidfd)
+		c.pidfd = -1
+
+		if err != nil {
+			// The process must still be reaped, whether it exited or not.
+			c.waitInGoroutine()
+			return
+		}
+		// The process exited, so this does not block.
+		c.exited(c.Cmd.Wait())
+	})
+
+	if err := c.ioc.SetRead(slot); err != nil {
+		return err
+	}
+	c.ioc.Register(slot)
+	c.pidfdSlot = slot
+	return nil
+}
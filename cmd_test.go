@@ -0,0 +1,192 @@
+package sonic
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/talostrading/sonic/internal"
+)
+
+// readAll reads s until io.EOF, sending what was read to the returned channel.
+func readAll(s AsyncReadStream) <-chan string {
+	ch := make(chan string, 1)
+	var out []byte
+	b := make([]byte, 128)
+	var onRead AsyncCallback
+	onRead = func(err error, n int) {
+		out = append(out, b[:n]...)
+		if err != nil {
+			ch <- string(out)
+			return
+		}
+		s.AsyncRead(b, onRead)
+	}
+	s.AsyncRead(b, onRead)
+	return ch
+}
+
+func TestCommandStdio(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	cmd := Command(ioc, "cat")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Close()
+
+	stdout := readAll(cmd.Stdout())
+	cmd.Stdin().AsyncWriteAll([]byte("hello"), func(err error, _ int) {
+		if err != nil {
+			t.Error(err)
+		}
+		_ = cmd.Stdin().Close()
+	})
+
+	if out := runUntilRecv(t, ioc, stdout); out != "hello" {
+		t.Fatalf("expected=hello got=%s", out)
+	}
+
+	exited := make(chan error, 1)
+	cmd.AsyncWait(func(state *os.ProcessState, err error) {
+		if err == nil && !state.Success() {
+			t.Error("expected the process to succeed")
+		}
+		exited <- err
+	})
+	if err := runUntilRecv(t, ioc, exited); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCommandExitCode(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	cmd := Command(ioc, "sh", "-c", "echo oops >&2; exit 3")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Close()
+
+	stderr := readAll(cmd.Stderr())
+
+	exited := make(chan error, 1)
+	cmd.AsyncWait(func(state *os.ProcessState, err error) {
+		exited <- err
+	})
+	err := runUntilRecv(t, ioc, exited)
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 {
+		t.Fatalf("expected exit code 3 got=%v", err)
+	}
+
+	if out := runUntilRecv(t, ioc, stderr); out != "oops\n" {
+		t.Fatalf("expected=oops got=%q", out)
+	}
+}
+
+func TestCommandStdoutEOF(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	cmd := Command(ioc, "true")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Close()
+
+	b := make([]byte, 1)
+	done := make(chan error, 1)
+	cmd.Stdout().AsyncRead(b, func(err error, _ int) {
+		done <- err
+	})
+	if err := runUntilRecv(t, ioc, done); err != io.EOF {
+		t.Fatalf("expected io.EOF got=%v", err)
+	}
+
+	exited := make(chan error, 1)
+	cmd.AsyncWait(func(_ *os.ProcessState, err error) {
+		exited <- err
+	})
+	if err := runUntilRecv(t, ioc, exited); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCommandCloseWhileWaiting(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	// cat exits once its input is closed by Close.
+	cmd := Command(ioc, "cat")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	exited := make(chan error, 1)
+	cmd.AsyncWait(func(_ *os.ProcessState, err error) {
+		exited <- err
+	})
+
+	pidfd := cmd.pidfd
+	if err := cmd.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.pidfd != -1 || cmd.pidfdSlot != nil {
+		t.Fatal("expected the pidfd to be closed")
+	}
+	if pidfd >= 0 {
+		var stat syscall.Stat_t
+		if err := syscall.Fstat(pidfd, &stat); err != syscall.EBADF {
+			t.Fatalf("expected the pidfd to be closed got=%v", err)
+		}
+		if ioc.pending.static[pidfd] != nil {
+			t.Fatal("expected the pidfd's slot to be deregistered")
+		}
+	}
+
+	// The exit is still reported.
+	if err := runUntilRecv(t, ioc, exited); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCommandPidfdError(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	cmd := Command(ioc, "true")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Close()
+
+	type exit struct {
+		state *os.ProcessState
+		err   error
+	}
+	exited := make(chan exit, 1)
+	cmd.AsyncWait(func(state *os.ProcessState, err error) {
+		exited <- exit{state, err}
+	})
+
+	slot := cmd.pidfdSlot
+	if slot == nil {
+		t.Skip("pidfd is not supported")
+	}
+
+	// An error while waiting on the pidfd does not leave the process unreaped.
+	_ = ioc.UnsetRead(slot)
+	slot.Handlers[internal.ReadEvent](syscall.EIO)
+
+	e := runUntilRecv(t, ioc, exited)
+	if e.err != nil {
+		t.Fatal(e.err)
+	}
+	if e.state == nil || !e.state.Exited() {
+		t.Fatal("expected the process to be reaped")
+	}
+}
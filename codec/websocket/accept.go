@@ -0,0 +1,293 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// handshakeReadSize is the number of bytes reserved in src for each read of an
+// upgrade request or response.
+const handshakeReadSize = 4096
+
+var headTerminator = []byte("\r\n\r\n")
+
+// Accept performs the server handshake on the supplied connection, which is usually returned by a sonic.Listener. The
+// upgrade request is read and validated, and the stream then reads and writes frames on the connection.
+//
+// The call blocks until one of the following conditions is true:
+//   - the upgrade request is received and the 101 response is sent
+//   - an error occurs
+//
+// The reads and writes on a non-blocking connection are retried until they complete, so AsyncAccept should be
+// preferred. An invalid or rejected upgrade request is replied to with the corresponding HTTP error before Accept
+// returns the error. The connection is not closed on error.
+func (s *Stream) Accept(conn sonic.Conn) error {
+	if s.role != RoleServer {
+		return ErrWrongHandshakeRole
+	}
+
+	s.reset()
+
+	n, err := s.readHead(conn)
+	if err == nil || errors.Is(err, ErrHandshakeTooLarge) {
+		err = s.prepareAccept(n, err)
+		if werr := writeHandshake(conn, s.handshakeBuffer); err == nil {
+			err = werr
+		}
+	}
+	return s.accepted(conn, err)
+}
+
+// AsyncAccept performs the server handshake on the supplied connection asynchronously. See Accept.
+//
+// This call does not block. The provided callback is called when the upgrade request is received and the response
+// is sent, or when an error occurs.
+func (s *Stream) AsyncAccept(conn sonic.Conn, callback func(error)) {
+	if s.role != RoleServer {
+		callback(ErrWrongHandshakeRole)
+		return
+	}
+
+	s.reset()
+
+	s.asyncReadHead(conn, func(err error, n int) {
+		if err != nil && !errors.Is(err, ErrHandshakeTooLarge) {
+			callback(s.accepted(conn, err))
+			return
+		}
+
+		err = s.prepareAccept(n, err)
+		conn.AsyncWriteAll(s.handshakeBuffer, func(werr error, _ int) {
+			if err == nil {
+				err = werr
+			}
+			callback(s.accepted(conn, err))
+		})
+	})
+}
+
+func (s *Stream) accepted(conn sonic.Conn, err error) error {
+	s.handshakeBuffer = s.handshakeBuffer[:0]
+
+	if err != nil {
+		s.state = StateTerminated
+		return err
+	}
+
+	s.conn = conn
+	s.state = StateActive
+	return s.init(conn)
+}
+
+// readHead reads from the stream into src until src starts with the head of an HTTP message, i.e. its start line and
+// headers, and returns the length of the head. The reads which would block are retried.
+func (s *Stream) readHead(stream sonic.Stream) (n int, err error) {
+	for {
+		n, err = s.headLength()
+		if n > 0 || err != nil {
+			return n, err
+		}
+
+		s.src.Reserve(handshakeReadSize)
+		_, err = s.src.ReadFrom(stream)
+		if err == nil {
+			s.src.Commit(s.src.WriteLen())
+		} else if err != sonicerrors.ErrWouldBlock {
+			return 0, err
+		}
+	}
+}
+
+// asyncReadHead is the asynchronous version of readHead.
+func (s *Stream) asyncReadHead(stream sonic.Stream, callback func(err error, n int)) {
+	if n, err := s.headLength(); n > 0 || err != nil {
+		callback(err, n)
+		return
+	}
+
+	s.src.Reserve(handshakeReadSize)
+	s.src.AsyncReadFrom(stream, func(err error, _ int) {
+		if err != nil {
+			callback(err, 0)
+			return
+		}
+		s.src.Commit(s.src.WriteLen())
+		s.asyncReadHead(stream, callback)
+	})
+}
+
+// headLength returns the length of the head of the HTTP message at the start of src, or 0 if it is not fully read
+// yet.
+func (s *Stream) headLength() (int, error) {
+	b := s.src.Data()
+	if i := bytes.Index(b, headTerminator); i >= 0 {
+		n := i + len(headTerminator)
+		if n > MaxHandshakeSize {
+			return 0, ErrHandshakeTooLarge
+		}
+		return n, nil
+	}
+	if len(b) >= MaxHandshakeSize {
+		return 0, ErrHandshakeTooLarge
+	}
+	return 0, nil
+}
+
+// prepareAccept parses and validates the upgrade request whose head is the first n bytes of src, and prepares the
+// response in handshakeBuffer. The bytes following the request are left in src, to be decoded as frames.
+//
+// err is the error which occurred while reading the request, if any. The returned error is non-nil if the request is
+// rejected, in which case the response is an HTTP error.
+func (s *Stream) prepareAccept(n int, err error) error {
+	if err != nil {
+		s.prepareRejection(http.StatusRequestHeaderFieldsTooLarge, nil)
+		return err
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(s.src.Data()[:n])))
+	s.src.Consume(n)
+	if err != nil {
+		s.prepareRejection(http.StatusBadRequest, nil)
+		return fmt.Errorf("%w: %v", ErrCannotUpgrade, err)
+	}
+
+	if s.upgradeRequestCallback != nil {
+		s.upgradeRequestCallback(req)
+	}
+
+	if status, err := s.validateUpgradeRequest(req); err != nil {
+		var header http.Header
+		if status == http.StatusUpgradeRequired {
+			header = http.Header{"Sec-WebSocket-Version": {"13"}}
+		}
+		s.prepareRejection(status, header)
+		return err
+	}
+
+	res := &http.Response{
+		Status:     "101 Switching Protocols",
+		StatusCode: http.StatusSwitchingProtocols,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+	res.Header.Set("Upgrade", "websocket")
+	res.Header.Set("Connection", "Upgrade")
+	res.Header.Set("Sec-WebSocket-Accept", MakeResponseKey([]byte(req.Header.Get("Sec-WebSocket-Key"))))
+
+	if offered := headerTokens(req.Header, "Sec-WebSocket-Protocol"); len(offered) > 0 && s.subprotocolCallback != nil {
+		if selected := s.subprotocolCallback(offered); slices.Contains(offered, selected) {
+			s.subprotocol = selected
+			res.Header.Set("Sec-WebSocket-Protocol", selected)
+		}
+	}
+
+	if s.deflateOptions != nil {
+		if params, extension, ok := acceptDeflateOffer(s.deflateOptions, req.Header); ok {
+			s.deflate.init(s.role, params, s.deflateOptions)
+			res.Header.Set("Sec-WebSocket-Extensions", extension)
+		}
+	}
+
+	if s.upgradeResponseCallback != nil {
+		s.upgradeResponseCallback(res)
+	}
+
+	s.prepareResponse(res.StatusCode, res.Header)
+	return nil
+}
+
+// validateUpgradeRequest checks the upgrade request as mandated by RFC 6455 section 4.2.1 and with the user provided
+// callbacks. It returns the status with which to reject the request along with the error, if the request is invalid.
+func (s *Stream) validateUpgradeRequest(req *http.Request) (status int, err error) {
+	if req.Method != http.MethodGet {
+		return http.StatusMethodNotAllowed, fmt.Errorf("%w: method %s", ErrCannotUpgrade, req.Method)
+	}
+
+	if !req.ProtoAtLeast(1, 1) {
+		return http.StatusBadRequest, fmt.Errorf("%w: protocol %s", ErrCannotUpgrade, req.Proto)
+	}
+
+	if !IsUpgradeReq(req) || !slices.ContainsFunc(headerTokens(req.Header, "Connection"), func(token string) bool {
+		return strings.EqualFold(token, "upgrade")
+	}) {
+		return http.StatusBadRequest, fmt.Errorf("%w: not a websocket upgrade", ErrCannotUpgrade)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(req.Header.Get("Sec-WebSocket-Key"))
+	if err != nil || len(key) != 16 {
+		return http.StatusBadRequest, fmt.Errorf("%w: invalid Sec-WebSocket-Key", ErrCannotUpgrade)
+	}
+
+	if version := req.Header.Get("Sec-WebSocket-Version"); version != "13" {
+		return http.StatusUpgradeRequired, fmt.Errorf("%w: unsupported version %q", ErrCannotUpgrade, version)
+	}
+
+	if s.originCallback != nil {
+		if origin := req.Header.Get("Origin"); !s.originCallback(origin) {
+			return http.StatusForbidden, fmt.Errorf("%w: origin %q not allowed", ErrCannotUpgrade, origin)
+		}
+	}
+
+	if s.acceptCallback != nil {
+		if err := s.acceptCallback(req); err != nil {
+			return http.StatusForbidden, err
+		}
+	}
+
+	return http.StatusSwitchingProtocols, nil
+}
+
+func (s *Stream) prepareRejection(status int, header http.Header) {
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set("Connection", "close")
+	header.Set("Content-Length", "0")
+	s.prepareResponse(status, header)
+}
+
+func (s *Stream) prepareResponse(status int, header http.Header) {
+	b := bytes.NewBuffer(s.handshakeBuffer[:0])
+	fmt.Fprintf(b, "HTTP/1.1 %03d %s\r\n", status, http.StatusText(status))
+	_ = header.Write(b)
+	b.WriteString("\r\n")
+	s.handshakeBuffer = b.Bytes()
+}
+
+// writeHandshake writes b to the stream, retrying the writes which would block.
+func writeHandshake(stream sonic.Stream, b []byte) error {
+	for len(b) > 0 {
+		n, err := stream.Write(b)
+		if err != nil && err != sonicerrors.ErrWouldBlock {
+			return err
+		}
+		if n > 0 {
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+// headerTokens returns the comma separated tokens of all the values of a header.
+func headerTokens(header http.Header, key string) (tokens []string) {
+	for _, value := range header.Values(key) {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,453 @@
+package websocket
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+// The example key from RFC 6455 section 1.3 and the server's response to it.
+const (
+	testRequestKey  = "dGhlIHNhbXBsZSBub25jZQ=="
+	testResponseKey = "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+)
+
+func testUpgradeRequest(extraHeaders string) string {
+	return "GET /stream HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + testRequestKey + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		extraHeaders +
+		"\r\n"
+}
+
+func pollUntil(t *testing.T, ioc *sonic.IO, done func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		_, _ = ioc.PollOne()
+	}
+}
+
+// acceptOne accepts a connection on a new listener and performs the server handshake on it with srv, asynchronously.
+// It returns the address of the listener.
+func acceptOne(t *testing.T, ioc *sonic.IO, srv *Stream, callback func(error)) string {
+	ln, err := sonic.Listen(ioc, "tcp", "localhost:0", sonicopts.Nonblocking(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	ln.AsyncAccept(func(err error, conn sonic.Conn) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+		srv.AsyncAccept(conn, callback)
+	})
+	return listenerAddr(t, ln)
+}
+
+// listenerAddr returns the address to dial a listener bound to an ephemeral port.
+func listenerAddr(t *testing.T, ln sonic.Listener) string {
+	addr, err := internal.SocketAddress(ln.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr.String()
+}
+
+func TestServerAsyncAcceptEcho(t *testing.T) {
+	assert := assert.New(t)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	srv, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetSubprotocolCallback(func(offered []string) string {
+		assert.Equal([]string{"chat", "superchat"}, offered)
+		return "superchat"
+	})
+
+	b := make([]byte, 128)
+	addr := acceptOne(t, ioc, srv, func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertState(t, srv, StateActive)
+		assert.Equal("superchat", srv.Subprotocol())
+
+		srv.AsyncNextMessage(b, func(err error, n int, messageType MessageType) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv.AsyncWrite(b[:n], messageType, func(err error) {
+				if err != nil {
+					t.Fatal(err)
+				}
+			})
+		})
+	})
+
+	clientDone := make(chan error, 1)
+	go func() {
+		clientDone <- func() error {
+			cioc := sonic.MustIO()
+			defer cioc.Close()
+
+			client, err := NewWebsocketStream(cioc, nil, RoleClient)
+			if err != nil {
+				return err
+			}
+			defer client.CloseNextLayer()
+
+			err = client.Handshake(
+				fmt.Sprintf("ws://%s", addr),
+				ExtraHeader(true, "Sec-WebSocket-Protocol", "chat, superchat"),
+			)
+			if err != nil {
+				return err
+			}
+			if client.Subprotocol() != "superchat" {
+				return fmt.Errorf("wrong subprotocol %q", client.Subprotocol())
+			}
+
+			if err := client.Write([]byte("hello"), TypeText); err != nil {
+				return err
+			}
+
+			b := make([]byte, 128)
+			messageType, n, err := client.NextMessage(b)
+			if err != nil {
+				return err
+			}
+			if messageType != TypeText || string(b[:n]) != "hello" {
+				return fmt.Errorf("wrong echo type=%s payload=%q", messageType, b[:n])
+			}
+			return nil
+		}()
+	}()
+
+	var clientErr error
+	pollUntil(t, ioc, func() bool {
+		select {
+		case clientErr = <-clientDone:
+			return true
+		default:
+			return false
+		}
+	})
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+}
+
+func TestServerAcceptRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+		status  int
+		header  string
+	}{
+		{
+			name:    "method",
+			request: "POST /stream HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n",
+			status:  http.StatusMethodNotAllowed,
+		},
+		{
+			name:    "not an upgrade",
+			request: "GET /stream HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name: "key",
+			request: "GET /stream HTTP/1.1\r\n" +
+				"Host: localhost\r\n" +
+				"Upgrade: websocket\r\n" +
+				"Connection: Upgrade\r\n" +
+				"Sec-WebSocket-Key: c2hvcnQ=\r\n" +
+				"Sec-WebSocket-Version: 13\r\n\r\n",
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "version",
+			request: "GET /stream HTTP/1.1\r\n" +
+				"Host: localhost\r\n" +
+				"Upgrade: websocket\r\n" +
+				"Connection: Upgrade\r\n" +
+				"Sec-WebSocket-Key: " + testRequestKey + "\r\n" +
+				"Sec-WebSocket-Version: 8\r\n\r\n",
+			status: http.StatusUpgradeRequired,
+			header: "Sec-WebSocket-Version",
+		},
+		{
+			name:    "origin",
+			request: testUpgradeRequest("Origin: https://elsewhere.example\r\n"),
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "accept callback",
+			request: testUpgradeRequest("Authorization: Bearer nope\r\n"),
+			status:  http.StatusForbidden,
+		},
+	}
+
+	errUnauthorized := errors.New("unauthorized")
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ioc := sonic.MustIO()
+			defer ioc.Close()
+
+			srv, err := NewWebsocketStream(ioc, nil, RoleServer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv.SetOriginCallback(func(origin string) bool {
+				return origin == "" || origin == "https://localhost"
+			})
+			srv.SetAcceptCallback(func(req *http.Request) error {
+				if req.Header.Get("Authorization") != "" {
+					return errUnauthorized
+				}
+				return nil
+			})
+
+			var (
+				done      bool
+				acceptErr error
+			)
+			addr := acceptOne(t, ioc, srv, func(err error) {
+				acceptErr = err
+				done = true
+			})
+
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err := io.WriteString(conn, test.request); err != nil {
+				t.Fatal(err)
+			}
+
+			pollUntil(t, ioc, func() bool { return done })
+			if acceptErr == nil {
+				t.Fatal("expected the request to be rejected")
+			}
+			if test.name == "accept callback" && acceptErr != errUnauthorized {
+				t.Fatalf("expected the accept callback's error got=%v", acceptErr)
+			}
+			assertState(t, srv, StateTerminated)
+
+			res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != test.status {
+				t.Fatalf("expected status %d got %d", test.status, res.StatusCode)
+			}
+			if test.header != "" && res.Header.Get(test.header) == "" {
+				t.Fatalf("expected the %s header in the response", test.header)
+			}
+		})
+	}
+}
+
+func TestServerAcceptFragmentedRequest(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	addr := listenerAddr(t, ln)
+
+	// The request is sent in pieces, and a frame is sent right after it.
+	clientDone := make(chan error, 1)
+	go func() {
+		clientDone <- func() error {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+
+			f := NewFrame()
+			f.SetFIN().SetText().SetIsMasked()
+			f.SetPayload([]byte("hello"))
+			f.MaskPayload()
+
+			req := testUpgradeRequest("")
+			pieces := []string{req[:10], req[10:50], req[50:] + string(f)}
+			for _, piece := range pieces {
+				if _, err := io.WriteString(conn, piece); err != nil {
+					return err
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			rd := bufio.NewReader(conn)
+			res, err := http.ReadResponse(rd, nil)
+			if err != nil {
+				return err
+			}
+			if res.StatusCode != http.StatusSwitchingProtocols {
+				return fmt.Errorf("wrong status %d", res.StatusCode)
+			}
+			if key := res.Header.Get("Sec-WebSocket-Accept"); key != testResponseKey {
+				return fmt.Errorf("wrong accept key %q", key)
+			}
+
+			// Both the frames written by the server are unmasked, including the one acquired masked.
+			for _, expected := range []string{"world", "again"} {
+				f := NewFrame()
+				if _, err := f.ReadFrom(rd); err != nil {
+					return err
+				}
+				if f.IsMasked() {
+					return fmt.Errorf("the server sent a masked frame")
+				}
+				if string(f.Payload()) != expected {
+					return fmt.Errorf("wrong payload %q expected %q", f.Payload(), expected)
+				}
+			}
+			return nil
+		}()
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	srv, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Accept(conn); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, srv, StateActive)
+
+	done := false
+	b := make([]byte, 128)
+	srv.AsyncNextMessage(b, func(err error, n int, messageType MessageType) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if messageType != TypeText || string(b[:n]) != "hello" {
+			t.Fatalf("wrong message type=%s payload=%q", messageType, b[:n])
+		}
+
+		if err := srv.Write([]byte("world"), TypeText); err != nil {
+			t.Fatal(err)
+		}
+
+		f := srv.AcquireFrame()
+		f.SetFIN().SetText().SetIsMasked()
+		f.SetPayload([]byte("again"))
+		if err := srv.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+		done = true
+	})
+	pollUntil(t, ioc, func() bool { return done })
+
+	if err := <-clientDone; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerUnmaskedFrameFromClient(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	srv, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		done    bool
+		readErr error
+	)
+	addr := acceptOne(t, ioc, srv, func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.AsyncNextFrame(func(err error, _ Frame) {
+			readErr = err
+			_ = srv.Flush()
+			done = true
+		})
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	f := NewFrame()
+	f.SetFIN().SetText().SetPayload([]byte("hello"))
+	if _, err := io.WriteString(conn, testUpgradeRequest("")+string(f)); err != nil {
+		t.Fatal(err)
+	}
+
+	pollUntil(t, ioc, func() bool { return done })
+	if readErr != ErrUnmaskedFramesFromClient {
+		t.Fatalf("expected ErrUnmaskedFramesFromClient got=%v", readErr)
+	}
+
+	rd := bufio.NewReader(conn)
+	if _, err := http.ReadResponse(rd, nil); err != nil {
+		t.Fatal(err)
+	}
+	closeFrame := NewFrame()
+	if _, err := closeFrame.ReadFrom(rd); err != nil {
+		t.Fatal(err)
+	}
+	if !closeFrame.Opcode().IsClose() || closeFrame.IsMasked() {
+		t.Fatal("expected an unmasked close frame")
+	}
+	if cc, _ := DecodeCloseFramePayload(closeFrame.Payload()); cc != CloseProtocolError {
+		t.Fatalf("expected close code %d got %d", CloseProtocolError, cc)
+	}
+}
+
+func TestClientCannotAccept(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Accept(nil); err != ErrWrongHandshakeRole {
+		t.Fatalf("expected ErrWrongHandshakeRole got=%v", err)
+	}
+}
@@ -9,6 +9,10 @@ const (
 	DefaultMaxMessageSize = 1024 * 512
 	CloseTimeout          = 5 * time.Second
 	DialTimeout           = 5 * time.Second
+
+	// MaxHandshakeSize is the maximum size of the HTTP request line or status
+	// line and headers of an upgrade request or response.
+	MaxHandshakeSize = 64 * 1024
 )
 
 type Role uint8
@@ -23,7 +27,7 @@ func (r Role) String() string {
 	case RoleClient:
 		return "role_client"
 	case RoleServer:
-		return "role_client"
+		return "role_server"
 	default:
 		return "role_unknown"
 	}
@@ -105,6 +109,19 @@ type ControlCallback = func(messageType MessageType, payload []byte)
 type UpgradeRequestCallback = func(req *http.Request)
 type UpgradeResponseCallback = func(res *http.Response)
 
+// OriginCallback reports whether a server accepts an upgrade request with the
+// given Origin header, which is empty if the request has none.
+type OriginCallback = func(origin string) bool
+
+// SubprotocolCallback returns the subprotocol a server selects among those
+// offered by the client in the Sec-WebSocket-Protocol header, or an empty
+// string to select none.
+type SubprotocolCallback = func(offered []string) string
+
+// AcceptCallback is invoked by a server with an upgrade request which passed
+// the protocol checks. A non-nil error rejects it.
+type AcceptCallback = func(req *http.Request) error
+
 type Header struct {
 	Key          string
 	Values       []string
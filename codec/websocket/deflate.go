@@ -0,0 +1,394 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The name of the permessage-deflate extension defined in RFC 7692.
+const deflateExtension = "permessage-deflate"
+
+const (
+	minDeflateWindowBits = 8
+	maxDeflateWindowBits = 15
+
+	// deflateWindowSize is the size of the largest LZ77 window, which is kept between messages to decompress them
+	// when the peer takes over its context.
+	deflateWindowSize = 1 << maxDeflateWindowBits
+)
+
+var (
+	// deflateTrailer is what the sender strips from the end of each compressed message.
+	deflateTrailer = []byte{0x00, 0x00, 0xff, 0xff}
+
+	// inflateTail is appended to a compressed message before it is decompressed. It is the stripped trailer followed
+	// by a final empty stored block, so that the decompressor reaches the end of its input.
+	inflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+)
+
+// DeflateOptions configure the permessage-deflate extension, defined in RFC 7692, which compresses the payloads of
+// messages. A client offers the extension during the handshake, and a server accepts such an offer, only if the
+// options are set with SetDeflateOptions.
+type DeflateOptions struct {
+	// ClientNoContextTakeover makes the client reset its compressor after each message. A client uses it in its offer,
+	// a server in its response.
+	ClientNoContextTakeover bool
+
+	// ServerNoContextTakeover makes the server reset its compressor after each message. A client requests it in its
+	// offer, and a server always uses it if set.
+	ServerNoContextTakeover bool
+
+	// ClientMaxWindowBits limits the size of the LZ77 window used by the client to compress, between 8 and 15. Zero
+	// means no limit.
+	ClientMaxWindowBits int
+
+	// ServerMaxWindowBits limits the size of the LZ77 window used by the server to compress, between 8 and 15. Zero
+	// means no limit.
+	ServerMaxWindowBits int
+
+	// Threshold is the size under which the messages written with Write and AsyncWrite are not compressed.
+	Threshold int
+
+	// Level is the compression level, as defined by compress/flate. Zero selects flate.DefaultCompression.
+	Level int
+}
+
+func (o *DeflateOptions) validate() error {
+	for _, bits := range []int{o.ClientMaxWindowBits, o.ServerMaxWindowBits} {
+		if bits != 0 && (bits < minDeflateWindowBits || bits > maxDeflateWindowBits) {
+			return fmt.Errorf("invalid deflate window bits %d", bits)
+		}
+	}
+	if o.Level < flate.HuffmanOnly || o.Level > flate.BestCompression {
+		return fmt.Errorf("invalid deflate level %d", o.Level)
+	}
+	return nil
+}
+
+// offer returns the value of the Sec-WebSocket-Extensions header with which a client offers the extension.
+func (o *DeflateOptions) offer() string {
+	var b strings.Builder
+	b.WriteString(deflateExtension)
+	if o.ClientNoContextTakeover {
+		b.WriteString("; client_no_context_takeover")
+	}
+	if o.ServerNoContextTakeover {
+		b.WriteString("; server_no_context_takeover")
+	}
+	if o.ServerMaxWindowBits != 0 {
+		fmt.Fprintf(&b, "; server_max_window_bits=%d", o.ServerMaxWindowBits)
+	}
+	// Any window size requested by the server can be honoured, so the parameter is always sent.
+	b.WriteString("; client_max_window_bits")
+	if o.ClientMaxWindowBits != 0 {
+		fmt.Fprintf(&b, "=%d", o.ClientMaxWindowBits)
+	}
+	return b.String()
+}
+
+// deflateParams are the parameters of the extension negotiated during the handshake. The window bits are 15 unless
+// negotiated otherwise.
+type deflateParams struct {
+	clientNoContextTakeover bool
+	serverNoContextTakeover bool
+	clientMaxWindowBits     int
+	serverMaxWindowBits     int
+
+	// Whether client_max_window_bits is present, with or without a value.
+	clientMaxWindowBitsOffered bool
+}
+
+// parseDeflateParams parses the parameters which follow the name of the extension in an offer or a response.
+func parseDeflateParams(params []string, offer bool) (p deflateParams, err error) {
+	p.clientMaxWindowBits = maxDeflateWindowBits
+	p.serverMaxWindowBits = maxDeflateWindowBits
+
+	seen := make(map[string]bool, len(params))
+	for _, param := range params {
+		key, value, hasValue := strings.Cut(param, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+
+		if seen[key] {
+			return p, fmt.Errorf("duplicate deflate parameter %s", key)
+		}
+		seen[key] = true
+
+		switch key {
+		case "client_no_context_takeover", "server_no_context_takeover":
+			if hasValue {
+				return p, fmt.Errorf("unexpected value for deflate parameter %s", key)
+			}
+			if key == "client_no_context_takeover" {
+				p.clientNoContextTakeover = true
+			} else {
+				p.serverNoContextTakeover = true
+			}
+		case "client_max_window_bits", "server_max_window_bits":
+			bits := maxDeflateWindowBits
+			if hasValue {
+				bits, err = strconv.Atoi(value)
+				if err != nil || bits < minDeflateWindowBits || bits > maxDeflateWindowBits {
+					return p, fmt.Errorf("invalid value for deflate parameter %s: %q", key, value)
+				}
+			} else if key == "server_max_window_bits" || !offer {
+				// Only a client can offer client_max_window_bits without a value.
+				return p, fmt.Errorf("missing value for deflate parameter %s", key)
+			}
+
+			if key == "client_max_window_bits" {
+				p.clientMaxWindowBits = bits
+				p.clientMaxWindowBitsOffered = true
+			} else {
+				p.serverMaxWindowBits = bits
+			}
+		default:
+			return p, fmt.Errorf("unknown deflate parameter %s", key)
+		}
+	}
+	return p, nil
+}
+
+// acceptDeflateOffer selects the first of the extension offers of an upgrade request which a server supports. It
+// returns the negotiated parameters and the value of the Sec-WebSocket-Extensions header of the response. ok is false
+// if no offer is supported, in which case the extension is not used.
+func acceptDeflateOffer(o *DeflateOptions, header http.Header) (p deflateParams, response string, ok bool) {
+	for _, extension := range headerTokens(header, "Sec-WebSocket-Extensions") {
+		params := strings.Split(extension, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), deflateExtension) {
+			continue
+		}
+
+		offer, err := parseDeflateParams(params[1:], true)
+		if err != nil {
+			continue
+		}
+
+		p = deflateParams{
+			clientNoContextTakeover: offer.clientNoContextTakeover || o.ClientNoContextTakeover,
+			serverNoContextTakeover: offer.serverNoContextTakeover || o.ServerNoContextTakeover,
+			clientMaxWindowBits:     maxDeflateWindowBits,
+			serverMaxWindowBits:     min(offer.serverMaxWindowBits, windowBits(o.ServerMaxWindowBits)),
+		}
+		if offer.clientMaxWindowBitsOffered && o.ClientMaxWindowBits != 0 {
+			p.clientMaxWindowBits = min(offer.clientMaxWindowBits, o.ClientMaxWindowBits)
+		}
+
+		var b strings.Builder
+		b.WriteString(deflateExtension)
+		if p.clientNoContextTakeover {
+			b.WriteString("; client_no_context_takeover")
+		}
+		if p.serverNoContextTakeover {
+			b.WriteString("; server_no_context_takeover")
+		}
+		if p.serverMaxWindowBits < maxDeflateWindowBits {
+			fmt.Fprintf(&b, "; server_max_window_bits=%d", p.serverMaxWindowBits)
+		}
+		if p.clientMaxWindowBits < maxDeflateWindowBits {
+			fmt.Fprintf(&b, "; client_max_window_bits=%d", p.clientMaxWindowBits)
+		}
+		return p, b.String(), true
+	}
+	return deflateParams{}, "", false
+}
+
+// checkDeflateResponse validates the Sec-WebSocket-Extensions header of an upgrade response against the offer made
+// with the options, which are nil if the extension was not offered. ok is false if the server declined the offer.
+func checkDeflateResponse(o *DeflateOptions, header http.Header) (p deflateParams, ok bool, err error) {
+	extensions := headerTokens(header, "Sec-WebSocket-Extensions")
+	if len(extensions) == 0 {
+		return p, false, nil
+	}
+	if o == nil || len(extensions) > 1 {
+		return p, false, fmt.Errorf("%w: unexpected extensions %q", ErrCannotUpgrade, extensions)
+	}
+
+	params := strings.Split(extensions[0], ";")
+	if !strings.EqualFold(strings.TrimSpace(params[0]), deflateExtension) {
+		return p, false, fmt.Errorf("%w: unexpected extension %q", ErrCannotUpgrade, extensions[0])
+	}
+
+	p, err = parseDeflateParams(params[1:], false)
+	if err == nil {
+		switch {
+		case o.ServerNoContextTakeover && !p.serverNoContextTakeover:
+			err = fmt.Errorf("server_no_context_takeover not acknowledged")
+		case p.serverMaxWindowBits > windowBits(o.ServerMaxWindowBits):
+			err = fmt.Errorf("server_max_window_bits=%d over the offered value", p.serverMaxWindowBits)
+		}
+	}
+	if err != nil {
+		return p, false, fmt.Errorf("%w: %v", ErrCannotUpgrade, err)
+	}
+
+	p.clientNoContextTakeover = p.clientNoContextTakeover || o.ClientNoContextTakeover
+	p.clientMaxWindowBits = min(p.clientMaxWindowBits, windowBits(o.ClientMaxWindowBits))
+	return p, true, nil
+}
+
+// windowBits returns the window bits set in DeflateOptions, where 0 means the largest window.
+func windowBits(bits int) int {
+	if bits == 0 {
+		return maxDeflateWindowBits
+	}
+	return bits
+}
+
+// deflateState holds the compressor and decompressor of a stream which negotiated the extension. They are created
+// with the first message which needs them, and are reused for the following messages and connections.
+type deflateState struct {
+	negotiated bool
+	threshold  int
+
+	// Compression.
+	level      int
+	takeover   bool // whether the compressor keeps its context between messages
+	writer     *flate.Writer
+	compressed bytes.Buffer
+
+	// Decompression.
+	peerTakeover bool // whether the peer keeps its context between messages
+	reader       io.ReadCloser
+	in           []byte // the compressed message, accumulated across its frames
+	inReader     bytes.Reader
+	window       []byte // the end of the previous messages, when the peer keeps its context
+}
+
+// init prepares the state for a connection which negotiated the extension with the given parameters.
+func (d *deflateState) init(role Role, p deflateParams, o *DeflateOptions) {
+	d.negotiated = true
+	d.threshold = o.Threshold
+
+	ownNoContextTakeover, ownWindowBits, peerNoContextTakeover :=
+		p.clientNoContextTakeover, p.clientMaxWindowBits, p.serverNoContextTakeover
+	if role == RoleServer {
+		ownNoContextTakeover, ownWindowBits, peerNoContextTakeover =
+			p.serverNoContextTakeover, p.serverMaxWindowBits, p.clientNoContextTakeover
+	}
+
+	level := o.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	if ownWindowBits < maxDeflateWindowBits {
+		// compress/flate always uses the largest window. Without matches, no distance exceeds the smaller one.
+		level = flate.HuffmanOnly
+	}
+	if d.writer != nil && level != d.level {
+		d.writer = nil
+	}
+	d.level = level
+	d.takeover = !ownNoContextTakeover
+	if d.writer != nil {
+		d.writer.Reset(&d.compressed)
+	}
+
+	d.peerTakeover = !peerNoContextTakeover
+	d.in = d.in[:0]
+	d.window = d.window[:0]
+}
+
+// compress returns the compressed b, without the trailer of the final flush. It is valid until the next call.
+func (d *deflateState) compress(b []byte) (_ []byte, err error) {
+	d.compressed.Reset()
+	if d.writer == nil {
+		d.writer, err = flate.NewWriter(&d.compressed, d.level)
+		if err != nil {
+			return nil, err
+		}
+	} else if !d.takeover {
+		d.writer.Reset(&d.compressed)
+	}
+
+	if _, err = d.writer.Write(b); err != nil {
+		return nil, err
+	}
+	if err = d.writer.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(d.compressed.Bytes(), deflateTrailer), nil
+}
+
+// beginMessage discards the compressed frames of a previous message which were not decompressed.
+func (d *deflateState) beginMessage() {
+	d.in = d.in[:0]
+}
+
+// appendFrame appends the compressed payload of a frame of the message to decompress.
+func (d *deflateState) appendFrame(payload []byte, maxMessageSize int) error {
+	if len(d.in)+len(payload) > maxMessageSize {
+		return ErrMessageTooBig
+	}
+	d.in = append(d.in, payload...)
+	return nil
+}
+
+// decompress decompresses the message accumulated by appendFrame into b. ErrMessageTooBig is returned if it does not
+// fit.
+func (d *deflateState) decompress(b []byte) (n int, err error) {
+	d.in = append(d.in, inflateTail...)
+	d.inReader.Reset(d.in)
+	d.in = d.in[:0]
+
+	var dict []byte
+	if d.peerTakeover {
+		dict = d.window
+	}
+	if d.reader == nil {
+		d.reader = flate.NewReader(&d.inReader)
+	}
+	if err := d.reader.(flate.Resetter).Reset(&d.inReader, dict); err != nil {
+		return 0, err
+	}
+
+	for {
+		if n == len(b) {
+			// The message fits only if there is nothing left to decompress.
+			var extra [1]byte
+			m, rerr := d.reader.Read(extra[:])
+			if m > 0 {
+				return n, ErrMessageTooBig
+			}
+			if rerr == nil {
+				continue
+			}
+			err = rerr
+		} else {
+			var m int
+			m, err = d.reader.Read(b[n:])
+			n += m
+			if err == nil {
+				continue
+			}
+		}
+
+		if err != io.EOF {
+			return n, err
+		}
+		break
+	}
+
+	if d.peerTakeover {
+		d.remember(b[:n])
+	}
+	return n, nil
+}
+
+// remember keeps the last deflateWindowSize bytes of the decompressed messages, which the peer can refer to in the
+// following ones.
+func (d *deflateState) remember(b []byte) {
+	if len(b) >= deflateWindowSize {
+		d.window = append(d.window[:0], b[len(b)-deflateWindowSize:]...)
+		return
+	}
+	if over := len(d.window) + len(b) - deflateWindowSize; over > 0 {
+		d.window = d.window[:copy(d.window, d.window[over:])]
+	}
+	d.window = append(d.window, b...)
+}
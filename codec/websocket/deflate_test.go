@@ -0,0 +1,409 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/talostrading/sonic"
+)
+
+func TestDeflateAcceptOffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  DeflateOptions
+		offers   string
+		response string
+		params   deflateParams
+	}{
+		{
+			name:     "default",
+			offers:   "permessage-deflate; client_max_window_bits",
+			response: "permessage-deflate",
+			params:   deflateParams{clientMaxWindowBits: 15, serverMaxWindowBits: 15},
+		},
+		{
+			name:     "no context takeover requested",
+			offers:   "permessage-deflate; server_no_context_takeover; client_no_context_takeover",
+			response: "permessage-deflate; client_no_context_takeover; server_no_context_takeover",
+			params: deflateParams{
+				clientNoContextTakeover: true,
+				serverNoContextTakeover: true,
+				clientMaxWindowBits:     15,
+				serverMaxWindowBits:     15,
+			},
+		},
+		{
+			name:     "window bits",
+			options:  DeflateOptions{ServerMaxWindowBits: 12, ClientMaxWindowBits: 11},
+			offers:   `permessage-deflate; server_max_window_bits=10; client_max_window_bits="13"`,
+			response: "permessage-deflate; server_max_window_bits=10; client_max_window_bits=11",
+			params:   deflateParams{clientMaxWindowBits: 11, serverMaxWindowBits: 10},
+		},
+		{
+			name:     "client window bits not offered",
+			options:  DeflateOptions{ServerNoContextTakeover: true, ClientMaxWindowBits: 11},
+			offers:   "permessage-deflate",
+			response: "permessage-deflate; server_no_context_takeover",
+			params:   deflateParams{serverNoContextTakeover: true, clientMaxWindowBits: 15, serverMaxWindowBits: 15},
+		},
+		{
+			name: "first valid offer",
+			offers: "x-webkit-deflate-frame, " +
+				"permessage-deflate; server_max_window_bits, " +
+				"permessage-deflate; foo, " +
+				"permessage-deflate; server_max_window_bits=9",
+			response: "permessage-deflate; server_max_window_bits=9",
+			params:   deflateParams{clientMaxWindowBits: 15, serverMaxWindowBits: 9},
+		},
+		{
+			name: "no valid offer",
+			offers: "permessage-deflate; server_max_window_bits=16, " +
+				"permessage-deflate; client_no_context_takeover; client_no_context_takeover",
+		},
+		{
+			name: "no offer",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := make(http.Header)
+			if test.offers != "" {
+				header.Set("Sec-WebSocket-Extensions", test.offers)
+			}
+
+			params, response, ok := acceptDeflateOffer(&test.options, header)
+			if ok != (test.response != "") {
+				t.Fatalf("expected accepted=%v got=%v", test.response != "", ok)
+			}
+			if response != test.response {
+				t.Fatalf("expected response %q got %q", test.response, response)
+			}
+			if ok && params != test.params {
+				t.Fatalf("expected params %+v got %+v", test.params, params)
+			}
+		})
+	}
+}
+
+func TestDeflateCheckResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  *DeflateOptions
+		response string
+		ok       bool
+		err      bool
+		params   deflateParams
+	}{
+		{
+			name:     "declined",
+			options:  &DeflateOptions{},
+			response: "",
+		},
+		{
+			name:     "accepted",
+			options:  &DeflateOptions{ClientNoContextTakeover: true, ClientMaxWindowBits: 12},
+			response: "permessage-deflate; client_max_window_bits=13; server_max_window_bits=10",
+			ok:       true,
+			params: deflateParams{
+				clientNoContextTakeover:    true,
+				clientMaxWindowBits:        12,
+				serverMaxWindowBits:        10,
+				clientMaxWindowBitsOffered: true,
+			},
+		},
+		{
+			name:     "not offered",
+			response: "permessage-deflate",
+			err:      true,
+		},
+		{
+			name:     "unknown extension",
+			options:  &DeflateOptions{},
+			response: "x-webkit-deflate-frame",
+			err:      true,
+		},
+		{
+			name:     "several extensions",
+			options:  &DeflateOptions{},
+			response: "permessage-deflate, permessage-deflate",
+			err:      true,
+		},
+		{
+			name:     "unknown parameter",
+			options:  &DeflateOptions{},
+			response: "permessage-deflate; foo=1",
+			err:      true,
+		},
+		{
+			name:     "client window bits without value",
+			options:  &DeflateOptions{},
+			response: "permessage-deflate; client_max_window_bits",
+			err:      true,
+		},
+		{
+			name:     "server window bits over the offer",
+			options:  &DeflateOptions{ServerMaxWindowBits: 10},
+			response: "permessage-deflate; server_max_window_bits=11",
+			err:      true,
+		},
+		{
+			name:     "server context takeover not acknowledged",
+			options:  &DeflateOptions{ServerNoContextTakeover: true},
+			response: "permessage-deflate",
+			err:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := make(http.Header)
+			if test.response != "" {
+				header.Set("Sec-WebSocket-Extensions", test.response)
+			}
+
+			params, ok, err := checkDeflateResponse(test.options, header)
+			if (err != nil) != test.err {
+				t.Fatalf("expected error=%v got=%v", test.err, err)
+			}
+			if ok != test.ok {
+				t.Fatalf("expected ok=%v got=%v", test.ok, ok)
+			}
+			if ok && params != test.params {
+				t.Fatalf("expected params %+v got %+v", test.params, params)
+			}
+		})
+	}
+}
+
+func TestDeflateContextTakeover(t *testing.T) {
+	message := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog ", 20))
+
+	for _, noContextTakeover := range []bool{false, true} {
+		t.Run(fmt.Sprintf("no_context_takeover=%v", noContextTakeover), func(t *testing.T) {
+			params := deflateParams{
+				clientNoContextTakeover: noContextTakeover,
+				clientMaxWindowBits:     maxDeflateWindowBits,
+				serverMaxWindowBits:     maxDeflateWindowBits,
+			}
+			var client, server deflateState
+			client.init(RoleClient, params, &DeflateOptions{})
+			server.init(RoleServer, params, &DeflateOptions{})
+
+			var sizes []int
+			b := make([]byte, 4096)
+			for i := 0; i < 3; i++ {
+				compressed, err := client.compress(message)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sizes = append(sizes, len(compressed))
+
+				if err := server.appendFrame(compressed, DefaultMaxMessageSize); err != nil {
+					t.Fatal(err)
+				}
+				n, err := server.decompress(b)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(b[:n], message) {
+					t.Fatalf("wrong message %q", b[:n])
+				}
+			}
+
+			// The messages following the first one refer to it when the context is taken over.
+			for _, size := range sizes[1:] {
+				if noContextTakeover && size != sizes[0] || !noContextTakeover && size >= sizes[0]/2 {
+					t.Fatalf("unexpected sizes of the compressed messages %v", sizes)
+				}
+			}
+		})
+	}
+}
+
+func TestDeflateMessageTooBig(t *testing.T) {
+	params := deflateParams{clientMaxWindowBits: maxDeflateWindowBits, serverMaxWindowBits: maxDeflateWindowBits}
+	var client, server deflateState
+	client.init(RoleClient, params, &DeflateOptions{})
+	server.init(RoleServer, params, &DeflateOptions{})
+
+	compressed, err := client.compress(make([]byte, 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.appendFrame(compressed, DefaultMaxMessageSize); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.decompress(make([]byte, 1023)); err != ErrMessageTooBig {
+		t.Fatalf("expected ErrMessageTooBig got=%v", err)
+	}
+
+	if err := server.appendFrame(compressed, len(compressed)-1); err != ErrMessageTooBig {
+		t.Fatalf("expected ErrMessageTooBig got=%v", err)
+	}
+}
+
+func TestDeflateStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		server DeflateOptions
+		client DeflateOptions
+	}{
+		{
+			name: "context takeover",
+		},
+		{
+			name:   "no context takeover",
+			server: DeflateOptions{ClientNoContextTakeover: true},
+			client: DeflateOptions{ServerNoContextTakeover: true},
+		},
+		{
+			name:   "window bits",
+			server: DeflateOptions{ServerMaxWindowBits: 9, ClientMaxWindowBits: 10},
+			client: DeflateOptions{ClientMaxWindowBits: 12},
+		},
+	}
+
+	large := strings.Repeat("sonic ", 1000)
+	messages := []string{"hello", large, "", large, "world"}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ioc := sonic.MustIO()
+			defer ioc.Close()
+
+			srv, err := NewWebsocketStream(ioc, nil, RoleServer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.server.Threshold = 1
+			if err := srv.SetDeflateOptions(&test.server); err != nil {
+				t.Fatal(err)
+			}
+
+			// The server echoes the messages until the client closes the connection.
+			b := make([]byte, 8192)
+			var onMessage AsyncMessageCallback
+			onMessage = func(err error, n int, messageType MessageType) {
+				if err != nil {
+					return
+				}
+				srv.AsyncWrite(b[:n], messageType, func(err error) {
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					srv.AsyncNextMessage(b, onMessage)
+				})
+			}
+			addr := acceptOne(t, ioc, srv, func(err error) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !srv.DeflateNegotiated() {
+					t.Fatal("deflate not negotiated by the server")
+				}
+				srv.AsyncNextMessage(b, onMessage)
+			})
+
+			clientDone := make(chan error, 1)
+			go func() {
+				clientDone <- runDeflateClient(addr, &test.client, messages)
+			}()
+
+			var clientErr error
+			pollUntil(t, ioc, func() bool {
+				select {
+				case clientErr = <-clientDone:
+					return true
+				default:
+					return false
+				}
+			})
+			if clientErr != nil {
+				t.Fatal(clientErr)
+			}
+		})
+	}
+}
+
+// runDeflateClient writes the messages to an echo server, expecting them back, then a compressed message made of
+// two frames.
+func runDeflateClient(addr string, options *DeflateOptions, messages []string) error {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		return err
+	}
+	defer client.CloseNextLayer()
+
+	options.Threshold = 1
+	if err := client.SetDeflateOptions(options); err != nil {
+		return err
+	}
+	if err := client.Handshake(fmt.Sprintf("ws://%s", addr)); err != nil {
+		return err
+	}
+	if !client.DeflateNegotiated() {
+		return fmt.Errorf("deflate not negotiated by the client")
+	}
+
+	b := make([]byte, 8192)
+	expectEcho := func(expected string) error {
+		messageType, n, err := client.NextMessage(b)
+		if err != nil {
+			return err
+		}
+		if messageType != TypeText || string(b[:n]) != expected {
+			return fmt.Errorf("wrong echo type=%s payload=%q expected=%q", messageType, b[:n], expected)
+		}
+		return nil
+	}
+
+	for _, message := range messages {
+		if err := client.Write([]byte(message), TypeText); err != nil {
+			return err
+		}
+		if err := expectEcho(message); err != nil {
+			return err
+		}
+	}
+
+	message := strings.Repeat("fragmented ", 100)
+	compressed, err := client.deflate.compress([]byte(message))
+	if err != nil {
+		return err
+	}
+	first := client.AcquireFrame()
+	first.SetText().SetRSV1().SetPayload(compressed[:len(compressed)/2])
+	if err := client.WriteFrame(first); err != nil {
+		return err
+	}
+	last := client.AcquireFrame()
+	last.SetFIN().SetContinuation().SetPayload(compressed[len(compressed)/2:])
+	if err := client.WriteFrame(last); err != nil {
+		return err
+	}
+	if err := expectEcho(message); err != nil {
+		return err
+	}
+
+	// The echo is compressed, and so much smaller than the message.
+	if err := client.Write([]byte(message), TypeText); err != nil {
+		return err
+	}
+	f, err := client.NextFrame()
+	if err != nil {
+		return err
+	}
+	if !f.IsRSV1() || f.PayloadLength() >= len(message)/2 {
+		return fmt.Errorf("the echo is not compressed rsv1=%v length=%d", f.IsRSV1(), f.PayloadLength())
+	}
+
+	return client.Close(CloseNormal, "")
+}
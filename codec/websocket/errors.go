@@ -27,7 +27,7 @@ var (
 
 	ErrMaskedFramesFromServer = errors.New("masked frames from server")
 
-	ErrUnmaskedFramesFromClient = errors.New("unmasked frames from server")
+	ErrUnmaskedFramesFromClient = errors.New("unmasked frames from client")
 
 	ErrReservedOpcode = errors.New("reserved opcode")
 
@@ -39,5 +39,9 @@ var (
 
 	ErrInvalidAddress = errors.New("invalid address")
 
+	ErrHandshakeTooLarge = errors.New("handshake larger than MaxHandshakeSize")
+
 	ErrInvalidUTF8 = errors.New("Invalid UTF-8 encoding")
+
+	ErrInvalidCompressedPayload = errors.New("invalid compressed payload")
 )
@@ -248,6 +248,18 @@ func (f *Frame) UnmaskPayload() {
 	}
 }
 
+// removeMask removes the mask of a frame whose payload is not masked yet, as
+// the frames acquired by a client, so that it can be written by a server.
+func (f *Frame) removeMask() {
+	if !f.IsMasked() {
+		return
+	}
+	offset := f.maskOffset()
+	copy((*f)[offset:], (*f)[offset+frameMaskLength:])
+	*f = (*f)[:len(*f)-frameMaskLength]
+	f.UnsetIsMasked()
+}
+
 func (f *Frame) fitPayload() ([]byte, error) {
 	length := f.PayloadLength()
 	if length <= 0 {
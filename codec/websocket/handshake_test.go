@@ -0,0 +1,253 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+)
+
+// serveUpgrade accepts a connection on ln and answers its upgrade request with a response padded with the given
+// number of headers, immediately followed by a text frame carrying the message. The request is sent on the returned
+// channel.
+func serveUpgrade(t *testing.T, ln net.Listener, headers int, message string) <-chan *http.Request {
+	reqs := make(chan *http.Request, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		reqs <- req
+
+		b := bytes.NewBuffer(nil)
+		b.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		fmt.Fprintf(b, "Sec-WebSocket-Accept: %s\r\n", MakeResponseKey([]byte(req.Header.Get("Sec-WebSocket-Key"))))
+		for i := 0; i < headers; i++ {
+			fmt.Fprintf(b, "X-Padding-%d: %s\r\n", i, strings.Repeat("x", 64))
+		}
+		b.WriteString("\r\n")
+
+		f := NewFrame()
+		f.SetFIN().SetText().SetPayload([]byte(message))
+		_, _ = f.WriteTo(b)
+
+		// The frame is sent in the same write as the response.
+		if _, err := conn.Write(b.Bytes()); err != nil {
+			t.Error(err)
+		}
+	}()
+	return reqs
+}
+
+func listenLocal(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+// clientHandshake performs the handshake of the client with Handshake or AsyncHandshake.
+func clientHandshake(t *testing.T, ioc *sonic.IO, ws *Stream, addr string, async bool) error {
+	if !async {
+		return ws.Handshake(addr)
+	}
+
+	var (
+		done         bool
+		handshakeErr error
+	)
+	ws.AsyncHandshake(addr, func(err error) {
+		handshakeErr, done = err, true
+	})
+	pollUntil(t, ioc, func() bool { return done })
+	return handshakeErr
+}
+
+// expectMessage reads the next message of the stream, which must be a text message carrying the expected payload.
+func expectMessage(t *testing.T, ioc *sonic.IO, ws *Stream, expected string) {
+	var (
+		done    bool
+		readErr error
+		n       int
+	)
+	b := make([]byte, 128)
+	ws.AsyncNextMessage(b, func(err error, nn int, _ MessageType) {
+		readErr, n, done = err, nn, true
+	})
+	pollUntil(t, ioc, func() bool { return done })
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if string(b[:n]) != expected {
+		t.Fatalf("wrong message %q expected %q", b[:n], expected)
+	}
+}
+
+func TestClientHandshakeLargeResponse(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		t.Run(fmt.Sprintf("async=%v", async), func(t *testing.T) {
+			ioc := sonic.MustIO()
+			defer ioc.Close()
+
+			ln := listenLocal(t)
+			serveUpgrade(t, ln, 100, "hello")
+
+			ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ws.CloseNextLayer()
+
+			var res *http.Response
+			ws.SetUpgradeResponseCallback(func(r *http.Response) {
+				res = r
+			})
+
+			if err := clientHandshake(t, ioc, ws, "ws://"+ln.Addr().String(), async); err != nil {
+				t.Fatal(err)
+			}
+			assertState(t, ws, StateActive)
+			if res == nil || res.Header.Get("X-Padding-99") == "" {
+				t.Fatal("the headers of the response are not all parsed")
+			}
+
+			// The frame which followed the response is decoded.
+			expectMessage(t, ioc, ws, "hello")
+		})
+	}
+}
+
+func TestClientHandshakeResponseTooLarge(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		t.Run(fmt.Sprintf("async=%v", async), func(t *testing.T) {
+			ioc := sonic.MustIO()
+			defer ioc.Close()
+
+			ln := listenLocal(t)
+			serveUpgrade(t, ln, MaxHandshakeSize/64, "hello")
+
+			ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ws.CloseNextLayer()
+
+			err = clientHandshake(t, ioc, ws, "ws://"+ln.Addr().String(), async)
+			if !errors.Is(err, ErrHandshakeTooLarge) {
+				t.Fatalf("expected ErrHandshakeTooLarge got=%v", err)
+			}
+			assertState(t, ws, StateTerminated)
+		})
+	}
+}
+
+func TestClientAsyncHandshakeDialAddr(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln := listenLocal(t)
+	reqs := serveUpgrade(t, ln, 0, "hello")
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.CloseNextLayer()
+
+	// The host of the address is not resolved.
+	ws.SetDialAddr(ln.Addr().String())
+	if err := clientHandshake(t, ioc, ws, "ws://sonic.invalid:8080/feed", true); err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-reqs
+	if req.Host != "sonic.invalid:8080" || req.URL.Path != "/feed" {
+		t.Fatalf("wrong request host=%s path=%s", req.Host, req.URL.Path)
+	}
+	if ws.RemoteAddr().String() != ln.Addr().String() {
+		t.Fatalf("wrong remote address %s", ws.RemoteAddr())
+	}
+	expectMessage(t, ioc, ws, "hello")
+}
+
+// newTestCertificate returns a self-signed certificate for localhost, and a pool which trusts it.
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func TestClientAsyncHandshakeTLS(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	cert, pool := newTestCertificate(t)
+	ln := tls.NewListener(listenLocal(t), &tls.Config{Certificates: []tls.Certificate{cert}})
+	serveUpgrade(t, ln, 50, "hello")
+
+	// The server name is taken from the address, whose host is looked up.
+	ws, err := NewWebsocketStream(ioc, &tls.Config{RootCAs: pool}, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.CloseNextLayer()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	if err := clientHandshake(t, ioc, ws, "wss://localhost:"+port, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ws.NextLayer().(*sonic.TLSConn); !ok {
+		t.Fatalf("expected a sonic.TLSConn got %T", ws.NextLayer())
+	}
+	expectMessage(t, ioc, ws, "hello")
+
+	if err := ws.Write([]byte("world"), TypeText); err != nil {
+		t.Fatal(err)
+	}
+}
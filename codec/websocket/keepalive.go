@@ -0,0 +1,200 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// KeepaliveOptions configure the pings a Stream sends to keep its connection alive and to detect a dead peer.
+type KeepaliveOptions struct {
+	// Interval is the delay between two pings.
+	Interval time.Duration
+
+	// Timeout is the delay after which the stream fails with sonicerrors.ErrTimeout if nothing is received from the
+	// peer, neither a pong nor any other frame. The peer is only checked when a ping is due, so the failure can happen
+	// up to Interval after the timeout. Zero disables the check.
+	Timeout time.Duration
+
+	// PingPayload, if set, returns the payload of each ping, which the peer echoes in its pong. It is truncated to
+	// MaxControlFramePayloadLength. The pings are empty otherwise.
+	PingPayload func() []byte
+
+	// PongCallback, if set, is invoked with the payload of each pong received. rtt is the time elapsed since the ping
+	// whose payload the pong echoes was sent, and 0 if the pong is not an answer to a recent ping.
+	//
+	// The caller must not perform any operations on the stream in the provided callback.
+	PongCallback func(payload []byte, rtt time.Duration)
+}
+
+// keepalive is the state of the keepalive of an active stream.
+type keepalive struct {
+	timer *sonic.Timer
+
+	// The number of frames received, and its value when the peer was last seen alive.
+	received     uint64
+	lastReceived uint64
+	lastAlive    time.Time
+
+	// The pings sent whose pongs are not received yet, oldest first.
+	pings []ping
+
+	// Set when the peer is found dead, and returned by the operations on the stream from then on.
+	err error
+}
+
+type ping struct {
+	payload []byte
+	sentAt  time.Time
+}
+
+// maxPendingPings is the number of pings sent without an answer which are kept to measure the round trip time.
+const maxPendingPings = 8
+
+// SetKeepalive makes the stream ping its peer at the given interval once the handshake completes, and fail when the
+// peer does not answer. The keepalive is disabled if the options are nil, which is the default. If the stream is
+// already active, the keepalive is restarted with the new options.
+//
+// The pings are sent while the IO runs, so the keepalive must be used with the asynchronous functions. The frames
+// received are only accounted for when they are read, so the stream must be read continuously with AsyncNextMessage
+// or AsyncNextFrame.
+//
+// When the peer is found dead, the pending read and write operations are cancelled and complete with
+// sonicerrors.ErrTimeout, as do the following ones. The connection is not closed.
+func (s *Stream) SetKeepalive(options *KeepaliveOptions) error {
+	if options != nil {
+		if options.Interval <= 0 {
+			return fmt.Errorf("invalid keepalive interval %s", options.Interval)
+		}
+		if options.Timeout < 0 {
+			return fmt.Errorf("invalid keepalive timeout %s", options.Timeout)
+		}
+		o := *options
+		options = &o
+	}
+	s.keepaliveOptions = options
+
+	if s.state == StateActive {
+		return s.startKeepalive()
+	}
+	return nil
+}
+
+func (s *Stream) Keepalive() *KeepaliveOptions {
+	return s.keepaliveOptions
+}
+
+// startKeepalive starts the keepalive, if enabled, when the stream becomes active.
+func (s *Stream) startKeepalive() (err error) {
+	s.stopKeepalive()
+	if s.keepaliveOptions == nil {
+		return nil
+	}
+
+	if s.keepalive.timer == nil {
+		s.keepalive.timer, err = sonic.NewTimer(s.ioc)
+		if err != nil {
+			return err
+		}
+	}
+	s.keepalive.lastReceived = s.keepalive.received
+	s.keepalive.lastAlive = time.Now()
+	s.keepalive.pings = s.keepalive.pings[:0]
+	return s.keepalive.timer.ScheduleRepeating(s.keepaliveOptions.Interval, s.onKeepalive)
+}
+
+func (s *Stream) stopKeepalive() {
+	if s.keepalive.timer != nil {
+		_ = s.keepalive.timer.Cancel()
+	}
+}
+
+func (s *Stream) closeKeepalive() {
+	if s.keepalive.timer != nil {
+		_ = s.keepalive.timer.Close()
+		s.keepalive.timer = nil
+	}
+}
+
+func (s *Stream) onKeepalive() {
+	if s.state != StateActive || s.keepaliveOptions == nil {
+		s.stopKeepalive()
+		return
+	}
+
+	now := time.Now()
+	if s.keepalive.received != s.keepalive.lastReceived {
+		s.keepalive.lastReceived = s.keepalive.received
+		s.keepalive.lastAlive = now
+	}
+
+	if timeout := s.keepaliveOptions.Timeout; timeout > 0 && now.Sub(s.keepalive.lastAlive) >= timeout {
+		s.keepaliveTimedOut()
+		return
+	}
+
+	var payload []byte
+	if s.keepaliveOptions.PingPayload != nil {
+		payload = s.keepaliveOptions.PingPayload()
+		if len(payload) > MaxControlFramePayloadLength {
+			payload = payload[:MaxControlFramePayloadLength]
+		}
+	}
+	pings := s.keepalive.pings
+	if len(pings) == maxPendingPings {
+		// The oldest ping is forgotten, and its buffer reused.
+		oldest := pings[0]
+		pings = append(pings[:copy(pings, pings[1:])], ping{payload: oldest.payload})
+	} else {
+		pings = append(pings, ping{})
+	}
+	last := &pings[len(pings)-1]
+	last.payload = append(last.payload[:0], payload...)
+	last.sentAt = now
+	s.keepalive.pings = pings
+
+	// A flush in progress writes the ping after the frames before it, and a user flush started meanwhile waits for
+	// the ping to be written. A write error is reported by the next operation on the stream.
+	s.prepareWrite(s.AcquireFrame().SetFIN().SetPing().SetPayload(payload))
+	if !s.flushing {
+		s.AsyncFlush(func(error) {})
+	}
+}
+
+// keepaliveTimedOut fails the stream, whose peer did not send anything before the keepalive timeout.
+func (s *Stream) keepaliveTimedOut() {
+	s.stopKeepalive()
+	s.keepalive.err = sonicerrors.ErrTimeout
+	s.state = StateTerminated
+	if s.stream != nil {
+		s.stream.Cancel()
+	}
+}
+
+// onPong is invoked with the payload of each pong received while the keepalive is enabled.
+func (s *Stream) onPong(payload []byte) {
+	var rtt time.Duration
+	for i := range s.keepalive.pings {
+		if bytes.Equal(payload, s.keepalive.pings[i].payload) {
+			rtt = time.Since(s.keepalive.pings[i].sentAt)
+			// The peer answers the pings in order, so the older ones are not answered.
+			s.keepalive.pings = s.keepalive.pings[:copy(s.keepalive.pings, s.keepalive.pings[i+1:])]
+			break
+		}
+	}
+	if cb := s.keepaliveOptions.PongCallback; cb != nil {
+		cb(payload, rtt)
+	}
+}
+
+// keepaliveError returns the error with which the keepalive failed the stream, if it did, in place of the non-nil
+// error of an operation.
+func (s *Stream) keepaliveError(err error) error {
+	if err != nil && s.keepalive.err != nil {
+		return s.keepalive.err
+	}
+	return err
+}
@@ -0,0 +1,242 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+func TestKeepalivePongs(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	srv, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		pings int
+		pongs []string
+	)
+	err = srv.SetKeepalive(&KeepaliveOptions{
+		Interval: 10 * time.Millisecond,
+		Timeout:  time.Second,
+		PingPayload: func() []byte {
+			pings++
+			return []byte(fmt.Sprintf("ping-%d", pings))
+		},
+		PongCallback: func(payload []byte, rtt time.Duration) {
+			if rtt <= 0 {
+				t.Errorf("expected the rtt of %q", payload)
+			}
+			pongs = append(pongs, string(payload))
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, rd := acceptRaw(t, ioc, srv)
+
+	// The client echoes 3 pings, then sends a message.
+	clientDone := make(chan error, 1)
+	go func() {
+		clientDone <- func() error {
+			for i := 1; i <= 3; i++ {
+				f := NewFrame()
+				if _, err := f.ReadFrom(rd); err != nil {
+					return err
+				}
+				if !f.Opcode().IsPing() || string(f.Payload()) != fmt.Sprintf("ping-%d", i) {
+					return fmt.Errorf("unexpected frame %s %q", f.Opcode(), f.Payload())
+				}
+				if _, err := conn.Write(maskedFrame(true, OpcodePong, f.Payload())); err != nil {
+					return err
+				}
+			}
+			_, err := conn.Write(maskedFrame(true, OpcodeText, []byte("hello")))
+			return err
+		}()
+	}()
+
+	var (
+		done    bool
+		readErr error
+		n       int
+	)
+	b := make([]byte, 128)
+	srv.AsyncNextMessage(b, func(err error, nn int, _ MessageType) {
+		readErr, n, done = err, nn, true
+	})
+	pollUntil(t, ioc, func() bool { return done })
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if string(b[:n]) != "hello" {
+		t.Fatalf("wrong message %q", b[:n])
+	}
+	if err := <-clientDone; err != nil {
+		t.Fatal(err)
+	}
+	if len(pongs) != 3 || pongs[2] != "ping-3" {
+		t.Fatalf("wrong pongs %q", pongs)
+	}
+}
+
+func TestKeepaliveTimeout(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	srv, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = srv.SetKeepalive(&KeepaliveOptions{
+		Interval: 10 * time.Millisecond,
+		Timeout:  50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, _ := acceptRaw(t, ioc, srv)
+
+	// The frames the client sends keep the connection alive, but not when it stops.
+	start := time.Now()
+	go func() {
+		for i := 0; i < 10; i++ {
+			_, _ = conn.Write(maskedFrame(true, OpcodeText, []byte("hello")))
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	var (
+		done     bool
+		readErr  error
+		messages int
+		onRead   AsyncMessageCallback
+	)
+	b := make([]byte, 128)
+	onRead = func(err error, _ int, _ MessageType) {
+		if err != nil {
+			readErr, done = err, true
+			return
+		}
+		messages++
+		srv.AsyncNextMessage(b, onRead)
+	}
+	srv.AsyncNextMessage(b, onRead)
+	pollUntil(t, ioc, func() bool { return done })
+
+	if readErr != sonicerrors.ErrTimeout {
+		t.Fatalf("expected ErrTimeout got=%v", readErr)
+	}
+	if messages != 10 {
+		t.Fatalf("expected 10 messages before the timeout got=%d", messages)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("timed out too early after %s", elapsed)
+	}
+	assertState(t, srv, StateTerminated)
+
+	if err := srv.Write([]byte("hello"), TypeText); err != sonicerrors.ErrTimeout {
+		t.Fatalf("expected ErrTimeout got=%v", err)
+	}
+}
+
+func TestKeepaliveWhileWriting(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	srv, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetMaxMessageSize(32 * 1024 * 1024)
+	err = srv.SetKeepalive(&KeepaliveOptions{
+		Interval: 10 * time.Millisecond,
+		Timeout:  10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, rd := acceptRaw(t, ioc, srv)
+
+	var (
+		messages int
+		onRead   AsyncMessageCallback
+	)
+	b := make([]byte, 128)
+	onRead = func(err error, _ int, _ MessageType) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages++
+		srv.AsyncNextMessage(b, onRead)
+	}
+	srv.AsyncNextMessage(b, onRead)
+
+	// The client does not read, so the message fills the socket buffers and the pings are sent while it is written.
+	large := make([]byte, 16*1024*1024)
+	for i := range large {
+		large[i] = byte(i)
+	}
+	writes := 0
+	srv.AsyncWrite(large, TypeBinary, func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		writes++
+	})
+	deadline := time.Now().Add(50 * time.Millisecond)
+	pollUntil(t, ioc, func() bool { return time.Now().After(deadline) })
+	if writes != 0 {
+		t.Fatal("the message is written while the client does not read")
+	}
+
+	// The next read and write do not write the frames in the middle of the message.
+	writeMaskedFrame(t, conn, true, OpcodeText, []byte("hello"))
+	pollUntil(t, ioc, func() bool { return messages == 1 })
+	srv.AsyncWrite([]byte("world"), TypeText, func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		writes++
+	})
+
+	clientDone := make(chan error, 1)
+	go func() {
+		clientDone <- func() error {
+			pings := 0
+			for i := 0; ; i++ {
+				f := NewFrame()
+				if _, err := f.ReadFrom(rd); err != nil {
+					return err
+				}
+				switch {
+				case i == 0:
+					if !f.Opcode().IsBinary() || !bytes.Equal(f.Payload(), large) {
+						return fmt.Errorf("the message is corrupted %s length=%d", f.Opcode(), f.PayloadLength())
+					}
+				case f.Opcode().IsPing():
+					pings++
+				case f.Opcode().IsText() && string(f.Payload()) == "world":
+					if pings == 0 {
+						return fmt.Errorf("no ping sent while writing")
+					}
+					return nil
+				default:
+					return fmt.Errorf("unexpected frame %s %q", f.Opcode(), f.Payload())
+				}
+			}
+		}()
+	}()
+
+	pollUntil(t, ioc, func() bool { return writes == 2 })
+	if err := <-clientDone; err != nil {
+		t.Fatal(err)
+	}
+}
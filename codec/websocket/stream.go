@@ -16,6 +16,7 @@ when the client cannot parse what the server sends.
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/sha1" //#nosec G505
 	"crypto/tls"
@@ -26,7 +27,6 @@ import (
 	"io"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"sync"
 	"syscall"
@@ -35,6 +35,7 @@ import (
 	"github.com/talostrading/sonic"
 	"github.com/talostrading/sonic/sonicerrors"
 	"github.com/talostrading/sonic/sonicopts"
+	"github.com/talostrading/sonic/util"
 )
 
 type Stream struct {
@@ -66,7 +67,8 @@ type Stream struct {
 	// Buffer for stream writes.
 	dst *sonic.ByteBuffer
 
-	// Contains the handshake response. Is emptied after the handshake is over.
+	// Contains the upgrade request sent by a client or the response sent by a server. Is emptied after the handshake
+	// is over.
 	handshakeBuffer []byte
 
 	// Contains frames waiting to be sent to the peer. Is emptied by AsyncFlush or Flush.
@@ -81,14 +83,49 @@ type Stream struct {
 	// Optional callback invoked when an upgrade response is received.
 	upgradeResponseCallback UpgradeResponseCallback
 
+	// Optional callbacks invoked by a server to validate an upgrade request.
+	originCallback      OriginCallback
+	subprotocolCallback SubprotocolCallback
+	acceptCallback      AcceptCallback
+
+	// The subprotocol selected by the server during the handshake, if any.
+	subprotocol string
+
 	// Used to establish a TCP connection to the peer with a timeout.
 	dialer *net.Dialer
 
+	// Optional address a client connects to in place of the host of the URL it is given.
+	dialAddr string
+
 	framePool sync.Pool
 
 	maxMessageSize int
 
 	validateUTF8 bool
+
+	// Validates the text message being read when validateUTF8 is set.
+	utf8Validator  utf8Validator
+	validatingUTF8 bool
+
+	// Optional permessage-deflate options. The extension is offered or accepted during the handshake only if set.
+	deflateOptions *DeflateOptions
+
+	// State of permessage-deflate, which is used if it was negotiated during the handshake.
+	deflate deflateState
+
+	// Whether the message being read is compressed.
+	inflating bool
+
+	// Optional keepalive options. The keepalive runs while the stream is active only if set.
+	keepaliveOptions *KeepaliveOptions
+	keepalive        keepalive
+
+	// Whether AsyncFlush is writing the pending frames, and the callbacks of the AsyncFlush calls waiting for it.
+	// Only one flush writes at any time, so that the frames are not interleaved: the calls made while flushing, like
+	// those of the keepalive, are completed once the frames prepared before them are written.
+	flushing      bool
+	flushCbs      []func(err error)
+	flushCbsSpare []func(err error)
 }
 
 func NewWebsocketStream(ioc *sonic.IO, tls *tls.Config, role Role) (s *Stream, err error) {
@@ -134,6 +171,8 @@ func (s *Stream) AcquireFrame() *Frame {
 }
 
 func (s *Stream) releaseFrame(f *Frame) {
+	// A frame with a small payload is shorter than the largest header, which SetPayload expects when reused.
+	*f = util.ExtendSlice(*f, frameMaxHeaderLength)
 	f.Reset()
 	s.framePool.Put(f)
 }
@@ -154,12 +193,22 @@ func (s *Stream) init(stream sonic.Stream) (err error) {
 	s.stream = stream
 	s.codec = NewFrameCodec(s.src, s.dst, s.maxMessageSize)
 	s.codecConn, err = sonic.NewCodecConn[Frame, Frame](stream, s.codec, s.src, s.dst)
+	if err == nil {
+		err = s.startKeepalive()
+	}
 	return
 }
 
 func (s *Stream) reset() {
 	s.handshakeBuffer = s.handshakeBuffer[:cap(s.handshakeBuffer)]
 	s.state = StateHandshake
+	s.subprotocol = ""
+	s.deflate.negotiated = false
+	s.inflating = false
+	s.validatingUTF8 = false
+	s.stopKeepalive()
+	s.keepalive.err = nil
+	s.flushing = false
 	s.stream = nil
 	s.conn = nil
 	s.src.Reset()
@@ -186,14 +235,45 @@ func (s *Stream) ValidatesUTF8() bool {
 	return s.validateUTF8
 }
 
-// ValidateUTF8 toggles UTF8 validation done on the payloads of Text frames.
+// ValidateUTF8 toggles UTF8 validation done on the payloads of Text frames. The payloads of the frames of a message are
+// validated as they are read, so an invalid message is detected as soon as possible, and the connection is then failed
+// with CloseBadPayload.
 func (s *Stream) ValidateUTF8(v bool) *Stream {
 	s.validateUTF8 = v
 	return s
 }
 
+// SupportsDeflate indicates that the stream can compress the payloads of messages with the permessage-deflate
+// extension. The extension is disabled by default and can be enabled with `SetDeflateOptions`.
 func (s *Stream) SupportsDeflate() bool {
-	return false
+	return true
+}
+
+// SetDeflateOptions enables the permessage-deflate extension, which is offered by a client and accepted by a server
+// in the following handshakes. The extension is disabled if the options are nil.
+//
+// Once negotiated, the messages written with Write and AsyncWrite are compressed, unless smaller than the threshold,
+// and the compressed messages are decompressed by NextMessage and AsyncNextMessage. The frames written with
+// WriteFrame and AsyncWriteFrame, and the frames read with NextFrame and AsyncNextFrame, are not processed.
+func (s *Stream) SetDeflateOptions(options *DeflateOptions) error {
+	if options != nil {
+		if err := options.validate(); err != nil {
+			return err
+		}
+		o := *options
+		options = &o
+	}
+	s.deflateOptions = options
+	return nil
+}
+
+func (s *Stream) DeflateOptions() *DeflateOptions {
+	return s.deflateOptions
+}
+
+// DeflateNegotiated indicates if the permessage-deflate extension was negotiated during the last handshake.
+func (s *Stream) DeflateNegotiated() bool {
+	return s.deflate.negotiated
 }
 
 func (s *Stream) canRead() bool {
@@ -229,11 +309,14 @@ func (s *Stream) NextFrame() (f Frame, err error) {
 		}
 	}
 
-	return
+	return f, s.keepaliveError(err)
 }
 
 func (s *Stream) nextFrame() (f Frame, err error) {
 	f, err = s.codecConn.ReadNext()
+	if err == nil {
+		s.keepalive.received++
+	}
 
 	// If we get an EOF error, TCP stream was closed
 	// This is an abnormal closure from the server
@@ -260,29 +343,40 @@ func (s *Stream) nextFrame() (f Frame, err error) {
 //   - an error occurs when reading/decoding the message bytes from the underlying stream
 //   - a frame is successfully read from the underlying stream
 func (s *Stream) AsyncNextFrame(callback AsyncFrameCallback) {
+	if s.flushing {
+		// The flush in progress writes the pending control frames. The read does not wait for it, as the peer might
+		// not read before it is answered.
+		s.onNextFrameFlush(nil, callback)
+		return
+	}
 	s.AsyncFlush(func(err error) {
-		if errors.Is(err, ErrMessageTooBig) {
-			s.AsyncClose(CloseGoingAway, "payload too big", func(err error) {})
-			callback(ErrMessageTooBig, nil)
-			return
-		}
+		s.onNextFrameFlush(err, callback)
+	})
+}
 
-		if err == nil && !s.canRead() {
-			err = io.EOF
-		}
+func (s *Stream) onNextFrameFlush(err error, callback AsyncFrameCallback) {
+	if errors.Is(err, ErrMessageTooBig) {
+		s.AsyncClose(CloseGoingAway, "payload too big", func(err error) {})
+		callback(ErrMessageTooBig, nil)
+		return
+	}
 
-		if err == nil {
-			s.asyncNextFrame(callback)
-		} else {
-			s.state = StateTerminated
-			callback(err, nil)
-		}
-	})
+	if err == nil && !s.canRead() {
+		err = io.EOF
+	}
+
+	if err == nil {
+		s.asyncNextFrame(callback)
+	} else {
+		s.state = StateTerminated
+		callback(s.keepaliveError(err), nil)
+	}
 }
 
 func (s *Stream) asyncNextFrame(callback AsyncFrameCallback) {
 	s.codecConn.AsyncReadNext(func(err error, f Frame) {
 		if err == nil {
+			s.keepalive.received++
 			err = s.handleFrame(f)
 
 			// If we get an EOF error, TCP stream was closed
@@ -295,7 +389,7 @@ func (s *Stream) asyncNextFrame(callback AsyncFrameCallback) {
 			f.SetFIN().SetClose().SetPayload(EncodeCloseFramePayload(CloseAbnormal, ""))
 		}
 
-		callback(err, f)
+		callback(s.keepaliveError(err), f)
 	})
 }
 
@@ -312,6 +406,7 @@ func (s *Stream) NextMessage(b []byte) (messageType MessageType, readBytes int,
 	var (
 		f            Frame
 		continuation = false
+		done         bool
 	)
 	messageType = TypeNone
 
@@ -330,35 +425,15 @@ func (s *Stream) NextMessage(b []byte) (messageType MessageType, readBytes int,
 				messageType = MessageType(f.Opcode())
 			}
 
-			n := copy(b[readBytes:], f.Payload())
-			readBytes += n
-
-			if readBytes > s.maxMessageSize || n != f.PayloadLength() {
-				err = ErrMessageTooBig
+			readBytes, done, err = s.readMessageFrame(f, b, readBytes, messageType, continuation)
+			if errors.Is(err, ErrMessageTooBig) {
 				_ = s.Close(CloseGoingAway, "payload too big")
-				break
 			}
 
-			// verify continuation
-			if !continuation {
-				// this is the first frame of the series
-				continuation = !f.IsFIN() //nolint:ineffassign
-				if f.Opcode().IsContinuation() {
-					err = ErrUnexpectedContinuation
-				}
-			} else {
-				// we are past the first frame of the series
-				continuation = !f.IsFIN() //nolint:ineffassign
-				if !f.Opcode().IsContinuation() {
-					err = ErrExpectedContinuation
-				}
-			}
-
-			continuation = !f.IsFIN()
-
-			if err != nil || !continuation {
+			if err != nil || done {
 				break
 			}
+			continuation = true
 		}
 	}
 
@@ -400,49 +475,89 @@ func (s *Stream) asyncNextMessage(
 					messageType = MessageType(f.Opcode())
 				}
 
-				n := copy(b[readBytes:], f.Payload())
-				readBytes += n
-
-				if readBytes > s.maxMessageSize || n != f.PayloadLength() {
-					err = ErrMessageTooBig
+				var done bool
+				readBytes, done, err = s.readMessageFrame(f, b, readBytes, messageType, continuation)
+				if errors.Is(err, ErrMessageTooBig) {
 					s.AsyncClose(
 						CloseGoingAway,
 						"payload too big",
 						func(err error) {},
 					)
-					callback(err, readBytes, messageType)
-					return
 				}
 
-				// verify continuation
-				if !continuation {
-					// this is the first frame of the series
-					continuation = !f.IsFIN()
-					if f.Opcode().IsContinuation() {
-						err = ErrUnexpectedContinuation
-					}
-				} else {
-					// we are past the first frame of the series
-					continuation = !f.IsFIN()
-					if !f.Opcode().IsContinuation() {
-						err = ErrExpectedContinuation
-					}
-				}
-
-				if err != nil || !continuation {
+				if err != nil || done {
 					callback(err, readBytes, messageType)
 				} else {
-					s.asyncNextMessage(b, readBytes, continuation, messageType, callback)
+					s.asyncNextMessage(b, readBytes, true, messageType, callback)
 				}
 			}
 		}
 	})
 }
 
+// readMessageFrame reads the payload of the data frame f into b, which holds the first readBytes bytes of a message of
+// the given type. continuation is true if f is not the first frame of the message. It returns the number of bytes of
+// the message read so far and whether the message is complete. The payload of a compressed message is decompressed
+// into b once its last frame is read.
+func (s *Stream) readMessageFrame(
+	f Frame,
+	b []byte,
+	readBytes int,
+	messageType MessageType,
+	continuation bool,
+) (int, bool, error) {
+	if continuation && !f.Opcode().IsContinuation() {
+		return readBytes, true, ErrExpectedContinuation
+	}
+	if !continuation && f.Opcode().IsContinuation() {
+		return readBytes, true, ErrUnexpectedContinuation
+	}
+
+	if !s.inflating {
+		n := copy(b[readBytes:], f.Payload())
+		readBytes += n
+
+		if readBytes > s.maxMessageSize || n != f.PayloadLength() {
+			return readBytes, true, ErrMessageTooBig
+		}
+		return readBytes, f.IsFIN(), nil
+	}
+
+	if err := s.deflate.appendFrame(f.Payload(), s.maxMessageSize); err != nil {
+		return readBytes, true, err
+	}
+	if !f.IsFIN() {
+		return readBytes, false, nil
+	}
+
+	if len(b) > s.maxMessageSize {
+		b = b[:s.maxMessageSize]
+	}
+	n, err := s.deflate.decompress(b)
+	if err == nil && s.validateUTF8 && messageType == TypeText && !utf8.Valid(b[:n]) {
+		err = ErrInvalidUTF8
+	}
+	if err != nil && !errors.Is(err, ErrMessageTooBig) {
+		closeCode := CloseBadPayload
+		if !errors.Is(err, ErrInvalidUTF8) {
+			closeCode = CloseProtocolError
+			err = fmt.Errorf("%w: %v", ErrInvalidCompressedPayload, err)
+		}
+		s.state = StateClosedByUs
+		s.prepareClose(EncodeCloseFramePayload(closeCode, ""))
+	}
+	return n, true, err
+}
+
 func (s *Stream) handleFrame(f Frame) (err error) {
 	err = s.verifyFrame(f)
 
 	if err == nil {
+		if s.role == RoleServer {
+			// The payload is unmasked in place, in the read buffer.
+			f.UnmaskPayload()
+		}
+
 		if f.Opcode().IsControl() {
 			err = s.handleControlFrame(f)
 		} else {
@@ -452,15 +567,24 @@ func (s *Stream) handleFrame(f Frame) (err error) {
 
 	if err != nil {
 		s.state = StateClosedByUs
+		closeCode := CloseProtocolError
+		if err == ErrInvalidUTF8 {
+			closeCode = CloseBadPayload
+		}
 		// TODO consider flushing the close
-		s.prepareClose(EncodeCloseFramePayload(CloseProtocolError, ""))
+		s.prepareClose(EncodeCloseFramePayload(closeCode, ""))
 	}
 
 	return err
 }
 
 func (s *Stream) verifyFrame(f Frame) error {
-	if f.IsRSV1() || f.IsRSV2() || f.IsRSV3() {
+	if f.IsRSV2() || f.IsRSV3() {
+		return ErrNonZeroReservedBits
+	}
+
+	// RSV1 marks the first frame of a compressed message.
+	if f.IsRSV1() && (!s.deflate.negotiated || f.Opcode().IsControl() || f.Opcode().IsContinuation()) {
 		return ErrNonZeroReservedBits
 	}
 
@@ -494,6 +618,9 @@ func (s *Stream) handleControlFrame(f Frame) (err error) {
 			s.prepareWrite(pongFrame)
 		}
 	case OpcodePong:
+		if s.keepaliveOptions != nil {
+			s.onPong(f.Payload())
+		}
 	case OpcodeClose:
 		switch s.state {
 		case StateHandshake:
@@ -505,7 +632,7 @@ func (s *Stream) handleControlFrame(f Frame) (err error) {
 			// The first 2 bytes are the close code. The rest, if any, is the reason - this must be UTF-8.
 			if len(payload) >= 2 {
 				if !utf8.Valid(payload[2:]) {
-					s.prepareClose(EncodeCloseCode(CloseProtocolError))
+					s.prepareClose(EncodeCloseCode(CloseBadPayload))
 				} else {
 					closeCode := DecodeCloseCode(payload)
 					if !ValidCloseCode(closeCode) {
@@ -539,8 +666,19 @@ func (s *Stream) handleDataFrame(f Frame) error {
 		return ErrReservedOpcode
 	}
 
-	if f.Opcode().IsText() && s.validateUTF8 {
-		if !utf8.Valid(f.Payload()) {
+	if !f.Opcode().IsContinuation() {
+		s.inflating = f.IsRSV1()
+		if s.inflating {
+			s.deflate.beginMessage()
+		}
+
+		// The payload of a compressed message is validated once decompressed.
+		s.validatingUTF8 = f.Opcode().IsText() && s.validateUTF8 && !s.inflating
+		s.utf8Validator.reset()
+	}
+
+	if s.validatingUTF8 {
+		if !s.utf8Validator.valid(f.Payload()) || (f.IsFIN() && !s.utf8Validator.complete()) {
 			return ErrInvalidUTF8
 		}
 	}
@@ -565,13 +703,16 @@ func (s *Stream) Write(b []byte, messageType MessageType) error {
 		// reserve space for mask if client
 		f := s.AcquireFrame().
 			SetFIN().
-			SetOpcode(Opcode(messageType)).
-			SetPayload(b)
+			SetOpcode(Opcode(messageType))
+		if err := s.setMessagePayload(f, b); err != nil {
+			s.releaseFrame(f)
+			return err
+		}
 		s.prepareWrite(f)
 		return s.Flush()
 	}
 
-	return sonicerrors.ErrCancelled
+	return s.keepaliveError(sonicerrors.ErrCancelled)
 }
 
 // WriteFrame writes the supplied frame to the underlying stream.
@@ -588,7 +729,7 @@ func (s *Stream) WriteFrame(f *Frame) error {
 		return s.Flush()
 	} else {
 		s.releaseFrame(f)
-		return sonicerrors.ErrCancelled
+		return s.keepaliveError(sonicerrors.ErrCancelled)
 	}
 }
 
@@ -610,12 +751,16 @@ func (s *Stream) AsyncWrite(b []byte, messageType MessageType, callback func(err
 	if s.state == StateActive {
 		f := s.AcquireFrame().
 			SetFIN().
-			SetOpcode(Opcode(messageType)).
-			SetPayload(b)
+			SetOpcode(Opcode(messageType))
+		if err := s.setMessagePayload(f, b); err != nil {
+			s.releaseFrame(f)
+			callback(err)
+			return
+		}
 		s.prepareWrite(f)
 		s.AsyncFlush(callback)
 	} else {
-		callback(sonicerrors.ErrCancelled)
+		callback(s.keepaliveError(sonicerrors.ErrCancelled))
 	}
 }
 
@@ -633,13 +778,31 @@ func (s *Stream) AsyncWriteFrame(f *Frame, callback func(err error)) {
 		s.AsyncFlush(callback)
 	} else {
 		s.releaseFrame(f)
-		callback(sonicerrors.ErrCancelled)
+		callback(s.keepaliveError(sonicerrors.ErrCancelled))
+	}
+}
+
+// setMessagePayload sets the payload of the frame of a single frame message. The payload is compressed if
+// permessage-deflate is negotiated, unless it is smaller than the threshold or the message is a control frame.
+func (s *Stream) setMessagePayload(f *Frame, b []byte) error {
+	if s.deflate.negotiated && len(b) >= s.deflate.threshold && !f.Opcode().IsControl() {
+		compressed, err := s.deflate.compress(b)
+		if err != nil {
+			return err
+		}
+		f.SetRSV1()
+		b = compressed
 	}
+	f.SetPayload(b)
+	return nil
 }
 
 func (s *Stream) prepareWrite(f *Frame) {
 	if s.role == RoleClient {
 		f.MaskPayload()
+	} else {
+		// A server must not mask the frames it sends.
+		f.removeMask()
 	}
 	s.pendingFrames = append(s.pendingFrames, f)
 }
@@ -722,23 +885,50 @@ func (s *Stream) Flush() (err error) {
 
 // Flush writes any pending control frames to the underlying stream asynchronously.
 //
-// This call does not block.
+// This call does not block. If a flush is in progress, the callback is invoked once it has written the pending
+// frames.
 func (s *Stream) AsyncFlush(callback func(err error)) {
+	s.flushCbs = append(s.flushCbs, callback)
+	if !s.flushing {
+		s.flushNext()
+	}
+}
+
+func (s *Stream) flushNext() {
 	if len(s.pendingFrames) == 0 {
-		callback(nil)
-	} else {
-		sent := s.pendingFrames[0]
-		s.pendingFrames = s.pendingFrames[1:]
+		s.completeFlush(nil)
+		return
+	}
 
-		s.codecConn.AsyncWriteNext(*sent, func(err error, _ int) {
-			s.releaseFrame(sent)
+	sent := s.pendingFrames[0]
+	s.pendingFrames = s.pendingFrames[1:]
 
-			if err != nil {
-				callback(err)
-			} else {
-				s.AsyncFlush(callback)
-			}
-		})
+	s.flushing = true
+	s.codecConn.AsyncWriteNext(*sent, func(err error, _ int) {
+		s.releaseFrame(sent)
+
+		if err != nil {
+			s.completeFlush(s.keepaliveError(err))
+		} else {
+			s.flushNext()
+		}
+	})
+}
+
+// completeFlush invokes the callbacks of the AsyncFlush calls waiting for the flush. The callbacks can start another
+// flush, whose callbacks are kept apart.
+func (s *Stream) completeFlush(err error) {
+	s.flushing = false
+
+	cbs := s.flushCbs
+	s.flushCbs = s.flushCbsSpare[:0]
+	s.flushCbsSpare = nil
+	for i, cb := range cbs {
+		cbs[i] = nil
+		cb(err)
+	}
+	if s.flushCbsSpare == nil {
+		s.flushCbsSpare = cbs[:0]
 	}
 }
 
@@ -775,14 +965,7 @@ func (s *Stream) Handshake(addr string, extraHeaders ...Header) (err error) {
 	})
 	<-done
 
-	if err != nil {
-		s.state = StateTerminated
-	} else {
-		s.state = StateActive
-		err = s.init(stream)
-	}
-
-	return
+	return s.handshaked(stream, err)
 }
 
 // AsyncHandshake performs the WebSocket handshake asynchronously in the client role.
@@ -790,6 +973,10 @@ func (s *Stream) Handshake(addr string, extraHeaders ...Header) (err error) {
 // This call does not block. The provided callback is called when the request is sent and the response is
 // received or when an error occurs.
 //
+// The handshake runs on the IO: the connection is established, secured with TLS for wss:// addresses, and upgraded
+// with asynchronous operations. Only the host name of the address, if it is not an IP address and no dial address is
+// set with SetDialAddr, is resolved in a separate goroutine, whose result is posted to the IO.
+//
 // Extra headers should be generated by calling `ExtraHeader(...)`.
 func (s *Stream) AsyncHandshake(addr string, callback func(error), extraHeaders ...Header) {
 	if s.role != RoleClient {
@@ -799,22 +986,21 @@ func (s *Stream) AsyncHandshake(addr string, callback func(error), extraHeaders
 
 	s.reset()
 
-	// I know, this is horrible, but if you help me write a TLS client for sonic
-	// we can asynchronously dial endpoints and remove the need for a goroutine
-	go func() {
-		s.handshake(addr, extraHeaders, func(err error, stream sonic.Stream) {
-			// TODO maybe report this error somehow although this is very fatal
-			_ = s.ioc.Post(func() {
-				if err != nil {
-					s.state = StateTerminated
-				} else {
-					s.state = StateActive
-					err = s.init(stream)
-				}
-				callback(err)
-			})
-		})
-	}()
+	s.asyncHandshake(addr, extraHeaders, func(err error, stream sonic.Stream) {
+		callback(s.handshaked(stream, err))
+	})
+}
+
+func (s *Stream) handshaked(stream sonic.Stream, err error) error {
+	s.handshakeBuffer = s.handshakeBuffer[:0]
+
+	if err != nil {
+		s.state = StateTerminated
+		return err
+	}
+
+	s.state = StateActive
+	return s.init(stream)
 }
 
 func (s *Stream) handshake(addr string, headers []Header, callback func(err error, stream sonic.Stream)) {
@@ -831,6 +1017,30 @@ func (s *Stream) handshake(addr string, headers []Header, callback func(err erro
 	}
 }
 
+func (s *Stream) asyncHandshake(addr string, headers []Header, callback func(err error, stream sonic.Stream)) {
+	url, err := s.resolve(addr)
+	if err != nil {
+		callback(err, nil)
+		return
+	}
+
+	s.asyncLookup(url, func(err error, addr string) {
+		if err != nil {
+			callback(err, nil)
+			return
+		}
+		s.asyncDial(url, addr, func(err error, stream sonic.Stream) {
+			if err != nil {
+				callback(err, nil)
+				return
+			}
+			s.asyncUpgrade(url, stream, headers, func(err error) {
+				callback(err, stream)
+			})
+		})
+	})
+}
+
 func (s *Stream) resolve(addr string) (resolvedUrl *url.URL, err error) {
 	resolvedUrl, err = url.Parse(addr)
 	if err == nil {
@@ -847,19 +1057,46 @@ func (s *Stream) resolve(addr string) (resolvedUrl *url.URL, err error) {
 	return
 }
 
+// dialAddress returns the address to which the client connects to reach the URL: the dial address if one is set,
+// otherwise the host of the URL and its port, which defaults to the one of the scheme.
+func (s *Stream) dialAddress(url *url.URL) string {
+	if s.dialAddr != "" {
+		return s.dialAddr
+	}
+
+	port := url.Port()
+	if port == "" {
+		port = "80"
+		if url.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(url.Hostname(), port)
+}
+
+// tlsConfig returns the TLS configuration with which the client connects to the URL. The server name defaults to the
+// host of the URL.
+func (s *Stream) tlsConfig(url *url.URL) (*tls.Config, error) {
+	if s.tls == nil {
+		return nil, fmt.Errorf("wss:// scheme endpoints require a TLS configuration")
+	}
+	config := s.tls
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = url.Hostname()
+	}
+	return config, nil
+}
+
 func (s *Stream) dial(url *url.URL, callback func(err error, stream sonic.Stream)) {
 	var (
 		err  error
 		sc   syscall.Conn
-		port = url.Port()
+		addr = s.dialAddress(url)
 	)
 
 	switch url.Scheme {
 	case "http":
-		if port == "" {
-			port = "80"
-		}
-		addr := url.Hostname() + ":" + port
 		s.conn, err = net.DialTimeout("tcp", addr, DialTimeout)
 		if err == nil {
 			sc = s.conn.(syscall.Conn)
@@ -870,18 +1107,11 @@ func (s *Stream) dial(url *url.URL, callback func(err error, stream sonic.Stream
 			s.conn = nil
 		}
 	case "https":
-		if s.tls == nil {
-			err = fmt.Errorf(
-				"wss:// scheme endpoints require a TLS configuration",
-			)
-		}
+		var config *tls.Config
+		config, err = s.tlsConfig(url)
 
 		if err == nil {
-			if port == "" {
-				port = "443"
-			}
-			addr := url.Hostname() + ":" + port
-			s.conn, err = tls.DialWithDialer(s.dialer, "tcp", addr, s.tls)
+			s.conn, err = tls.DialWithDialer(s.dialer, "tcp", addr, config)
 			if err == nil {
 				sc = s.conn.(*tls.Conn).NetConn().(syscall.Conn)
 			} else {
@@ -907,17 +1137,123 @@ func (s *Stream) dial(url *url.URL, callback func(err error, stream sonic.Stream
 	}
 }
 
+// asyncLookup resolves the host of the URL and invokes the callback with the IP address and port to connect to. The
+// host is looked up only if it is not an IP address and no dial address is set. The resolver of the standard library
+// blocks, so the lookup is done in a separate goroutine, and the callback posted to the IO.
+func (s *Stream) asyncLookup(url *url.URL, callback func(err error, addr string)) {
+	addr := s.dialAddress(url)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		callback(err, addr)
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), DialTimeout)
+		defer cancel()
+
+		// sonic's sockets are IPv4 only.
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", host)
+		if err == nil {
+			addr = net.JoinHostPort(ips[0].String(), port)
+		}
+		_ = s.ioc.Post(func() {
+			callback(err, addr)
+		})
+	}()
+}
+
+// asyncDial connects to the IP address and port, and performs the TLS handshake if the URL is secure.
+func (s *Stream) asyncDial(url *url.URL, addr string, callback func(err error, stream sonic.Stream)) {
+	var config *tls.Config
+	switch url.Scheme {
+	case "http":
+	case "https":
+		var err error
+		if config, err = s.tlsConfig(url); err != nil {
+			callback(err, nil)
+			return
+		}
+	default:
+		callback(fmt.Errorf("invalid url scheme=%s", url.Scheme), nil)
+		return
+	}
+
+	sonic.AsyncDialTimeout(s.ioc, "tcp", addr, DialTimeout, func(err error, conn sonic.Conn) {
+		if err != nil {
+			callback(err, nil)
+			return
+		}
+
+		s.conn = conn
+		if config == nil {
+			callback(nil, conn)
+			return
+		}
+
+		tlsConn := sonic.NewTLSClient(s.ioc, conn, config)
+		s.conn = tlsConn
+		tlsConn.AsyncHandshake(func(err error) {
+			callback(err, tlsConn)
+		})
+	}, sonicopts.NoDelay(true))
+}
+
 func (s *Stream) upgrade(uri *url.URL, stream sonic.Stream, headers []Header) error {
-	req, err := http.NewRequest("GET", uri.String(), nil)
+	req, expectedKey, err := s.prepareUpgrade(uri, headers)
+	if err != nil {
+		return err
+	}
+
+	if err := writeHandshake(stream, s.handshakeBuffer); err != nil {
+		return err
+	}
+
+	n, err := s.readHead(stream)
 	if err != nil {
 		return err
 	}
+	return s.checkUpgradeResponse(n, req, expectedKey)
+}
+
+// asyncUpgrade is the asynchronous version of upgrade.
+func (s *Stream) asyncUpgrade(uri *url.URL, stream sonic.Stream, headers []Header, callback func(error)) {
+	req, expectedKey, err := s.prepareUpgrade(uri, headers)
+	if err != nil {
+		callback(err)
+		return
+	}
+
+	stream.AsyncWriteAll(s.handshakeBuffer, func(err error, _ int) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		s.asyncReadHead(stream, func(err error, n int) {
+			if err == nil {
+				err = s.checkUpgradeResponse(n, req, expectedKey)
+			}
+			callback(err)
+		})
+	})
+}
+
+// prepareUpgrade prepares the upgrade request in handshakeBuffer. It returns the request and the value of the
+// Sec-WebSocket-Accept header expected in the response.
+func (s *Stream) prepareUpgrade(uri *url.URL, headers []Header) (req *http.Request, expectedKey string, err error) {
+	req, err = http.NewRequest("GET", uri.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
 
 	sentKey, expectedKey := s.makeHandshakeKey()
 	req.Header.Set("Upgrade", "websocket")
 	req.Header.Set("Connection", "upgrade")
 	req.Header.Set("Sec-WebSocket-Key", string(sentKey))
 	req.Header.Set("Sec-Websocket-Version", "13")
+	if s.deflateOptions != nil {
+		req.Header.Set("Sec-WebSocket-Extensions", s.deflateOptions.offer())
+	}
 
 	for _, header := range headers {
 		if header.CanonicalKey {
@@ -938,42 +1274,30 @@ func (s *Stream) upgrade(uri *url.URL, stream sonic.Stream, headers []Header) er
 		s.upgradeRequestCallback(req)
 	}
 
-	err = req.Write(stream)
-	if err != nil {
-		return err
+	b := bytes.NewBuffer(s.handshakeBuffer[:0])
+	if err := req.Write(b); err != nil {
+		return nil, "", err
 	}
+	s.handshakeBuffer = b.Bytes()
 
-	s.handshakeBuffer = s.handshakeBuffer[:cap(s.handshakeBuffer)]
-	n, err := stream.Read(s.handshakeBuffer)
-	if err != nil {
-		return err
-	}
-	s.handshakeBuffer = s.handshakeBuffer[:n]
-	rd := bytes.NewReader(s.handshakeBuffer)
-	res, err := http.ReadResponse(bufio.NewReader(rd), req)
-	if err != nil {
-		return err
-	}
+	return req, expectedKey, nil
+}
 
-	rawRes, err := httputil.DumpResponse(res, true)
+// checkUpgradeResponse parses and validates the upgrade response whose head is the first n bytes of src. The bytes
+// following the response are frames the server sent right after it, and are left in src to be decoded.
+func (s *Stream) checkUpgradeResponse(n int, req *http.Request, expectedKey string) error {
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(s.src.Data()[:n])), req)
+	s.src.Consume(n)
 	if err != nil {
 		return err
 	}
 
-	resLen := len(rawRes)
-	extra := len(s.handshakeBuffer) - resLen
-	if extra > 0 {
-		// we got some frames as well with the handshake so we can put
-		// them in src for later decoding before clearing the handshake
-		// buffer
-		_, _ = s.src.Write(s.handshakeBuffer[resLen:])
-	}
-	s.handshakeBuffer = s.handshakeBuffer[:0]
-
 	if s.upgradeResponseCallback != nil {
 		s.upgradeResponseCallback(res)
 	}
 
+	s.subprotocol = res.Header.Get("Sec-WebSocket-Protocol")
+
 	if !IsUpgradeRes(res) {
 		return ErrCannotUpgrade
 	}
@@ -982,6 +1306,14 @@ func (s *Stream) upgrade(uri *url.URL, stream sonic.Stream, headers []Header) er
 		return ErrCannotUpgrade
 	}
 
+	params, ok, err := checkDeflateResponse(s.deflateOptions, res.Header)
+	if err != nil {
+		return err
+	}
+	if ok {
+		s.deflate.init(s.role, params, s.deflateOptions)
+	}
+
 	return nil
 }
 
@@ -1017,8 +1349,19 @@ func (s *Stream) ControlCallback() ControlCallback {
 	return s.controlCallback
 }
 
+// SetDialAddr sets the address, in the ip:port form, to which a client connects in the following handshakes, in place
+// of resolving the host of the address given to Handshake or AsyncHandshake. That host is still sent in the Host
+// header and used as the TLS server name. An empty address restores the resolution, which is the default.
+func (s *Stream) SetDialAddr(addr string) {
+	s.dialAddr = addr
+}
+
+func (s *Stream) DialAddr() string {
+	return s.dialAddr
+}
+
 // SetUpgradeRequestCallback sets a function that will be invoked during the handshake just before the upgrade request
-// is sent.
+// is sent. In the server role, it is invoked when the upgrade request is received, before it is validated.
 //
 // The caller must not perform any operations on the stream in the provided callback.
 func (s *Stream) SetUpgradeRequestCallback(upgradeRequestCallback UpgradeRequestCallback) {
@@ -1030,7 +1373,7 @@ func (s *Stream) UpgradeRequestCallback() UpgradeRequestCallback {
 }
 
 // SetUpgradeResponseCallback sets a function that will be invoked during the handshake just after the upgrade response
-// is received.
+// is received. In the server role, it is invoked just before the 101 response is sent, and can add headers to it.
 //
 // The caller must not perform any operations on the stream in the provided callback.
 func (s *Stream) SetUpgradeResponseCallback(upgradeResponseCallback UpgradeResponseCallback) {
@@ -1041,6 +1384,44 @@ func (s *Stream) UpgradeResponseCallback() UpgradeResponseCallback {
 	return s.upgradeResponseCallback
 }
 
+// SetOriginCallback sets a function that a server invokes with the Origin header of an upgrade request. The request is
+// rejected if it returns false. All origins are accepted if no callback is set.
+func (s *Stream) SetOriginCallback(originCallback OriginCallback) {
+	s.originCallback = originCallback
+}
+
+func (s *Stream) OriginCallback() OriginCallback {
+	return s.originCallback
+}
+
+// SetSubprotocolCallback sets a function that a server invokes with the subprotocols offered in an upgrade request, if
+// any. The subprotocol it returns is sent back to the client if it is one of those offered. No subprotocol is selected
+// if no callback is set.
+func (s *Stream) SetSubprotocolCallback(subprotocolCallback SubprotocolCallback) {
+	s.subprotocolCallback = subprotocolCallback
+}
+
+func (s *Stream) SubprotocolCallback() SubprotocolCallback {
+	return s.subprotocolCallback
+}
+
+// SetAcceptCallback sets a function that a server invokes with an upgrade request after validating its key, version
+// and origin. The request is rejected with 403 Forbidden if the function returns an error, which Accept then returns.
+//
+// The caller must not perform any operations on the stream in the provided callback.
+func (s *Stream) SetAcceptCallback(acceptCallback AcceptCallback) {
+	s.acceptCallback = acceptCallback
+}
+
+func (s *Stream) AcceptCallback() AcceptCallback {
+	return s.acceptCallback
+}
+
+// Subprotocol returns the subprotocol selected by the server during the handshake, or an empty string if none was.
+func (s *Stream) Subprotocol() string {
+	return s.subprotocol
+}
+
 // SetMaxMessageSize sets the maximum size of a message that can be read from or written to a peer.
 //
 // - If a message exceeds the limit while reading, the connection is closed abnormally.
@@ -1071,12 +1452,13 @@ func (s *Stream) LocalAddr() net.Addr {
 
 func (s *Stream) RawFd() int {
 	if s.NextLayer() != nil {
-		return s.NextLayer().(*sonic.AsyncAdapter).RawFd()
+		return s.NextLayer().RawFd()
 	}
 	return -1
 }
 
 func (s *Stream) CloseNextLayer() (err error) {
+	s.closeKeepalive()
 	if s.conn != nil {
 		err = s.conn.Close()
 		s.conn = nil
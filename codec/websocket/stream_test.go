@@ -331,8 +331,16 @@ func TestClientReconnectOnFailedRead(t *testing.T) {
 	srv := NewMockServer()
 	port := 0
 
+	// The servers after the first are sent before they listen, so that the client reconnects once they do.
+	servers := make(chan *MockServer, 1)
+
 	go func() {
+		defer close(servers)
 		for i := 0; i < 10; i++ {
+			if i > 0 {
+				servers <- srv
+			}
+
 			var err error
 			if port != 0 {
 				err = srv.Accept(fmt.Sprintf("localhost:%d", port))
@@ -372,6 +380,9 @@ func TestClientReconnectOnFailedRead(t *testing.T) {
 	onNextMessage = func(err error, n int, _ MessageType) {
 		if err != nil {
 			assertState(t, ws, StateTerminated)
+			if next, ok := <-servers; ok {
+				<-next.portChan
+			}
 			connect() // reconnect again
 		} else {
 			b = b[:n]
@@ -526,7 +537,7 @@ func TestClientSuccessfulHandshake(t *testing.T) {
 		if srv.IsClosed() {
 			break
 		}
-		ioc.RunOne()
+		_, _ = ioc.PollOne()
 	}
 }
 
@@ -581,7 +592,7 @@ func TestClientSuccessfulHandshakeWithExtraHeaders(t *testing.T) {
 	)
 
 	for !srv.IsClosed() {
-		ioc.RunOne()
+		_, _ = ioc.PollOne()
 	}
 
 	for key := range expected {
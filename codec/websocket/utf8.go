@@ -0,0 +1,108 @@
+package websocket
+
+import "unicode/utf8"
+
+// utf8Validator validates UTF-8 text received in pieces, such as the payloads of the frames of a fragmented message.
+// A code point can be split across pieces. The validation fails as soon as a piece cannot be part of valid text,
+// without waiting for the end of the code point it contains.
+type utf8Validator struct {
+	pending  [utf8.UTFMax]byte // the beginning of a code point continued in the next piece
+	npending int
+}
+
+func (v *utf8Validator) reset() {
+	v.npending = 0
+}
+
+// valid reports whether b can follow the pieces validated so far.
+func (v *utf8Validator) valid(b []byte) bool {
+	if v.npending > 0 {
+		need := utf8SequenceLength(v.pending[0])
+		n := copy(v.pending[v.npending:need], b)
+		b = b[n:]
+		v.npending += n
+
+		if v.npending < need {
+			return validUTF8Prefix(v.pending[:v.npending])
+		}
+		v.npending = 0
+		if !utf8.Valid(v.pending[:need]) {
+			return false
+		}
+	}
+
+	i := incompleteUTF8Suffix(b)
+	if !utf8.Valid(b[:i]) || !validUTF8Prefix(b[i:]) {
+		return false
+	}
+	v.npending = copy(v.pending[:], b[i:])
+	return true
+}
+
+// complete reports whether the pieces validated so far do not end in the middle of a code point.
+func (v *utf8Validator) complete() bool {
+	return v.npending == 0
+}
+
+// utf8SequenceLength returns the length of the sequence which starts with the byte c, or 0 if c cannot start a
+// multi-byte sequence.
+func utf8SequenceLength(c byte) int {
+	switch {
+	case c >= 0xC2 && c <= 0xDF:
+		return 2
+	case c >= 0xE0 && c <= 0xEF:
+		return 3
+	case c >= 0xF0 && c <= 0xF4:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// incompleteUTF8Suffix returns the index at which b ends with the beginning of a multi-byte sequence, or len(b) if it
+// does not.
+func incompleteUTF8Suffix(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-(utf8.UTFMax-1); i-- {
+		c := b[i]
+		if c < utf8.RuneSelf {
+			break
+		}
+		if c >= 0xC0 {
+			if len(b)-i < utf8SequenceLength(c) {
+				return i
+			}
+			break
+		}
+	}
+	return len(b)
+}
+
+// validUTF8Prefix reports whether the incomplete sequence b can be completed into a valid code point.
+func validUTF8Prefix(b []byte) bool {
+	if len(b) == 0 {
+		return true
+	}
+	if utf8SequenceLength(b[0]) <= len(b) {
+		return false
+	}
+	for i, c := range b[1:] {
+		lo, hi := byte(0x80), byte(0xBF)
+		if i == 0 {
+			// The second byte excludes the overlong encodings, the surrogates and the code points over U+10FFFF.
+			switch b[0] {
+			case 0xE0:
+				lo = 0xA0
+			case 0xED:
+				hi = 0x9F
+			case 0xF0:
+				lo = 0x90
+			case 0xF4:
+				hi = 0x8F
+			}
+		}
+		if c < lo || c > hi {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,262 @@
+package websocket
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/talostrading/sonic"
+)
+
+func TestUTF8ValidatorSplits(t *testing.T) {
+	texts := []string{
+		"hello",
+		"κόσμε",
+		"\u0080߿ࠀ￿\U00010000\U0010ffff",
+		"a€b𝄞c",
+	}
+
+	for _, text := range texts {
+		b := []byte(text)
+		for i := 0; i <= len(b); i++ {
+			for j := i; j <= len(b); j++ {
+				var v utf8Validator
+				if !v.valid(b[:i]) || !v.valid(b[i:j]) || !v.valid(b[j:]) || !v.complete() {
+					t.Fatalf("%q split at %d and %d is not valid", text, i, j)
+				}
+			}
+		}
+	}
+}
+
+func TestUTF8ValidatorFailsFast(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		invalid int // the index of the first byte which makes the text invalid
+	}{
+		{name: "surrogate", text: "κόσμε\xed\xa0\x80edited", invalid: 11},
+		{name: "over U+10FFFF", text: "ab\xf4\x90\x80\x80", invalid: 3},
+		{name: "overlong", text: "\xe0\x80\xaf", invalid: 1},
+		{name: "invalid byte", text: "ab\xc1\xbf", invalid: 2},
+		{name: "unexpected continuation", text: "a\x80", invalid: 1},
+		{name: "missing continuation", text: "\xe2\x82a", invalid: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var v utf8Validator
+			b := []byte(test.text)
+			for i := range b {
+				if valid := v.valid(b[i : i+1]); valid != (i < test.invalid) {
+					t.Fatalf("expected valid=%v at %d got=%v", i < test.invalid, i, valid)
+				}
+				if !v.valid(nil) && i < test.invalid {
+					t.Fatalf("an empty piece is not valid at %d", i)
+				}
+				if i == test.invalid {
+					break
+				}
+			}
+
+			v.reset()
+			if v.valid(b) {
+				t.Fatal("expected the whole text to be invalid")
+			}
+		})
+	}
+
+	var v utf8Validator
+	if !v.valid([]byte("a\xe2\x82")) || v.complete() {
+		t.Fatal("expected a valid but incomplete text")
+	}
+}
+
+// acceptRaw accepts a connection with srv and returns the client end of it, on which the server's response is
+// already read.
+func acceptRaw(t *testing.T, ioc *sonic.IO, srv *Stream) (net.Conn, *bufio.Reader) {
+	done := false
+	addr := acceptOne(t, ioc, srv, func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		done = true
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, err := io.WriteString(conn, testUpgradeRequest("")); err != nil {
+		t.Fatal(err)
+	}
+	pollUntil(t, ioc, func() bool { return done })
+
+	rd := bufio.NewReader(conn)
+	if _, err := http.ReadResponse(rd, nil); err != nil {
+		t.Fatal(err)
+	}
+	return conn, rd
+}
+
+func maskedFrame(fin bool, opcode Opcode, payload []byte) Frame {
+	f := NewFrame()
+	if fin {
+		f.SetFIN()
+	}
+	f.SetOpcode(opcode).SetIsMasked()
+	f.SetPayload(payload)
+	f.MaskPayload()
+	return f
+}
+
+func writeMaskedFrame(t *testing.T, conn net.Conn, fin bool, opcode Opcode, payload []byte) {
+	if _, err := conn.Write(maskedFrame(fin, opcode, payload)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectCloseCode(t *testing.T, rd *bufio.Reader, expected CloseCode) {
+	f := NewFrame()
+	if _, err := f.ReadFrom(rd); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Opcode().IsClose() {
+		t.Fatalf("expected a close frame got %s", f.Opcode())
+	}
+	if cc, _ := DecodeCloseFramePayload(f.Payload()); cc != expected {
+		t.Fatalf("expected close code %d got %d", expected, cc)
+	}
+}
+
+func TestStreamUTF8Fragmented(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	srv, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.ValidateUTF8(true)
+	conn, rd := acceptRaw(t, ioc, srv)
+
+	// The code points are split across the frames.
+	text := []byte("κόσμε 𝄞")
+	writeMaskedFrame(t, conn, false, OpcodeText, text[:1])
+	writeMaskedFrame(t, conn, false, OpcodeContinuation, text[1:len(text)-2])
+	writeMaskedFrame(t, conn, true, OpcodeContinuation, text[len(text)-2:])
+
+	var (
+		done    bool
+		readErr error
+		n       int
+	)
+	b := make([]byte, 128)
+	srv.AsyncNextMessage(b, func(err error, nn int, _ MessageType) {
+		readErr, n, done = err, nn, true
+	})
+	pollUntil(t, ioc, func() bool { return done })
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if string(b[:n]) != string(text) {
+		t.Fatalf("wrong message %q", b[:n])
+	}
+
+	// The invalid sequence fails the connection before the message ends.
+	writeMaskedFrame(t, conn, false, OpcodeText, []byte("hello \xed\xa0"))
+
+	done = false
+	srv.AsyncNextMessage(b, func(err error, _ int, _ MessageType) {
+		readErr, done = err, true
+		_ = srv.Flush()
+	})
+	pollUntil(t, ioc, func() bool { return done })
+	if readErr != ErrInvalidUTF8 {
+		t.Fatalf("expected ErrInvalidUTF8 got=%v", readErr)
+	}
+	expectCloseCode(t, rd, CloseBadPayload)
+}
+
+func TestStreamUTF8Incomplete(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	srv, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.ValidateUTF8(true)
+	conn, rd := acceptRaw(t, ioc, srv)
+
+	// The message ends in the middle of a code point.
+	writeMaskedFrame(t, conn, false, OpcodeText, []byte("hello"))
+	writeMaskedFrame(t, conn, true, OpcodeContinuation, []byte("\xe2\x82"))
+
+	var (
+		done    bool
+		readErr error
+	)
+	srv.AsyncNextMessage(make([]byte, 128), func(err error, _ int, _ MessageType) {
+		readErr, done = err, true
+		_ = srv.Flush()
+	})
+	pollUntil(t, ioc, func() bool { return done })
+	if readErr != ErrInvalidUTF8 {
+		t.Fatalf("expected ErrInvalidUTF8 got=%v", readErr)
+	}
+	expectCloseCode(t, rd, CloseBadPayload)
+}
+
+func TestStreamUTF8CloseReason(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	srv, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, rd := acceptRaw(t, ioc, srv)
+
+	writeMaskedFrame(t, conn, true, OpcodeClose, EncodeCloseFramePayload(CloseNormal, "bye \xff"))
+
+	done := false
+	srv.AsyncNextFrame(func(err error, _ Frame) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = srv.Flush()
+		done = true
+	})
+	pollUntil(t, ioc, func() bool { return done })
+	assertState(t, srv, StateClosedByPeer)
+	expectCloseCode(t, rd, CloseBadPayload)
+}
+
+func BenchmarkUTF8Validator(b *testing.B) {
+	payloads := map[string][]byte{
+		"ascii": []byte(strings.Repeat("the quick brown fox jumps over the lazy dog ", 100)),
+		"mixed": []byte(strings.Repeat("κόσμε the quick brown 𝄞 ", 100)),
+	}
+
+	for name, payload := range payloads {
+		b.Run(name, func(b *testing.B) {
+			var v utf8Validator
+			b.SetBytes(int64(len(payload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// Split the payload in frames, in the middle of code points.
+				v.reset()
+				for j := 0; j < len(payload); j += 1001 {
+					if !v.valid(payload[j:min(j+1001, len(payload))]) {
+						b.Fatal("invalid payload")
+					}
+				}
+			}
+		})
+	}
+}
@@ -1,15 +1,23 @@
 package sonic
 
 import (
+	"fmt"
+	"io"
 	"net"
+	"syscall"
 	"time"
 
+	"github.com/talostrading/sonic/sonicerrors"
 	"github.com/talostrading/sonic/sonicopts"
 
 	"github.com/talostrading/sonic/internal"
 )
 
-var _ Conn = &conn{}
+var (
+	_ Conn       = &conn{}
+	_ TCPInfoer  = &conn{}
+	_ HalfCloser = &conn{}
+)
 
 type conn struct {
 	*file
@@ -42,6 +50,153 @@ func DialTimeout(
 	return newConn(ioc, fd, localAddr, remoteAddr), nil
 }
 
+// AsyncDial connects asynchronously to a TCP address. The callback is invoked
+// once the connection is established or, if it could not be established
+// within 10 seconds, with sonicerrors.ErrTimeout.
+//
+// Only the connection is asynchronous: addr should be an IP address, as a host
+// name is resolved with a blocking lookup.
+func AsyncDial(
+	ioc *IO,
+	network, addr string,
+	cb func(error, Conn),
+	opts ...sonicopts.Option,
+) {
+	AsyncDialTimeout(ioc, network, addr, 10*time.Second, cb, opts...)
+}
+
+// AsyncDialTimeout is like AsyncDial but with a timeout.
+func AsyncDialTimeout(
+	ioc *IO,
+	network, addr string,
+	timeout time.Duration,
+	cb func(error, Conn),
+	opts ...sonicopts.Option,
+) {
+	if len(network) < 3 || network[:3] != "tcp" {
+		cb(fmt.Errorf("network %s not supported", network), nil)
+		return
+	}
+
+	fd, remoteAddr, err := internal.ConnectAsync(network, addr, opts...)
+	if err != nil {
+		cb(err, nil)
+		return
+	}
+	c := newConn(ioc, fd, nil, remoteAddr)
+	c.asyncConnect(timeout, func(done func(error)) {
+		done(nil)
+	}, cb)
+}
+
+// AsyncDialWithData connects asynchronously to a TCP address and writes data
+// as the first payload, like a login message. The callback is invoked once the
+// data is written or, if the connection could not be established within 10
+// seconds, with sonicerrors.ErrTimeout.
+//
+// On Linux the data is sent in the SYN with TCP Fast Open if the kernel holds
+// a cookie for the server from a previous connection, which saves a round
+// trip. Otherwise, or if Fast Open is disabled, it is written once connected.
+func AsyncDialWithData(
+	ioc *IO,
+	network, addr string,
+	data []byte,
+	cb func(error, Conn),
+	opts ...sonicopts.Option,
+) {
+	AsyncDialWithDataTimeout(ioc, network, addr, data, 10*time.Second, cb, opts...)
+}
+
+// AsyncDialWithDataTimeout is like AsyncDialWithData but with a timeout.
+func AsyncDialWithDataTimeout(
+	ioc *IO,
+	network, addr string,
+	data []byte,
+	timeout time.Duration,
+	cb func(error, Conn),
+	opts ...sonicopts.Option,
+) {
+	if len(network) < 3 || network[:3] != "tcp" {
+		cb(fmt.Errorf("network %s not supported", network), nil)
+		return
+	}
+
+	fd, remoteAddr, n, err := internal.ConnectFastOpen(network, addr, data, opts...)
+	if err != nil {
+		cb(err, nil)
+		return
+	}
+	c := newConn(ioc, fd, nil, remoteAddr)
+	c.asyncConnect(timeout, func(done func(error)) {
+		if n == len(data) {
+			done(nil)
+			return
+		}
+		c.AsyncWriteAll(data[n:], func(err error, _ int) {
+			done(err)
+		})
+	}, cb)
+}
+
+// asyncConnect waits for the connection of c, which is in progress, and then
+// invokes connected, which completes the dial by calling done. The dial fails
+// with sonicerrors.ErrTimeout if it is not complete within the timeout, in
+// which case c is closed.
+func (c *conn) asyncConnect(
+	timeout time.Duration,
+	connected func(done func(error)),
+	cb func(error, Conn),
+) {
+	timer, err := NewTimer(c.ioc)
+	if err != nil {
+		_ = c.Close()
+		cb(err, nil)
+		return
+	}
+
+	finished := false
+	finish := func(err error) {
+		if finished {
+			return
+		}
+		finished = true
+		_ = timer.Close()
+		if err != nil {
+			_ = c.Close()
+			cb(err, nil)
+			return
+		}
+		cb(nil, c)
+	}
+
+	if err := timer.ScheduleOnce(timeout, func() {
+		finish(sonicerrors.ErrTimeout)
+	}); err != nil {
+		finish(err)
+		return
+	}
+
+	c.file.slot.Set(internal.WriteEvent, func(err error) {
+		c.ioc.Deregister(&c.file.slot)
+		if err == nil {
+			err = internal.ConnectError(c.RawFd())
+		}
+		if err == nil {
+			c.localAddr, err = internal.SocketAddress(c.RawFd())
+		}
+		if err != nil {
+			finish(err)
+			return
+		}
+		connected(finish)
+	})
+	if err := c.ioc.SetWrite(&c.file.slot); err != nil {
+		finish(err)
+		return
+	}
+	c.ioc.Register(&c.file.slot)
+}
+
 func newConn(
 	ioc *IO,
 	fd int,
@@ -71,6 +226,47 @@ func (c *conn) SetWriteDeadline(t time.Time) error {
 	panic("not implemented")
 }
 
+// TCPInfo returns the kernel's statistics of the connection. It fails if the
+// connection is not a TCP connection.
+func (c *conn) TCPInfo() (TCPInfo, error) {
+	return getTCPInfo(c.file.slot.Fd)
+}
+
+func (c *conn) CloseRead() error {
+	if c.Closed() {
+		return io.EOF
+	}
+	return syscall.Shutdown(c.file.slot.Fd, syscall.SHUT_RD)
+}
+
+func (c *conn) CloseWrite() error {
+	if c.Closed() {
+		return io.EOF
+	}
+	return syscall.Shutdown(c.file.slot.Fd, syscall.SHUT_WR)
+}
+
+// AsyncShutdown shuts down the writing side once the pending asynchronous
+// write completes. If the connection is closed before, the callback is invoked
+// with sonicerrors.ErrCancelled.
+func (c *conn) AsyncShutdown(cb func(error)) {
+	c.file.whenWritesDone(func() {
+		if c.Closed() {
+			cb(sonicerrors.ErrCancelled)
+		} else {
+			cb(c.CloseWrite())
+		}
+	})
+}
+
+// connHalfCloser returns conn as a HalfCloser if it implements it.
+func connHalfCloser(conn Conn) (HalfCloser, error) {
+	if c, ok := conn.(HalfCloser); ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("%T cannot be half-closed", conn)
+}
+
 func (c *conn) RawFd() int {
 	return c.file.slot.Fd
 }
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/talostrading/sonic/sonicerrors"
 	"github.com/talostrading/sonic/sonicopts"
 )
 
@@ -468,3 +469,285 @@ func TestDispatchLimit(t *testing.T) {
 
 	marker <- struct{}{} // to close the write end
 }
+
+func TestConnAsyncShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The server drains the client until it shuts down its side, replies with
+	// the number of bytes it read and closes.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		_, _ = fmt.Fprintf(conn, "%d", len(b))
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Large enough for the write to be pending when shutting down.
+	payload := make([]byte, 8*1024*1024)
+
+	var (
+		wrote, shutdown, done bool
+		readErr               error
+		reply                 []byte
+		b                     = make([]byte, 128)
+	)
+	conn.AsyncWriteAll(payload, func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shutdown {
+			t.Fatal("shut down before the write completed")
+		}
+		wrote = true
+	})
+
+	var onRead AsyncCallback
+	onRead = func(err error, n int) {
+		reply = append(reply, b[:n]...)
+		if err != nil {
+			readErr = err
+			done = true
+			return
+		}
+		conn.AsyncRead(b, onRead)
+	}
+	conn.(HalfCloser).AsyncShutdown(func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !wrote {
+			t.Fatal("shut down before the write completed")
+		}
+		shutdown = true
+		conn.AsyncRead(b, onRead)
+	})
+	runUntil(t, ioc, &done)
+
+	if readErr != io.EOF {
+		t.Fatalf("expected io.EOF got=%v", readErr)
+	}
+	if string(reply) != fmt.Sprint(len(payload)) {
+		t.Fatalf("unexpected reply=%s", string(reply))
+	}
+}
+
+func TestConnCloseRead(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.(HalfCloser).CloseRead(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected io.EOF got=%v", err)
+	}
+
+	// The writing side is still open.
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnAsyncReadAllShortReads(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The peer writes the payload in two parts, so the first read is short.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("hel"))
+		time.Sleep(10 * time.Millisecond)
+		_, _ = conn.Write([]byte("lo"))
+		_, _ = conn.Read(make([]byte, 1))
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var (
+		done    bool
+		readErr error
+		n       int
+	)
+	b := make([]byte, 5)
+	conn.AsyncReadAll(b, func(err error, nn int) {
+		readErr, n, done = err, nn, true
+	})
+	runUntil(t, ioc, &done)
+
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if n != len(b) || string(b) != "hello" {
+		t.Fatalf("short read n=%d b=%q", n, b)
+	}
+}
+
+func TestConnAsyncWriteAllShortWrites(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The payload does not fit in the socket buffers, so the writes are short.
+	payload := make([]byte, 16*1024*1024)
+	received := make(chan int, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			received <- 0
+			return
+		}
+		defer conn.Close()
+		time.Sleep(10 * time.Millisecond)
+		n, _ := io.ReadFull(conn, make([]byte, len(payload)))
+		received <- n
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var (
+		done     bool
+		writeErr error
+		n        int
+	)
+	conn.AsyncWriteAll(payload, func(err error, nn int) {
+		writeErr, n, done = err, nn, true
+	})
+	runUntil(t, ioc, &done)
+
+	if writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	if n != len(payload) {
+		t.Fatalf("short write n=%d", n)
+	}
+	if r := <-received; r != len(payload) {
+		t.Fatalf("the peer received %d bytes", r)
+	}
+}
+
+func TestConnAsyncDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	var (
+		done bool
+		conn Conn
+	)
+	AsyncDial(ioc, "tcp", addr, func(err error, c Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, done = c, true
+	})
+	runUntil(t, ioc, &done)
+	defer conn.Close()
+
+	if conn.LocalAddr() == nil || conn.RemoteAddr().String() != addr {
+		t.Fatalf("wrong addresses local=%v remote=%v", conn.LocalAddr(), conn.RemoteAddr())
+	}
+
+	b := make([]byte, 5)
+	done = false
+	conn.AsyncWriteAll([]byte("hello"), func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.AsyncReadAll(b, func(err error, _ int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			done = true
+		})
+	})
+	runUntil(t, ioc, &done)
+	if string(b) != "hello" {
+		t.Fatalf("wrong echo %q", b)
+	}
+
+	// Nobody listens on the address once the listener is closed.
+	ln.Close()
+	var dialErr error
+	done = false
+	AsyncDial(ioc, "tcp", addr, func(err error, _ Conn) {
+		dialErr, done = err, true
+	})
+	runUntil(t, ioc, &done)
+	if dialErr != sonicerrors.ErrConnRefused {
+		t.Fatalf("expected ErrConnRefused got=%v", dialErr)
+	}
+}
@@ -0,0 +1,109 @@
+package sonic
+
+import (
+	"net/netip"
+	"time"
+)
+
+// ControlFlags is a bitmask of the ancillary data, or control messages, that
+// can be sent or received along with a datagram.
+type ControlFlags uint32
+
+const (
+	// ControlPacketInfo is IP_PKTINFO/IPV6_PKTINFO: the interface and
+	// destination address of a received datagram, or the interface and source
+	// address of an outgoing one.
+	ControlPacketInfo ControlFlags = 1 << iota
+
+	// ControlTTL is IP_TTL/IPV6_HOPLIMIT.
+	ControlTTL
+
+	// ControlTOS is IP_TOS/IPV6_TCLASS.
+	ControlTOS
+
+	// ControlDropped is SO_RXQ_OVFL: the cumulative number of datagrams the
+	// kernel dropped on the socket because its receive buffer was full.
+	ControlDropped
+
+	// ControlTimestamp is SO_TIMESTAMPNS: the time at which the kernel
+	// received the datagram.
+	ControlTimestamp
+)
+
+func (f ControlFlags) String() string {
+	var s string
+	add := func(name string) {
+		if len(s) > 0 {
+			s += "|"
+		}
+		s += name
+	}
+	if f&ControlPacketInfo != 0 {
+		add("packet_info")
+	}
+	if f&ControlTTL != 0 {
+		add("ttl")
+	}
+	if f&ControlTOS != 0 {
+		add("tos")
+	}
+	if f&ControlDropped != 0 {
+		add("dropped")
+	}
+	if f&ControlTimestamp != 0 {
+		add("timestamp")
+	}
+	if len(s) == 0 {
+		return "none"
+	}
+	return s
+}
+
+// ControlMessage is the typed form of the ancillary data read with
+// Socket.RecvMsg or written with Socket.SendMsg.
+//
+// A ControlMessage can be reused across reads: Parse resets it before
+// decoding, and no memory is allocated in the process.
+type ControlMessage struct {
+	// Flags marks which of the below fields are valid. Parse sets a flag for
+	// each control message it decodes. Marshal only encodes the fields whose
+	// flag is set.
+	Flags ControlFlags
+
+	// IfIndex is the index of the interface on which the datagram arrived
+	// or, when sending, the interface on which it should leave. 0 means any
+	// interface.
+	IfIndex int
+
+	// Dst is the destination address of a received datagram. For multicast,
+	// this is the group the datagram was sent to.
+	Dst netip.Addr
+
+	// Src is the source address of an outgoing datagram. If left invalid, the
+	// kernel picks it.
+	Src netip.Addr
+
+	// TTL is the time-to-live, or hop limit for IPv6, of the datagram.
+	TTL int
+
+	// TOS is the type-of-service, or traffic class for IPv6, of the datagram.
+	TOS int
+
+	// Dropped is the cumulative number of datagrams dropped by the kernel on
+	// the socket at the time this datagram was received. Only ever received.
+	Dropped uint32
+
+	// Timestamp is the time the kernel received the datagram. Only ever
+	// received.
+	Timestamp time.Time
+}
+
+// Reset clears all fields of the control message.
+func (cm *ControlMessage) Reset() {
+	*cm = ControlMessage{}
+}
+
+// Has returns true if all control messages in flags are set.
+func (cm *ControlMessage) Has(flags ControlFlags) bool {
+	return cm.Flags&flags == flags
+}
@@ -0,0 +1,37 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package sonic
+
+import (
+	"fmt"
+)
+
+var errControlMessageNotSupported = fmt.Errorf(
+	"control messages are not yet supported on BSD")
+
+// ControlMessageSpace returns the number of bytes the out-of-band buffer
+// passed to Socket.RecvMsg must have in order to receive all control messages
+// in flags. Control messages are not yet supported on BSD, so this is always
+// 0.
+func ControlMessageSpace(flags ControlFlags) int {
+	return 0
+}
+
+// SetControlMessage is not yet supported on BSD.
+func (s *Socket) SetControlMessage(flags ControlFlags, on bool) error {
+	return errControlMessageNotSupported
+}
+
+// Parse is not yet supported on BSD.
+func (cm *ControlMessage) Parse(oob []byte) error {
+	cm.Reset()
+	if len(oob) > 0 {
+		return errControlMessageNotSupported
+	}
+	return nil
+}
+
+// Marshal is not yet supported on BSD. It returns b unchanged.
+func (cm *ControlMessage) Marshal(b []byte, domain SocketDomain) []byte {
+	return b
+}
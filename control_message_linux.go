@@ -0,0 +1,266 @@
+//go:build linux
+
+package sonic
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ControlMessageSpace returns the number of bytes the out-of-band buffer
+// passed to Socket.RecvMsg must have in order to receive all control messages
+// in flags, for both IPv4 and IPv6.
+func ControlMessageSpace(flags ControlFlags) (n int) {
+	if flags&ControlPacketInfo != 0 {
+		n += unix.CmsgSpace(unix.SizeofInet6Pktinfo)
+	}
+	if flags&ControlTTL != 0 {
+		n += unix.CmsgSpace(4)
+	}
+	if flags&ControlTOS != 0 {
+		n += unix.CmsgSpace(4)
+	}
+	if flags&ControlDropped != 0 {
+		n += unix.CmsgSpace(4)
+	}
+	if flags&ControlTimestamp != 0 {
+		n += unix.CmsgSpace(int(unsafe.Sizeof(unix.Timespec{})))
+	}
+	return n
+}
+
+// SetControlMessage enables or disables the reception of the given control
+// messages on the socket. The control messages are then returned in the oob
+// buffer of RecvMsg.
+func (s *Socket) SetControlMessage(flags ControlFlags, on bool) error {
+	v := 0
+	if on {
+		v = 1
+	}
+
+	set := func(level, opt int) error {
+		return syscall.SetsockoptInt(s.fd, level, opt, v)
+	}
+
+	ipv6 := s.domain == SocketDomainIPv6
+	if flags&ControlPacketInfo != 0 {
+		var err error
+		if ipv6 {
+			err = set(unix.IPPROTO_IPV6, unix.IPV6_RECVPKTINFO)
+		} else {
+			err = set(unix.IPPROTO_IP, unix.IP_PKTINFO)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot set packet_info err=%v", err)
+		}
+	}
+	if flags&ControlTTL != 0 {
+		var err error
+		if ipv6 {
+			err = set(unix.IPPROTO_IPV6, unix.IPV6_RECVHOPLIMIT)
+		} else {
+			err = set(unix.IPPROTO_IP, unix.IP_RECVTTL)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot set ttl err=%v", err)
+		}
+	}
+	if flags&ControlTOS != 0 {
+		var err error
+		if ipv6 {
+			err = set(unix.IPPROTO_IPV6, unix.IPV6_RECVTCLASS)
+		} else {
+			err = set(unix.IPPROTO_IP, unix.IP_RECVTOS)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot set tos err=%v", err)
+		}
+	}
+	if flags&ControlDropped != 0 {
+		if err := set(unix.SOL_SOCKET, unix.SO_RXQ_OVFL); err != nil {
+			return fmt.Errorf("cannot set dropped err=%v", err)
+		}
+	}
+	if flags&ControlTimestamp != 0 {
+		if err := set(unix.SOL_SOCKET, unix.SO_TIMESTAMPNS); err != nil {
+			return fmt.Errorf("cannot set timestamp err=%v", err)
+		}
+	}
+	return nil
+}
+
+// Parse decodes the out-of-band bytes returned by Socket.RecvMsg into cm.
+// Control messages that are not known are skipped.
+func (cm *ControlMessage) Parse(oob []byte) error {
+	cm.Reset()
+
+	for len(oob) >= unix.SizeofCmsghdr {
+		hdr, data, rest, err := unix.ParseOneSocketControlMessage(oob)
+		if err != nil {
+			return err
+		}
+		oob = rest
+
+		switch hdr.Level {
+		case unix.IPPROTO_IP:
+			switch hdr.Type {
+			case unix.IP_PKTINFO:
+				if len(data) < unix.SizeofInet4Pktinfo {
+					return errShortControlMessage(hdr)
+				}
+				/* #nosec G103 -- the use of unsafe has been audited */
+				info := (*unix.Inet4Pktinfo)(unsafe.Pointer(&data[0]))
+				cm.IfIndex = int(info.Ifindex)
+				cm.Dst = netip.AddrFrom4(info.Addr)
+				cm.Flags |= ControlPacketInfo
+			case unix.IP_TTL:
+				if len(data) < 4 {
+					return errShortControlMessage(hdr)
+				}
+				cm.TTL = int(int32(binary.NativeEndian.Uint32(data)))
+				cm.Flags |= ControlTTL
+			case unix.IP_TOS:
+				// On receive, the kernel passes the TOS as a single byte.
+				if len(data) < 1 {
+					return errShortControlMessage(hdr)
+				}
+				cm.TOS = int(data[0])
+				cm.Flags |= ControlTOS
+			}
+		case unix.IPPROTO_IPV6:
+			switch hdr.Type {
+			case unix.IPV6_PKTINFO:
+				if len(data) < unix.SizeofInet6Pktinfo {
+					return errShortControlMessage(hdr)
+				}
+				/* #nosec G103 -- the use of unsafe has been audited */
+				info := (*unix.Inet6Pktinfo)(unsafe.Pointer(&data[0]))
+				cm.IfIndex = int(info.Ifindex)
+				cm.Dst = netip.AddrFrom16(info.Addr)
+				cm.Flags |= ControlPacketInfo
+			case unix.IPV6_HOPLIMIT:
+				if len(data) < 4 {
+					return errShortControlMessage(hdr)
+				}
+				cm.TTL = int(int32(binary.NativeEndian.Uint32(data)))
+				cm.Flags |= ControlTTL
+			case unix.IPV6_TCLASS:
+				if len(data) < 4 {
+					return errShortControlMessage(hdr)
+				}
+				cm.TOS = int(int32(binary.NativeEndian.Uint32(data)))
+				cm.Flags |= ControlTOS
+			}
+		case unix.SOL_SOCKET:
+			switch hdr.Type {
+			case unix.SO_RXQ_OVFL:
+				if len(data) < 4 {
+					return errShortControlMessage(hdr)
+				}
+				cm.Dropped = binary.NativeEndian.Uint32(data)
+				cm.Flags |= ControlDropped
+			case unix.SCM_TIMESTAMPNS:
+				if len(data) < int(unsafe.Sizeof(unix.Timespec{})) {
+					return errShortControlMessage(hdr)
+				}
+				/* #nosec G103 -- the use of unsafe has been audited */
+				ts := (*unix.Timespec)(unsafe.Pointer(&data[0]))
+				cm.Timestamp = time.Unix(ts.Unix())
+				cm.Flags |= ControlTimestamp
+			}
+		}
+	}
+
+	return nil
+}
+
+func errShortControlMessage(hdr unix.Cmsghdr) error {
+	return fmt.Errorf(
+		"short control message level=%d type=%d len=%d",
+		hdr.Level, hdr.Type, hdr.Len)
+}
+
+// Marshal appends the control messages marked in cm.Flags to b, encoded for
+// the given socket domain, and returns the extended buffer. The result can be
+// passed as the oob argument of Socket.SendMsg.
+//
+// Only ControlPacketInfo, ControlTTL and ControlTOS can be sent.
+func (cm *ControlMessage) Marshal(b []byte, domain SocketDomain) []byte {
+	ipv6 := domain == SocketDomainIPv6
+
+	if cm.Flags&ControlPacketInfo != 0 {
+		if ipv6 {
+			var data []byte
+			b, data = appendControlMessage(
+				b, unix.IPPROTO_IPV6, unix.IPV6_PKTINFO, unix.SizeofInet6Pktinfo)
+			/* #nosec G103 -- the use of unsafe has been audited */
+			info := (*unix.Inet6Pktinfo)(unsafe.Pointer(&data[0]))
+			info.Ifindex = uint32(cm.IfIndex)
+			if cm.Src.IsValid() {
+				info.Addr = cm.Src.As16()
+			}
+		} else {
+			var data []byte
+			b, data = appendControlMessage(
+				b, unix.IPPROTO_IP, unix.IP_PKTINFO, unix.SizeofInet4Pktinfo)
+			/* #nosec G103 -- the use of unsafe has been audited */
+			info := (*unix.Inet4Pktinfo)(unsafe.Pointer(&data[0]))
+			info.Ifindex = int32(cm.IfIndex)
+			if cm.Src.IsValid() {
+				info.Spec_dst = cm.Src.As4()
+			}
+		}
+	}
+	if cm.Flags&ControlTTL != 0 {
+		var data []byte
+		if ipv6 {
+			b, data = appendControlMessage(
+				b, unix.IPPROTO_IPV6, unix.IPV6_HOPLIMIT, 4)
+		} else {
+			b, data = appendControlMessage(b, unix.IPPROTO_IP, unix.IP_TTL, 4)
+		}
+		binary.NativeEndian.PutUint32(data, uint32(cm.TTL))
+	}
+	if cm.Flags&ControlTOS != 0 {
+		var data []byte
+		if ipv6 {
+			b, data = appendControlMessage(
+				b, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, 4)
+		} else {
+			b, data = appendControlMessage(b, unix.IPPROTO_IP, unix.IP_TOS, 4)
+		}
+		binary.NativeEndian.PutUint32(data, uint32(cm.TOS))
+	}
+
+	return b
+}
+
+// appendControlMessage appends a zeroed control message with room for n data
+// bytes to b. It returns the extended buffer and the data part of the
+// appended control message.
+func appendControlMessage(
+	b []byte,
+	level, typ int32,
+	n int,
+) (extended []byte, data []byte) {
+	off := len(b)
+	space := unix.CmsgSpace(n)
+	for i := 0; i < space; i++ {
+		b = append(b, 0)
+	}
+
+	/* #nosec G103 -- the use of unsafe has been audited */
+	hdr := (*unix.Cmsghdr)(unsafe.Pointer(&b[off]))
+	hdr.Level = level
+	hdr.Type = typ
+	hdr.SetLen(unix.CmsgLen(n))
+
+	start := off + unix.CmsgLen(0)
+	return b, b[start : start+n]
+}
@@ -0,0 +1,132 @@
+//go:build linux
+
+package sonic
+
+import (
+	"net/netip"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+func TestControlMessageMarshalParse(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, domain := range []SocketDomain{SocketDomainIPv4, SocketDomainIPv6} {
+		cm := ControlMessage{
+			Flags: ControlTTL | ControlTOS,
+			TTL:   42,
+			TOS:   0x10,
+		}
+		oob := cm.Marshal(nil, domain)
+		assert.NotEmpty(oob)
+
+		var parsed ControlMessage
+		assert.Nil(parsed.Parse(oob))
+		assert.True(parsed.Has(ControlTTL))
+		assert.Equal(42, parsed.TTL)
+
+		if domain == SocketDomainIPv6 {
+			// On IPv4 the kernel encodes the received TOS as a single byte so
+			// it only round-trips on IPv6.
+			assert.True(parsed.Has(ControlTOS))
+			assert.Equal(0x10, parsed.TOS)
+		}
+	}
+}
+
+func TestControlMessageParseEmpty(t *testing.T) {
+	assert := assert.New(t)
+
+	cm := ControlMessage{Flags: ControlTTL, TTL: 1}
+	assert.Nil(cm.Parse(nil))
+	assert.Equal(ControlFlags(0), cm.Flags)
+	assert.Equal(0, cm.TTL)
+}
+
+func TestControlFlagsString(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("none", ControlFlags(0).String())
+	assert.Equal("packet_info|dropped", (ControlPacketInfo | ControlDropped).String())
+}
+
+func TestSocketRecvMsgSendMsg(t *testing.T) {
+	assert := assert.New(t)
+
+	flags := ControlPacketInfo | ControlTTL | ControlTOS | ControlDropped | ControlTimestamp
+
+	reader, err := NewSocket(SocketDomainIPv4, SocketTypeDatagram, SocketProtocolUDP)
+	assert.Nil(err)
+	defer reader.Close()
+	assert.Nil(reader.Bind(netip.MustParseAddrPort("127.0.0.1:0")))
+	assert.Nil(reader.SetControlMessage(flags, true))
+
+	sa, err := syscall.Getsockname(reader.RawFd())
+	assert.Nil(err)
+	readerAddr := netip.AddrPortFrom(
+		netip.AddrFrom4(sa.(*syscall.SockaddrInet4).Addr),
+		uint16(sa.(*syscall.SockaddrInet4).Port))
+
+	writer, err := NewSocket(SocketDomainIPv4, SocketTypeDatagram, SocketProtocolUDP)
+	assert.Nil(err)
+	defer writer.Close()
+
+	out := ControlMessage{
+		Flags: ControlTTL | ControlTOS,
+		TTL:   17,
+		TOS:   0x20,
+	}
+	oob := out.Marshal(nil, SocketDomainIPv4)
+
+	before := time.Now()
+	n, err := writer.SendMsg([]byte("hello"), oob, 0, readerAddr)
+	assert.Nil(err)
+	assert.Equal(5, n)
+
+	var (
+		b     = make([]byte, 128)
+		inOOB = make([]byte, ControlMessageSpace(flags))
+	)
+	n, oobn, _, from, err := reader.RecvMsg(b, inOOB, 0)
+	assert.Nil(err)
+	assert.Equal("hello", string(b[:n]))
+	assert.True(from.Addr().IsLoopback())
+
+	var in ControlMessage
+	assert.Nil(in.Parse(inOOB[:oobn]))
+
+	assert.True(in.Has(ControlPacketInfo))
+	assert.Equal(readerAddr.Addr(), in.Dst)
+	assert.NotZero(in.IfIndex)
+
+	assert.True(in.Has(ControlTTL))
+	assert.Equal(17, in.TTL)
+
+	assert.True(in.Has(ControlTOS))
+	assert.Equal(0x20, in.TOS)
+
+	assert.True(in.Has(ControlTimestamp))
+	assert.False(in.Timestamp.Before(before.Add(-time.Second)))
+
+	// SO_RXQ_OVFL is only reported once the kernel dropped something.
+	if in.Has(ControlDropped) {
+		assert.Equal(uint32(0), in.Dropped)
+	}
+}
+
+func TestSocketRecvMsgWouldBlock(t *testing.T) {
+	assert := assert.New(t)
+
+	sock, err := NewSocket(SocketDomainIPv4, SocketTypeDatagram, SocketProtocolUDP)
+	assert.Nil(err)
+	defer sock.Close()
+	assert.Nil(sock.SetNonblocking(true))
+	assert.Nil(sock.Bind(netip.MustParseAddrPort("127.0.0.1:0")))
+
+	_, _, _, _, err = sock.RecvMsg(make([]byte, 16), make([]byte, 64), 0)
+	assert.Equal(sonicerrors.ErrWouldBlock, err)
+}
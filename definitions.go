@@ -125,6 +125,34 @@ type Conn interface {
 	net.Conn
 }
 
+// HalfCloser is implemented by the connections whose reading and writing sides
+// can be shut down independently, like the ones returned by Dial, DialTLS and
+// SocketPair.
+type HalfCloser interface {
+	// CloseRead shuts down the reading side of the connection. Subsequent
+	// reads return io.EOF.
+	CloseRead() error
+
+	// CloseWrite shuts down the writing side of the connection. The peer reads
+	// io.EOF once it received everything written before.
+	CloseWrite() error
+
+	// AsyncShutdown shuts down the writing side of the connection once the
+	// pending asynchronous writes complete. The connection can still be read
+	// until the peer closes its side, after which reads return io.EOF.
+	AsyncShutdown(cb func(error))
+}
+
+// TCPInfoer is implemented by the connections which report the kernel's
+// statistics of their TCP connection, like the ones returned by Dial and
+// DialTLS.
+type TCPInfoer interface {
+	// TCPInfo returns the kernel's statistics of a TCP connection: round-trip
+	// time, retransmits, congestion window etc. It fails for non-TCP
+	// connections.
+	TCPInfo() (TCPInfo, error)
+}
+
 type AsyncReadCallbackPacket func(error, int, net.Addr)
 type AsyncWriteCallbackPacket func(error)
 
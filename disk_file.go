@@ -0,0 +1,376 @@
+package sonic
+
+import (
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// DiskFile is a regular file whose I/O does not block the IO.
+//
+// Regular files are always ready as far as the poller is concerned, so reading
+// or writing them through a File blocks the goroutine running the IO for as
+// long as the disk takes. A DiskFile instead hands its operations to a small
+// pool of goroutines shared by all DiskFiles, and completes them on the IO.
+//
+// The operations of a DiskFile run one at a time, in the order they were
+// issued. So an AsyncFsync issued after an AsyncWriteAt covers it, and an
+// AsyncReadAt issued after an AsyncWriteAt sees what was written, without
+// waiting for the first operation to complete before issuing the second.
+//
+// The buffers given to the asynchronous operations must not be used until
+// their callback is invoked.
+type DiskFile struct {
+	ioc      *IO
+	fd       int
+	notifier *Notifier
+
+	// Guards the fields shared with the pool.
+	mu      sync.Mutex
+	queued  []*diskOp // issued, waiting for a worker
+	done    []*diskOp // run, waiting to be completed on the IO
+	running bool      // a worker is running the queued operations
+
+	pending    int // operations issued and not yet completed
+	waiting    bool
+	closed     bool
+	released   bool
+	onNotifyFn func(error, uint64)
+}
+
+type diskOpKind uint8
+
+const (
+	diskRead diskOpKind = iota
+	diskWrite
+	diskFsync
+	diskFallocate
+	diskCall
+)
+
+type diskOp struct {
+	kind diskOpKind
+	b    []byte
+	off  int64
+	len  int64
+
+	fn    func() error  // for calls
+	cb    AsyncCallback // for reads and writes
+	errCb func(error)   // for the other operations
+
+	n   int
+	err error
+}
+
+// OpenDiskFile opens the named regular file with the given flags, like
+// os.OpenFile.
+func OpenDiskFile(ioc *IO, path string, flags int, mode os.FileMode) (*DiskFile, error) {
+	fd, err := syscall.Open(path, flags|syscall.O_CLOEXEC, uint32(mode))
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
+	f, err := newDiskFile(ioc, fd)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+	return f, nil
+}
+
+// newDiskFile creates a DiskFile which owns fd.
+func newDiskFile(ioc *IO, fd int) (*DiskFile, error) {
+	notifier, err := NewNotifier(ioc)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &DiskFile{
+		ioc:      ioc,
+		fd:       fd,
+		notifier: notifier,
+	}
+	f.onNotifyFn = f.onNotify
+	return f, nil
+}
+
+// ReadAt reads len(b) bytes at offset off, blocking. It returns io.EOF if
+// fewer bytes are read, like io.ReaderAt.
+func (f *DiskFile) ReadAt(b []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, io.EOF
+	}
+	return preadFull(f.fd, b, off)
+}
+
+// WriteAt writes b at offset off, blocking.
+func (f *DiskFile) WriteAt(b []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, io.EOF
+	}
+	return pwriteFull(f.fd, b, off)
+}
+
+// AsyncReadAt reads len(b) bytes at offset off. The callback is invoked with
+// io.EOF if fewer bytes are read, like io.ReaderAt.
+func (f *DiskFile) AsyncReadAt(b []byte, off int64, cb AsyncCallback) {
+	f.submit(&diskOp{kind: diskRead, b: b, off: off, cb: cb})
+}
+
+// AsyncWriteAt writes b at offset off.
+func (f *DiskFile) AsyncWriteAt(b []byte, off int64, cb AsyncCallback) {
+	f.submit(&diskOp{kind: diskWrite, b: b, off: off, cb: cb})
+}
+
+// AsyncFsync flushes the file to the disk, including what was written by the
+// operations issued before.
+func (f *DiskFile) AsyncFsync(cb func(error)) {
+	f.submit(&diskOp{kind: diskFsync, errCb: cb})
+}
+
+// AsyncFallocate allocates the disk space for n bytes at offset off, growing
+// the file if needed, so that writing them later does not fail for lack of
+// space. On BSD the file is only grown.
+func (f *DiskFile) AsyncFallocate(off, n int64, cb func(error)) {
+	f.submit(&diskOp{kind: diskFallocate, off: off, len: n, errCb: cb})
+}
+
+// asyncCall runs fn like the other operations of the file, in order, and
+// invokes the callback with its error.
+func (f *DiskFile) asyncCall(fn func() error, cb func(error)) {
+	f.submit(&diskOp{kind: diskCall, fn: fn, errCb: cb})
+}
+
+func (f *DiskFile) submit(op *diskOp) {
+	if f.closed {
+		op.err = io.EOF
+		f.complete(op)
+		return
+	}
+
+	f.pending++
+	if !f.waiting {
+		f.waiting = true
+		f.notifier.AsyncWait(f.onNotifyFn)
+	}
+
+	f.mu.Lock()
+	f.queued = append(f.queued, op)
+	start := !f.running
+	f.running = true
+	f.mu.Unlock()
+
+	if start {
+		diskPool.submit(f)
+	}
+}
+
+// run runs the queued operations. It is called by a worker of the pool.
+func (f *DiskFile) run() {
+	for {
+		f.mu.Lock()
+		if len(f.queued) == 0 {
+			f.running = false
+			f.mu.Unlock()
+			return
+		}
+		op := f.queued[0]
+		f.queued[0] = nil
+		f.queued = f.queued[1:]
+		f.mu.Unlock()
+
+		op.run(f.fd)
+
+		// Notify under the lock, so that the IO cannot complete the last
+		// operation and close the notifier before it is notified.
+		f.mu.Lock()
+		f.done = append(f.done, op)
+		_ = f.notifier.Notify()
+		f.mu.Unlock()
+	}
+}
+
+func (op *diskOp) run(fd int) {
+	switch op.kind {
+	case diskRead:
+		op.n, op.err = preadFull(fd, op.b, op.off)
+	case diskWrite:
+		op.n, op.err = pwriteFull(fd, op.b, op.off)
+	case diskFsync:
+		if err := syscall.Fsync(fd); err != nil {
+			op.err = os.NewSyscallError("fsync", err)
+		}
+	case diskFallocate:
+		op.err = fallocate(fd, op.off, op.len)
+	case diskCall:
+		op.err = op.fn()
+	}
+}
+
+func (f *DiskFile) onNotify(err error, _ uint64) {
+	f.waiting = false
+	if err != nil {
+		// The notifier is closed, the remaining operations complete on Close.
+		return
+	}
+
+	f.mu.Lock()
+	done := f.done
+	f.done = nil
+	f.mu.Unlock()
+
+	for _, op := range done {
+		f.pending--
+		f.complete(op)
+	}
+
+	if f.pending > 0 {
+		if !f.waiting {
+			f.waiting = true
+			f.notifier.AsyncWait(f.onNotifyFn)
+		}
+	} else if f.closed {
+		f.release()
+	}
+}
+
+func (f *DiskFile) complete(op *diskOp) {
+	f.ioc.Dispatched++
+	if op.errCb != nil {
+		op.errCb(op.err)
+	} else {
+		op.cb(op.err, op.n)
+	}
+	f.ioc.Dispatched--
+}
+
+// Size returns the size of the file.
+func (f *DiskFile) Size() (int64, error) {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(f.fd, &st); err != nil {
+		return 0, os.NewSyscallError("fstat", err)
+	}
+	return st.Size, nil
+}
+
+// Close closes the file. The operations which did not start yet complete with
+// sonicerrors.ErrCancelled. The file descriptor is closed once the running
+// operation, if any, completes.
+func (f *DiskFile) Close() error {
+	if f.closed {
+		return io.EOF
+	}
+	f.closed = true
+
+	f.mu.Lock()
+	cancelled := f.queued
+	f.queued = nil
+	f.mu.Unlock()
+
+	for _, op := range cancelled {
+		f.pending--
+		op.err = sonicerrors.ErrCancelled
+		f.complete(op)
+	}
+
+	if f.pending == 0 {
+		return f.release()
+	}
+	return nil
+}
+
+func (f *DiskFile) release() error {
+	// Close can be called from the callback of the last operation, which is
+	// followed by a release from onNotify.
+	if f.released {
+		return nil
+	}
+	f.released = true
+
+	_ = f.notifier.Close()
+	return syscall.Close(f.fd)
+}
+
+// RawFd returns the file descriptor of the file.
+func (f *DiskFile) RawFd() int {
+	return f.fd
+}
+
+func preadFull(fd int, b []byte, off int64) (n int, err error) {
+	for n < len(b) {
+		nn, err := syscall.Pread(fd, b[n:], off+int64(n))
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return n, os.NewSyscallError("pread", err)
+		}
+		if nn == 0 {
+			return n, io.EOF
+		}
+		n += nn
+	}
+	return n, nil
+}
+
+func pwriteFull(fd int, b []byte, off int64) (n int, err error) {
+	for n < len(b) {
+		nn, err := syscall.Pwrite(fd, b[n:], off+int64(n))
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return n, os.NewSyscallError("pwrite", err)
+		}
+		n += nn
+	}
+	return n, nil
+}
+
+// diskPoolSize is the number of goroutines running the operations of all
+// DiskFiles.
+const diskPoolSize = 4
+
+var diskPool = &diskWorkers{}
+
+// diskWorkers runs the operations of DiskFiles with a fixed number of
+// goroutines, started on first use. A file is submitted once it has queued
+// operations and no worker runs them.
+type diskWorkers struct {
+	once  sync.Once
+	mu    sync.Mutex
+	cond  *sync.Cond
+	files []*DiskFile
+}
+
+func (p *diskWorkers) submit(f *DiskFile) {
+	p.once.Do(func() {
+		p.cond = sync.NewCond(&p.mu)
+		for i := 0; i < diskPoolSize; i++ {
+			go p.work()
+		}
+	})
+
+	p.mu.Lock()
+	p.files = append(p.files, f)
+	p.mu.Unlock()
+	p.cond.Signal()
+}
+
+func (p *diskWorkers) work() {
+	for {
+		p.mu.Lock()
+		for len(p.files) == 0 {
+			p.cond.Wait()
+		}
+		f := p.files[0]
+		p.files[0] = nil
+		p.files = p.files[1:]
+		p.mu.Unlock()
+
+		f.run()
+	}
+}
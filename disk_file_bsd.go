@@ -0,0 +1,24 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package sonic
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocate grows the file to off+n bytes if it is smaller. The space is not
+// reserved.
+func fallocate(fd int, off, n int64) error {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return os.NewSyscallError("fstat", err)
+	}
+	if st.Size >= off+n {
+		return nil
+	}
+	if err := syscall.Ftruncate(fd, off+n); err != nil {
+		return os.NewSyscallError("ftruncate", err)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package sonic
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func fallocate(fd int, off, n int64) error {
+	for {
+		err := unix.Fallocate(fd, 0, off, n)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return os.NewSyscallError("fallocate", err)
+		}
+		return nil
+	}
+}
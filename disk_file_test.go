@@ -0,0 +1,184 @@
+package sonic
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+func openTestDiskFile(t *testing.T, ioc *IO) *DiskFile {
+	path := filepath.Join(t.TempDir(), "disk")
+	f, err := OpenDiskFile(ioc, path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestDiskFileWriteReadAt(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	f := openTestDiskFile(t, ioc)
+	defer f.Close()
+
+	// The operations run in order, so none needs to wait for the previous one.
+	var order []string
+	f.AsyncWriteAt([]byte("hello"), 0, func(err error, n int) {
+		if err != nil || n != 5 {
+			t.Errorf("write err=%v n=%d", err, n)
+		}
+		order = append(order, "write")
+	})
+	f.AsyncWriteAt([]byte("sonic"), 5, func(err error, n int) {
+		if err != nil || n != 5 {
+			t.Errorf("write err=%v n=%d", err, n)
+		}
+		order = append(order, "write")
+	})
+	f.AsyncFsync(func(err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		order = append(order, "fsync")
+	})
+
+	b := make([]byte, 10)
+	f.AsyncReadAt(b, 0, func(err error, n int) {
+		if err != nil || string(b[:n]) != "hellosonic" {
+			t.Errorf("read err=%v b=%s", err, b[:n])
+		}
+		order = append(order, "read")
+	})
+
+	// Reading past the end reports io.EOF with what was read.
+	tail := make([]byte, 10)
+	f.AsyncReadAt(tail, 5, func(err error, n int) {
+		if err != io.EOF || string(tail[:n]) != "sonic" {
+			t.Errorf("read err=%v b=%s", err, tail[:n])
+		}
+		order = append(order, "read")
+	})
+
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"write", "write", "fsync", "read", "read"}
+	if len(order) != len(expected) {
+		t.Fatalf("expected=%v got=%v", expected, order)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("expected=%v got=%v", expected, order)
+		}
+	}
+}
+
+func TestDiskFileFallocate(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	f := openTestDiskFile(t, ioc)
+	defer f.Close()
+
+	done := false
+	f.AsyncFallocate(0, 1<<20, func(err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		done = true
+	})
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+	if !done {
+		t.Fatal("fallocate did not complete")
+	}
+
+	size, err := f.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 1<<20 {
+		t.Fatalf("expected size=%d got=%d", 1<<20, size)
+	}
+}
+
+func TestDiskFileClose(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	f := openTestDiskFile(t, ioc)
+
+	var errs []error
+	b := make([]byte, 1<<20)
+	for i := 0; i < 16; i++ {
+		f.AsyncWriteAt(b, int64(i*len(b)), func(err error, _ int) {
+			errs = append(errs, err)
+		})
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The writes which did not start before Close are cancelled, in order.
+	if len(errs) != 16 {
+		t.Fatalf("expected 16 completions got=%d", len(errs))
+	}
+	cancelled := false
+	for i, err := range errs {
+		switch err {
+		case nil:
+			if cancelled {
+				t.Fatalf("write %d completed after a cancelled one", i)
+			}
+		case sonicerrors.ErrCancelled:
+			cancelled = true
+		default:
+			t.Fatalf("unexpected error=%v", err)
+		}
+	}
+	if !cancelled {
+		t.Fatal("expected some writes to be cancelled")
+	}
+
+	f.AsyncFsync(func(err error) {
+		if err != io.EOF {
+			t.Errorf("expected io.EOF got=%v", err)
+		}
+	})
+}
+
+func TestDiskFileCloseFromCallback(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	f := openTestDiskFile(t, ioc)
+
+	closed := false
+	f.AsyncWriteAt([]byte("hello"), 0, func(err error, _ int) {
+		if err != nil {
+			t.Error(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Error(err)
+		}
+		closed = true
+	})
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+	if !closed {
+		t.Fatal("write did not complete")
+	}
+	if err := f.Close(); err != io.EOF {
+		t.Fatalf("expected io.EOF got=%v", err)
+	}
+}
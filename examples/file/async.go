@@ -1,36 +1,69 @@
 package main
 
 import (
+	"encoding/binary"
 	"fmt"
 	"os"
 
 	"github.com/talostrading/sonic"
 )
 
+// Appends length-prefixed records to a journal without blocking the IO, making
+// each batch durable before acknowledging it, and then reads the journal back.
 func main() {
 	ioc := sonic.MustIO()
 
-	file, err := sonic.Open(ioc, "/tmp/tmp.log", os.O_RDWR|os.O_APPEND|os.O_TRUNC|os.O_CREATE, 0644)
+	file, err := sonic.OpenDiskFile(ioc, "/tmp/tmp.log", os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0644)
 	if err != nil {
 		panic(err)
 	}
 	defer file.Close()
 
-	file.AsyncWrite([]byte("hello, sonic!"), func(err error, n int) {
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println("wrote", n, "bytes")
+	var offset int64
+	appendRecord := func(record string) {
+		b := make([]byte, 4+len(record))
+		binary.LittleEndian.PutUint32(b, uint32(len(record)))
+		copy(b[4:], record)
 
-		if _, err := file.Seek(0, 0); err != nil {
-			panic(err)
+		file.AsyncWriteAt(b, offset, func(err error, n int) {
+			if err != nil {
+				panic(err)
+			}
+		})
+		offset += int64(len(b))
+	}
+
+	batches := [][]string{
+		{"hello", "sonic"},
+		{"journals", "do", "not", "block"},
+	}
+	for i, batch := range batches {
+		for _, record := range batch {
+			appendRecord(record)
 		}
 
-		b := make([]byte, n)
-		file.AsyncRead(b, func(err error, n int) {
-			fmt.Println("read", n, "bytes:", string(b))
+		// The fsync runs after the writes issued before it, so it covers them.
+		file.AsyncFsync(func(err error) {
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("batch", i, "is durable")
 		})
+	}
+
+	b := make([]byte, offset)
+	file.AsyncReadAt(b, 0, func(err error, n int) {
+		if err != nil {
+			panic(err)
+		}
+		for len(b) > 0 {
+			size := binary.LittleEndian.Uint32(b)
+			fmt.Println("read record:", string(b[4:4+size]))
+			b = b[4+size:]
+		}
 	})
 
-	ioc.RunPending()
+	if err := ioc.RunPending(); err != nil {
+		panic(err)
+	}
 }
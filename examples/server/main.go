@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+var (
+	addr     = flag.String("addr", ":8082", "server address")
+	maxConns = flag.Int("max-conns", 1024, "maximum number of connections")
+	idle     = flag.Duration("idle", time.Minute, "idle timeout")
+)
+
+// echoHandler echoes back everything it reads.
+type echoHandler struct{}
+
+func (echoHandler) OnOpen(c *sonic.ServerConn) {
+	fmt.Println("opened", c.ID(), c.RemoteAddr())
+}
+
+func (echoHandler) OnData(c *sonic.ServerConn, b []byte) {
+	c.Write(b)
+}
+
+func (echoHandler) OnClose(c *sonic.ServerConn, err error) {
+	fmt.Println("closed", c.ID(), err)
+}
+
+func (echoHandler) OnAcceptError(err error) {
+	fmt.Println("accept failed", err)
+}
+
+func main() {
+	flag.Parse()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", *addr, sonicopts.Nonblocking(true))
+	if err != nil {
+		panic(err)
+	}
+
+	s := sonic.NewServer(ioc, ln, echoHandler{})
+	s.SetMaxConns(*maxConns)
+	s.SetIdleTimeout(*idle)
+	s.Start()
+
+	// Drain the connections on SIGINT/SIGTERM.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	done := false
+	for !done {
+		select {
+		case <-sig:
+			s.AsyncShutdown(5*time.Second, func(err error) {
+				fmt.Println("shut down", err)
+				done = true
+			})
+		default:
+		}
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+
+	"github.com/talostrading/sonic"
+)
+
+var (
+	certPath = flag.String("cert", "../async_adapter/tls/certs/server.pem", "server certificate path")
+	keyPath  = flag.String("key", "../async_adapter/tls/certs/server.key", "server private key path")
+	addr     = flag.String("addr", ":8080", "tls server address")
+)
+
+func main() {
+	flag.Parse()
+
+	cert, err := tls.LoadX509KeyPair(*certPath, *keyPath)
+	if err != nil {
+		panic(err)
+	}
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.ListenTLS(ioc, "tcp", *addr, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer ln.Close()
+
+	ln.SetOnHandshakeError(func(err error, remoteAddr net.Addr) {
+		fmt.Println("handshake with", remoteAddr, "failed:", err)
+	})
+
+	var onAccept sonic.AcceptCallback
+	onAccept = func(err error, conn sonic.Conn) {
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("accepted conn", conn.RemoteAddr(),
+			"server_name", conn.(*sonic.TLSConn).ServerName())
+		echo(conn)
+		ln.AsyncAccept(onAccept)
+	}
+	ln.AsyncAccept(onAccept)
+
+	fmt.Println("listening")
+	ioc.Run()
+}
+
+func echo(conn sonic.Conn) {
+	b := make([]byte, 4096)
+
+	var onRead sonic.AsyncCallback
+	onRead = func(err error, n int) {
+		if err != nil {
+			fmt.Println("conn", conn.RemoteAddr(), "closed:", err)
+			_ = conn.Close()
+			return
+		}
+		conn.AsyncWriteAll(b[:n], func(err error, _ int) {
+			if err != nil {
+				_ = conn.Close()
+			} else {
+				conn.AsyncRead(b, onRead)
+			}
+		})
+	}
+	conn.AsyncRead(b, onRead)
+}
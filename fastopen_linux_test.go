@@ -0,0 +1,197 @@
+//go:build linux
+
+package sonic
+
+import (
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+// The tests below pass whether or not the net.ipv4.tcp_fastopen sysctl enables
+// Fast Open: the data is sent after the handshake if it cannot be sent in the
+// SYN.
+
+func newFastOpenListener(t *testing.T, ioc *IO) (Listener, string) {
+	ln, err := Listen(
+		ioc, "tcp", "localhost:0",
+		sonicopts.Nonblocking(true), sonicopts.FastOpen(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qlen, err := internal.GetFastOpen(ln.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qlen != 16 {
+		t.Fatalf("expected a Fast Open queue of 16 got=%d", qlen)
+	}
+
+	addr, err := internal.SocketAddress(ln.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ln, addr.String()
+}
+
+// acceptAndRead accepts a client and reads n bytes from it.
+func acceptAndRead(t *testing.T, ioc *IO, ln Listener, n int) string {
+	var (
+		done bool
+		b    = make([]byte, n)
+	)
+	ln.AsyncAccept(func(err error, conn Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.AsyncReadAll(b, func(err error, _ int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+			done = true
+		})
+	})
+	runUntil(t, ioc, &done)
+	return string(b)
+}
+
+func TestAsyncDialWithData(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, addr := newFastOpenListener(t, ioc)
+	defer ln.Close()
+
+	// The first connection fetches a cookie, the second one can use it.
+	for i := 0; i < 2; i++ {
+		var conn Conn
+		AsyncDialWithData(ioc, "tcp", addr, []byte("login"), func(err error, c Conn) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn = c
+		})
+
+		if b := acceptAndRead(t, ioc, ln, 5); b != "login" {
+			t.Fatalf("unexpected payload=%s", b)
+		}
+
+		done := conn != nil
+		runUntil(t, ioc, &done)
+		conn = nil
+	}
+}
+
+func TestAsyncDialWithDataRefused(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	// Find a port nobody listens on.
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	var (
+		done    bool
+		dialErr error
+	)
+	AsyncDialWithData(ioc, "tcp", addr, []byte("login"), func(err error, _ Conn) {
+		dialErr = err
+		done = true
+	})
+	runUntil(t, ioc, &done)
+
+	if dialErr != sonicerrors.ErrConnRefused {
+		t.Fatalf("expected ErrConnRefused got=%v", dialErr)
+	}
+}
+
+func TestDialFastOpenConnect(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, addr := newFastOpenListener(t, ioc)
+	defer ln.Close()
+
+	conn, err := Dial(ioc, "tcp", addr, sonicopts.FastOpenConnect(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	on, err := internal.GetFastOpenConnect(conn.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !on {
+		t.Fatal("expected TCP_FASTOPEN_CONNECT to be set")
+	}
+
+	// Connecting is deferred to the first write.
+	conn.AsyncWriteAll([]byte("login"), func(err error, _ int) {
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+	})
+	if b := acceptAndRead(t, ioc, ln, 5); b != "login" {
+		t.Fatalf("unexpected payload=%s", b)
+	}
+}
+
+func TestAsyncDialWithDataTimeout(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	// A listener which does not accept, with a full backlog, drops the SYNs.
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+	addr, err := internal.SocketAddress(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		c, err := net.DialTimeout("tcp", addr.String(), 100*time.Millisecond)
+		if err != nil {
+			break
+		}
+		defer c.Close()
+	}
+
+	var (
+		done    bool
+		dialErr error
+	)
+	start := time.Now()
+	AsyncDialWithDataTimeout(ioc, "tcp", addr.String(), []byte("login"), 50*time.Millisecond, func(err error, _ Conn) {
+		dialErr = err
+		done = true
+	})
+	runUntil(t, ioc, &done)
+
+	if dialErr != sonicerrors.ErrTimeout {
+		t.Fatalf("expected ErrTimeout got=%v", dialErr)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("timed out after %s", elapsed)
+	}
+}
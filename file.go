@@ -18,6 +18,9 @@ type file struct {
 	closed       uint32
 	readReactor  fileReadReactor
 	writeReactor fileWriteReactor
+
+	// Invoked once no asynchronous write is pending, see whenWritesDone.
+	writesDoneFn func()
 }
 
 type fileReadReactor struct {
@@ -70,6 +73,12 @@ func (r *fileWriteReactor) onWrite(err error) {
 	} else {
 		r.file.asyncWriteNow(r.b, r.wroteSoFar, r.writeAll, r.cb)
 	}
+
+	if r.file.writesDoneFn != nil && !r.file.writePending() {
+		fn := r.file.writesDoneFn
+		r.file.writesDoneFn = nil
+		fn()
+	}
 }
 
 func newFile(ioc *IO, fd int) *file {
@@ -88,6 +97,11 @@ func newFile(ioc *IO, fd int) *file {
 	return f
 }
 
+// Open opens the named file with the given flags. The asynchronous operations
+// of the returned File wait for the poller to report the file ready, which
+// suits pipes, terminals and character devices. Regular files are always
+// ready, so their asynchronous operations block the IO: use OpenDiskFile for
+// them instead.
 func Open(ioc *IO, path string, flags int, mode os.FileMode) (File, error) {
 	fd, err := syscall.Open(path, flags, uint32(mode))
 	if err != nil {
@@ -179,7 +193,7 @@ func (f *file) asyncReadNow(b []byte, readSoFar int, readAll bool, cb AsyncCallb
 	}
 
 	// handles (readAll == false) and (readAll == true && readSoFar != len(b)).
-	if err == sonicerrors.ErrWouldBlock {
+	if err == sonicerrors.ErrWouldBlock || err == nil {
 		// If readAll == true then read some without errors.
 		// We schedule an asynchronous read.
 		f.scheduleRead(readSoFar, cb)
@@ -238,7 +252,8 @@ func (f *file) asyncWriteNow(b []byte, wroteSoFar int, writeAll bool, cb AsyncCa
 	}
 
 	// Handles (writeAll == false) and (writeAll == true && wroteSoFar != len(b)).
-	if err == sonicerrors.ErrWouldBlock {
+	// A short write without an error means the send buffer is full.
+	if err == sonicerrors.ErrWouldBlock || err == nil {
 		f.scheduleWrite(wroteSoFar, cb)
 	} else {
 		cb(err, wroteSoFar)
@@ -261,6 +276,26 @@ func (f *file) scheduleWrite(wroteSoFar int, cb AsyncCallback) {
 	}
 }
 
+// writePending returns true if an asynchronous write waits for the file to
+// become writable.
+func (f *file) writePending() bool {
+	return f.slot.Events&internal.PollerWriteEvent == internal.PollerWriteEvent
+}
+
+// whenWritesDone invokes fn once no asynchronous write is pending, which is
+// immediately if there is none. Writes issued from the callbacks of the pending
+// writes are waited for too. If the file is closed before, fn is invoked after
+// closing it.
+func (f *file) whenWritesDone(fn func()) {
+	if f.Closed() || !f.writePending() {
+		f.ioc.Dispatched++
+		fn()
+		f.ioc.Dispatched--
+		return
+	}
+	f.writesDoneFn = fn
+}
+
 func (f *file) Close() error {
 	if !atomic.CompareAndSwapUint32(&f.closed, 0, 1) {
 		return io.EOF
@@ -271,7 +306,12 @@ func (f *file) Close() error {
 	}
 	f.ioc.Deregister(&f.slot)
 
-	return syscall.Close(f.slot.Fd)
+	err := syscall.Close(f.slot.Fd)
+	if fn := f.writesDoneFn; fn != nil {
+		f.writesDoneFn = nil
+		fn()
+	}
+	return err
 }
 
 func (f *file) Closed() bool {
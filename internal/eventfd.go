@@ -14,12 +14,13 @@ type EventFd struct {
 }
 
 func NewEventFd(nonBlocking bool) (*EventFd, error) {
-	var nonBlock uintptr = 0
+	// EFD_CLOEXEC and EFD_NONBLOCK have the values of O_CLOEXEC and O_NONBLOCK.
+	var flags uintptr = syscall.O_CLOEXEC
 	if nonBlocking {
-		nonBlock = syscall.O_NONBLOCK
+		flags |= syscall.O_NONBLOCK
 	}
 
-	fd, _, err := syscall.Syscall(syscall.SYS_EVENTFD2, 0, nonBlock, 0)
+	fd, _, err := syscall.Syscall(syscall.SYS_EVENTFD2, 0, flags, 0)
 	if err != 0 {
 		_ = syscall.Close(int(fd))
 		return nil, os.NewSyscallError("eventfd", err)
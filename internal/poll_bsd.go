@@ -58,6 +58,9 @@ type poller struct {
 	// entails writing a single byte to the write end of the wakeupPipe.
 	posts []func()
 
+	// running holds the posts which are being executed.
+	running []func()
+
 	// lck synchronizes access to the handlers slice.
 	// This is needed because multiple goroutines can call ioc.Post(...)
 	// on the same IO object.
@@ -217,13 +220,17 @@ func (p *poller) executePost() {
 		}
 	}
 
+	// The handlers run without holding the lock so that they can Post.
 	p.lck.Lock()
-	for _, handler := range p.posts {
+	p.posts, p.running = p.running[:0], p.posts
+	p.lck.Unlock()
+
+	for _, handler := range p.running {
 		handler()
+		p.lck.Lock()
 		p.pending--
+		p.lck.Unlock()
 	}
-	p.posts = p.posts[:0]
-	p.lck.Unlock()
 }
 
 func (p *poller) SetRead(slot *Slot) error {
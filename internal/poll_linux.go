@@ -65,6 +65,9 @@ type poller struct {
 	// entails writing a single byte to the write end of the wakeupPipe.
 	posts []func()
 
+	// running holds the posts which are being executed.
+	running []func()
+
 	// lck synchronizes access to the posts slice.
 	// This is needed because multiple goroutines can call ioc.Post(...)
 	// on the same IO object.
@@ -186,6 +189,13 @@ func (p *poller) Poll(timeoutMs int) (n int, err error) {
 			continue
 		}
 
+		// A hangup or an error is reported without EPOLLIN or EPOLLOUT, for
+		// example once the writing end of a pipe is closed. The handlers then
+		// get the error, or io.EOF, from the read or write they retry.
+		if events&(syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+			events |= PollerReadEvent | PollerWriteEvent
+		}
+
 		if events&slot.Events&PollerReadEvent == PollerReadEvent {
 			// TODO this errors should be reported
 			_ = p.DelRead(slot)
@@ -210,13 +220,17 @@ func (p *poller) dispatch() {
 		}
 	}
 
+	// The handlers run without holding the lock so that they can Post.
 	p.lck.Lock()
-	for _, handler := range p.posts {
+	p.posts, p.running = p.running[:0], p.posts
+	p.lck.Unlock()
+
+	for _, handler := range p.running {
 		handler()
+		p.lck.Lock()
 		p.pending--
+		p.lck.Unlock()
 	}
-	p.posts = p.posts[:0]
-	p.lck.Unlock()
 }
 
 func (p *poller) SetRead(slot *Slot) error {
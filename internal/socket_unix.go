@@ -19,16 +19,6 @@ var (
 	errUnknownNetwork = errors.New("unknown network argument")
 )
 
-func maybeBindBeforeConnect(fd int, opts ...sonicopts.Option) error {
-	for _, opt := range opts {
-		if opt.Type() == sonicopts.TypeBindSocket {
-			addr := opt.Value().(net.Addr)
-			return syscall.Bind(fd, ToSockaddr(addr))
-		}
-	}
-	return nil
-}
-
 func socket(domain, socketType, proto int, nonblock bool) (fd int, err error) {
 	fd, err = syscall.Socket(domain, socketType, proto)
 	if err != nil {
@@ -126,10 +116,6 @@ func connect(fd int, remoteAddr net.Addr, timeout time.Duration, opts ...sonicop
 		return err
 	}
 
-	if err := maybeBindBeforeConnect(fd, opts...); err != nil {
-		return err
-	}
-
 	startTime := time.Now()
 
 	for {
@@ -229,6 +215,80 @@ func ConnectTCP(
 	return
 }
 
+// ConnectAsync starts connecting a non-blocking TCP socket. The connection is
+// in progress once it returns: it is established once the socket becomes
+// writable, and ConnectError then reports its outcome.
+func ConnectAsync(
+	network, addr string,
+	opts ...sonicopts.Option,
+) (fd int, remoteAddr net.Addr, err error) {
+	fd, tcpAddr, err := CreateSocketTCP(network, addr, true)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	if err := ApplyOpts(fd, opts...); err != nil {
+		_ = syscall.Close(fd)
+		return -1, nil, err
+	}
+
+	for {
+		err = syscall.Connect(fd, ToSockaddr(tcpAddr))
+		if !errors.Is(err, syscall.EINTR) {
+			break
+		}
+	}
+	if err != nil && !errors.Is(err, syscall.EINPROGRESS) {
+		_ = syscall.Close(fd)
+		return -1, nil, os.NewSyscallError("connect", err)
+	}
+	return fd, tcpAddr, nil
+}
+
+// ConnectFastOpen starts connecting a non-blocking TCP socket, sending data in
+// the SYN with TCP Fast Open if possible. It returns the number of bytes of
+// data sent or queued with the SYN. The connection is in progress once it
+// returns: it is established once the socket becomes writable.
+func ConnectFastOpen(
+	network, addr string,
+	data []byte,
+	opts ...sonicopts.Option,
+) (fd int, remoteAddr net.Addr, n int, err error) {
+	fd, remoteAddr, err = CreateSocketTCP(network, addr, true)
+	if err != nil {
+		return -1, nil, 0, err
+	}
+
+	if err := ApplyOpts(fd, opts...); err != nil {
+		_ = syscall.Close(fd)
+		return -1, nil, 0, err
+	}
+
+	n, err = sendFastOpen(fd, remoteAddr, data)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return -1, nil, 0, err
+	}
+	return fd, remoteAddr, n, nil
+}
+
+// ConnectError returns the outcome of a non-blocking connect once the socket
+// becomes writable.
+func ConnectError(fd int) error {
+	socketErr, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+	if err != nil {
+		return os.NewSyscallError("getsockopt", err)
+	}
+	if socketErr != 0 {
+		var err error = syscall.Errno(socketErr)
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return sonicerrors.ErrConnRefused
+		}
+		return err
+	}
+	return nil
+}
+
 func ConnectUDP(
 	network, addr string,
 	timeout time.Duration,
@@ -299,7 +359,11 @@ func ListenUDP(network, addr string, opts ...sonicopts.Option) (int, net.Addr, e
 	return fd, localAddr, nil
 }
 
+// ApplyOpts sets the options on the socket. If BindSocket is given, the socket
+// is bound once the other options are set, so that options like ReuseAddr
+// apply to the bind.
 func ApplyOpts(fd int, opts ...sonicopts.Option) error {
+	var bindAddr net.Addr
 	for _, opt := range opts {
 		switch t := opt.Type(); t {
 		case sonicopts.TypeNonblocking:
@@ -355,13 +419,54 @@ func ApplyOpts(fd int, opts ...sonicopts.Option) error {
 				return os.NewSyscallError(fmt.Sprintf("tcp_no_delay(%v)", v), err)
 			}
 		case sonicopts.TypeBindSocket:
-			addr := opt.Value().(net.Addr)
-			return syscall.Bind(fd, ToSockaddr(addr))
+			bindAddr = opt.Value().(net.Addr)
+		case sonicopts.TypeRecvBuffer:
+			v := opt.Value().(sonicopts.BufferParams)
+			if err := setBuffer(fd, syscall.SO_RCVBUF, v); err != nil {
+				return os.NewSyscallError(fmt.Sprintf("recv_buffer(%+v)", v), err)
+			}
+		case sonicopts.TypeSendBuffer:
+			v := opt.Value().(sonicopts.BufferParams)
+			if err := setBuffer(fd, syscall.SO_SNDBUF, v); err != nil {
+				return os.NewSyscallError(fmt.Sprintf("send_buffer(%+v)", v), err)
+			}
+		case sonicopts.TypeLinger:
+			v := opt.Value().(sonicopts.LingerParams)
+			l := &syscall.Linger{Linger: int32(v.Timeout.Seconds())}
+			if v.On {
+				l.Onoff = 1
+			}
+			if err := syscall.SetsockoptLinger(
+				fd,
+				syscall.SOL_SOCKET,
+				syscall.SO_LINGER,
+				l,
+			); err != nil {
+				return os.NewSyscallError(fmt.Sprintf("linger(%+v)", v), err)
+			}
+		case sonicopts.TypeTOS:
+			v := opt.Value().(int)
+			if err := syscall.SetsockoptInt(
+				fd,
+				syscall.IPPROTO_IP,
+				syscall.IP_TOS,
+				v,
+			); err != nil {
+				return os.NewSyscallError(fmt.Sprintf("tos(%v)", v), err)
+			}
 		default:
-			return fmt.Errorf("unsupported socket option %s", t)
+			// Options which are only available on some platforms.
+			if err := applyPlatformOpt(fd, opt); err != nil {
+				return err
+			}
 		}
 	}
 
+	if bindAddr != nil {
+		if err := syscall.Bind(fd, ToSockaddr(bindAddr)); err != nil {
+			return os.NewSyscallError(fmt.Sprintf("bind(%s)", bindAddr), err)
+		}
+	}
 	return nil
 }
 
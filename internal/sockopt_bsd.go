@@ -0,0 +1,33 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package internal
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+func setBuffer(fd int, opt int, v sonicopts.BufferParams) error {
+	if v.Force {
+		return fmt.Errorf("forcing the buffer size is only supported on Linux")
+	}
+	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, opt, v.Size)
+}
+
+func applyPlatformOpt(fd int, opt sonicopts.Option) error {
+	return fmt.Errorf("unsupported socket option %s", opt.Type())
+}
+
+// sendFastOpen starts connecting a non-blocking socket. TCP Fast Open is not
+// supported on BSD, so no data is sent with the SYN.
+func sendFastOpen(fd int, to net.Addr, data []byte) (int, error) {
+	err := syscall.Connect(fd, ToSockaddr(to))
+	if err == nil || err == syscall.EINPROGRESS {
+		return 0, nil
+	}
+	return 0, os.NewSyscallError("connect", err)
+}
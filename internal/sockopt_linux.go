@@ -0,0 +1,195 @@
+//go:build linux
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/talostrading/sonic/sonicopts"
+	"golang.org/x/sys/unix"
+)
+
+func setBuffer(fd int, opt int, v sonicopts.BufferParams) error {
+	if v.Force {
+		switch opt {
+		case syscall.SO_RCVBUF:
+			opt = unix.SO_RCVBUFFORCE
+		case syscall.SO_SNDBUF:
+			opt = unix.SO_SNDBUFFORCE
+		}
+	}
+	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, opt, v.Size)
+}
+
+func boolToInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func applyPlatformOpt(fd int, opt sonicopts.Option) error {
+	switch t := opt.Type(); t {
+	case sonicopts.TypeKeepAlive:
+		v := opt.Value().(sonicopts.KeepAliveParams)
+		if err := setKeepAlive(fd, v); err != nil {
+			return os.NewSyscallError(fmt.Sprintf("keep_alive(%+v)", v), err)
+		}
+	case sonicopts.TypeQuickAck:
+		v := opt.Value().(bool)
+		if err := syscall.SetsockoptInt(
+			fd,
+			syscall.IPPROTO_TCP,
+			unix.TCP_QUICKACK,
+			boolToInt(v),
+		); err != nil {
+			return os.NewSyscallError(fmt.Sprintf("quick_ack(%v)", v), err)
+		}
+	case sonicopts.TypeUserTimeout:
+		v := opt.Value().(time.Duration)
+		if err := syscall.SetsockoptInt(
+			fd,
+			syscall.IPPROTO_TCP,
+			unix.TCP_USER_TIMEOUT,
+			int(v.Milliseconds()),
+		); err != nil {
+			return os.NewSyscallError(fmt.Sprintf("user_timeout(%v)", v), err)
+		}
+	case sonicopts.TypePriority:
+		v := opt.Value().(int)
+		if err := syscall.SetsockoptInt(
+			fd,
+			syscall.SOL_SOCKET,
+			unix.SO_PRIORITY,
+			v,
+		); err != nil {
+			return os.NewSyscallError(fmt.Sprintf("priority(%v)", v), err)
+		}
+	case sonicopts.TypeBusyPoll:
+		v := opt.Value().(time.Duration)
+		if err := syscall.SetsockoptInt(
+			fd,
+			syscall.SOL_SOCKET,
+			unix.SO_BUSY_POLL,
+			int(v.Microseconds()),
+		); err != nil {
+			return os.NewSyscallError(fmt.Sprintf("busy_poll(%v)", v), err)
+		}
+	case sonicopts.TypeIncomingCPU:
+		v := opt.Value().(int)
+		if err := syscall.SetsockoptInt(
+			fd,
+			syscall.SOL_SOCKET,
+			unix.SO_INCOMING_CPU,
+			v,
+		); err != nil {
+			return os.NewSyscallError(fmt.Sprintf("incoming_cpu(%v)", v), err)
+		}
+	case sonicopts.TypeNotSentLowat:
+		v := opt.Value().(int)
+		if err := syscall.SetsockoptInt(
+			fd,
+			syscall.IPPROTO_TCP,
+			unix.TCP_NOTSENT_LOWAT,
+			v,
+		); err != nil {
+			return os.NewSyscallError(fmt.Sprintf("not_sent_lowat(%v)", v), err)
+		}
+	case sonicopts.TypeFastOpen:
+		v := opt.Value().(int)
+		if err := syscall.SetsockoptInt(
+			fd,
+			syscall.IPPROTO_TCP,
+			unix.TCP_FASTOPEN,
+			v,
+		); err != nil {
+			return os.NewSyscallError(fmt.Sprintf("fast_open(%v)", v), err)
+		}
+	case sonicopts.TypeFastOpenConnect:
+		v := opt.Value().(bool)
+		if err := syscall.SetsockoptInt(
+			fd,
+			syscall.IPPROTO_TCP,
+			unix.TCP_FASTOPEN_CONNECT,
+			boolToInt(v),
+		); err != nil {
+			return os.NewSyscallError(fmt.Sprintf("fast_open_connect(%v)", v), err)
+		}
+	default:
+		return fmt.Errorf("unsupported socket option %s", t)
+	}
+	return nil
+}
+
+func setKeepAlive(fd int, v sonicopts.KeepAliveParams) error {
+	if err := syscall.SetsockoptInt(
+		fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1,
+	); err != nil {
+		return err
+	}
+	if secs := int(v.Idle.Seconds()); secs > 0 {
+		if err := syscall.SetsockoptInt(
+			fd, syscall.IPPROTO_TCP, unix.TCP_KEEPIDLE, secs,
+		); err != nil {
+			return err
+		}
+	}
+	if secs := int(v.Interval.Seconds()); secs > 0 {
+		if err := syscall.SetsockoptInt(
+			fd, syscall.IPPROTO_TCP, unix.TCP_KEEPINTVL, secs,
+		); err != nil {
+			return err
+		}
+	}
+	if v.Count > 0 {
+		if err := syscall.SetsockoptInt(
+			fd, syscall.IPPROTO_TCP, unix.TCP_KEEPCNT, v.Count,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GetFastOpen(fd int) (int, error) {
+	return syscall.GetsockoptInt(fd, syscall.IPPROTO_TCP, unix.TCP_FASTOPEN)
+}
+
+func GetFastOpenConnect(fd int) (bool, error) {
+	v, err := syscall.GetsockoptInt(
+		fd, syscall.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT)
+	return v != 0, err
+}
+
+// sendFastOpen starts connecting a non-blocking socket and sends data in the
+// SYN if the kernel holds a Fast Open cookie for the peer. It returns the
+// number of bytes sent or queued with the SYN, which is 0 if there is no
+// cookie. It falls back to a regular connect if Fast Open is disabled on the
+// client. In all cases the connection is in progress once it returns.
+func sendFastOpen(fd int, to net.Addr, data []byte) (int, error) {
+	n, err := unix.SendmsgN(fd, data, nil, toUnixSockaddr(to), unix.MSG_FASTOPEN)
+	switch {
+	case err == nil:
+		return n, nil
+	case errors.Is(err, syscall.EINPROGRESS):
+		return 0, nil
+	case errors.Is(err, syscall.EOPNOTSUPP):
+		err = syscall.Connect(fd, ToSockaddr(to))
+		if err == nil || errors.Is(err, syscall.EINPROGRESS) {
+			return 0, nil
+		}
+		return 0, os.NewSyscallError("connect", err)
+	default:
+		return 0, os.NewSyscallError("sendmsg(MSG_FASTOPEN)", err)
+	}
+}
+
+func toUnixSockaddr(addr net.Addr) unix.Sockaddr {
+	sa := ToSockaddr(addr).(*syscall.SockaddrInet4)
+	return &unix.SockaddrInet4{Port: sa.Port, Addr: sa.Addr}
+}
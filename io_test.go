@@ -55,6 +55,52 @@ func TestPost(t *testing.T) {
 	}
 }
 
+func TestPostFromHandler(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	const n = 1000
+
+	// Each handler posts the next one while another goroutine posts concurrently.
+	var nested, concurrent int
+	var post func(i int)
+	post = func(i int) {
+		_ = ioc.Post(func() {
+			nested++
+			if i+1 < n {
+				post(i + 1)
+			}
+		})
+	}
+	post(0)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			_ = ioc.Post(func() {
+				concurrent++
+			})
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for nested < n || concurrent < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out nested=%d concurrent=%d", nested, concurrent)
+		}
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	<-done
+
+	if p := ioc.Pending(); p != 0 {
+		t.Fatalf("not accounting for pending operations correctly expected=%d given=%d", 0, p)
+	}
+	if g := ioc.Posted(); g != 0 {
+		t.Fatalf("expected 0 posted events but got %d", g)
+	}
+}
+
 func TestEmptyPoll(t *testing.T) {
 	ioc := MustIO()
 	defer ioc.Close()
@@ -0,0 +1,17 @@
+// Package journal provides an append-only binary journal to record streams of
+// messages, like the packets of a multicast feed or the messages of a
+// WebSocket, and replay them.
+//
+// A journal is a directory of segment files named by their index. Each segment
+// starts with a header made of the magic bytes "SNJL" and a format version,
+// followed by records. Each record is made of a header and a payload. The
+// header holds, in little endian:
+//   - the length of the payload, as an uint32.
+//   - the source of the record, an uint32 identifier chosen by the writer.
+//   - the timestamp of the record, in nanoseconds, as an int64.
+//   - the CRC-32C of the rest of the header and of the payload, as an uint32.
+//   - 4 reserved bytes, set to zero.
+//
+// Segments can be preallocated, in which case they end with zeros. A record
+// header made of zeros marks the end of a segment.
+package journal
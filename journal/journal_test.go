@@ -0,0 +1,321 @@
+package journal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+)
+
+type testRecord struct {
+	source    uint32
+	timestamp int64
+	payload   string
+}
+
+func writeTestJournal(t *testing.T, dir string, segmentSize int64, preallocate bool, records []testRecord) uint64 {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	w, err := NewWriter(ioc, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetSegmentSize(segmentSize)
+	w.SetPreallocate(preallocate)
+
+	for _, r := range records {
+		if err := w.AppendAt(r.source, r.timestamp, []byte(r.payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	segment := w.Segment()
+
+	closed := false
+	w.AsyncClose(func(err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		closed = true
+	})
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+	if !closed {
+		t.Fatal("the writer did not close")
+	}
+	return segment
+}
+
+func readTestJournal(t *testing.T, dir string) (records []testRecord, err error) {
+	rd, err := OpenReader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+
+	var r Record
+	for {
+		if err := rd.Next(&r); err != nil {
+			if err == io.EOF {
+				return records, nil
+			}
+			return records, err
+		}
+		records = append(records, testRecord{r.Source, r.Timestamp, string(r.Payload)})
+	}
+}
+
+func makeTestRecords(n int) (records []testRecord) {
+	for i := 0; i < n; i++ {
+		records = append(records, testRecord{
+			source:    uint32(i % 3),
+			timestamp: int64(i),
+			payload:   fmt.Sprintf("record-%d", i),
+		})
+	}
+	return records
+}
+
+func checkTestRecords(t *testing.T, expected, got []testRecord) {
+	if len(expected) != len(got) {
+		t.Fatalf("expected %d records got=%d", len(expected), len(got))
+	}
+	for i := range expected {
+		if expected[i] != got[i] {
+			t.Fatalf("record %d: expected=%+v got=%+v", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestJournalRotation(t *testing.T) {
+	for _, preallocate := range []bool{true, false} {
+		t.Run(fmt.Sprintf("preallocate=%v", preallocate), func(t *testing.T) {
+			dir := t.TempDir()
+			records := makeTestRecords(100)
+
+			segment := writeTestJournal(t, dir, 256, preallocate, records)
+			if segment < 10 {
+				t.Fatalf("expected the segments to rotate, last segment=%d", segment)
+			}
+
+			got, err := readTestJournal(t, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkTestRecords(t, records, got)
+		})
+	}
+}
+
+func TestJournalNewSegmentOnReopen(t *testing.T) {
+	dir := t.TempDir()
+	records := makeTestRecords(20)
+
+	first := writeTestJournal(t, dir, DefaultSegmentSize, true, records[:10])
+	second := writeTestJournal(t, dir, DefaultSegmentSize, true, records[10:])
+	if second != first+1 {
+		t.Fatalf("expected segment=%d got=%d", first+1, second)
+	}
+
+	got, err := readTestJournal(t, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestRecords(t, records, got)
+}
+
+func TestJournalCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	records := makeTestRecords(3)
+	segment := writeTestJournal(t, dir, DefaultSegmentSize, false, records)
+
+	path := segmentPath(dir, segment)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(b, []byte("record-1"))
+	b[i] ^= 0xff
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readTestJournal(t, dir)
+	if err != ErrCorrupt {
+		t.Fatalf("expected ErrCorrupt got=%v", err)
+	}
+	checkTestRecords(t, records[:1], got)
+}
+
+func TestJournalRecordTooLarge(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	w, err := NewWriter(ioc, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.SetSegmentSize(64)
+
+	if err := w.Append(0, make([]byte, 64)); err != ErrRecordTooLarge {
+		t.Fatalf("expected ErrRecordTooLarge got=%v", err)
+	}
+}
+
+func TestJournalCloseWhileWriting(t *testing.T) {
+	dir := t.TempDir()
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	w, err := NewWriter(ioc, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetPreallocate(false)
+
+	// The first record is being written while the second is buffered.
+	if err := w.Append(0, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Append(0, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if w.writing == 0 || len(w.buf) == 0 {
+		t.Fatal("expected a write in flight and a buffered record")
+	}
+
+	// Wait for the first record to reach the file, so that its write
+	// completes successfully after Close.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if fi, err := os.Stat(segmentPath(dir, w.Segment())); err == nil && fi.Size() > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the first record was not written")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := w.Close(); err != ErrUnwritten {
+		t.Fatalf("expected ErrUnwritten got=%v", err)
+	}
+	// The completion of the write in flight does not write the buffered
+	// record.
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Append(0, []byte("third")); err != ErrClosed {
+		t.Fatalf("expected ErrClosed got=%v", err)
+	}
+}
+
+func TestJournalAppendTimestamp(t *testing.T) {
+	dir := t.TempDir()
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	w, err := NewWriter(ioc, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now().UnixNano()
+	for i := 0; i < 10; i++ {
+		if err := w.Append(0, []byte("record")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after := time.Now().UnixNano()
+	w.AsyncClose(func(err error) {
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readTestJournal(t, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := before
+	for _, r := range got {
+		if r.timestamp < last || r.timestamp > after {
+			t.Fatalf("timestamp=%d not within [%d, %d]", r.timestamp, last, after)
+		}
+		last = r.timestamp
+	}
+}
+
+func replayTestJournal(t *testing.T, dir string, speed float64) ([]testRecord, time.Duration) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	rd, err := OpenReader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewReplayer(ioc, rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.SetSpeed(speed)
+
+	var got []testRecord
+	done := false
+	start := time.Now()
+	p.AsyncReplay(func(r *Record) {
+		got = append(got, testRecord{r.Source, r.Timestamp, string(r.Payload)})
+	}, func(err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		done = true
+	})
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+	if !done {
+		t.Fatal("the replay did not complete")
+	}
+	return got, time.Since(start)
+}
+
+func TestJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+	records := makeTestRecords(3 * ReplayBatch)
+	writeTestJournal(t, dir, 4096, true, records)
+
+	got, _ := replayTestJournal(t, dir, 0)
+	checkTestRecords(t, records, got)
+}
+
+func TestJournalReplayPaced(t *testing.T) {
+	dir := t.TempDir()
+	records := []testRecord{
+		{0, int64(0), "a"},
+		{0, int64(20 * time.Millisecond), "b"},
+		{0, int64(40 * time.Millisecond), "c"},
+	}
+	writeTestJournal(t, dir, DefaultSegmentSize, true, records)
+
+	got, elapsed := replayTestJournal(t, dir, 1)
+	checkTestRecords(t, records, got)
+	if elapsed < 40*time.Millisecond {
+		t.Fatalf("replayed too fast at speed 1: %s", elapsed)
+	}
+
+	got, elapsed = replayTestJournal(t, dir, 2)
+	checkTestRecords(t, records, got)
+	if elapsed < 20*time.Millisecond {
+		t.Fatalf("replayed too fast at speed 2: %s", elapsed)
+	}
+}
@@ -0,0 +1,156 @@
+package journal
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+)
+
+// Reader reads the records of a journal, in the order they were appended,
+// with blocking reads.
+type Reader struct {
+	dir     string
+	indexes []uint64
+
+	file      *os.File
+	rd        *bufio.Reader
+	remaining int64 // bytes left to read in the segment
+	buf       []byte
+}
+
+// OpenReader opens the journal in dir for reading. The segments are listed
+// once: those created afterwards are not read.
+func OpenReader(dir string) (*Reader, error) {
+	indexes, err := segments(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{
+		dir:     dir,
+		indexes: indexes,
+		buf:     make([]byte, RecordHeaderSize),
+	}, nil
+}
+
+// Next reads the next record into r. The payload of r is only valid until the
+// next call to Next. It returns io.EOF once all records are read and
+// ErrCorrupt if a record is damaged, which can happen to the last record of a
+// journal whose writer did not close it.
+func (rd *Reader) Next(r *Record) error {
+	for {
+		if rd.file == nil {
+			if len(rd.indexes) == 0 {
+				return io.EOF
+			}
+			if err := rd.openSegment(); err != nil {
+				return err
+			}
+		}
+
+		err := rd.next(r)
+		if err == io.EOF {
+			_ = rd.file.Close()
+			rd.file = nil
+			continue
+		}
+		return err
+	}
+}
+
+func (rd *Reader) openSegment() error {
+	index := rd.indexes[0]
+	rd.indexes = rd.indexes[1:]
+
+	file, err := os.Open(segmentPath(rd.dir, index))
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	r := bufio.NewReaderSize(file, 64*1024)
+
+	header := rd.buf[:SegmentHeaderSize]
+	if _, err := io.ReadFull(r, header); err != nil {
+		_ = file.Close()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrNotSegment
+		}
+		return err
+	}
+	if err := decodeSegmentHeader(header); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	rd.file, rd.rd = file, r
+	rd.remaining = info.Size() - SegmentHeaderSize
+	return nil
+}
+
+// next reads the next record of the current segment, returning io.EOF at its
+// end.
+func (rd *Reader) next(r *Record) error {
+	if rd.remaining < RecordHeaderSize {
+		// The end of a preallocated segment is made of zeros which do not fit
+		// a record.
+		tail := rd.buf[:rd.remaining]
+		if _, err := io.ReadFull(rd.rd, tail); err != nil {
+			return ErrCorrupt
+		}
+		for _, c := range tail {
+			if c != 0 {
+				return ErrCorrupt
+			}
+		}
+		return io.EOF
+	}
+
+	header := rd.buf[:RecordHeaderSize]
+	if _, err := io.ReadFull(rd.rd, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return ErrCorrupt
+		}
+		return err
+	}
+
+	rd.remaining -= RecordHeaderSize
+
+	n, ok := decodeRecordHeader(header)
+	if !ok {
+		return io.EOF
+	}
+	if int64(n) > rd.remaining {
+		return ErrCorrupt
+	}
+	rd.remaining -= int64(n)
+
+	size := RecordHeaderSize + n
+	if cap(rd.buf) < size {
+		b := make([]byte, size)
+		copy(b, header)
+		rd.buf = b
+	}
+	b := rd.buf[:size]
+	if _, err := io.ReadFull(rd.rd, b[RecordHeaderSize:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrCorrupt
+		}
+		return err
+	}
+	return decodeRecord(b, r)
+}
+
+// Close closes the reader.
+func (rd *Reader) Close() error {
+	rd.indexes = nil
+	if rd.file != nil {
+		err := rd.file.Close()
+		rd.file = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package journal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SegmentHeaderSize is the size of the header of a segment.
+	SegmentHeaderSize = 8
+
+	// RecordHeaderSize is the size of the header of a record.
+	RecordHeaderSize = 24
+
+	// Version is the version of the format of the segments written.
+	Version = 1
+
+	segmentExt = ".journal"
+)
+
+var segmentMagic = [4]byte{'S', 'N', 'J', 'L'}
+
+var (
+	ErrClosed         = errors.New("journal: closed")
+	ErrCorrupt        = errors.New("journal: corrupt record")
+	ErrRecordTooLarge = errors.New("journal: record larger than a segment")
+	ErrNotSegment     = errors.New("journal: not a segment")
+	ErrUnwritten      = errors.New("journal: closed before all records were written")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Record is a record of the journal.
+type Record struct {
+	// Timestamp is the time at which the record was appended, or the time
+	// given to AppendAt, in nanoseconds.
+	Timestamp int64
+
+	// Source identifies what the record was recorded from.
+	Source uint32
+
+	// Payload is the recorded message.
+	Payload []byte
+}
+
+func encodeSegmentHeader(b []byte) []byte {
+	b = append(b, segmentMagic[:]...)
+	return binary.LittleEndian.AppendUint32(b, Version)
+}
+
+func decodeSegmentHeader(b []byte) error {
+	if len(b) < SegmentHeaderSize || [4]byte(b[:4]) != segmentMagic {
+		return ErrNotSegment
+	}
+	if v := binary.LittleEndian.Uint32(b[4:]); v != Version {
+		return fmt.Errorf("journal: unsupported version %d", v)
+	}
+	return nil
+}
+
+// appendRecord appends the encoded record to b.
+func appendRecord(b []byte, source uint32, timestamp int64, payload []byte) []byte {
+	start := len(b)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(payload)))
+	b = binary.LittleEndian.AppendUint32(b, source)
+	b = binary.LittleEndian.AppendUint64(b, uint64(timestamp))
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0) // crc and reserved
+	b = append(b, payload...)
+
+	binary.LittleEndian.PutUint32(b[start+16:], recordCRC(b[start:]))
+	return b
+}
+
+// recordCRC returns the CRC of the encoded record b, which covers everything
+// but the CRC itself.
+func recordCRC(b []byte) uint32 {
+	crc := crc32.Update(0, crcTable, b[:16])
+	return crc32.Update(crc, crcTable, b[20:])
+}
+
+// decodeRecordHeader decodes the header of a record. It returns false if the
+// header marks the end of the segment.
+func decodeRecordHeader(b []byte) (length int, ok bool) {
+	for _, c := range b[:RecordHeaderSize] {
+		if c != 0 {
+			return int(binary.LittleEndian.Uint32(b)), true
+		}
+	}
+	return 0, false
+}
+
+// decodeRecord decodes the record b, header included, checking its CRC. The
+// payload of the record aliases b.
+func decodeRecord(b []byte, r *Record) error {
+	if binary.LittleEndian.Uint32(b[16:]) != recordCRC(b) {
+		return ErrCorrupt
+	}
+	r.Source = binary.LittleEndian.Uint32(b[4:])
+	r.Timestamp = int64(binary.LittleEndian.Uint64(b[8:]))
+	r.Payload = b[RecordHeaderSize:]
+	return nil
+}
+
+func segmentPath(dir string, index uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", index, segmentExt))
+}
+
+// segments returns the indexes of the segments in dir, in increasing order.
+func segments(dir string) ([]uint64, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []uint64
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), segmentExt)
+		index, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	return indexes, nil
+}
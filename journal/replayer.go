@@ -0,0 +1,153 @@
+package journal
+
+import (
+	"io"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// ReplayBatch is the maximum number of records a Replayer replays before
+// letting the IO run other handlers.
+const ReplayBatch = 1024
+
+// Replayer replays the records of a journal into a callback on an IO, either
+// as fast as possible or paced like they were recorded.
+//
+// Records are read with the blocking reads of a Reader.
+type Replayer struct {
+	ioc   *sonic.IO
+	rd    *Reader
+	speed float64
+	timer *sonic.Timer
+
+	record  Record
+	first   int64     // timestamp of the first record
+	start   time.Time // when the first record was replayed
+	started bool
+
+	onRecord  func(*Record)
+	cb        func(error)
+	replaying bool
+	closed    bool
+
+	continueFn func()
+	deliverFn  func()
+}
+
+// NewReplayer creates a Replayer which replays the records read by rd. The
+// Replayer owns rd: it is closed with the Replayer.
+func NewReplayer(ioc *sonic.IO, rd *Reader) (*Replayer, error) {
+	timer, err := sonic.NewTimer(ioc)
+	if err != nil {
+		return nil, err
+	}
+	p := &Replayer{
+		ioc:   ioc,
+		rd:    rd,
+		timer: timer,
+	}
+	p.continueFn = p.replay
+	p.deliverFn = p.deliver
+	return p, nil
+}
+
+// SetSpeed sets the pace of the replay relative to the one recorded: 1 replays
+// the records with the delays between their timestamps, 2 replays them twice
+// as fast and so on. A speed of 0, the default, replays them as fast as
+// possible.
+func (p *Replayer) SetSpeed(speed float64) {
+	p.speed = speed
+}
+
+// AsyncReplay replays the records, invoking onRecord for each of them. The
+// record is only valid until onRecord returns. The callback is invoked once
+// all records are replayed, with the error which stopped the replay
+// otherwise, like ErrCorrupt, or sonicerrors.ErrCancelled if the Replayer was
+// closed.
+func (p *Replayer) AsyncReplay(onRecord func(*Record), cb func(error)) {
+	if p.closed {
+		cb(sonicerrors.ErrCancelled)
+		return
+	}
+	if p.replaying {
+		cb(sonicerrors.ErrWouldBlock)
+		return
+	}
+	p.replaying = true
+	p.onRecord = onRecord
+	p.cb = cb
+	p.replay()
+}
+
+// replay replays up to ReplayBatch records, and then continues from a post or
+// from the timer.
+func (p *Replayer) replay() {
+	for i := 0; i < ReplayBatch; i++ {
+		if p.closed {
+			return
+		}
+
+		if err := p.rd.Next(&p.record); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			p.done(err)
+			return
+		}
+
+		if p.speed > 0 {
+			if !p.started {
+				p.started = true
+				p.first = p.record.Timestamp
+				p.start = time.Now()
+			}
+			offset := time.Duration(float64(p.record.Timestamp-p.first) / p.speed)
+			if delay := time.Until(p.start.Add(offset)); delay > 0 {
+				if err := p.timer.ScheduleOnce(delay, p.deliverFn); err != nil {
+					p.done(err)
+				}
+				return
+			}
+		}
+
+		p.onRecord(&p.record)
+	}
+
+	if err := p.ioc.Post(p.continueFn); err != nil {
+		p.done(err)
+	}
+}
+
+// deliver replays the record the timer waited for and continues the replay.
+func (p *Replayer) deliver() {
+	if p.closed {
+		return
+	}
+	p.onRecord(&p.record)
+	p.replay()
+}
+
+func (p *Replayer) done(err error) {
+	p.replaying = false
+	cb := p.cb
+	p.cb, p.onRecord = nil, nil
+	cb(err)
+}
+
+// Close stops the replay and closes the Reader. A pending AsyncReplay
+// completes with sonicerrors.ErrCancelled.
+func (p *Replayer) Close() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	_ = p.timer.Close()
+	err := p.rd.Close()
+	if p.replaying {
+		p.done(sonicerrors.ErrCancelled)
+	}
+	return err
+}
@@ -0,0 +1,281 @@
+package journal
+
+import (
+	"os"
+	"time"
+
+	"github.com/talostrading/sonic"
+)
+
+// DefaultSegmentSize is the size of the segments, if no other size is set.
+const DefaultSegmentSize = 64 * 1024 * 1024
+
+// Writer appends records to a journal without blocking the IO.
+//
+// Appended records are buffered and written by a DiskFile. While a write is
+// in progress, the records appended meanwhile accumulate and are written
+// together once it completes. A segment is rotated once the next record does
+// not fit in it: it is then synced and closed.
+//
+// The writer never appends to existing segments: it starts a new one after
+// the last segment in the directory.
+//
+// A Writer is not safe for concurrent use: it must be used from the goroutine
+// running its IO.
+type Writer struct {
+	ioc   *sonic.IO
+	dir   string
+	epoch time.Time // when the writer was created, see now
+
+	segmentSize int64
+	preallocate bool
+
+	seg      *sonic.DiskFile
+	segIndex uint64
+	offset   int64 // where the next record goes in the segment
+
+	buf       []byte // records appended but not yet written
+	bufOffset int64  // where buf goes in the segment
+	free      [][]byte
+	writing   int // writes in progress
+
+	err    error // the first write error, returned by the next appends
+	closed bool
+}
+
+// NewWriter creates a Writer appending to the journal in dir, which is created
+// if it does not exist. The first segment is created on the first append.
+func NewWriter(ioc *sonic.IO, dir string) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	indexes, err := segments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		ioc:         ioc,
+		dir:         dir,
+		epoch:       time.Now(),
+		segmentSize: DefaultSegmentSize,
+		preallocate: true,
+	}
+	if len(indexes) > 0 {
+		w.segIndex = indexes[len(indexes)-1]
+	}
+	return w, nil
+}
+
+// SetSegmentSize sets the size of the segments. It applies to the segments
+// created afterwards.
+func (w *Writer) SetSegmentSize(n int64) {
+	w.segmentSize = n
+}
+
+// SetPreallocate sets whether the segments are preallocated when created, so
+// that appending to them does not fail for lack of disk space and does not
+// have to update the file size. It is true by default and applies to the
+// segments created afterwards.
+func (w *Writer) SetPreallocate(preallocate bool) {
+	w.preallocate = preallocate
+}
+
+// Append appends a record, timestamped with the time in nanoseconds since the
+// Unix epoch. The payload is copied, so it can be reused once Append returns.
+// The error is that of a previous write, if any failed.
+func (w *Writer) Append(source uint32, payload []byte) error {
+	return w.AppendAt(source, w.now(), payload)
+}
+
+// now returns the time in nanoseconds since the Unix epoch. It is measured with
+// the monotonic clock from the creation of the writer, so that the timestamps
+// of a writer never go backwards when the wall clock is set.
+func (w *Writer) now() int64 {
+	return w.epoch.UnixNano() + int64(time.Since(w.epoch))
+}
+
+// AppendAt appends a record with the given timestamp, like a timestamp taken by
+// the kernel when the message was received. The payload is copied, so it can
+// be reused once AppendAt returns.
+func (w *Writer) AppendAt(source uint32, timestamp int64, payload []byte) error {
+	if w.closed {
+		return ErrClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+
+	n := int64(RecordHeaderSize + len(payload))
+	if n > w.segmentSize-SegmentHeaderSize {
+		return ErrRecordTooLarge
+	}
+	if w.seg == nil || w.offset+n > w.segmentSize {
+		if err := w.rotate(); err != nil {
+			w.err = err
+			return err
+		}
+	}
+
+	w.buf = appendRecord(w.buf, source, timestamp, payload)
+	w.offset += n
+	if w.writing == 0 {
+		w.flush()
+	}
+	return nil
+}
+
+// Err returns the first error which occurred while writing, if any.
+func (w *Writer) Err() error {
+	return w.err
+}
+
+// Segment returns the index of the segment being appended to.
+func (w *Writer) Segment() uint64 {
+	return w.segIndex
+}
+
+func (w *Writer) flush() {
+	if w.closed || w.seg == nil || len(w.buf) == 0 {
+		return
+	}
+
+	seg, b, off := w.seg, w.buf, w.bufOffset
+	w.buf, w.bufOffset = w.takeFree(), w.offset
+	w.writing++
+
+	seg.AsyncWriteAt(b, off, func(err error, _ int) {
+		w.writing--
+		w.free = append(w.free, b[:0])
+		if err != nil && w.err == nil {
+			w.err = err
+		}
+		if w.writing == 0 && w.err == nil && !w.closed && w.seg != nil {
+			w.flush()
+		}
+	})
+}
+
+func (w *Writer) takeFree() []byte {
+	if n := len(w.free); n > 0 {
+		b := w.free[n-1]
+		w.free = w.free[:n-1]
+		return b
+	}
+	return nil
+}
+
+// rotate finishes the current segment, if any, and creates the next one.
+func (w *Writer) rotate() error {
+	if w.seg != nil {
+		w.flush()
+		w.finish(w.seg, nil)
+		w.seg = nil
+	}
+
+	seg, err := sonic.OpenDiskFile(
+		w.ioc,
+		segmentPath(w.dir, w.segIndex+1),
+		os.O_RDWR|os.O_CREATE|os.O_EXCL,
+		0o644,
+	)
+	if err != nil {
+		return err
+	}
+	w.seg = seg
+	w.segIndex++
+
+	if w.preallocate {
+		seg.AsyncFallocate(0, w.segmentSize, w.onError)
+	}
+	w.buf = encodeSegmentHeader(w.buf)
+	w.bufOffset = 0
+	w.offset = SegmentHeaderSize
+	return nil
+}
+
+// finish syncs and closes a segment once the writes issued before are done.
+func (w *Writer) finish(seg *sonic.DiskFile, cb func(error)) {
+	seg.AsyncFsync(func(err error) {
+		w.onError(err)
+		_ = seg.Close()
+		if cb != nil {
+			cb(err)
+		}
+	})
+}
+
+func (w *Writer) onError(err error) {
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+// AsyncSync invokes the callback once the records appended before are written
+// and synced to the disk.
+func (w *Writer) AsyncSync(cb func(error)) {
+	if w.closed {
+		cb(ErrClosed)
+		return
+	}
+	if w.seg == nil {
+		cb(w.err)
+		return
+	}
+
+	w.flush()
+	w.seg.AsyncFsync(func(err error) {
+		if err == nil {
+			err = w.err
+		}
+		cb(err)
+	})
+}
+
+// AsyncClose writes and syncs the records appended before, closes the journal
+// and then invokes the callback.
+func (w *Writer) AsyncClose(cb func(error)) {
+	if w.closed {
+		cb(ErrClosed)
+		return
+	}
+	w.flush()
+	w.closed = true
+
+	if w.seg == nil {
+		cb(w.err)
+		return
+	}
+
+	w.finish(w.seg, func(err error) {
+		if err == nil {
+			err = w.err
+		}
+		cb(err)
+	})
+	w.seg = nil
+}
+
+// Close closes the journal immediately. The records appended but not yet
+// written are dropped, and those being written may be lost, in which case
+// ErrUnwritten is returned. Use AsyncClose to write them first.
+func (w *Writer) Close() error {
+	if w.closed {
+		return ErrClosed
+	}
+	w.closed = true
+
+	var err error
+	if len(w.buf) > 0 || w.writing > 0 {
+		err = ErrUnwritten
+	}
+	w.buf = w.buf[:0]
+
+	if w.seg != nil {
+		if cerr := w.seg.Close(); cerr != nil {
+			err = cerr
+		}
+		w.seg = nil
+	}
+	return err
+}
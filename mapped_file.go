@@ -0,0 +1,282 @@
+package sonic
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// MapFlag configures how MapFile maps a file.
+type MapFlag int
+
+const (
+	// MapReadOnly maps the file for reading only. The file is not grown.
+	MapReadOnly MapFlag = 1 << iota
+
+	// MapPrivate maps the file copy-on-write: writes to the mapping are not
+	// carried to the file, nor visible to other mappings of it.
+	MapPrivate
+
+	// MapCreate creates the file if it does not exist.
+	MapCreate
+
+	// MapPopulate prefaults the mapping, so that accessing it does not page
+	// fault. It is ignored on BSD.
+	MapPopulate
+
+	// MapHugePages asks for the mapping to be backed by transparent huge pages,
+	// which the kernel does for files on tmpfs or, if configured to, on some
+	// filesystems for read-only mappings. It is ignored on BSD.
+	MapHugePages
+)
+
+// Advice is a hint about how a mapping is going to be accessed, see
+// MappedFile.Advise.
+type Advice int
+
+const (
+	AdviseNormal     Advice = unix.MADV_NORMAL
+	AdviseRandom     Advice = unix.MADV_RANDOM
+	AdviseSequential Advice = unix.MADV_SEQUENTIAL
+	AdviseWillNeed   Advice = unix.MADV_WILLNEED
+	AdviseDontNeed   Advice = unix.MADV_DONTNEED
+)
+
+// MappedFile is a file mapped in memory. Its bytes are read and written in
+// place, without copies.
+//
+// A MappedFile is not safe for concurrent use, although the bytes of the
+// mapping can be accessed from any goroutine.
+type MappedFile struct {
+	fd    int
+	flags MapFlag
+	data  []byte
+
+	disk *DiskFile // to sync asynchronously, created on the first AsyncMsync
+
+	closed bool
+}
+
+// MapFile maps the named file in memory. If size is greater than the size of
+// the file, the file is grown to size, unless it is mapped read-only. If size
+// is 0, the whole file is mapped.
+func MapFile(path string, size int64, flags MapFlag) (*MappedFile, error) {
+	openFlags := syscall.O_RDWR
+	if flags&MapReadOnly != 0 {
+		openFlags = syscall.O_RDONLY
+	}
+	if flags&MapCreate != 0 {
+		openFlags |= syscall.O_CREAT
+	}
+
+	fd, err := syscall.Open(path, openFlags|syscall.O_CLOEXEC, 0o644)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
+	m := &MappedFile{
+		fd:    fd,
+		flags: flags,
+	}
+	if err := m.mapSize(size); err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+	return m, nil
+}
+
+// mapSize grows the file to size if needed and maps it.
+func (m *MappedFile) mapSize(size int64) error {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(m.fd, &st); err != nil {
+		return os.NewSyscallError("fstat", err)
+	}
+	if size == 0 {
+		size = st.Size
+	}
+	if size == 0 {
+		return errors.New("sonic: cannot map an empty file")
+	}
+
+	if size > st.Size {
+		if m.flags&MapReadOnly != 0 {
+			return io.ErrUnexpectedEOF
+		}
+		if err := syscall.Ftruncate(m.fd, size); err != nil {
+			return os.NewSyscallError("ftruncate", err)
+		}
+	}
+
+	data, err := unix.Mmap(m.fd, 0, int(size), m.prot(), m.mapFlags())
+	if err != nil {
+		return os.NewSyscallError("mmap", err)
+	}
+	if err := m.adviseHugePages(data); err != nil {
+		_ = unix.Munmap(data)
+		return err
+	}
+	m.data = data
+	return nil
+}
+
+func (m *MappedFile) prot() int {
+	if m.flags&MapReadOnly != 0 {
+		return unix.PROT_READ
+	}
+	return unix.PROT_READ | unix.PROT_WRITE
+}
+
+func (m *MappedFile) mapFlags() int {
+	flags := unix.MAP_SHARED
+	if m.flags&MapPrivate != 0 {
+		flags = unix.MAP_PRIVATE
+	}
+	if m.flags&MapPopulate != 0 {
+		flags |= mapPopulate
+	}
+	return flags
+}
+
+func (m *MappedFile) adviseHugePages(data []byte) error {
+	if m.flags&MapHugePages == 0 {
+		return nil
+	}
+	return adviseHugePages(data)
+}
+
+// Bytes returns the mapping. It is invalidated by Grow and Close: accessing it
+// afterwards faults.
+func (m *MappedFile) Bytes() []byte {
+	return m.data
+}
+
+// Len returns the size of the mapping.
+func (m *MappedFile) Len() int {
+	return len(m.data)
+}
+
+// Grow grows the file and the mapping to size. The mapping may move, so the
+// slices previously returned by Bytes must not be used afterwards. On BSD the
+// file is mapped again, so the changes made to a private mapping are lost.
+func (m *MappedFile) Grow(size int64) error {
+	if m.closed {
+		return io.EOF
+	}
+	if m.flags&MapReadOnly != 0 {
+		return errors.New("sonic: cannot grow a read-only mapping")
+	}
+	if size <= int64(len(m.data)) {
+		return nil
+	}
+
+	if err := syscall.Ftruncate(m.fd, size); err != nil {
+		return os.NewSyscallError("ftruncate", err)
+	}
+	data, err := m.remap(int(size))
+	if err != nil {
+		return err
+	}
+	if err := m.adviseHugePages(data); err != nil {
+		m.data = data
+		return err
+	}
+	m.data = data
+	return nil
+}
+
+// Advise tells the kernel how the mapping is going to be accessed, so that it
+// can read ahead, or free the pages which are not needed anymore.
+func (m *MappedFile) Advise(advice Advice) error {
+	if m.closed {
+		return io.EOF
+	}
+	if err := unix.Madvise(m.data, int(advice)); err != nil {
+		return os.NewSyscallError("madvise", err)
+	}
+	return nil
+}
+
+// Sync writes the modified pages of the mapping to the file, blocking until
+// they are on the disk.
+func (m *MappedFile) Sync() error {
+	if m.closed {
+		return io.EOF
+	}
+	return msync(m.data)
+}
+
+// AsyncMsync writes the modified pages of the mapping to the file without
+// blocking the IO, invoking the callback once they are on the disk. The sync
+// runs in the goroutines of DiskFile. All calls must be given the same IO.
+//
+// The mapping must not be grown while a sync is pending. If it is closed, the
+// pending sync may fail.
+func (m *MappedFile) AsyncMsync(ioc *IO, cb func(error)) {
+	if m.closed {
+		cb(io.EOF)
+		return
+	}
+
+	if m.disk == nil {
+		fd, err := dupCloexec(m.fd)
+		if err != nil {
+			cb(err)
+			return
+		}
+		disk, err := newDiskFile(ioc, fd)
+		if err != nil {
+			_ = syscall.Close(fd)
+			cb(err)
+			return
+		}
+		m.disk = disk
+	}
+
+	data := m.data
+	m.disk.asyncCall(func() error {
+		return msync(data)
+	}, cb)
+}
+
+func msync(data []byte) error {
+	if err := unix.Msync(data, unix.MS_SYNC); err != nil {
+		return os.NewSyscallError("msync", err)
+	}
+	return nil
+}
+
+func dupCloexec(fd int) (int, error) {
+	syscall.ForkLock.RLock()
+	nfd, err := syscall.Dup(fd)
+	if err == nil {
+		syscall.CloseOnExec(nfd)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return -1, os.NewSyscallError("dup", err)
+	}
+	return nfd, nil
+}
+
+// Close unmaps the file and closes it. The modified pages of a shared mapping
+// are written to the file by the kernel eventually; call Sync before to make
+// sure they are on the disk.
+func (m *MappedFile) Close() error {
+	if m.closed {
+		return io.EOF
+	}
+	m.closed = true
+
+	if m.disk != nil {
+		_ = m.disk.Close()
+	}
+	err := unix.Munmap(m.data)
+	m.data = nil
+	if cerr := syscall.Close(m.fd); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package sonic
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const mapPopulate = 0
+
+// remap maps the file again with the new size, as there is no mremap.
+func (m *MappedFile) remap(size int) ([]byte, error) {
+	data, err := unix.Mmap(m.fd, 0, size, m.prot(), m.mapFlags())
+	if err != nil {
+		return nil, os.NewSyscallError("mmap", err)
+	}
+	if err := unix.Munmap(m.data); err != nil {
+		_ = unix.Munmap(data)
+		return nil, os.NewSyscallError("munmap", err)
+	}
+	return data, nil
+}
+
+func adviseHugePages([]byte) error {
+	return nil
+}
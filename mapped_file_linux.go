@@ -0,0 +1,26 @@
+//go:build linux
+
+package sonic
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const mapPopulate = unix.MAP_POPULATE
+
+func (m *MappedFile) remap(size int) ([]byte, error) {
+	data, err := unix.Mremap(m.data, size, unix.MREMAP_MAYMOVE)
+	if err != nil {
+		return nil, os.NewSyscallError("mremap", err)
+	}
+	return data, nil
+}
+
+func adviseHugePages(data []byte) error {
+	if err := unix.Madvise(data, unix.MADV_HUGEPAGE); err != nil {
+		return os.NewSyscallError("madvise", err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package sonic
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMappedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapped")
+
+	m, err := MapFile(path, 4096, MapCreate|MapPopulate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Len() != 4096 {
+		t.Fatalf("expected len=4096 got=%d", m.Len())
+	}
+	copy(m.Bytes(), "hello")
+	if err := m.Advise(AdviseSequential); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Grow(8192); err != nil {
+		t.Fatal(err)
+	}
+	if m.Len() != 8192 || string(m.Bytes()[:5]) != "hello" {
+		t.Fatalf("unexpected mapping after Grow len=%d", m.Len())
+	}
+	copy(m.Bytes()[4096:], "sonic")
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 8192 || string(b[:5]) != "hello" || string(b[4096:4101]) != "sonic" {
+		t.Fatalf("unexpected file len=%d", len(b))
+	}
+
+	// The whole file is mapped if no size is given.
+	ro, err := MapFile(path, 0, MapReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	if ro.Len() != 8192 || string(ro.Bytes()[4096:4101]) != "sonic" {
+		t.Fatalf("unexpected read-only mapping len=%d", ro.Len())
+	}
+	if err := ro.Grow(16384); err == nil {
+		t.Fatal("expected a read-only mapping not to grow")
+	}
+}
+
+func TestMappedFilePrivate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapped")
+	if err := os.WriteFile(path, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := MapFile(path, 0, MapPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(m.Bytes(), "HELLO")
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("expected the file to be unchanged got=%s", b)
+	}
+}
+
+func TestMappedFileAsyncMsync(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	path := filepath.Join(t.TempDir(), "mapped")
+	m, err := MapFile(path, 4096, MapCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	copy(m.Bytes(), "hello")
+	synced := false
+	m.AsyncMsync(ioc, func(err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		synced = true
+	})
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+	if !synced {
+		t.Fatal("the sync did not complete")
+	}
+}
+
+func TestMappedFileHugePages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapped")
+	m, err := MapFile(path, 4<<20, MapCreate|MapHugePages)
+	if err != nil {
+		t.Skipf("huge pages are not supported: %v", err)
+	}
+	defer m.Close()
+
+	copy(m.Bytes(), "hello")
+	if err := m.Grow(8 << 20); err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Bytes()[:5]) != "hello" {
+		t.Fatal("unexpected mapping after Grow")
+	}
+}
@@ -1,16 +1,19 @@
 package multicast
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/netip"
+	"slices"
 	"syscall"
 
 	"github.com/talostrading/sonic"
 	"github.com/talostrading/sonic/internal"
 	"github.com/talostrading/sonic/net/ipv4"
 	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
 )
 
 var emptyIPv4Addr = [4]byte{0x0, 0x0, 0x0, 0x0}
@@ -23,6 +26,8 @@ type UDPPeer struct {
 	stats      *Stats
 	read       *readReactor
 	write      *writeReactor
+	readMsg    *readMsgReactor
+	writeMsg   *writeMsgReactor
 	outbound   *net.Interface
 	outboundIP netip.Addr
 	inbound    *net.Interface
@@ -30,10 +35,19 @@ type UDPPeer struct {
 	ttl        uint8
 	all        bool
 
+	// The groups joined, to join them again with Rejoin.
+	memberships []membership
+
 	slot internal.Slot
 
 	sockAddr syscall.Sockaddr
 	closed   bool
+
+	// Set if SetDropCounting(true). Reads are then done with recvmsg in order
+	// to receive the SO_RXQ_OVFL control message.
+	dropCounting bool
+	dropOOB      []byte
+	dropCM       sonic.ControlMessage
 }
 
 // NewUDPPeer creates a new UDPPeer capable of reading/writing multicast packets
@@ -122,7 +136,15 @@ type UDPPeer struct {
 // Once from SetLoop(true) and once from the router. Your network router sees
 // the packets sent by the writer and destined to a routable multicast IP coming
 // in and it routes them back to your machine.
-func NewUDPPeer(ioc *sonic.IO, network string, addr string) (*UDPPeer, error) {
+//
+// Additional socket options, such as sonicopts.RecvBuffer, can be passed in
+// opts. They are applied before the socket is bound.
+func NewUDPPeer(
+	ioc *sonic.IO,
+	network string,
+	addr string,
+	opts ...sonicopts.Option,
+) (*UDPPeer, error) {
 	resolvedAddr, err := net.ResolveUDPAddr(network, addr)
 
 	if err != nil {
@@ -164,6 +186,11 @@ func NewUDPPeer(ioc *sonic.IO, network string, addr string) (*UDPPeer, error) {
 		return nil, fmt.Errorf("error on socket REUSE_ADDR")
 	}
 
+	if err := internal.ApplyOpts(socket.RawFd(), opts...); err != nil {
+		_ = socket.Close()
+		return nil, err
+	}
+
 	if err := socket.Bind(resolvedAddr.AddrPort()); err != nil {
 		return nil, fmt.Errorf(
 			"cannot bind socket to addr=%s err=%v", resolvedAddr, err)
@@ -207,6 +234,8 @@ func NewUDPPeer(ioc *sonic.IO, network string, addr string) (*UDPPeer, error) {
 	}
 	p.read = &readReactor{peer: p}
 	p.write = &writeReactor{peer: p}
+	p.readMsg = &readMsgReactor{peer: p}
+	p.writeMsg = &writeMsgReactor{peer: p}
 	p.slot.Fd = p.socket.RawFd()
 
 	if ipv == 4 {
@@ -408,13 +437,84 @@ func (p *UDPPeer) JoinSourceOn(
 	}
 
 	if mip.Is4() || mip.Is4In6() {
-		return p.joinIPv4(mip, iff, sip)
+		err = p.joinIPv4(mip, iff, sip)
 	} else if mip.Is6() {
-		return p.joinIPv6(mip, iff, sip)
+		err = p.joinIPv6(mip, iff, sip)
 	} else {
 		return fmt.Errorf(
 			"unknown IP addressing scheme for addr=%s", multicastIP)
 	}
+	if err == nil {
+		p.memberships = append(p.memberships, membership{
+			group:  mip,
+			source: sip,
+			iface:  string(interfaceName),
+		})
+	}
+	return err
+}
+
+type membership struct {
+	group  netip.Addr
+	source netip.Addr
+	iface  string
+}
+
+// Rejoin joins again the groups joined on the given interface with JoinOn or
+// JoinSourceOn.
+//
+// The kernel keeps the memberships of a socket across an interface going down
+// and up, but not across the interface being removed and created again, as the
+// new interface has a new index. Rejoin is meant to be called when an
+// interface comes back up, like the netlink package does. The groups which are
+// still joined are left as they are.
+//
+// If the interface is the one set with SetInbound, the socket is bound to it
+// again, for the same reason.
+func (p *UDPPeer) Rejoin(interfaceName InterfaceName) error {
+	var errs []error
+	if p.inbound != nil && p.inbound.Name == string(interfaceName) {
+		// The interface is refreshed, as it may have a new index.
+		if iff, err := p.socket.BindToDevice(string(interfaceName)); err != nil {
+			errs = append(errs, fmt.Errorf(
+				"could not bind again to interface=%s err=%v",
+				interfaceName, err))
+		} else {
+			p.inbound = iff
+		}
+	}
+	for _, m := range p.memberships {
+		if m.iface != string(interfaceName) {
+			continue
+		}
+
+		iff, err := resolveMulticastInterface(m.iface)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		err = p.joinIPv4(m.group, iff, m.source)
+		if err != nil && !errors.Is(err, syscall.EADDRINUSE) {
+			errs = append(errs, fmt.Errorf(
+				"could not rejoin group=%s on interface=%s err=%v",
+				m.group, m.iface, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Interfaces returns the names of the interfaces on which groups were joined
+// with JoinOn or JoinSourceOn.
+func (p *UDPPeer) Interfaces() []InterfaceName {
+	var names []InterfaceName
+	for _, m := range p.memberships {
+		if m.iface == "" || slices.Contains(names, InterfaceName(m.iface)) {
+			continue
+		}
+		names = append(names, InterfaceName(m.iface))
+	}
+	return names
 }
 
 func (p *UDPPeer) joinIPv4(
@@ -469,13 +569,19 @@ func (p *UDPPeer) LeaveSource(multicastIP IP, sourceIP SourceIP) error {
 	}
 
 	if mip.Is4() || mip.Is4In6() {
-		return p.leaveIPv4(mip, sip)
+		err = p.leaveIPv4(mip, sip)
 	} else if mip.Is6() {
-		return p.leaveIPv6(mip, sip)
+		err = p.leaveIPv6(mip, sip)
 	} else {
 		return fmt.Errorf(
 			"unknown IP addressing scheme for addr=%s", multicastIP)
 	}
+	if err == nil {
+		p.memberships = slices.DeleteFunc(p.memberships, func(m membership) bool {
+			return m.group == mip && m.source == sip
+		})
+	}
+	return err
 }
 
 func (p *UDPPeer) leaveIPv4(multicastIP, sourceIP netip.Addr) (err error) {
@@ -554,8 +660,46 @@ func (p *UDPPeer) unblockIPv6(multicastIP, sourceIP netip.Addr) (err error) {
 	panic("IPv6 multicast peer not yet supported")
 }
 
+// SetDropCounting enables or disables counting the datagrams dropped by the
+// kernel on this peer's socket because its receive buffer was full
+// (SO_RXQ_OVFL).
+//
+// When enabled, each read carries the cumulative number of kernel drops,
+// which is then exposed by Stats().KernelDrops(). Comparing it with the gaps in
+// the sequence numbers of a feed tells packets lost upstream from packets the
+// kernel dropped because the peer did not drain its socket fast enough.
+//
+// Reads are slightly more expensive when drop counting is enabled, as they
+// are done with recvmsg instead of recvfrom.
+func (p *UDPPeer) SetDropCounting(on bool) error {
+	if err := p.socket.SetControlMessage(sonic.ControlDropped, on); err != nil {
+		return err
+	}
+	p.dropCounting = on
+	if on && p.dropOOB == nil {
+		p.dropOOB = make([]byte, sonic.ControlMessageSpace(sonic.ControlDropped))
+	}
+	return nil
+}
+
+func (p *UDPPeer) DropCounting() bool {
+	return p.dropCounting
+}
+
 func (p *UDPPeer) Read(b []byte) (int, netip.AddrPort, error) {
-	return p.socket.RecvFrom(b, 0)
+	if !p.dropCounting {
+		return p.socket.RecvFrom(b, 0)
+	}
+
+	n, oobn, _, from, err := p.socket.RecvMsg(b, p.dropOOB, 0)
+	if err == nil && oobn > 0 {
+		// The kernel only sends SO_RXQ_OVFL once something has been dropped.
+		if p.dropCM.Parse(p.dropOOB[:oobn]) == nil &&
+			p.dropCM.Has(sonic.ControlDropped) {
+			p.stats.kernel.dropped = p.dropCM.Dropped
+		}
+	}
+	return n, from, err
 }
 
 func (p *UDPPeer) SetAsyncReadBuffer(to []byte) {
@@ -666,6 +810,141 @@ func (p *UDPPeer) scheduleWrite(fn func(error, int)) {
 	}
 }
 
+// SetControlMessage enables or disables the reception of the given control
+// messages. These are then returned by ReadMsg and AsyncReadMsg.
+//
+// For example, with sonic.ControlPacketInfo, a peer that joined several groups
+// on the same port can tell which group a datagram was sent to by looking at
+// the Dst of the parsed sonic.ControlMessage.
+func (p *UDPPeer) SetControlMessage(flags sonic.ControlFlags, on bool) error {
+	return p.socket.SetControlMessage(flags, on)
+}
+
+// ReadMsg reads a datagram into b and its control messages into oob. See
+// sonic.ControlMessageSpace for how big oob should be.
+func (p *UDPPeer) ReadMsg(
+	b, oob []byte,
+) (n, oobn int, from netip.AddrPort, err error) {
+	n, oobn, _, from, err = p.socket.RecvMsg(b, oob, 0)
+	return n, oobn, from, err
+}
+
+func (p *UDPPeer) AsyncReadMsg(
+	b, oob []byte,
+	fn func(err error, n, oobn int, from netip.AddrPort),
+) {
+	p.readMsg.b = b
+	p.readMsg.oob = oob
+	p.readMsg.fn = fn
+
+	if p.ioc.Dispatched < sonic.MaxCallbackDispatch {
+		p.asyncReadMsgNow(
+			b, oob,
+			func(err error, n, oobn int, addr netip.AddrPort) {
+				p.ioc.Dispatched++
+				fn(err, n, oobn, addr)
+				p.ioc.Dispatched--
+			})
+	} else {
+		p.scheduleReadMsg(fn)
+	}
+}
+
+func (p *UDPPeer) asyncReadMsgNow(
+	b, oob []byte,
+	fn func(error, int, int, netip.AddrPort),
+) {
+	n, oobn, addr, err := p.ReadMsg(b, oob)
+
+	if err == nil {
+		p.stats.async.immediateReads++
+		fn(err, n, oobn, addr)
+		return
+	}
+
+	if err == sonicerrors.ErrWouldBlock {
+		p.scheduleReadMsg(fn)
+	} else {
+		fn(err, 0, 0, addr)
+	}
+}
+
+func (p *UDPPeer) scheduleReadMsg(fn func(error, int, int, netip.AddrPort)) {
+	if p.Closed() {
+		fn(io.EOF, 0, 0, netip.AddrPort{})
+	} else {
+		p.slot.Set(internal.ReadEvent, p.readMsg.on)
+
+		if err := p.ioc.SetRead(&p.slot); err != nil {
+			fn(err, 0, 0, netip.AddrPort{})
+		} else {
+			p.stats.async.scheduledReads++
+			p.ioc.Register(&p.slot)
+		}
+	}
+}
+
+// WriteMsg writes b to addr along with the control messages in oob. See
+// sonic.ControlMessage.Marshal for how to build oob.
+func (p *UDPPeer) WriteMsg(b, oob []byte, addr netip.AddrPort) (int, error) {
+	return p.socket.SendMsg(b, oob, 0, addr)
+}
+
+func (p *UDPPeer) AsyncWriteMsg(
+	b, oob []byte,
+	addr netip.AddrPort,
+	fn func(error, int),
+) {
+	p.writeMsg.b = b
+	p.writeMsg.oob = oob
+	p.writeMsg.addr = addr
+	p.writeMsg.fn = fn
+
+	if p.ioc.Dispatched < sonic.MaxCallbackDispatch {
+		p.asyncWriteMsgNow(b, oob, addr, func(err error, n int) {
+			p.ioc.Dispatched++
+			fn(err, n)
+			p.ioc.Dispatched--
+		})
+	} else {
+		p.scheduleWriteMsg(fn)
+	}
+}
+
+func (p *UDPPeer) asyncWriteMsgNow(
+	b, oob []byte,
+	addr netip.AddrPort,
+	fn func(error, int),
+) {
+	n, err := p.WriteMsg(b, oob, addr)
+
+	if err == nil {
+		fn(err, n)
+		return
+	}
+
+	if err == sonicerrors.ErrWouldBlock ||
+		err == sonicerrors.ErrNoBufferSpaceAvailable {
+		p.scheduleWriteMsg(fn)
+	} else {
+		fn(err, 0)
+	}
+}
+
+func (p *UDPPeer) scheduleWriteMsg(fn func(error, int)) {
+	if p.Closed() {
+		fn(io.EOF, 0)
+	} else {
+		p.slot.Set(internal.WriteEvent, p.writeMsg.on)
+
+		if err := p.ioc.SetWrite(&p.slot); err != nil {
+			fn(err, 0)
+		} else {
+			p.ioc.Register(&p.slot)
+		}
+	}
+}
+
 // LocalAddr of the peer. Note that the IP can be zero if addr is empty in
 // NewUDPPeer.
 func (p *UDPPeer) LocalAddr() *net.UDPAddr {
@@ -8,12 +8,14 @@ import (
 	"net/netip"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/talostrading/sonic"
 	"github.com/talostrading/sonic/net/ipv4"
 	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
 )
 
 // TODO: really don't know how to make this run on my mac
@@ -490,3 +492,201 @@ func TestUDPPeerIPv4_JoinReadBlockUnblockRead(t *testing.T) {
 		t.Fatal("did not read anything after unblocking")
 	}
 }
+
+func TestUDPPeerIPv4_DemultiplexByGroup(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	groups := []string{"224.0.0.21", "224.0.0.22"}
+
+	r, err := NewUDPPeer(ioc, "udp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for _, group := range groups {
+		if err := r.Join(IP(group)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.SetControlMessage(sonic.ControlPacketInfo, true); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(map[netip.Addr]int)
+
+	var (
+		rb  = make([]byte, 128)
+		oob = make([]byte, sonic.ControlMessageSpace(sonic.ControlPacketInfo))
+		cm  sonic.ControlMessage
+	)
+	var onRead func(error, int, int, netip.AddrPort)
+	onRead = func(err error, n, oobn int, _ netip.AddrPort) {
+		if err == nil {
+			if err := cm.Parse(oob[:oobn]); err != nil {
+				t.Fatal(err)
+			}
+			if !cm.Has(sonic.ControlPacketInfo) {
+				t.Fatal("expected packet info")
+			}
+			if string(rb[:n]) != cm.Dst.String() {
+				t.Fatalf(
+					"datagram for group=%s arrived on group=%s",
+					string(rb[:n]), cm.Dst)
+			}
+			received[cm.Dst]++
+			r.AsyncReadMsg(rb, oob, onRead)
+		}
+	}
+	r.AsyncReadMsg(rb, oob, onRead)
+
+	w, err := NewUDPPeer(ioc, "udp", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 10; i++ {
+		for _, group := range groups {
+			addr := netip.AddrPortFrom(
+				netip.MustParseAddr(group), uint16(r.LocalAddr().Port))
+			_, err := w.WriteMsg([]byte(group), nil, addr)
+			if err != nil && err != sonicerrors.ErrNoBufferSpaceAvailable {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(time.Millisecond)
+
+		for j := 0; j < 10; j++ {
+			_, _ = ioc.PollOne()
+		}
+	}
+
+	for _, group := range groups {
+		if received[netip.MustParseAddr(group)] == 0 {
+			t.Fatalf("did not receive anything on group=%s", group)
+		}
+	}
+}
+
+func TestUDPPeerIPv4_KernelDrops(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	multicastIP := "224.0.0.23"
+
+	r, err := NewUDPPeer(ioc, "udp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if err := r.Join(IP(multicastIP)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetDropCounting(true); err != nil {
+		t.Fatal(err)
+	}
+	if !r.DropCounting() {
+		t.Fatal("drop counting should be enabled")
+	}
+
+	// Make the receive buffer as small as possible so the kernel drops
+	// datagrams that we do not drain.
+	if err := syscall.SetsockoptInt(
+		r.NextLayer().RawFd(), syscall.SOL_SOCKET, syscall.SO_RCVBUF, 1,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewUDPPeer(ioc, "udp", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	addr := netip.AddrPortFrom(
+		netip.MustParseAddr(multicastIP), uint16(r.LocalAddr().Port))
+	wb := make([]byte, 1024)
+	for i := 0; i < 128; i++ {
+		_, _ = w.Write(wb, addr)
+	}
+
+	rb := make([]byte, 1024)
+	drain := func() {
+		for {
+			_, _, err := r.Read(rb)
+			if err != nil {
+				break
+			}
+		}
+	}
+	drain()
+
+	// The drop count is attached to a datagram when it is queued, so only the
+	// datagrams queued after the drops carry it. The writer's send buffer might
+	// still be full, so retry until it drains.
+	for {
+		_, err := w.Write(wb, addr)
+		if err == nil {
+			break
+		}
+		if err != sonicerrors.ErrWouldBlock {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	drain()
+
+	drops := r.Stats().KernelDrops()
+	if drops == 0 {
+		t.Fatal("expected the kernel to drop datagrams")
+	}
+	log.Printf("kernel dropped %d datagrams", drops)
+
+	r.Stats().Reset()
+	if r.Stats().KernelDrops() != 0 {
+		t.Fatal("kernel drops should be 0 after reset")
+	}
+	if r.Stats().KernelDropsTotal() != drops {
+		t.Fatal("total kernel drops should not be affected by reset")
+	}
+}
+
+func TestUDPPeerIPv4_SocketOpts(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	p, err := NewUDPPeer(
+		ioc, "udp", ":0",
+		sonicopts.RecvBuffer(65536),
+		sonicopts.BusyPoll(20*time.Microsecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	rcvbuf, err := sonicopts.GetRecvBuffer(p.NextLayer().RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rcvbuf != 2*65536 {
+		t.Fatalf("unexpected receive buffer size=%d", rcvbuf)
+	}
+
+	busyPoll, err := sonicopts.GetBusyPoll(p.NextLayer().RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if busyPoll != 20*time.Microsecond {
+		t.Fatalf("unexpected busy poll=%s", busyPoll)
+	}
+
+	if _, err := NewUDPPeer(
+		ioc, "udp", ":0", sonicopts.NotSentLowat(1),
+	); err == nil {
+		t.Fatal("TCP options should not be accepted by a UDP peer")
+	}
+}
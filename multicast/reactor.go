@@ -36,3 +36,38 @@ func (r *writeReactor) on(err error) {
 		r.peer.asyncWriteNow(r.b, r.addr, r.fn)
 	}
 }
+
+type readMsgReactor struct {
+	peer *UDPPeer
+	b    []byte
+	oob  []byte
+	fn   func(error, int, int, netip.AddrPort)
+}
+
+func (r *readMsgReactor) on(err error) {
+	r.peer.ioc.Deregister(&r.peer.slot)
+
+	if err != nil {
+		r.fn(err, 0, 0, netip.AddrPort{})
+	} else {
+		r.peer.asyncReadMsgNow(r.b, r.oob, r.fn)
+	}
+}
+
+type writeMsgReactor struct {
+	peer *UDPPeer
+	b    []byte
+	oob  []byte
+	addr netip.AddrPort
+	fn   func(error, int)
+}
+
+func (r *writeMsgReactor) on(err error) {
+	r.peer.ioc.Deregister(&r.peer.slot)
+
+	if err != nil {
+		r.fn(err, 0)
+	} else {
+		r.peer.asyncWriteMsgNow(r.b, r.oob, r.addr, r.fn)
+	}
+}
@@ -8,6 +8,15 @@ type Stats struct {
 		immediateWrites int
 		scheduledWrites int
 	}
+
+	kernel struct {
+		// dropped is the cumulative number of datagrams dropped by the kernel
+		// on the peer's socket, as reported with the last datagram read.
+		dropped uint32
+
+		// droppedAtReset is the value of dropped at the last Reset.
+		droppedAtReset uint32
+	}
 }
 
 func (s *Stats) Reset() {
@@ -16,6 +25,8 @@ func (s *Stats) Reset() {
 
 	s.async.immediateWrites = 0
 	s.async.scheduledWrites = 0
+
+	s.kernel.droppedAtReset = s.kernel.dropped
 }
 
 // AsyncReadPerf gives an indication of the async read performance of the peer.
@@ -54,3 +65,22 @@ func (s *Stats) AsyncImmediateWrites() int {
 func (s *Stats) AsyncScheduledWrites() int {
 	return s.async.scheduledWrites
 }
+
+// KernelDrops returns the number of datagrams the kernel dropped on the peer's
+// socket since the last Reset, or since the socket was created if Reset was
+// not called, because the socket's receive buffer was full. These are
+// datagrams that reached the host but that the peer did not drain fast enough.
+//
+// This is only counted if drop counting has been enabled on the peer with
+// SetDropCounting. The count is updated on each read.
+func (s *Stats) KernelDrops() uint32 {
+	return s.kernel.dropped - s.kernel.droppedAtReset
+}
+
+// KernelDropsTotal is like KernelDrops but it is not affected by Reset. It is
+// the cumulative number of drops since the socket was created, as the kernel
+// counts them, including the drops which happened before drop counting was
+// enabled.
+func (s *Stats) KernelDropsTotal() uint32 {
+	return s.kernel.dropped
+}
@@ -0,0 +1,179 @@
+// Package netlink reports the changes to the network interfaces of the host,
+// such as links going up or down and addresses being added or removed.
+//
+// On Linux, a Monitor reads the events from an rtnetlink socket on a sonic.IO,
+// so they are delivered on the same goroutine as the rest of the IO's
+// handlers. A Rejoiner uses them to join again the multicast groups of
+// multicast.UDPPeers when their interfaces come back.
+package netlink
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+
+	"github.com/talostrading/sonic/multicast"
+)
+
+// EventType is the kind of change reported by an Event.
+type EventType uint8
+
+const (
+	// LinkUp is reported when an interface becomes up and running.
+	LinkUp EventType = iota + 1
+
+	// LinkDown is reported when an interface stops being up and running, for
+	// example when it is brought down or loses its carrier.
+	LinkDown
+
+	// LinkRemoved is reported when an interface is removed.
+	LinkRemoved
+
+	// AddrAdded is reported when an address is added to an interface.
+	AddrAdded
+
+	// AddrRemoved is reported when an address is removed from an interface.
+	AddrRemoved
+)
+
+func (t EventType) String() string {
+	switch t {
+	case LinkUp:
+		return "link_up"
+	case LinkDown:
+		return "link_down"
+	case LinkRemoved:
+		return "link_removed"
+	case AddrAdded:
+		return "addr_added"
+	case AddrRemoved:
+		return "addr_removed"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(t))
+	}
+}
+
+// Event is a change to a network interface.
+type Event struct {
+	Type EventType
+
+	// Index and Name identify the interface.
+	Index int
+	Name  string
+
+	// Addr is the address added or removed, for AddrAdded and AddrRemoved.
+	Addr netip.Prefix
+}
+
+func (ev Event) String() string {
+	if ev.Addr.IsValid() {
+		return fmt.Sprintf(
+			"%s interface=%s index=%d addr=%s", ev.Type, ev.Name, ev.Index, ev.Addr)
+	}
+	return fmt.Sprintf("%s interface=%s index=%d", ev.Type, ev.Name, ev.Index)
+}
+
+// AsyncEventCallback is invoked with the next event of a Monitor.
+type AsyncEventCallback func(err error, ev Event)
+
+// ErrOverflow is reported when the kernel dropped events because they were not
+// read fast enough. The states of the links are reloaded, so the events that
+// follow are consistent, but the changes in between are lost.
+var ErrOverflow = errors.New("netlink events were dropped")
+
+// Rejoiner joins again the multicast groups of UDPPeers when their interfaces
+// come back, by calling UDPPeer.Rejoin.
+//
+// The kernel keeps the memberships of a socket while an interface is down, but
+// drops them when the interface is removed. An interface which is created again
+// with the same name, like a VPN tunnel or a bond, needs its groups to be
+// joined again once it is up and has an IPv4 address.
+//
+// The events of a Monitor are passed to OnEvent:
+//
+//	var onEvent netlink.AsyncEventCallback
+//	onEvent = func(err error, ev netlink.Event) {
+//		if err == nil {
+//			if err := rejoiner.OnEvent(ev); err != nil {
+//				log.Println(err)
+//			}
+//		}
+//		monitor.AsyncNextEvent(onEvent)
+//	}
+//	monitor.AsyncNextEvent(onEvent)
+type Rejoiner struct {
+	peers []*multicast.UDPPeer
+}
+
+// NewRejoiner creates a Rejoiner for the given peers.
+func NewRejoiner(peers ...*multicast.UDPPeer) *Rejoiner {
+	return &Rejoiner{peers: peers}
+}
+
+// Add adds a peer whose groups are to be joined again.
+func (r *Rejoiner) Add(peer *multicast.UDPPeer) {
+	if !slices.Contains(r.peers, peer) {
+		r.peers = append(r.peers, peer)
+	}
+}
+
+// Remove removes a peer added with Add or NewRejoiner.
+func (r *Rejoiner) Remove(peer *multicast.UDPPeer) {
+	r.peers = slices.DeleteFunc(r.peers, func(p *multicast.UDPPeer) bool {
+		return p == peer
+	})
+}
+
+// OnEvent joins again the groups of the peers on the interface of the event,
+// if the event is a LinkUp or the addition of an IPv4 address, and the
+// interface is then up with an IPv4 address. The other events are ignored.
+func (r *Rejoiner) OnEvent(ev Event) error {
+	switch ev.Type {
+	case LinkUp:
+	case AddrAdded:
+		if !ev.Addr.Addr().Is4() {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	if !canJoin(ev.Name) {
+		return nil
+	}
+
+	var errs []error
+	for _, peer := range r.peers {
+		if peer.Closed() {
+			continue
+		}
+		if !slices.Contains(peer.Interfaces(), multicast.InterfaceName(ev.Name)) {
+			continue
+		}
+		if err := peer.Rejoin(multicast.InterfaceName(ev.Name)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// canJoin returns true if groups can be joined on the interface: it is up and
+// has an IPv4 address.
+func canJoin(name string) bool {
+	iff, err := net.InterfaceByName(name)
+	if err != nil || iff.Flags&net.FlagUp == 0 {
+		return false
+	}
+	addrs, err := iff.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package netlink
+
+import (
+	"fmt"
+
+	"github.com/talostrading/sonic"
+)
+
+var errMonitorNotSupported = fmt.Errorf("netlink is not yet supported on BSD")
+
+// Monitor reports the changes to the network interfaces. It is not yet
+// supported on BSD.
+type Monitor struct{}
+
+// NewMonitor fails on BSD.
+func NewMonitor(ioc *sonic.IO) (*Monitor, error) {
+	return nil, errMonitorNotSupported
+}
+
+func (m *Monitor) AsyncNextEvent(cb AsyncEventCallback) {
+	cb(errMonitorNotSupported, Event{})
+}
+
+func (m *Monitor) Close() error {
+	return errMonitorNotSupported
+}
@@ -0,0 +1,314 @@
+//go:build linux
+
+package netlink
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicerrors"
+	"golang.org/x/sys/unix"
+)
+
+// Monitor reports the changes to the network interfaces, read from an
+// rtnetlink socket subscribed to the link and address notifications.
+//
+// The states of the links are loaded when the Monitor is created, so LinkUp
+// and LinkDown are only reported when a link changes state, and not for every
+// notification the kernel sends about a link.
+//
+// A Monitor is not safe for concurrent use: it must be used from the goroutine
+// running its IO.
+type Monitor struct {
+	ioc    *sonic.IO
+	fd     int
+	slot   internal.Slot
+	closed bool
+
+	buf    []byte
+	events []Event // parsed but not yet delivered
+	links  map[int]link
+
+	cb       AsyncEventCallback
+	onReadFn func(error)
+}
+
+type link struct {
+	name string
+	up   bool
+}
+
+// monitorBufferSize fits the largest notification the kernel sends, which is
+// at most a page on most systems, and many smaller ones.
+const monitorBufferSize = 32 * 1024
+
+// NewMonitor creates a Monitor.
+func NewMonitor(ioc *sonic.IO) (*Monitor, error) {
+	fd, err := syscall.Socket(
+		syscall.AF_NETLINK,
+		syscall.SOCK_RAW|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC,
+		syscall.NETLINK_ROUTE,
+	)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	// Bound before the links are loaded, so no change is missed in between.
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: unix.RTMGRP_LINK |
+			unix.RTMGRP_IPV4_IFADDR |
+			unix.RTMGRP_IPV6_IFADDR,
+	}); err != nil {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	m := &Monitor{
+		ioc: ioc,
+		fd:  fd,
+		buf: make([]byte, monitorBufferSize),
+	}
+	m.slot.Fd = fd
+	m.onReadFn = m.onRead
+
+	if err := m.loadLinks(); err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Monitor) loadLinks() error {
+	iffs, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+	m.links = make(map[int]link, len(iffs))
+	for _, iff := range iffs {
+		m.links[iff.Index] = link{
+			name: iff.Name,
+			up:   iff.Flags&(net.FlagUp|net.FlagRunning) == net.FlagUp|net.FlagRunning,
+		}
+	}
+	return nil
+}
+
+// AsyncNextEvent invokes the callback with the next event. The error is
+// ErrOverflow if events were dropped, and the read error otherwise.
+func (m *Monitor) AsyncNextEvent(cb AsyncEventCallback) {
+	if ev, ok, err := m.next(); ok || err != nil {
+		m.ioc.Dispatched++
+		cb(err, ev)
+		m.ioc.Dispatched--
+		return
+	}
+
+	m.cb = cb
+	m.scheduleRead()
+}
+
+func (m *Monitor) scheduleRead() {
+	if m.closed {
+		cb := m.cb
+		m.cb = nil
+		cb(io.EOF, Event{})
+		return
+	}
+
+	m.slot.Set(internal.ReadEvent, m.onReadFn)
+	if err := m.ioc.SetRead(&m.slot); err != nil {
+		cb := m.cb
+		m.cb = nil
+		cb(err, Event{})
+		return
+	}
+	m.ioc.Register(&m.slot)
+}
+
+func (m *Monitor) onRead(err error) {
+	m.ioc.Deregister(&m.slot)
+
+	cb := m.cb
+	if err != nil {
+		m.cb = nil
+		cb(err, Event{})
+		return
+	}
+
+	ev, ok, err := m.next()
+	if !ok && err == nil {
+		// All the notifications were about changes which are not reported.
+		m.scheduleRead()
+		return
+	}
+	m.cb = nil
+	cb(err, ev)
+}
+
+// next returns the next event, reading the socket until there is one or until
+// the read would block.
+func (m *Monitor) next() (ev Event, ok bool, err error) {
+	for len(m.events) == 0 {
+		if m.closed {
+			return Event{}, false, io.EOF
+		}
+
+		n, from, err := syscall.Recvfrom(m.fd, m.buf, 0)
+		if err != nil {
+			switch err {
+			case syscall.EAGAIN:
+				return Event{}, false, nil
+			case syscall.EINTR:
+				continue
+			case syscall.ENOBUFS:
+				// The socket is usable after an overflow, but the links
+				// might have changed in the meantime.
+				if err := m.loadLinks(); err != nil {
+					return Event{}, false, err
+				}
+				return Event{}, false, ErrOverflow
+			default:
+				return Event{}, false, os.NewSyscallError("recvfrom", err)
+			}
+		}
+
+		if sa, isNetlink := from.(*syscall.SockaddrNetlink); !isNetlink || sa.Pid != 0 {
+			// Only the kernel's notifications are of interest.
+			continue
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(m.buf[:n])
+		if err != nil {
+			return Event{}, false, err
+		}
+		for i := range msgs {
+			m.parse(&msgs[i])
+		}
+	}
+
+	ev = m.events[0]
+	m.events = m.events[1:]
+	if len(m.events) == 0 {
+		m.events = m.events[:0:0]
+	}
+	return ev, true, nil
+}
+
+func (m *Monitor) parse(msg *syscall.NetlinkMessage) {
+	switch msg.Header.Type {
+	case syscall.RTM_NEWLINK, syscall.RTM_DELLINK:
+		m.parseLink(msg)
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		m.parseAddr(msg)
+	}
+}
+
+func (m *Monitor) parseLink(msg *syscall.NetlinkMessage) {
+	if len(msg.Data) < syscall.SizeofIfInfomsg {
+		return
+	}
+	index := int(int32(binary.NativeEndian.Uint32(msg.Data[4:])))
+	flags := binary.NativeEndian.Uint32(msg.Data[8:])
+
+	prev, known := m.links[index]
+	name := prev.name
+	if attrs, err := syscall.ParseNetlinkRouteAttr(msg); err == nil {
+		for _, attr := range attrs {
+			if attr.Attr.Type == syscall.IFLA_IFNAME {
+				name = strings.TrimRight(string(attr.Value), "\x00")
+			}
+		}
+	}
+
+	if msg.Header.Type == syscall.RTM_DELLINK {
+		if known {
+			delete(m.links, index)
+			m.events = append(m.events, Event{
+				Type:  LinkRemoved,
+				Index: index,
+				Name:  name,
+			})
+		}
+		return
+	}
+
+	up := flags&(syscall.IFF_UP|syscall.IFF_RUNNING) == syscall.IFF_UP|syscall.IFF_RUNNING
+	m.links[index] = link{name: name, up: up}
+	if up != prev.up {
+		typ := LinkDown
+		if up {
+			typ = LinkUp
+		}
+		m.events = append(m.events, Event{Type: typ, Index: index, Name: name})
+	}
+}
+
+func (m *Monitor) parseAddr(msg *syscall.NetlinkMessage) {
+	if len(msg.Data) < syscall.SizeofIfAddrmsg {
+		return
+	}
+	prefixLen := int(msg.Data[1])
+	index := int(binary.NativeEndian.Uint32(msg.Data[4:]))
+
+	attrs, err := syscall.ParseNetlinkRouteAttr(msg)
+	if err != nil {
+		return
+	}
+
+	// IFA_LOCAL is the address of the interface, and IFA_ADDRESS the one of
+	// the peer on point-to-point links. Only the latter is sent otherwise,
+	// as is always the case for IPv6.
+	var addr, local netip.Addr
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case syscall.IFA_ADDRESS:
+			addr, _ = netip.AddrFromSlice(attr.Value)
+		case syscall.IFA_LOCAL:
+			local, _ = netip.AddrFromSlice(attr.Value)
+		}
+	}
+	if local.IsValid() {
+		addr = local
+	}
+	if !addr.IsValid() {
+		return
+	}
+
+	typ := AddrAdded
+	if msg.Header.Type == syscall.RTM_DELADDR {
+		typ = AddrRemoved
+	}
+	m.events = append(m.events, Event{
+		Type:  typ,
+		Index: index,
+		Name:  m.links[index].name,
+		Addr:  netip.PrefixFrom(addr, prefixLen),
+	})
+}
+
+// Close closes the Monitor. A pending AsyncNextEvent completes with
+// sonicerrors.ErrCancelled.
+func (m *Monitor) Close() error {
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+
+	_ = m.ioc.UnsetReadWrite(&m.slot)
+	m.ioc.Deregister(&m.slot)
+	err := syscall.Close(m.fd)
+
+	if cb := m.cb; cb != nil {
+		m.cb = nil
+		cb(sonicerrors.ErrCancelled, Event{})
+	}
+	return err
+}
@@ -0,0 +1,289 @@
+//go:build linux
+
+package netlink
+
+import (
+	"net"
+	"net/netip"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/multicast"
+	"github.com/talostrading/sonic/sonicerrors"
+	"golang.org/x/sys/unix"
+)
+
+// enterNetns moves the test's goroutine into a new network namespace, where
+// interfaces can be created and removed without affecting the host. The
+// goroutine's thread is never unlocked, so it is discarded once the test ends.
+func enterNetns(t *testing.T) {
+	t.Helper()
+
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("the ip command is not available")
+	}
+
+	runtime.LockOSThread()
+	if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+		t.Skipf("cannot create a network namespace err=%v", err)
+	}
+}
+
+func ip(t *testing.T, args ...string) string {
+	t.Helper()
+
+	out, err := exec.Command("ip", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("ip %s err=%v out=%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+// nextEvents runs the IO until the monitor delivers an event matching each of
+// the given ones, in any order. The other events are ignored.
+func nextEvents(t *testing.T, ioc *sonic.IO, m *Monitor, onEvent func(Event), want ...Event) {
+	t.Helper()
+
+	var (
+		done bool
+		err  error
+		fn   AsyncEventCallback
+	)
+	fn = func(e error, ev Event) {
+		if e != nil {
+			err = e
+			return
+		}
+		if onEvent != nil {
+			onEvent(ev)
+		}
+		want = slices.DeleteFunc(want, func(w Event) bool {
+			return matches(ev, w)
+		})
+		if len(want) == 0 {
+			done = true
+			return
+		}
+		m.AsyncNextEvent(fn)
+	}
+	m.AsyncNextEvent(fn)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !done && err == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("did not get the events %v", want)
+		}
+		if e := ioc.RunOneFor(100 * time.Millisecond); e != nil && e != sonicerrors.ErrTimeout {
+			t.Fatal(e)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func matches(ev, want Event) bool {
+	return ev.Type == want.Type && ev.Name == want.Name && ev.Addr == want.Addr
+}
+
+func TestMonitor(t *testing.T) {
+	enterNetns(t)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	m, err := NewMonitor(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	ip(t, "link", "add", "v0", "type", "veth", "peer", "name", "v1")
+	ip(t, "link", "set", "v0", "up")
+	ip(t, "link", "set", "v1", "up")
+	nextEvents(t, ioc, m, nil,
+		Event{Type: LinkUp, Name: "v0"},
+		Event{Type: LinkUp, Name: "v1"},
+	)
+
+	addr := netip.MustParsePrefix("10.9.0.1/24")
+	ip(t, "addr", "add", addr.String(), "dev", "v0")
+	nextEvents(t, ioc, m, nil, Event{Type: AddrAdded, Name: "v0", Addr: addr})
+
+	ip(t, "addr", "del", addr.String(), "dev", "v0")
+	nextEvents(t, ioc, m, nil, Event{Type: AddrRemoved, Name: "v0", Addr: addr})
+
+	// v0 loses its carrier when its peer goes down. The veth pair reports
+	// the changes to either end in no particular order.
+	ip(t, "link", "set", "v1", "down")
+	nextEvents(t, ioc, m, nil,
+		Event{Type: LinkDown, Name: "v1"},
+		Event{Type: LinkDown, Name: "v0"},
+	)
+
+	ip(t, "link", "del", "v0")
+	nextEvents(t, ioc, m, nil, Event{Type: LinkRemoved, Name: "v0"})
+}
+
+func TestMonitorClose(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	m, err := NewMonitor(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got error
+	m.AsyncNextEvent(func(err error, _ Event) {
+		got = err
+	})
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got != sonicerrors.ErrCancelled {
+		t.Fatalf("expected ErrCancelled got=%v", got)
+	}
+}
+
+func TestRejoiner(t *testing.T) {
+	enterNetns(t)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	m, err := NewMonitor(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// The datagrams are sent on v1 and received on v0, in the same namespace,
+	// so v0 must accept datagrams from a local address.
+	sysctl(t, "net.ipv4.conf.all.rp_filter", "0")
+	sysctl(t, "net.ipv4.conf.default.rp_filter", "0")
+	sysctl(t, "net.ipv4.conf.default.accept_local", "1")
+
+	createLink := func() {
+		ip(t, "link", "add", "v0", "type", "veth", "peer", "name", "v1")
+		ip(t, "addr", "add", "10.9.0.1/24", "dev", "v0")
+		ip(t, "addr", "add", "10.9.1.1/24", "dev", "v1")
+		ip(t, "link", "set", "v0", "multicast", "on", "up")
+		ip(t, "link", "set", "v1", "multicast", "on", "up")
+		ip(t, "route", "add", "239.9.9.9/32", "dev", "v1")
+	}
+	createLink()
+	nextEvents(t, ioc, m, nil, Event{Type: LinkUp, Name: "v0"})
+
+	peer, err := multicast.NewUDPPeer(ioc, "udp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	if err := peer.SetInbound("v0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.JoinOn("239.9.9.9", "v0"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(ip(t, "maddr", "show", "dev", "v0"), "239.9.9.9") {
+		t.Fatal("the group is not joined")
+	}
+
+	group := netip.AddrPortFrom(
+		netip.MustParseAddr("239.9.9.9"), uint16(peer.LocalAddr().Port))
+	expectDatagram(t, ioc, peer, group)
+
+	rejoiner := NewRejoiner(peer)
+	var rejoinErr error
+	onEvent := func(ev Event) {
+		if err := rejoiner.OnEvent(ev); err != nil {
+			rejoinErr = err
+		}
+	}
+
+	// The membership is dropped with the interface.
+	ip(t, "link", "del", "v0")
+	nextEvents(t, ioc, m, onEvent, Event{Type: LinkRemoved, Name: "v0"})
+
+	createLink()
+	nextEvents(t, ioc, m, onEvent, Event{Type: LinkUp, Name: "v0"})
+	if rejoinErr != nil {
+		t.Fatal(rejoinErr)
+	}
+	if !strings.Contains(ip(t, "maddr", "show", "dev", "v0"), "239.9.9.9") {
+		t.Fatal("the group is not joined again")
+	}
+	iff, err := net.InterfaceByName("v0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.Inbound().Index != iff.Index {
+		t.Fatalf("expected the inbound interface index=%d got=%d",
+			iff.Index, peer.Inbound().Index)
+	}
+	expectDatagram(t, ioc, peer, group)
+
+	// Rejoining a group which is still joined is not an error.
+	if err := rejoiner.OnEvent(Event{Type: LinkUp, Name: "v0"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sysctl(t *testing.T, name, value string) {
+	t.Helper()
+
+	// The command runs in the namespace of the test's thread.
+	out, err := exec.Command("sysctl", "-w", name+"="+value).CombinedOutput()
+	if err != nil {
+		t.Skipf("cannot set %s err=%v out=%s", name, err, out)
+	}
+}
+
+// expectDatagram sends a datagram to the group out of v1 and runs the IO until
+// the peer receives it.
+func expectDatagram(t *testing.T, ioc *sonic.IO, peer *multicast.UDPPeer, group netip.AddrPort) {
+	t.Helper()
+
+	sender, err := multicast.NewUDPPeer(ioc, "udp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	if err := sender.SetOutboundIPv4("v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		done    bool
+		readErr error
+		b       = make([]byte, 128)
+		n       int
+	)
+	peer.AsyncRead(b, func(err error, nn int, _ netip.AddrPort) {
+		readErr, n, done = err, nn, true
+	})
+
+	deadline := time.Now().Add(time.Second)
+	for !done {
+		if time.Now().After(deadline) {
+			t.Fatal("the datagram was not received")
+		}
+		if _, err := sender.Write([]byte("hello"), group); err != nil {
+			t.Fatal(err)
+		}
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if string(b[:n]) != "hello" {
+		t.Fatalf("expected=hello got=%q", b[:n])
+	}
+}
@@ -0,0 +1,107 @@
+package sonic
+
+import (
+	"math"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+)
+
+// Notifier wakes up an IO from other goroutines. Notify can be called from any
+// goroutine, while AsyncWait must be called from the goroutine running the IO.
+//
+// Notifications are coalesced: a wait completes once with the number of
+// notifications received since the previous wait completed.
+//
+// It is backed by an eventfd on Linux and by a pipe on BSD.
+type Notifier struct {
+	ioc     *IO
+	f       *file
+	writeFd int
+
+	// The number of Notify calls in progress, plus notifierClosed once the
+	// Notifier is closed.
+	users atomic.Int32
+
+	b        [8]byte
+	waitCb   func(error, uint64)
+	onReadFn AsyncCallback
+}
+
+const notifierClosed = math.MinInt32
+
+// NewNotifier creates a Notifier.
+func NewNotifier(ioc *IO) (*Notifier, error) {
+	readFd, writeFd, err := newNotifierFds()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Notifier{
+		ioc:     ioc,
+		f:       newFile(ioc, readFd),
+		writeFd: writeFd,
+	}
+	n.onReadFn = n.onRead
+	return n, nil
+}
+
+// Notify notifies the goroutine running the IO. It is safe to call it
+// concurrently, from any goroutine. It does not block. It fails with
+// net.ErrClosed once the Notifier is closed.
+func (n *Notifier) Notify() error {
+	if n.users.Add(1) < 0 {
+		n.users.Add(-1)
+		return net.ErrClosed
+	}
+	err := notify(n.writeFd)
+	n.users.Add(-1)
+	return err
+}
+
+// AsyncWait invokes the callback once at least one notification is received,
+// with the number of notifications received. On BSD the number saturates at
+// the capacity of the pipe.
+func (n *Notifier) AsyncWait(cb func(err error, count uint64)) {
+	n.waitCb = cb
+	n.f.AsyncRead(n.b[:], n.onReadFn)
+}
+
+func (n *Notifier) onRead(err error, nn int) {
+	cb := n.waitCb
+	n.waitCb = nil
+	if err != nil {
+		cb(err, 0)
+		return
+	}
+	cb(nil, notifierCount(n.f, n.b[:], nn))
+}
+
+// Close closes the Notifier. A pending AsyncWait is not completed. Notify
+// fails afterwards. The file descriptors are closed once the Notify calls in
+// progress return.
+func (n *Notifier) Close() error {
+	for {
+		users := n.users.Load()
+		if users < 0 {
+			return net.ErrClosed
+		}
+		if n.users.CompareAndSwap(users, users+notifierClosed) {
+			break
+		}
+	}
+	for n.users.Load() != notifierClosed {
+		runtime.Gosched()
+	}
+
+	if n.writeFd != n.f.RawFd() {
+		_ = syscall.Close(n.writeFd)
+	}
+	return n.f.Close()
+}
+
+// RawFd returns the file descriptor the IO waits on.
+func (n *Notifier) RawFd() int {
+	return n.f.RawFd()
+}
@@ -0,0 +1,39 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package sonic
+
+import (
+	"os"
+	"syscall"
+)
+
+func newNotifierFds() (readFd, writeFd int, err error) {
+	return nonblockingPipe(true, true)
+}
+
+var notifierOne = []byte{1}
+
+func notify(fd int) error {
+	_, err := syscall.Write(fd, notifierOne)
+	if err == syscall.EAGAIN {
+		// The pipe is full, so the IO is going to be woken up anyway.
+		return nil
+	}
+	if err != nil {
+		return os.NewSyscallError("write", err)
+	}
+	return nil
+}
+
+// notifierCount returns the number of notifications: each is a byte in the
+// pipe, which is drained.
+func notifierCount(f *file, b []byte, n int) uint64 {
+	count := uint64(n)
+	for {
+		n, err := f.Read(b)
+		if err != nil {
+			return count
+		}
+		count += uint64(n)
+	}
+}
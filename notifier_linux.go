@@ -0,0 +1,45 @@
+//go:build linux
+
+package sonic
+
+import (
+	"encoding/binary"
+	"os"
+	"syscall"
+
+	"github.com/talostrading/sonic/internal"
+)
+
+func newNotifierFds() (readFd, writeFd int, err error) {
+	e, err := internal.NewEventFd(true)
+	if err != nil {
+		return -1, -1, err
+	}
+	return e.Fd(), e.Fd(), nil
+}
+
+var notifierOne = func() (b [8]byte) {
+	binary.NativeEndian.PutUint64(b[:], 1)
+	return
+}()
+
+func notify(fd int) error {
+	_, err := syscall.Write(fd, notifierOne[:])
+	if err == syscall.EAGAIN {
+		// The counter is saturated, so the IO is going to be woken up anyway.
+		return nil
+	}
+	if err != nil {
+		return os.NewSyscallError("write", err)
+	}
+	return nil
+}
+
+// notifierCount returns the number of notifications: reading an eventfd
+// returns its counter and resets it.
+func notifierCount(_ *file, b []byte, n int) uint64 {
+	if n < 8 {
+		return 0
+	}
+	return binary.NativeEndian.Uint64(b)
+}
@@ -0,0 +1,113 @@
+package sonic
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+func TestNotifier(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	n, err := NewNotifier(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	const (
+		goroutines = 4
+		each       = 100
+	)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < each; j++ {
+				if err := n.Notify(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+
+	var (
+		total  uint64
+		onWait func(error, uint64)
+	)
+	onWait = func(err error, count uint64) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count == 0 {
+			t.Fatal("woken up without a notification")
+		}
+		total += count
+		if total < goroutines*each {
+			n.AsyncWait(onWait)
+		}
+	}
+	n.AsyncWait(onWait)
+
+	done := false
+	for !done {
+		_ = ioc.RunOne()
+		done = total >= goroutines*each
+	}
+	wg.Wait()
+
+	if total != goroutines*each {
+		t.Fatalf("expected %d notifications got=%d", goroutines*each, total)
+	}
+}
+
+func TestNotifierClose(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	n, err := NewNotifier(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Notify is called concurrently with Close.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if err := n.Notify(); err != nil {
+					if !errors.Is(err, net.ErrClosed) {
+						t.Error(err)
+					}
+					return
+				}
+			}
+		}()
+	}
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	// The file descriptors are reused, but Notify does not write to them.
+	r, w, err := Pipe(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if err := n.Notify(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed got=%v", err)
+	}
+	b := make([]byte, 8)
+	if _, err := r.Read(b); err != sonicerrors.ErrWouldBlock {
+		t.Fatalf("expected nothing to read got err=%v", err)
+	}
+}
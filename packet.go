@@ -35,7 +35,13 @@ func NewPacketConn(ioc *IO, network, addr string, opts ...sonicopts.Option) (Pac
 		return nil, err
 	}
 
+	if err := internal.ApplyOpts(fd, opts...); err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+
 	if err := syscall.Bind(fd, internal.ToSockaddr(localAddr)); err != nil {
+		_ = syscall.Close(fd)
 		return nil, err
 	}
 
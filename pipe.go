@@ -0,0 +1,57 @@
+package sonic
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// Pipe creates a pipe. Bytes written to w can be read from r. Both ends are
+// non-blocking. Reading from w or writing to r fails.
+func Pipe(ioc *IO) (r, w Stream, err error) {
+	rfd, wfd, err := nonblockingPipe(true, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newFile(ioc, rfd), newFile(ioc, wfd), nil
+}
+
+// nonblockingPipe creates a pipe whose ends are closed on exec, setting the
+// given ends non-blocking.
+func nonblockingPipe(readNonblock, writeNonblock bool) (r, w int, err error) {
+	var p [2]int
+	both := readNonblock && writeNonblock
+	if err := cloexecPipe(&p, both); err != nil {
+		return -1, -1, os.NewSyscallError("pipe", err)
+	}
+	if both {
+		return p[0], p[1], nil
+	}
+
+	if err = syscall.SetNonblock(p[0], readNonblock); err == nil {
+		err = syscall.SetNonblock(p[1], writeNonblock)
+	}
+	if err != nil {
+		_ = syscall.Close(p[0])
+		_ = syscall.Close(p[1])
+		return -1, -1, os.NewSyscallError("set_nonblock", err)
+	}
+	return p[0], p[1], nil
+}
+
+// SocketPair creates a pair of connected unix domain stream sockets. Bytes
+// written to one end can be read from the other. Both ends are non-blocking
+// and closed on exec.
+//
+// Unlike a pipe, both ends are full-duplex and can be half-closed with
+// CloseWrite. The sockets are unnamed, so their addresses are empty and
+// TCPInfo fails.
+func SocketPair(ioc *IO) (Conn, Conn, error) {
+	fds, err := nonblockingSocketPair()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	addr := &net.UnixAddr{Net: "unix"}
+	return newConn(ioc, fds[0], addr, addr), newConn(ioc, fds[1], addr, addr), nil
+}
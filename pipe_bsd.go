@@ -0,0 +1,55 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package sonic
+
+import (
+	"os"
+	"syscall"
+)
+
+// Not all BSDs have pipe2 and the SOCK_CLOEXEC flag, so the file descriptors
+// are marked close-on-exec after they are created. Like os.Pipe, the fork lock
+// is held so that they do not leak into a child started concurrently.
+
+func cloexecPipe(p *[2]int, nonblock bool) (err error) {
+	syscall.ForkLock.RLock()
+	err = syscall.Pipe(p[:])
+	if err == nil {
+		syscall.CloseOnExec(p[0])
+		syscall.CloseOnExec(p[1])
+	}
+	syscall.ForkLock.RUnlock()
+
+	if err == nil && nonblock {
+		if err = syscall.SetNonblock(p[0], true); err == nil {
+			err = syscall.SetNonblock(p[1], true)
+		}
+		if err != nil {
+			_ = syscall.Close(p[0])
+			_ = syscall.Close(p[1])
+		}
+	}
+	return err
+}
+
+func nonblockingSocketPair() (fds [2]int, err error) {
+	syscall.ForkLock.RLock()
+	fds, err = syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return fds, os.NewSyscallError("socketpair", err)
+	}
+
+	for _, fd := range fds {
+		if err := syscall.SetNonblock(fd, true); err != nil {
+			_ = syscall.Close(fds[0])
+			_ = syscall.Close(fds[1])
+			return fds, os.NewSyscallError("set_nonblock", err)
+		}
+	}
+	return fds, nil
+}
@@ -0,0 +1,25 @@
+//go:build linux
+
+package sonic
+
+import (
+	"os"
+	"syscall"
+)
+
+func cloexecPipe(p *[2]int, nonblock bool) error {
+	flags := syscall.O_CLOEXEC
+	if nonblock {
+		flags |= syscall.O_NONBLOCK
+	}
+	return syscall.Pipe2(p[:], flags)
+}
+
+func nonblockingSocketPair() ([2]int, error) {
+	fds, err := syscall.Socketpair(
+		syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fds, os.NewSyscallError("socketpair", err)
+	}
+	return fds, nil
+}
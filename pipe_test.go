@@ -0,0 +1,197 @@
+package sonic
+
+import (
+	"io"
+	"testing"
+
+	"github.com/talostrading/sonic/sonicerrors"
+	"golang.org/x/sys/unix"
+)
+
+func TestPipe(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	r, w, err := Pipe(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var (
+		done bool
+		b    = make([]byte, 5)
+	)
+	r.AsyncReadAll(b, func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The reader sees io.EOF once the writer is closed.
+		w.Close()
+		r.AsyncRead(b, func(err error, _ int) {
+			if err != io.EOF {
+				t.Fatalf("expected io.EOF got=%v", err)
+			}
+			done = true
+		})
+	})
+	w.AsyncWriteAll([]byte("hello"), func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	runUntil(t, ioc, &done)
+
+	if string(b) != "hello" {
+		t.Fatalf("unexpected read=%s", string(b))
+	}
+}
+
+func TestSocketPair(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	a, b, err := SocketPair(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	defer b.Close()
+
+	// Both ends are full-duplex: b echoes back what a writes, until a shuts
+	// down its side.
+	var (
+		done  bool
+		echo  = make([]byte, 128)
+		reply = make([]byte, 5)
+	)
+	var onEcho AsyncCallback
+	onEcho = func(err error, n int) {
+		if err == io.EOF {
+			b.Close()
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.AsyncWriteAll(echo[:n], func(err error, _ int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.AsyncRead(echo, onEcho)
+		})
+	}
+	b.AsyncRead(echo, onEcho)
+
+	a.AsyncWriteAll([]byte("hello"), func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.AsyncReadAll(reply, func(err error, _ int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := a.(HalfCloser).CloseWrite(); err != nil {
+				t.Fatal(err)
+			}
+			a.AsyncRead(reply, func(err error, _ int) {
+				if err != io.EOF {
+					t.Fatalf("expected io.EOF got=%v", err)
+				}
+				done = true
+			})
+		})
+	})
+	runUntil(t, ioc, &done)
+
+	if string(reply) != "hello" {
+		t.Fatalf("unexpected reply=%s", string(reply))
+	}
+}
+
+func TestPipeHangup(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	r, w, err := Pipe(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// The pending read completes once the writer is closed, without any data to read.
+	var done bool
+	r.AsyncRead(make([]byte, 5), func(err error, _ int) {
+		if err != io.EOF {
+			t.Fatalf("expected io.EOF got=%v", err)
+		}
+		done = true
+	})
+	w.Close()
+	runUntil(t, ioc, &done)
+}
+
+func TestPipeWriteError(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	r, w, err := Pipe(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// Fill the pipe so that the next write is pending.
+	b := make([]byte, 4096)
+	for {
+		if _, err := w.Write(b); err == sonicerrors.ErrWouldBlock {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The pending write fails once the reader is closed.
+	var done bool
+	w.AsyncWriteAll(b, func(err error, _ int) {
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		done = true
+	})
+	r.Close()
+	runUntil(t, ioc, &done)
+}
+
+func TestPipeCloseOnExec(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	r, w, err := Pipe(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	a, b, err := SocketPair(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	defer b.Close()
+	n, err := NewNotifier(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	for _, fd := range []int{r.RawFd(), w.RawFd(), a.RawFd(), b.RawFd(), n.RawFd(), n.writeFd} {
+		flags, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flags&unix.FD_CLOEXEC == 0 {
+			t.Fatalf("fd=%d is not closed on exec", fd)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package sonic
+
+import (
+	"os"
+	"syscall"
+)
+
+// PTY is a pseudo-terminal. The master is an asynchronous stream driven by an
+// IO: what is written to it is input to the terminal, and the output of the
+// terminal is read from it. The slave is the terminal a child process uses.
+//
+// To run a process on the terminal, give it the slave as its standard streams
+// and make it the controlling terminal of a new session:
+//
+//	cmd := sonic.Command(ioc, "sh")
+//	cmd.Cmd.Stdin, cmd.Cmd.Stdout, cmd.Cmd.Stderr = pty.Slave(), pty.Slave(), pty.Slave()
+//	cmd.Cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+type PTY struct {
+	*file
+
+	slave *os.File
+}
+
+// OpenPTY opens a new pseudo-terminal. The master is non-blocking, the slave
+// is blocking.
+func OpenPTY(ioc *IO) (*PTY, error) {
+	master, slaveName, err := openPTY()
+	if err != nil {
+		return nil, err
+	}
+
+	slaveFd, err := syscall.Open(slaveName, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		_ = syscall.Close(master)
+		return nil, os.NewSyscallError("open", err)
+	}
+
+	if err := syscall.SetNonblock(master, true); err != nil {
+		_ = syscall.Close(master)
+		_ = syscall.Close(slaveFd)
+		return nil, os.NewSyscallError("set_nonblock", err)
+	}
+
+	return &PTY{
+		file:  newFile(ioc, master),
+		slave: os.NewFile(uintptr(slaveFd), slaveName),
+	}, nil
+}
+
+// Slave returns the slave side of the terminal. It is closed with the PTY.
+func (p *PTY) Slave() *os.File {
+	return p.slave
+}
+
+// SlaveName returns the path of the slave side of the terminal.
+func (p *PTY) SlaveName() string {
+	return p.slave.Name()
+}
+
+// MakeRaw puts the terminal in raw mode: input is available to the process on
+// the slave byte by byte, without being echoed or interpreted, and its output
+// is not processed.
+func (p *PTY) MakeRaw() error {
+	_, err := makeRaw(int(p.slave.Fd()))
+	return err
+}
+
+// Size returns the size of the terminal.
+func (p *PTY) Size() (rows, cols uint16, err error) {
+	return terminalSize(p.RawFd())
+}
+
+// SetSize resizes the terminal. The foreground process group of the terminal
+// is signalled with SIGWINCH. This is typically called to propagate the
+// resizes of the controlling terminal reported by StdStream.AsyncResize.
+func (p *PTY) SetSize(rows, cols uint16) error {
+	return setTerminalSize(p.RawFd(), rows, cols)
+}
+
+// Close closes both the master and the slave.
+func (p *PTY) Close() error {
+	err := p.file.Close()
+	if serr := p.slave.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
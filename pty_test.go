@@ -0,0 +1,151 @@
+package sonic
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestPTYCommand(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	pty, err := OpenPTY(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pty.Close()
+	if err := pty.MakeRaw(); err != nil {
+		t.Fatal(err)
+	}
+
+	// In raw mode the input is not echoed, so only what cat writes is read.
+	cmd := Command(ioc, "cat")
+	cmd.Cmd.Stdin, cmd.Cmd.Stdout, cmd.Cmd.Stderr = pty.Slave(), pty.Slave(), pty.Slave()
+	cmd.Cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cmd.Signal(syscall.SIGKILL)
+		exited := make(chan error, 1)
+		cmd.AsyncWait(func(_ *os.ProcessState, err error) {
+			exited <- err
+		})
+		runUntilRecv(t, ioc, exited)
+	}()
+
+	pty.AsyncWriteAll([]byte("hello"), func(err error, _ int) {
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	var out []byte
+	b := make([]byte, 128)
+	read := make(chan error, 1)
+	var onRead AsyncCallback
+	onRead = func(err error, n int) {
+		out = append(out, b[:n]...)
+		if err != nil || len(out) >= 5 {
+			read <- err
+			return
+		}
+		pty.AsyncRead(b, onRead)
+	}
+	pty.AsyncRead(b, onRead)
+	if err := runUntilRecv(t, ioc, read); err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "hello" {
+		t.Fatalf("expected=hello got=%q", out)
+	}
+}
+
+func TestPTYSize(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	pty, err := OpenPTY(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pty.Close()
+
+	if err := pty.SetSize(24, 80); err != nil {
+		t.Fatal(err)
+	}
+	rows, cols, err := pty.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 24 || cols != 80 {
+		t.Fatalf("expected 24x80 got=%dx%d", rows, cols)
+	}
+	if !isTerminal(int(pty.Slave().Fd())) {
+		t.Fatal("expected the slave to be a terminal")
+	}
+}
+
+func TestStdinRestoresFlags(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	before, err := unix.FcntlInt(uintptr(syscall.Stdin), unix.F_GETFL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdin, err := Stdin(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags, err := unix.FcntlInt(uintptr(syscall.Stdin), unix.F_GETFL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdin.Shared() && flags&unix.O_NONBLOCK == 0 {
+		t.Fatal("expected stdin to be non-blocking")
+	}
+	if !stdin.Shared() && flags != before {
+		t.Fatalf("expected stdin's flags=%x to be left alone got=%x", before, flags)
+	}
+
+	if err := stdin.Close(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := unix.FcntlInt(uintptr(syscall.Stdin), unix.F_GETFL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after != before {
+		t.Fatalf("expected flags=%x got=%x", before, after)
+	}
+}
+
+func TestStdStreamAsyncResize(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	stdin, err := Stdin(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+
+	// The size cannot be read if stdin is not a terminal, but the resize is
+	// reported anyway.
+	resized := make(chan struct{}, 1)
+	stdin.AsyncResize(func(err error, _, _ uint16) {
+		if err != nil && stdin.IsTerminal() {
+			t.Error(err)
+		}
+		resized <- struct{}{}
+	})
+	if err := syscall.Kill(os.Getpid(), syscall.SIGWINCH); err != nil {
+		t.Fatal(err)
+	}
+	runUntilRecv(t, ioc, resized)
+}
@@ -0,0 +1,387 @@
+package sonic
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+var _ AsyncStream = &ReconnectingConn{}
+
+// Backoff controls the delay between reconnection attempts of a
+// ReconnectingConn.
+//
+// The n-th consecutive failed attempt (starting from 0) is followed by a delay
+// of min(Initial * Multiplier^n, Max), scaled by a random factor in
+// [1 - Jitter, 1 + Jitter].
+type Backoff struct {
+	Initial    time.Duration // above 0
+	Max        time.Duration // no limit if 0
+	Multiplier float64       // at least 1
+	Jitter     float64       // in [0, 1]
+}
+
+// DefaultBackoff is the Backoff used by a ReconnectingConn if none is set.
+var DefaultBackoff = Backoff{
+	Initial:    100 * time.Millisecond,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Delay returns the delay after the given number of consecutive failed
+// attempts.
+func (b Backoff) Delay(attempt int) time.Duration {
+	limit := float64(math.MaxInt64)
+	if b.Max > 0 {
+		limit = float64(b.Max)
+	}
+	multiplier := math.Max(b.Multiplier, 1)
+
+	d := float64(b.Initial)
+	for i := 0; i < attempt && d < limit; i++ {
+		d *= multiplier
+	}
+	d = math.Min(d, limit)
+	if b.Jitter > 0 {
+		/* #nosec G404 -- jitter does not need a secure random source */
+		d *= 1 + b.Jitter*(2*rand.Float64()-1)
+	}
+	if d >= float64(math.MaxInt64) {
+		return math.MaxInt64
+	}
+	return time.Duration(d)
+}
+
+func (b Backoff) validate() error {
+	if b.Initial <= 0 || b.Max < 0 {
+		return errors.New("sonic: backoff delays must be positive")
+	}
+	if b.Multiplier < 1 {
+		return errors.New("sonic: backoff multiplier must be at least 1")
+	}
+	if b.Jitter < 0 || b.Jitter > 1 {
+		return errors.New("sonic: backoff jitter must be in [0, 1]")
+	}
+	return nil
+}
+
+type reconnState uint8
+
+const (
+	reconnIdle reconnState = iota
+	reconnDialing
+	reconnConnected
+	reconnWaiting
+	reconnClosed
+)
+
+// ReconnectingConn is a client connection which redials whenever it is lost.
+//
+// Callers see a stable AsyncStream. Asynchronous operations pending when the
+// connection is lost, or issued while it is down, fail with
+// sonicerrors.ErrDisconnected. The reconnection attempts are spaced by an
+// exponential Backoff driven by a Timer.
+//
+// The usual flow is:
+//   - SetOnConnect to send a login and subscriptions with AsyncWrite, and to
+//     start the read loop with AsyncRead.
+//   - SetOnDisconnect to be notified of why the connection was lost.
+//   - Start.
+//
+// Only one read and one write may be pending at any time, as with any other
+// Conn.
+type ReconnectingConn struct {
+	ioc     *IO
+	dial    func(cb func(error, Conn))
+	timer   *Timer
+	backoff Backoff
+
+	onConnect    func()
+	onDisconnect func(error)
+
+	conn     Conn
+	state    reconnState
+	attempts int
+
+	readCb  AsyncCallback
+	writeCb AsyncCallback
+
+	// These are bound once so that we do not allocate closures on each read
+	// and write.
+	onReadFn  AsyncCallback
+	onWriteFn AsyncCallback
+	onDialFn  func(error, Conn)
+	redialFn  func()
+}
+
+// NewReconnectingConn creates a ReconnectingConn which connects with
+// AsyncDial(ioc, network, addr, cb, opts...) for TCP networks, so that the IO
+// is not blocked while connecting, and with Dial(ioc, network, addr, opts...)
+// otherwise.
+func NewReconnectingConn(
+	ioc *IO,
+	network, addr string,
+	opts ...sonicopts.Option,
+) (*ReconnectingConn, error) {
+	if len(network) >= 3 && network[:3] == "tcp" {
+		return NewReconnectingConnWithAsyncDialer(ioc, func(cb func(error, Conn)) {
+			AsyncDial(ioc, network, addr, cb, opts...)
+		})
+	}
+	return NewReconnectingConnWithDialer(ioc, func() (Conn, error) {
+		return Dial(ioc, network, addr, opts...)
+	})
+}
+
+// NewReconnectingConnWithDialer creates a ReconnectingConn which connects with
+// the given dial function. The dial function is called on the IO, which it
+// blocks until it returns; NewReconnectingConnWithAsyncDialer should be
+// preferred for connections which take time to establish.
+func NewReconnectingConnWithDialer(
+	ioc *IO,
+	dial func() (Conn, error),
+) (*ReconnectingConn, error) {
+	return NewReconnectingConnWithAsyncDialer(ioc, func(cb func(error, Conn)) {
+		conn, err := dial()
+		cb(err, conn)
+	})
+}
+
+// NewReconnectingConnWithAsyncDialer creates a ReconnectingConn which connects
+// with the given asynchronous dial function. The dial function must invoke the
+// callback on the IO once the connection is established or has failed, like
+// AsyncDial does.
+func NewReconnectingConnWithAsyncDialer(
+	ioc *IO,
+	dial func(cb func(error, Conn)),
+) (*ReconnectingConn, error) {
+	timer, err := NewTimer(ioc)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ReconnectingConn{
+		ioc:     ioc,
+		dial:    dial,
+		timer:   timer,
+		backoff: DefaultBackoff,
+		state:   reconnIdle,
+	}
+	c.onReadFn = c.onRead
+	c.onWriteFn = c.onWrite
+	c.onDialFn = c.onDial
+	c.redialFn = c.redial
+	return c, nil
+}
+
+// SetBackoff sets the backoff between reconnection attempts. It fails if the
+// initial delay is 0 or the multiplier below 1, as the attempts would not be
+// spaced, or if another field is out of its range.
+func (c *ReconnectingConn) SetBackoff(b Backoff) error {
+	if err := b.validate(); err != nil {
+		return err
+	}
+	c.backoff = b
+	return nil
+}
+
+// SetOnConnect sets the hook invoked each time a connection is established.
+// This is where logins and subscriptions should be (re)sent.
+func (c *ReconnectingConn) SetOnConnect(fn func()) {
+	c.onConnect = fn
+}
+
+// SetOnDisconnect sets the hook invoked each time an established connection is
+// lost, with the error that caused it. It is invoked before the next
+// reconnection attempt is scheduled. Calling Close from it stops reconnecting.
+//
+// It is also invoked if the next attempt cannot be scheduled, with the error of
+// the Timer, once the ReconnectingConn is closed.
+func (c *ReconnectingConn) SetOnDisconnect(fn func(error)) {
+	c.onDisconnect = fn
+}
+
+// Start makes the first connection attempt. If it fails, the next attempts
+// are scheduled with the configured backoff.
+func (c *ReconnectingConn) Start() {
+	if c.state == reconnIdle {
+		c.redial()
+	}
+}
+
+func (c *ReconnectingConn) redial() {
+	if c.state == reconnClosed {
+		return
+	}
+
+	c.state = reconnDialing
+	c.dial(c.onDialFn)
+}
+
+func (c *ReconnectingConn) onDial(err error, conn Conn) {
+	if c.state == reconnClosed {
+		// Closed while dialing.
+		if err == nil {
+			_ = conn.Close()
+		}
+		return
+	}
+
+	if err != nil {
+		c.scheduleRedial()
+		return
+	}
+
+	c.conn = conn
+	c.state = reconnConnected
+	c.attempts = 0
+	if c.onConnect != nil {
+		c.onConnect()
+	}
+}
+
+func (c *ReconnectingConn) scheduleRedial() {
+	c.state = reconnWaiting
+	delay := c.backoff.Delay(c.attempts)
+	c.attempts++
+	if err := c.timer.ScheduleOnce(delay, c.redialFn); err != nil {
+		// Nothing would redial, so stop instead of waiting forever.
+		_ = c.Close()
+		if c.onDisconnect != nil {
+			c.onDisconnect(err)
+		}
+	}
+}
+
+// Connected returns true if there is an established connection.
+func (c *ReconnectingConn) Connected() bool {
+	return c.state == reconnConnected
+}
+
+// Attempts returns the number of consecutive failed connection attempts.
+func (c *ReconnectingConn) Attempts() int {
+	return c.attempts
+}
+
+// NextLayer returns the current connection, or nil if there is none.
+func (c *ReconnectingConn) NextLayer() Conn {
+	return c.conn
+}
+
+func (c *ReconnectingConn) disconnect(cause error) {
+	conn := c.conn
+	c.conn = nil
+	c.state = reconnIdle
+
+	// Fail any other pending operation before closing.
+	conn.Cancel()
+	_ = conn.Close()
+
+	if c.onDisconnect != nil {
+		c.onDisconnect(cause)
+	}
+
+	if c.state == reconnIdle {
+		c.scheduleRedial()
+	}
+}
+
+func (c *ReconnectingConn) AsyncRead(b []byte, cb AsyncCallback) {
+	if c.conn == nil {
+		cb(sonicerrors.ErrDisconnected, 0)
+		return
+	}
+	c.readCb = cb
+	c.conn.AsyncRead(b, c.onReadFn)
+}
+
+func (c *ReconnectingConn) AsyncReadAll(b []byte, cb AsyncCallback) {
+	if c.conn == nil {
+		cb(sonicerrors.ErrDisconnected, 0)
+		return
+	}
+	c.readCb = cb
+	c.conn.AsyncReadAll(b, c.onReadFn)
+}
+
+func (c *ReconnectingConn) onRead(err error, n int) {
+	cb := c.readCb
+	c.readCb = nil
+	if err != nil {
+		if c.conn == nil {
+			err = sonicerrors.ErrDisconnected
+		} else if err != sonicerrors.ErrCancelled {
+			c.disconnect(err)
+			err = sonicerrors.ErrDisconnected
+		}
+	}
+	cb(err, n)
+}
+
+func (c *ReconnectingConn) AsyncWrite(b []byte, cb AsyncCallback) {
+	if c.conn == nil {
+		cb(sonicerrors.ErrDisconnected, 0)
+		return
+	}
+	c.writeCb = cb
+	c.conn.AsyncWrite(b, c.onWriteFn)
+}
+
+func (c *ReconnectingConn) AsyncWriteAll(b []byte, cb AsyncCallback) {
+	if c.conn == nil {
+		cb(sonicerrors.ErrDisconnected, 0)
+		return
+	}
+	c.writeCb = cb
+	c.conn.AsyncWriteAll(b, c.onWriteFn)
+}
+
+func (c *ReconnectingConn) onWrite(err error, n int) {
+	cb := c.writeCb
+	c.writeCb = nil
+	if err != nil {
+		if c.conn == nil {
+			err = sonicerrors.ErrDisconnected
+		} else if err != sonicerrors.ErrCancelled {
+			c.disconnect(err)
+			err = sonicerrors.ErrDisconnected
+		}
+	}
+	cb(err, n)
+}
+
+// Cancel cancels the pending operations on the current connection, if any.
+// They fail with sonicerrors.ErrCancelled and the connection stays up.
+func (c *ReconnectingConn) Cancel() {
+	if c.conn != nil {
+		c.conn.Cancel()
+	}
+}
+
+// Close closes the current connection, if any, and stops reconnecting. Pending
+// operations fail with sonicerrors.ErrDisconnected. The OnDisconnect hook is
+// not invoked.
+func (c *ReconnectingConn) Close() error {
+	if c.state == reconnClosed {
+		return nil
+	}
+	c.state = reconnClosed
+	_ = c.timer.Close()
+
+	if conn := c.conn; conn != nil {
+		c.conn = nil
+		conn.Cancel()
+		return conn.Close()
+	}
+	return nil
+}
+
+// Closed returns true if Close has been called.
+func (c *ReconnectingConn) Closed() bool {
+	return c.state == reconnClosed
+}
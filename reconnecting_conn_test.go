@@ -0,0 +1,284 @@
+package sonic
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{
+		Initial:    10 * time.Millisecond,
+		Max:        50 * time.Millisecond,
+		Multiplier: 2,
+	}
+
+	expected := []time.Duration{
+		10 * time.Millisecond,
+		20 * time.Millisecond,
+		40 * time.Millisecond,
+		50 * time.Millisecond,
+		50 * time.Millisecond,
+	}
+	for attempt, delay := range expected {
+		if got := b.Delay(attempt); got != delay {
+			t.Fatalf("attempt=%d expected=%s got=%s", attempt, delay, got)
+		}
+	}
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := b.Delay(0)
+		if d < 5*time.Millisecond || d > 15*time.Millisecond {
+			t.Fatalf("delay=%s is out of the jitter bounds", d)
+		}
+	}
+
+	// Without a maximum, the delay keeps growing.
+	b = Backoff{Initial: time.Second, Multiplier: 2}
+	if got := b.Delay(3); got != 8*time.Second {
+		t.Fatalf("expected=8s got=%s", got)
+	}
+	if got := b.Delay(1000); got != math.MaxInt64 {
+		t.Fatalf("expected the largest delay got=%s", got)
+	}
+
+	// A multiplier below 1 does not shrink the delay, and is rejected.
+	b = Backoff{Initial: time.Second, Multiplier: 0.5}
+	if got := b.Delay(3); got != time.Second {
+		t.Fatalf("expected=1s got=%s", got)
+	}
+	ioc := MustIO()
+	defer ioc.Close()
+	conn, err := NewReconnectingConnWithDialer(ioc, func() (Conn, error) {
+		return nil, sonicerrors.ErrDisconnected
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.SetBackoff(b); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := conn.SetBackoff(Backoff{Multiplier: 2}); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestReconnectingConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:10220")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The server reads the login of each client and then drops it.
+	logins := make(chan string, 8)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			b := make([]byte, 5)
+			n, _ := conn.Read(b)
+			logins <- string(b[:n])
+			conn.Close()
+		}
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := NewReconnectingConn(ioc, "tcp", "localhost:10220")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetBackoff(Backoff{
+		Initial:    time.Millisecond,
+		Max:        10 * time.Millisecond,
+		Multiplier: 2,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		connects    int
+		disconnects int
+		readErrs    []error
+		b           = make([]byte, 128)
+	)
+	conn.SetOnConnect(func() {
+		connects++
+		conn.AsyncWriteAll([]byte("login"), func(err error, _ int) {
+			if err != nil && err != sonicerrors.ErrDisconnected {
+				t.Fatal(err)
+			}
+		})
+		conn.AsyncRead(b, func(err error, _ int) {
+			readErrs = append(readErrs, err)
+		})
+	})
+	conn.SetOnDisconnect(func(err error) {
+		disconnects++
+		if disconnects == 2 {
+			conn.Close()
+		}
+	})
+
+	conn.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && !conn.Closed() {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	if connects != 2 {
+		t.Fatalf("expected 2 connects, got %d", connects)
+	}
+	if disconnects != 2 {
+		t.Fatalf("expected 2 disconnects, got %d", disconnects)
+	}
+	for _, err := range readErrs {
+		if err != sonicerrors.ErrDisconnected {
+			t.Fatalf("expected ErrDisconnected, got %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if login := <-logins; login != "login" {
+			t.Fatalf("unexpected login=%s", login)
+		}
+	}
+
+	conn.AsyncRead(b, func(err error, _ int) {
+		if err != sonicerrors.ErrDisconnected {
+			t.Fatal("expected ErrDisconnected after close")
+		}
+	})
+}
+
+func TestReconnectingConnRetriesDial(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	var dials int
+	conn, err := NewReconnectingConnWithDialer(ioc, func() (Conn, error) {
+		dials++
+		return nil, sonicerrors.ErrConnRefused
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetBackoff(Backoff{
+		Initial:    time.Millisecond,
+		Max:        time.Millisecond,
+		Multiplier: 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	conn.Start()
+
+	if conn.Connected() {
+		t.Fatal("should not be connected")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && dials < 5 {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if dials < 5 {
+		t.Fatalf("expected at least 5 dials, got %d", dials)
+	}
+	if conn.Attempts() < 4 {
+		t.Fatalf("expected at least 4 failed attempts, got %d", conn.Attempts())
+	}
+}
+
+func TestReconnectingConnDialsAsync(t *testing.T) {
+	// Find a port nobody listens on.
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := NewReconnectingConn(ioc, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetBackoff(Backoff{
+		Initial:    time.Millisecond,
+		Max:        time.Millisecond,
+		Multiplier: 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The connection is refused on the IO, not while Start blocks.
+	conn.Start()
+	if conn.Attempts() != 0 {
+		t.Fatalf("expected the dial to be pending, got %d failed attempts", conn.Attempts())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && conn.Attempts() < 3 {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if conn.Attempts() < 3 {
+		t.Fatalf("expected at least 3 failed attempts, got %d", conn.Attempts())
+	}
+
+	// Closing while a dial is pending stops reconnecting once it completes.
+	for conn.state != reconnDialing {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	attempts := conn.Attempts()
+	for i := 0; i < 10; i++ {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if conn.Attempts() != attempts || !conn.Closed() {
+		t.Fatal("should not reconnect once closed")
+	}
+}
+
+func TestReconnectingConnScheduleError(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := NewReconnectingConnWithDialer(ioc, func() (Conn, error) {
+		return nil, sonicerrors.ErrConnRefused
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var disconnectErr error
+	conn.SetOnDisconnect(func(err error) {
+		disconnectErr = err
+	})
+
+	// The next attempt cannot be scheduled on a closed timer.
+	_ = conn.timer.Close()
+	conn.Start()
+	if disconnectErr != sonicerrors.ErrCancelled {
+		t.Fatalf("expected ErrCancelled got=%v", disconnectErr)
+	}
+	if !conn.Closed() {
+		t.Fatal("expected the connection to be closed")
+	}
+}
@@ -0,0 +1,543 @@
+package sonic
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// ServerHandler handles the lifecycle of the connections of a Server. The
+// handler is invoked from the IO, so it must not block.
+type ServerHandler interface {
+	// OnOpen is invoked once a connection is accepted, before any of its data
+	// is read.
+	OnOpen(c *ServerConn)
+
+	// OnData is invoked with the bytes read from a connection. The slice is
+	// reused once OnData returns, so it must be copied to be kept.
+	OnData(c *ServerConn, b []byte)
+
+	// OnClose is invoked once a connection is closed. The error is why it was
+	// closed: io.EOF if the peer closed it, sonicerrors.ErrTimeout if it was
+	// idle for too long, nil if it was closed by ServerConn.Close, Server.Close
+	// or Server.AsyncShutdown and the read or write error otherwise.
+	OnClose(c *ServerConn, err error)
+
+	// OnAcceptError is invoked when accepting a connection fails. The server
+	// keeps accepting: right away, or after a delay if it is out of file
+	// descriptors or memory.
+	OnAcceptError(err error)
+}
+
+// DefaultServerReadBufferSize is the size of the buffer each connection of a
+// Server reads into, if no other size is set.
+const DefaultServerReadBufferSize = 4096
+
+// The delays after which a Server accepts again once it ran out of file
+// descriptors or memory. The delay doubles with each failure, and is reset by
+// the next accepted connection.
+const (
+	serverAcceptRetryMin = 5 * time.Millisecond
+	serverAcceptRetryMax = time.Second
+)
+
+// Server accepts connections from a Listener and drives each of them through a
+// ServerHandler.
+//
+// The server keeps a registry of its connections. It stops accepting once it
+// holds the maximum number of connections, leaving the clients in the
+// listener's backlog until a connection closes. Connections on which nothing
+// is read for longer than the idle timeout are closed.
+//
+// A Server is not safe for concurrent use: it must be used from the goroutine
+// running its IO.
+type Server struct {
+	ioc     *IO
+	ln      Listener
+	handler ServerHandler
+
+	maxConns       int
+	idleTimeout    time.Duration
+	readBufferSize int
+
+	conns  map[*ServerConn]struct{}
+	nextID uint64
+
+	started   bool
+	accepting bool
+	draining  bool
+	closed    bool
+
+	drainTimer *Timer
+	drainErr   error
+	onDrained  func(error)
+
+	retryTimer *Timer
+	retryDelay time.Duration
+	retrying   bool
+
+	onAcceptFn AcceptCallback
+	onRetryFn  func()
+}
+
+// NewServer creates a Server which accepts connections from ln once Start is
+// called. The Server owns ln: it is closed when the server closes or shuts
+// down.
+func NewServer(ioc *IO, ln Listener, handler ServerHandler) *Server {
+	s := &Server{
+		ioc:            ioc,
+		ln:             ln,
+		handler:        handler,
+		readBufferSize: DefaultServerReadBufferSize,
+		conns:          make(map[*ServerConn]struct{}),
+	}
+	s.onAcceptFn = s.onAccept
+	s.onRetryFn = s.onRetry
+	return s
+}
+
+// SetMaxConns sets the maximum number of open connections. A value of 0, the
+// default, means no limit.
+func (s *Server) SetMaxConns(n int) {
+	s.maxConns = n
+	s.acceptMore()
+}
+
+// SetIdleTimeout sets the time after which a connection on which nothing is
+// read is closed with sonicerrors.ErrTimeout. A timeout of 0, the default,
+// disables it. It applies to the connections accepted afterwards.
+func (s *Server) SetIdleTimeout(timeout time.Duration) {
+	s.idleTimeout = timeout
+}
+
+// SetReadBufferSize sets the size of the buffer each connection reads into. It
+// applies to the connections accepted afterwards.
+func (s *Server) SetReadBufferSize(n int) {
+	s.readBufferSize = n
+}
+
+// Start starts accepting connections.
+func (s *Server) Start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.acceptMore()
+}
+
+// Conns returns the number of open connections.
+func (s *Server) Conns() int {
+	return len(s.conns)
+}
+
+// Each invokes fn for each open connection, in no particular order.
+func (s *Server) Each(fn func(c *ServerConn)) {
+	for c := range s.conns {
+		fn(c)
+	}
+}
+
+// Broadcast writes b to all open connections. b can be reused once Broadcast
+// returns.
+func (s *Server) Broadcast(b []byte) {
+	for c := range s.conns {
+		c.Write(b)
+	}
+}
+
+// Addr returns the listener's network address.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+func (s *Server) acceptMore() {
+	if !s.started || s.accepting || s.retrying || s.draining || s.closed {
+		return
+	}
+	if s.maxConns > 0 && len(s.conns) >= s.maxConns {
+		return
+	}
+	s.accepting = true
+	s.ln.AsyncAccept(s.onAcceptFn)
+}
+
+func (s *Server) onAccept(err error, conn Conn) {
+	s.accepting = false
+
+	if err != nil {
+		if s.draining || s.closed || err == sonicerrors.ErrCancelled {
+			return
+		}
+		s.handler.OnAcceptError(err)
+		if s.draining || s.closed {
+			return
+		}
+
+		if outOfResources(err) {
+			// The listener stays readable until a file descriptor or memory is
+			// freed, so accepting right away would spin.
+			s.retryLater()
+		} else {
+			// Errors like a failed TLS handshake concern a single client.
+			_ = s.ioc.Post(s.acceptMore)
+		}
+		return
+	}
+	if s.draining || s.closed {
+		_ = conn.Close()
+		return
+	}
+
+	s.retryDelay = 0
+	s.open(conn)
+	s.acceptMore()
+}
+
+func outOfResources(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.ENOMEM)
+}
+
+// retryLater accepts again after a delay, or once a connection is closed if
+// the delay cannot be scheduled.
+func (s *Server) retryLater() {
+	if s.retryDelay == 0 {
+		s.retryDelay = serverAcceptRetryMin
+	} else if s.retryDelay *= 2; s.retryDelay > serverAcceptRetryMax {
+		s.retryDelay = serverAcceptRetryMax
+	}
+
+	s.retrying = true
+	if s.retryTimer == nil {
+		timer, err := NewTimer(s.ioc)
+		if err != nil {
+			return
+		}
+		s.retryTimer = timer
+	}
+	_ = s.retryTimer.ScheduleOnce(s.retryDelay, s.onRetryFn)
+}
+
+func (s *Server) onRetry() {
+	s.retrying = false
+	s.acceptMore()
+}
+
+func (s *Server) stopRetrying() {
+	s.retrying = false
+	if s.retryTimer != nil {
+		_ = s.retryTimer.Close()
+		s.retryTimer = nil
+	}
+}
+
+func (s *Server) open(conn Conn) {
+	s.nextID++
+	c := &ServerConn{
+		server:       s,
+		conn:         conn,
+		id:           s.nextID,
+		readBuf:      make([]byte, s.readBufferSize),
+		out:          NewByteBuffer(),
+		idleTimeout:  s.idleTimeout,
+		lastActivity: time.Now(),
+	}
+	c.onReadFn = c.onRead
+	c.onWriteFn = c.onWrite
+	c.onIdleFn = c.onIdle
+
+	if c.idleTimeout > 0 {
+		timer, err := NewTimer(s.ioc)
+		if err == nil {
+			err = timer.ScheduleOnce(c.idleTimeout, c.onIdleFn)
+		}
+		if err != nil {
+			// The handler never saw the connection, so it is dropped silently.
+			if timer != nil {
+				_ = timer.Close()
+			}
+			_ = conn.Close()
+			return
+		}
+		c.idleTimer = timer
+	}
+
+	s.conns[c] = struct{}{}
+	s.handler.OnOpen(c)
+	if !c.closed {
+		c.conn.AsyncRead(c.readBuf, c.onReadFn)
+	}
+}
+
+func (s *Server) remove(c *ServerConn) {
+	delete(s.conns, c)
+	if s.draining && len(s.conns) == 0 {
+		s.drained()
+	}
+	if s.retrying {
+		// The connection freed a file descriptor.
+		s.retrying = false
+		if s.retryTimer != nil {
+			_ = s.retryTimer.Cancel()
+		}
+	}
+	s.acceptMore()
+}
+
+// AsyncShutdown gracefully shuts down the server. It stops accepting, flushes
+// what was written to each connection and then shuts down its writing side.
+// Connections keep being read until their peer closes them. The callback is
+// invoked once all connections are closed, with sonicerrors.ErrTimeout if some
+// had to be closed forcibly because the timeout expired. A timeout of 0 waits
+// indefinitely.
+func (s *Server) AsyncShutdown(timeout time.Duration, cb func(error)) {
+	if s.draining || s.closed {
+		cb(sonicerrors.ErrCancelled)
+		return
+	}
+	s.draining = true
+	s.onDrained = cb
+	s.stopRetrying()
+	_ = s.ln.Close()
+
+	if len(s.conns) == 0 {
+		s.drained()
+		return
+	}
+
+	if timeout > 0 {
+		timer, err := NewTimer(s.ioc)
+		if err == nil {
+			err = timer.ScheduleOnce(timeout, func() {
+				s.drainErr = sonicerrors.ErrTimeout
+				s.closeAll()
+			})
+		}
+		if err != nil {
+			if timer != nil {
+				_ = timer.Close()
+			}
+			s.drainErr = err
+			s.closeAll()
+			return
+		}
+		s.drainTimer = timer
+	}
+
+	for c := range s.conns {
+		c.shutdown()
+	}
+}
+
+func (s *Server) drained() {
+	if s.drainTimer != nil {
+		_ = s.drainTimer.Close()
+		s.drainTimer = nil
+	}
+	if cb := s.onDrained; cb != nil {
+		s.onDrained = nil
+		s.closed = true
+		cb(s.drainErr)
+	}
+}
+
+func (s *Server) closeAll() {
+	for c := range s.conns {
+		c.closeWith(nil)
+	}
+}
+
+// Close closes the listener and all connections immediately. A pending
+// AsyncShutdown completes with sonicerrors.ErrCancelled.
+func (s *Server) Close() error {
+	if s.closed {
+		return nil
+	}
+
+	var err error
+	if !s.draining {
+		err = s.ln.Close()
+	}
+	s.draining = false
+	s.closed = true
+	s.stopRetrying()
+
+	s.closeAll()
+	if cb := s.onDrained; cb != nil {
+		s.onDrained = nil
+		if s.drainTimer != nil {
+			_ = s.drainTimer.Close()
+			s.drainTimer = nil
+		}
+		cb(sonicerrors.ErrCancelled)
+	}
+	return err
+}
+
+// ServerConn is a connection accepted by a Server.
+type ServerConn struct {
+	server *Server
+	conn   Conn
+	id     uint64
+
+	readBuf []byte
+	out     *ByteBuffer // bytes written but not yet flushed
+	writing bool
+
+	idleTimeout  time.Duration
+	idleTimer    *Timer
+	lastActivity time.Time
+
+	shuttingDown bool
+	closed       bool
+
+	// Context is left to the handler, to associate its own state with the
+	// connection.
+	Context any
+
+	onReadFn  AsyncCallback
+	onWriteFn AsyncCallback
+	onIdleFn  func()
+}
+
+// ID returns the identifier of the connection, unique within its Server.
+func (c *ServerConn) ID() uint64 {
+	return c.id
+}
+
+// Conn returns the underlying connection.
+func (c *ServerConn) Conn() Conn {
+	return c.conn
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *ServerConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Closed returns true if the connection is closed.
+func (c *ServerConn) Closed() bool {
+	return c.closed
+}
+
+// Write queues b to be written to the connection. b can be reused once Write
+// returns. A write error closes the connection.
+func (c *ServerConn) Write(b []byte) {
+	if c.closed || c.shuttingDown {
+		return
+	}
+	_, _ = c.out.Write(b)
+	c.out.Commit(len(b))
+	if !c.writing {
+		c.flush()
+	}
+}
+
+// Buffered returns the number of bytes written but not yet flushed.
+func (c *ServerConn) Buffered() int {
+	return c.out.ReadLen()
+}
+
+func (c *ServerConn) flush() {
+	c.writing = true
+	c.conn.AsyncWriteAll(c.out.Data(), c.onWriteFn)
+}
+
+func (c *ServerConn) onWrite(err error, n int) {
+	c.writing = false
+	if c.closed {
+		return
+	}
+	if err != nil {
+		c.closeWith(err)
+		return
+	}
+
+	c.out.Consume(n)
+	if c.out.ReadLen() > 0 {
+		c.flush()
+	} else if _, ok := c.conn.(HalfCloser); !ok && c.shuttingDown {
+		c.closeWith(nil)
+	}
+}
+
+func (c *ServerConn) onRead(err error, n int) {
+	if c.closed {
+		return
+	}
+	if err != nil {
+		if c.shuttingDown && err == io.EOF {
+			err = nil
+		}
+		c.closeWith(err)
+		return
+	}
+
+	c.lastActivity = time.Now()
+	c.server.handler.OnData(c, c.readBuf[:n])
+	if !c.closed {
+		c.conn.AsyncRead(c.readBuf, c.onReadFn)
+	}
+}
+
+func (c *ServerConn) onIdle() {
+	if c.closed {
+		return
+	}
+	if idle := time.Since(c.lastActivity); idle < c.idleTimeout {
+		if err := c.idleTimer.ScheduleOnce(c.idleTimeout-idle, c.onIdleFn); err != nil {
+			c.closeWith(err)
+		}
+		return
+	}
+	c.closeWith(sonicerrors.ErrTimeout)
+}
+
+// shutdown shuts down the writing side once everything written is flushed. The
+// connection closes once the peer closes its side, or once flushed if it is not
+// a HalfCloser.
+func (c *ServerConn) shutdown() {
+	if c.closed || c.shuttingDown {
+		return
+	}
+	c.shuttingDown = true
+	hc, ok := c.conn.(HalfCloser)
+	if !ok {
+		// The connection is closed once flushed instead.
+		if !c.writing {
+			c.closeWith(nil)
+		}
+		return
+	}
+	hc.AsyncShutdown(func(err error) {
+		if err != nil && !c.closed {
+			c.closeWith(err)
+		}
+	})
+}
+
+// Close closes the connection. Bytes written but not yet flushed are
+// discarded.
+func (c *ServerConn) Close() {
+	c.closeWith(nil)
+}
+
+func (c *ServerConn) closeWith(err error) {
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	if c.idleTimer != nil {
+		_ = c.idleTimer.Close()
+	}
+	c.conn.Cancel()
+	_ = c.conn.Close()
+
+	c.server.handler.OnClose(c, err)
+	c.server.remove(c)
+}
@@ -0,0 +1,405 @@
+package sonic
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+type testServerHandler struct {
+	echo   bool
+	opened int
+	closed []error
+
+	acceptErrs []error
+}
+
+func (h *testServerHandler) OnOpen(c *ServerConn) {
+	h.opened++
+}
+
+func (h *testServerHandler) OnData(c *ServerConn, b []byte) {
+	if h.echo {
+		c.Write(b)
+	}
+}
+
+func (h *testServerHandler) OnClose(c *ServerConn, err error) {
+	h.closed = append(h.closed, err)
+}
+
+func (h *testServerHandler) OnAcceptError(err error) {
+	h.acceptErrs = append(h.acceptErrs, err)
+}
+
+// newTestServer returns a Server listening on an ephemeral port, and the
+// address to dial it.
+func newTestServer(t *testing.T, h ServerHandler) (*IO, *Server, string) {
+	ioc := MustIO()
+	ln, err := Listen(ioc, "tcp", "localhost:0", sonicopts.Nonblocking(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := internal.SocketAddress(ln.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ioc, NewServer(ioc, ln, h), addr.String()
+}
+
+// runUntilRecv runs the IO until something is received from ch.
+func runUntilRecv[T any](t *testing.T, ioc *IO, ch <-chan T) T {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		select {
+		case v := <-ch:
+			return v
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+}
+
+func TestServerEcho(t *testing.T) {
+	h := &testServerHandler{echo: true}
+	ioc, s, addr := newTestServer(t, h)
+	defer ioc.Close()
+	defer s.Close()
+	s.Start()
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		b := make([]byte, 5)
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			done <- err
+			return
+		}
+		if _, err := io.ReadFull(conn, b); err != nil {
+			done <- err
+			return
+		}
+		if string(b) != "hello" {
+			done <- io.ErrUnexpectedEOF
+			return
+		}
+		done <- nil
+	}()
+	if err := runUntilRecv(t, ioc, done); err != nil {
+		t.Fatal(err)
+	}
+
+	closed := false
+	for !closed {
+		_ = ioc.RunOneFor(time.Millisecond)
+		closed = s.Conns() == 0
+	}
+	if h.opened != 1 || len(h.closed) != 1 || h.closed[0] != io.EOF {
+		t.Fatalf("unexpected lifecycle opened=%d closed=%v", h.opened, h.closed)
+	}
+}
+
+func TestServerMaxConns(t *testing.T) {
+	h := &testServerHandler{}
+	ioc, s, addr := newTestServer(t, h)
+	defer ioc.Close()
+	defer s.Close()
+	s.SetMaxConns(1)
+	s.Start()
+
+	var clients []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		clients = append(clients, conn)
+	}
+
+	deadline := time.Now().Add(100 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if h.opened != 1 {
+		t.Fatalf("expected 1 connection got=%d", h.opened)
+	}
+
+	// The second client is accepted once the first one is gone.
+	clients[0].Close()
+	deadline = time.Now().Add(5 * time.Second)
+	for h.opened != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if s.Conns() != 1 {
+		t.Fatalf("expected 1 connection got=%d", s.Conns())
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	h := &testServerHandler{echo: true}
+	ioc, s, addr := newTestServer(t, h)
+	defer ioc.Close()
+	defer s.Close()
+	s.SetIdleTimeout(100 * time.Millisecond)
+	s.Start()
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		// Activity postpones the timeout.
+		b := make([]byte, 1)
+		for i := 0; i < 3; i++ {
+			time.Sleep(50 * time.Millisecond)
+			if _, err := conn.Write([]byte("a")); err != nil {
+				done <- err
+				return
+			}
+			if _, err := io.ReadFull(conn, b); err != nil {
+				done <- err
+				return
+			}
+		}
+
+		_, err = conn.Read(b)
+		done <- err
+	}()
+	if err := runUntilRecv(t, ioc, done); err != io.EOF {
+		t.Fatalf("expected io.EOF got=%v", err)
+	}
+	if len(h.closed) != 1 || h.closed[0] != sonicerrors.ErrTimeout {
+		t.Fatalf("expected a timeout got=%v", h.closed)
+	}
+}
+
+func TestServerBroadcastAndShutdown(t *testing.T) {
+	h := &testServerHandler{}
+	ioc, s, addr := newTestServer(t, h)
+	defer ioc.Close()
+	defer s.Close()
+	s.Start()
+
+	const n = 3
+	received := make(chan string, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				received <- err.Error()
+				return
+			}
+			defer conn.Close()
+			// The server shuts down its side after the broadcast.
+			b, _ := io.ReadAll(conn)
+			received <- string(b)
+		}()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Conns() != n {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	s.Broadcast([]byte("hello"))
+
+	shutdown := make(chan error, 1)
+	s.AsyncShutdown(time.Second, func(err error) {
+		shutdown <- err
+	})
+	for i := 0; i < n; i++ {
+		if b := runUntilRecv(t, ioc, received); b != "hello" {
+			t.Fatalf("unexpected broadcast=%s", b)
+		}
+	}
+	if err := runUntilRecv(t, ioc, shutdown); err != nil {
+		t.Fatal(err)
+	}
+	if s.Conns() != 0 || len(h.closed) != n {
+		t.Fatalf("expected all connections to be closed got=%d", s.Conns())
+	}
+	for _, err := range h.closed {
+		if err != nil {
+			t.Fatalf("expected a graceful close got=%v", err)
+		}
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	h := &testServerHandler{}
+	ioc, s, addr := newTestServer(t, h)
+	defer ioc.Close()
+	defer s.Close()
+	s.Start()
+
+	// The client never closes its side.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Conns() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	shutdown := make(chan error, 1)
+	s.AsyncShutdown(50*time.Millisecond, func(err error) {
+		shutdown <- err
+	})
+	if err := runUntilRecv(t, ioc, shutdown); err != sonicerrors.ErrTimeout {
+		t.Fatalf("expected a timeout got=%v", err)
+	}
+	if s.Conns() != 0 {
+		t.Fatalf("expected all connections to be closed got=%d", s.Conns())
+	}
+}
+
+// wholeListener accepts connections which are not HalfClosers.
+type wholeListener struct {
+	Listener
+}
+
+func (l wholeListener) AsyncAccept(cb AcceptCallback) {
+	l.Listener.AsyncAccept(func(err error, conn Conn) {
+		if conn != nil {
+			conn = wholeConn{conn}
+		}
+		cb(err, conn)
+	})
+}
+
+type wholeConn struct {
+	Conn
+}
+
+func TestServerShutdownWithoutHalfCloser(t *testing.T) {
+	h := &testServerHandler{}
+	ioc, s, addr := newTestServer(t, h)
+	defer ioc.Close()
+	s = NewServer(ioc, wholeListener{s.ln}, h)
+	defer s.Close()
+	s.Start()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		// The server closes the connection once the broadcast is flushed.
+		b, _ := io.ReadAll(conn)
+		received <- string(b)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Conns() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	s.Broadcast([]byte("hello"))
+
+	shutdown := make(chan error, 1)
+	s.AsyncShutdown(time.Second, func(err error) {
+		shutdown <- err
+	})
+	if b := runUntilRecv(t, ioc, received); b != "hello" {
+		t.Fatalf("unexpected broadcast=%s", b)
+	}
+	if err := runUntilRecv(t, ioc, shutdown); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.closed) != 1 || h.closed[0] != nil {
+		t.Fatalf("expected a graceful close got=%v", h.closed)
+	}
+}
+
+// exhaustedListener fails to accept with EMFILE while exhausted is set.
+type exhaustedListener struct {
+	Listener
+	exhausted bool
+	calls     int
+}
+
+func (l *exhaustedListener) AsyncAccept(cb AcceptCallback) {
+	l.calls++
+	if l.exhausted {
+		cb(os.NewSyscallError("accept", syscall.EMFILE), nil)
+		return
+	}
+	l.Listener.AsyncAccept(cb)
+}
+
+func TestServerAcceptOutOfFds(t *testing.T) {
+	h := &testServerHandler{}
+	ioc, s, addr := newTestServer(t, h)
+	defer ioc.Close()
+	ln := &exhaustedListener{Listener: s.ln, exhausted: true}
+	s = NewServer(ioc, ln, h)
+	defer s.Close()
+	s.Start()
+
+	// The server backs off instead of accepting again right away.
+	deadline := time.Now().Add(100 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if ln.calls < 2 || ln.calls > 10 {
+		t.Fatalf("accepted %d times while out of file descriptors", ln.calls)
+	}
+	if len(h.acceptErrs) != ln.calls || !errors.Is(h.acceptErrs[0], syscall.EMFILE) {
+		t.Fatalf("wrong accept errors %v", h.acceptErrs)
+	}
+
+	// It accepts once file descriptors are available again.
+	ln.exhausted = false
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	deadline = time.Now().Add(5 * time.Second)
+	for h.opened == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+}
@@ -258,6 +258,79 @@ func (s *Socket) SendTo(
 	}
 }
 
+// RecvMsg reads a datagram into b and its ancillary data into oob. oob
+// should be sized with ControlMessageSpace for the control messages enabled
+// on the socket with SetControlMessage. The returned oob bytes can be parsed
+// with ControlMessage.Parse.
+//
+// A Socket is not attached to an IO, so it is only read synchronously. A
+// multicast.UDPPeer, which registers its socket with an IO, reads with
+// AsyncReadMsg.
+func (s *Socket) RecvMsg(
+	b, oob []byte,
+	flags SocketIOFlags,
+) (n, oobn int, recvFlags int, peerAddr netip.AddrPort, err error) {
+	n, oobn, recvFlags, s.readSockAddr, err = syscall.Recvmsg(
+		s.fd, b, oob, int(flags))
+	if err != nil {
+		if err == syscall.EWOULDBLOCK || err == syscall.EAGAIN {
+			return 0, 0, 0, netip.AddrPort{}, sonicerrors.ErrWouldBlock
+		}
+		return 0, 0, 0, netip.AddrPort{}, err
+	}
+	if n == 0 && oobn == 0 {
+		return 0, 0, 0, netip.AddrPort{}, io.EOF
+	}
+
+	if n < 0 {
+		n = 0
+	}
+
+	switch sa := s.readSockAddr.(type) {
+	case *syscall.SockaddrInet4:
+		peerAddr = netip.AddrPortFrom(netip.AddrFrom4(sa.Addr), uint16(sa.Port))
+	case *syscall.SockaddrInet6:
+		peerAddr = netip.AddrPortFrom(netip.AddrFrom16(sa.Addr), uint16(sa.Port))
+	default:
+		return n, oobn, recvFlags, netip.AddrPort{}, fmt.Errorf(
+			"can only recvmsg from ipv4 and ipv6 peers err=%v", err)
+	}
+	return n, oobn, recvFlags, peerAddr, nil
+}
+
+// SendMsg writes b as a single datagram to peerAddr, along with the ancillary
+// data in oob. oob can be built with ControlMessage.Marshal.
+//
+// Like RecvMsg it is synchronous: multicast.UDPPeer writes with AsyncWriteMsg.
+func (s *Socket) SendMsg(
+	b, oob []byte,
+	flags SocketIOFlags,
+	peerAddr netip.AddrPort,
+) (int, error) {
+	var sa syscall.Sockaddr
+	if peerAddr.Addr().Is4() || peerAddr.Addr().Is4In6() {
+		s.writeSockAddrIpv4.Addr = peerAddr.Addr().As4()
+		s.writeSockAddrIpv4.Port = int(peerAddr.Port())
+		sa = s.writeSockAddrIpv4
+	} else {
+		sa = &syscall.SockaddrInet6{
+			Addr: peerAddr.Addr().As16(),
+			Port: int(peerAddr.Port()),
+		}
+	}
+
+	n, err := syscall.SendmsgN(s.fd, b, oob, sa, int(flags))
+	if err == nil {
+		return n, nil
+	} else if err == syscall.EWOULDBLOCK || err == syscall.EAGAIN {
+		return 0, sonicerrors.ErrWouldBlock
+	} else if err == syscall.ENOBUFS {
+		return 0, sonicerrors.ErrNoBufferSpaceAvailable
+	} else {
+		return 0, err
+	}
+}
+
 func (s *Socket) Close() (err error) {
 	if s.fd >= 0 {
 		err = syscall.Close(s.fd)
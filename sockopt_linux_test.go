@@ -0,0 +1,195 @@
+//go:build linux
+
+package sonic
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+func assertSocketOpts(t *testing.T, fd int, tcp bool) {
+	assert := assert.New(t)
+
+	rcvbuf, err := sonicopts.GetRecvBuffer(fd)
+	assert.Nil(err)
+	assert.Equal(2*8192, rcvbuf) // Linux doubles the value
+
+	sndbuf, err := sonicopts.GetSendBuffer(fd)
+	assert.Nil(err)
+	assert.Equal(2*16384, sndbuf)
+
+	linger, err := sonicopts.GetLinger(fd)
+	assert.Nil(err)
+	assert.Equal(sonicopts.LingerParams{On: true, Timeout: 2 * time.Second}, linger)
+
+	tos, err := sonicopts.GetTOS(fd)
+	assert.Nil(err)
+	assert.Equal(0x10, tos)
+
+	priority, err := sonicopts.GetPriority(fd)
+	assert.Nil(err)
+	assert.Equal(3, priority)
+
+	busyPoll, err := sonicopts.GetBusyPoll(fd)
+	assert.Nil(err)
+	assert.Equal(50*time.Microsecond, busyPoll)
+
+	cpu, err := sonicopts.GetIncomingCPU(fd)
+	assert.Nil(err)
+	assert.Equal(0, cpu)
+
+	if !tcp {
+		return
+	}
+
+	on, keepAlive, err := sonicopts.GetKeepAlive(fd)
+	assert.Nil(err)
+	assert.True(on)
+	assert.Equal(sonicopts.KeepAliveParams{
+		Idle:     10 * time.Second,
+		Interval: 2 * time.Second,
+		Count:    3,
+	}, keepAlive)
+
+	userTimeout, err := sonicopts.GetUserTimeout(fd)
+	assert.Nil(err)
+	assert.Equal(1500*time.Millisecond, userTimeout)
+
+	quickAck, err := sonicopts.GetQuickAck(fd)
+	assert.Nil(err)
+	assert.True(quickAck)
+
+	lowat, err := sonicopts.GetNotSentLowat(fd)
+	assert.Nil(err)
+	assert.Equal(4096, lowat)
+}
+
+func testSocketOpts(tcp bool) []sonicopts.Option {
+	opts := []sonicopts.Option{
+		sonicopts.RecvBuffer(8192),
+		sonicopts.SendBuffer(16384),
+		sonicopts.Linger(true, 2*time.Second),
+		sonicopts.TOS(0x10),
+		sonicopts.Priority(3),
+		sonicopts.BusyPoll(50 * time.Microsecond),
+		sonicopts.IncomingCPU(0),
+	}
+	if tcp {
+		opts = append(opts,
+			sonicopts.KeepAlive(10*time.Second, 2*time.Second, 3),
+			sonicopts.QuickAck(true),
+			sonicopts.UserTimeout(1500*time.Millisecond),
+			sonicopts.NotSentLowat(4096),
+		)
+	}
+	return opts
+}
+
+func TestSocketOptsDial(t *testing.T) {
+	mark := make(chan struct{}, 1)
+	defer func() { <-mark }()
+	go func() {
+		ln, err := net.Listen("tcp", "localhost:10210")
+		if err != nil {
+			panic(err)
+		}
+		defer func() {
+			ln.Close()
+			mark <- struct{}{}
+		}()
+		mark <- struct{}{}
+
+		conn, err := ln.Accept()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		<-mark
+	}()
+	<-mark
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", "localhost:10210", testSocketOpts(true)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	assertSocketOpts(t, conn.RawFd(), true)
+
+	mark <- struct{}{}
+}
+
+func TestSocketOptsListen(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "tcp", "localhost:10211", testSocketOpts(true)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	assertSocketOpts(t, ln.RawFd(), true)
+}
+
+func TestSocketOptsPacketConn(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := NewPacketConn(ioc, "udp", "localhost:10212", testSocketOpts(false)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	assertSocketOpts(t, conn.RawFd(), false)
+}
+
+func TestSocketOptsUnsupported(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	// TCP options cannot be set on UDP sockets.
+	_, err := NewPacketConn(ioc, "udp", "localhost:10213", sonicopts.NotSentLowat(1))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestSocketOptsDialBind(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	// The options given after BindSocket are applied too.
+	bindAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)}
+	opts := append([]sonicopts.Option{sonicopts.BindSocket(bindAddr)}, testSocketOpts(true)...)
+	conn, err := Dial(ioc, "tcp", ln.Addr().String(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	assertSocketOpts(t, conn.RawFd(), true)
+	localAddr, err := internal.SocketAddress(conn.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !localAddr.(*net.TCPAddr).IP.Equal(bindAddr.IP) {
+		t.Fatalf("bound to %s expected %s", localAddr, bindAddr.IP)
+	}
+}
@@ -9,4 +9,6 @@ var (
 	ErrNeedMore               = errors.New("need to read/write more bytes")
 	ErrNoBufferSpaceAvailable = errors.New("no buffer space available")
 	ErrConnRefused            = errors.New("connection refused") // a connect() on a stream socket found no one listening on the remote address
+	ErrDisconnected           = errors.New("disconnected")       // the connection was lost while the operation was pending
+	ErrHandshakeIncomplete    = errors.New("handshake not completed")
 )
@@ -0,0 +1,67 @@
+package sonicopts
+
+// BufferParams is the value of the RecvBuffer and SendBuffer options.
+type BufferParams struct {
+	// Size of the buffer in bytes. Note that Linux doubles this value to
+	// account for bookkeeping overhead.
+	Size int
+
+	// Force uses SO_RCVBUFFORCE/SO_SNDBUFFORCE, which allow a privileged
+	// process (CAP_NET_ADMIN) to go over the net.core.rmem_max and
+	// net.core.wmem_max limits. Linux only.
+	Force bool
+}
+
+type recvBuffer struct {
+	v BufferParams
+}
+
+// RecvBuffer sets the size of the socket's receive buffer (SO_RCVBUF).
+func RecvBuffer(size int) Option {
+	return &recvBuffer{
+		v: BufferParams{Size: size},
+	}
+}
+
+// RecvBufferForce is like RecvBuffer but it uses SO_RCVBUFFORCE, which ignores
+// the net.core.rmem_max limit. Requires CAP_NET_ADMIN. Linux only.
+func RecvBufferForce(size int) Option {
+	return &recvBuffer{
+		v: BufferParams{Size: size, Force: true},
+	}
+}
+
+func (o *recvBuffer) Type() OptionType {
+	return TypeRecvBuffer
+}
+
+func (o *recvBuffer) Value() interface{} {
+	return o.v
+}
+
+type sendBuffer struct {
+	v BufferParams
+}
+
+// SendBuffer sets the size of the socket's send buffer (SO_SNDBUF).
+func SendBuffer(size int) Option {
+	return &sendBuffer{
+		v: BufferParams{Size: size},
+	}
+}
+
+// SendBufferForce is like SendBuffer but it uses SO_SNDBUFFORCE, which ignores
+// the net.core.wmem_max limit. Requires CAP_NET_ADMIN. Linux only.
+func SendBufferForce(size int) Option {
+	return &sendBuffer{
+		v: BufferParams{Size: size, Force: true},
+	}
+}
+
+func (o *sendBuffer) Type() OptionType {
+	return TypeSendBuffer
+}
+
+func (o *sendBuffer) Value() interface{} {
+	return o.v
+}
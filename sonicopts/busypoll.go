@@ -0,0 +1,24 @@
+package sonicopts
+
+import "time"
+
+type busyPoll struct {
+	v time.Duration
+}
+
+// BusyPoll sets SO_BUSY_POLL: the approximate time to busy-poll the device's
+// receive queue when there is no data. Rounded down to microseconds. Raising
+// this value above net.core.busy_read requires CAP_NET_ADMIN. Linux only.
+func BusyPoll(v time.Duration) Option {
+	return &busyPoll{
+		v: v,
+	}
+}
+
+func (o *busyPoll) Type() OptionType {
+	return TypeBusyPoll
+}
+
+func (o *busyPoll) Value() interface{} {
+	return o.v
+}
@@ -16,6 +16,19 @@ const (
 	TypeNoDelay
 	TypeBindSocket
 	TypeMulticast
+	TypeRecvBuffer
+	TypeSendBuffer
+	TypeKeepAlive
+	TypeQuickAck
+	TypeUserTimeout
+	TypeLinger
+	TypePriority
+	TypeTOS
+	TypeBusyPoll
+	TypeIncomingCPU
+	TypeNotSentLowat
+	TypeFastOpen
+	TypeFastOpenConnect
 	MaxOption
 )
 
@@ -33,6 +46,32 @@ func (t OptionType) String() string {
 		return "bind_socket"
 	case TypeMulticast:
 		return "multicast"
+	case TypeRecvBuffer:
+		return "recv_buffer"
+	case TypeSendBuffer:
+		return "send_buffer"
+	case TypeKeepAlive:
+		return "keep_alive"
+	case TypeQuickAck:
+		return "quick_ack"
+	case TypeUserTimeout:
+		return "user_timeout"
+	case TypeLinger:
+		return "linger"
+	case TypePriority:
+		return "priority"
+	case TypeTOS:
+		return "tos"
+	case TypeBusyPoll:
+		return "busy_poll"
+	case TypeIncomingCPU:
+		return "incoming_cpu"
+	case TypeNotSentLowat:
+		return "not_sent_lowat"
+	case TypeFastOpen:
+		return "fast_open"
+	case TypeFastOpenConnect:
+		return "fast_open_connect"
 	default:
 		panic(fmt.Errorf("invalid option %d", t))
 	}
@@ -0,0 +1,44 @@
+package sonicopts
+
+type fastOpen struct {
+	v int
+}
+
+// FastOpen enables TCP Fast Open on a listener (TCP_FASTOPEN): clients which
+// hold a cookie from a previous connection can send data in their SYN. The
+// value is the maximum number of pending Fast Open requests. The server side
+// must also be enabled by the net.ipv4.tcp_fastopen sysctl. Linux only.
+func FastOpen(queueLen int) Option {
+	return &fastOpen{
+		v: queueLen,
+	}
+}
+
+func (o *fastOpen) Type() OptionType {
+	return TypeFastOpen
+}
+
+func (o *fastOpen) Value() interface{} {
+	return o.v
+}
+
+type fastOpenConnect struct {
+	v bool
+}
+
+// FastOpenConnect enables TCP Fast Open on a client (TCP_FASTOPEN_CONNECT):
+// connecting is deferred to the first write, whose data is sent in the SYN if
+// the client holds a cookie for the server. Linux only.
+func FastOpenConnect(v bool) Option {
+	return &fastOpenConnect{
+		v: v,
+	}
+}
+
+func (o *fastOpenConnect) Type() OptionType {
+	return TypeFastOpenConnect
+}
+
+func (o *fastOpenConnect) Value() interface{} {
+	return o.v
+}
@@ -0,0 +1,51 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly || linux
+
+package sonicopts
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// The getters below read back the value of an option from the socket fd, for
+// example the RawFd of a Conn or of a PacketConn.
+
+func getsockoptInt(fd, level, opt int) (int, error) {
+	v, err := syscall.GetsockoptInt(fd, level, opt)
+	if err != nil {
+		return 0, os.NewSyscallError("getsockopt", err)
+	}
+	return v, nil
+}
+
+// GetRecvBuffer returns the size of the socket's receive buffer. Note that
+// Linux reports double the size set with RecvBuffer.
+func GetRecvBuffer(fd int) (int, error) {
+	return getsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+}
+
+// GetSendBuffer returns the size of the socket's send buffer. Note that Linux
+// reports double the size set with SendBuffer.
+func GetSendBuffer(fd int) (int, error) {
+	return getsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+}
+
+// GetLinger returns the socket's SO_LINGER setting.
+func GetLinger(fd int) (LingerParams, error) {
+	l, err := unix.GetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER)
+	if err != nil {
+		return LingerParams{}, os.NewSyscallError("getsockopt", err)
+	}
+	return LingerParams{
+		On:      l.Onoff != 0,
+		Timeout: time.Duration(l.Linger) * time.Second,
+	}, nil
+}
+
+// GetTOS returns the IP type of service of the socket.
+func GetTOS(fd int) (int, error) {
+	return getsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_TOS)
+}
@@ -0,0 +1,70 @@
+//go:build linux
+
+package sonicopts
+
+import (
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// GetKeepAlive returns whether keepalive is enabled on the socket along with
+// its probe parameters.
+func GetKeepAlive(fd int) (on bool, v KeepAliveParams, err error) {
+	keepalive, err := getsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+	if err != nil {
+		return false, v, err
+	}
+	idle, err := getsockoptInt(fd, syscall.IPPROTO_TCP, unix.TCP_KEEPIDLE)
+	if err != nil {
+		return false, v, err
+	}
+	interval, err := getsockoptInt(fd, syscall.IPPROTO_TCP, unix.TCP_KEEPINTVL)
+	if err != nil {
+		return false, v, err
+	}
+	count, err := getsockoptInt(fd, syscall.IPPROTO_TCP, unix.TCP_KEEPCNT)
+	if err != nil {
+		return false, v, err
+	}
+	return keepalive != 0, KeepAliveParams{
+		Idle:     time.Duration(idle) * time.Second,
+		Interval: time.Duration(interval) * time.Second,
+		Count:    count,
+	}, nil
+}
+
+// GetQuickAck returns whether TCP_QUICKACK is set on the socket. The kernel
+// clears it on its own, so it only reflects the current mode.
+func GetQuickAck(fd int) (bool, error) {
+	v, err := getsockoptInt(fd, syscall.IPPROTO_TCP, unix.TCP_QUICKACK)
+	return v != 0, err
+}
+
+// GetUserTimeout returns the socket's TCP_USER_TIMEOUT.
+func GetUserTimeout(fd int) (time.Duration, error) {
+	v, err := getsockoptInt(fd, syscall.IPPROTO_TCP, unix.TCP_USER_TIMEOUT)
+	return time.Duration(v) * time.Millisecond, err
+}
+
+// GetPriority returns the socket's SO_PRIORITY.
+func GetPriority(fd int) (int, error) {
+	return getsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_PRIORITY)
+}
+
+// GetBusyPoll returns the socket's SO_BUSY_POLL.
+func GetBusyPoll(fd int) (time.Duration, error) {
+	v, err := getsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_BUSY_POLL)
+	return time.Duration(v) * time.Microsecond, err
+}
+
+// GetIncomingCPU returns the socket's SO_INCOMING_CPU.
+func GetIncomingCPU(fd int) (int, error) {
+	return getsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_INCOMING_CPU)
+}
+
+// GetNotSentLowat returns the socket's TCP_NOTSENT_LOWAT.
+func GetNotSentLowat(fd int) (int, error) {
+	return getsockoptInt(fd, syscall.IPPROTO_TCP, unix.TCP_NOTSENT_LOWAT)
+}
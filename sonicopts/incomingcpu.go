@@ -0,0 +1,22 @@
+package sonicopts
+
+type incomingCPU struct {
+	v int
+}
+
+// IncomingCPU sets SO_INCOMING_CPU: the CPU on which packets for this socket
+// are expected to be processed. With ReusePort, this steers flows to the
+// socket owned by the thread pinned to that CPU. Linux only.
+func IncomingCPU(v int) Option {
+	return &incomingCPU{
+		v: v,
+	}
+}
+
+func (o *incomingCPU) Type() OptionType {
+	return TypeIncomingCPU
+}
+
+func (o *incomingCPU) Value() interface{} {
+	return o.v
+}
@@ -0,0 +1,42 @@
+package sonicopts
+
+import "time"
+
+// KeepAliveParams is the value of the KeepAlive option.
+type KeepAliveParams struct {
+	// Idle is the time the connection must be idle before the first probe is
+	// sent (TCP_KEEPIDLE). Rounded down to seconds. Ignored if 0.
+	Idle time.Duration
+
+	// Interval is the time between probes (TCP_KEEPINTVL). Rounded down to
+	// seconds. Ignored if 0.
+	Interval time.Duration
+
+	// Count is the number of unanswered probes after which the connection is
+	// dropped (TCP_KEEPCNT). Ignored if 0.
+	Count int
+}
+
+type keepAlive struct {
+	v KeepAliveParams
+}
+
+// KeepAlive enables TCP keepalive (SO_KEEPALIVE) with the given probe idle
+// time, interval and count.
+func KeepAlive(idle, interval time.Duration, count int) Option {
+	return &keepAlive{
+		v: KeepAliveParams{
+			Idle:     idle,
+			Interval: interval,
+			Count:    count,
+		},
+	}
+}
+
+func (o *keepAlive) Type() OptionType {
+	return TypeKeepAlive
+}
+
+func (o *keepAlive) Value() interface{} {
+	return o.v
+}
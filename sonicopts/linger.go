@@ -0,0 +1,33 @@
+package sonicopts
+
+import "time"
+
+// LingerParams is the value of the Linger option.
+type LingerParams struct {
+	On      bool
+	Timeout time.Duration // rounded down to seconds
+}
+
+type linger struct {
+	v LingerParams
+}
+
+// Linger sets SO_LINGER. If on is true, Close blocks for at most timeout while
+// unsent data is flushed. If on is true and timeout is 0, Close resets the
+// connection and discards any unsent data.
+func Linger(on bool, timeout time.Duration) Option {
+	return &linger{
+		v: LingerParams{
+			On:      on,
+			Timeout: timeout,
+		},
+	}
+}
+
+func (o *linger) Type() OptionType {
+	return TypeLinger
+}
+
+func (o *linger) Value() interface{} {
+	return o.v
+}
@@ -0,0 +1,22 @@
+package sonicopts
+
+type notSentLowat struct {
+	v int
+}
+
+// NotSentLowat sets TCP_NOTSENT_LOWAT: the number of unsent bytes in the send
+// queue below which the socket is reported as writable. Keeps the send queue,
+// and hence the latency of newly written data, small.
+func NotSentLowat(v int) Option {
+	return &notSentLowat{
+		v: v,
+	}
+}
+
+func (o *notSentLowat) Type() OptionType {
+	return TypeNotSentLowat
+}
+
+func (o *notSentLowat) Value() interface{} {
+	return o.v
+}
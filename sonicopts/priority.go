@@ -0,0 +1,22 @@
+package sonicopts
+
+type priority struct {
+	v int
+}
+
+// Priority sets SO_PRIORITY: the protocol-defined priority of all packets sent
+// on the socket, used by the kernel to pick a queue on the network device.
+// Linux only.
+func Priority(v int) Option {
+	return &priority{
+		v: v,
+	}
+}
+
+func (o *priority) Type() OptionType {
+	return TypePriority
+}
+
+func (o *priority) Value() interface{} {
+	return o.v
+}
@@ -0,0 +1,22 @@
+package sonicopts
+
+type quickAck struct {
+	v bool
+}
+
+// QuickAck sets TCP_QUICKACK. If true, ACKs are sent immediately instead of
+// being delayed. Note that the kernel may reset this flag on its own, so it
+// might need to be set again after reads. Linux only.
+func QuickAck(v bool) Option {
+	return &quickAck{
+		v: v,
+	}
+}
+
+func (o *quickAck) Type() OptionType {
+	return TypeQuickAck
+}
+
+func (o *quickAck) Value() interface{} {
+	return o.v
+}
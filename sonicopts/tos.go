@@ -0,0 +1,21 @@
+package sonicopts
+
+type tos struct {
+	v int
+}
+
+// TOS sets IP_TOS: the type-of-service field of the IPv4 header of all packets
+// sent on the socket. For example, 0x10 for low delay. IPv4 only.
+func TOS(v int) Option {
+	return &tos{
+		v: v,
+	}
+}
+
+func (o *tos) Type() OptionType {
+	return TypeTOS
+}
+
+func (o *tos) Value() interface{} {
+	return o.v
+}
@@ -0,0 +1,24 @@
+package sonicopts
+
+import "time"
+
+type userTimeout struct {
+	v time.Duration
+}
+
+// UserTimeout sets TCP_USER_TIMEOUT: the maximum time transmitted data may
+// remain unacknowledged before the connection is dropped. Rounded down to
+// milliseconds. Linux only.
+func UserTimeout(v time.Duration) Option {
+	return &userTimeout{
+		v: v,
+	}
+}
+
+func (o *userTimeout) Type() OptionType {
+	return TypeUserTimeout
+}
+
+func (o *userTimeout) Value() interface{} {
+	return o.v
+}
@@ -0,0 +1,202 @@
+package sonic
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/talostrading/sonic/sonicerrors"
+	"golang.org/x/sys/unix"
+)
+
+// StdStream is an asynchronous stream on one of the standard streams of the
+// process, usually a terminal.
+//
+// The stream reads and writes a new open file description of what the
+// standard file descriptor refers to, so that making it non-blocking leaves
+// the standard stream, and the other processes sharing it like the shell,
+// alone. It is reopened through /proc/self/fd on Linux and through /dev/tty on
+// other platforms if it is the controlling terminal. Regular files, sockets
+// and, on other platforms, everything but the controlling terminal cannot be
+// reopened: the stream then uses a duplicate of the standard file descriptor,
+// which shares its file status flags with the original, so the standard stream
+// becomes non-blocking for the whole process and the processes sharing it.
+// Shared reports which is the case. Close restores the flags and the terminal
+// mode. When several shared standard streams refer to the same file, close
+// them in the reverse order of their creation, so that the flags of the first
+// one are restored last.
+//
+// While a StdStream is open, the corresponding os.Stdin, os.Stdout or
+// os.Stderr must not be used, as it is not prepared for non-blocking reads or
+// writes.
+type StdStream struct {
+	*file
+
+	flags   int           // file status flags before the stream was created
+	shared  bool          // the file description is the standard stream's
+	termios *unix.Termios // terminal mode before MakeRaw, if called
+
+	resizeCh    chan os.Signal
+	resizeCb    func(err error, rows, cols uint16)
+	onResizeFn  func()
+	resizeQueue bool // a resize happened while no callback was pending
+}
+
+// Stdin returns an asynchronous stream reading the standard input.
+func Stdin(ioc *IO) (*StdStream, error) {
+	return newStdStream(ioc, syscall.Stdin)
+}
+
+// Stdout returns an asynchronous stream writing the standard output.
+func Stdout(ioc *IO) (*StdStream, error) {
+	return newStdStream(ioc, syscall.Stdout)
+}
+
+// Stderr returns an asynchronous stream writing the standard error.
+func Stderr(ioc *IO) (*StdStream, error) {
+	return newStdStream(ioc, syscall.Stderr)
+}
+
+func newStdStream(ioc *IO, stdFd int) (*StdStream, error) {
+	flags, err := unix.FcntlInt(uintptr(stdFd), unix.F_GETFL, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("fcntl", err)
+	}
+
+	fd, err := reopenStd(stdFd, flags)
+	shared := err != nil
+	if shared {
+		// Duplicate the descriptor, so that closing the stream does not close
+		// the standard stream.
+		syscall.ForkLock.RLock()
+		fd, err = syscall.Dup(stdFd)
+		if err == nil {
+			syscall.CloseOnExec(fd)
+		}
+		syscall.ForkLock.RUnlock()
+		if err != nil {
+			return nil, os.NewSyscallError("dup", err)
+		}
+	}
+
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError("set_nonblock", err)
+	}
+
+	s := &StdStream{
+		file:   newFile(ioc, fd),
+		flags:  flags,
+		shared: shared,
+	}
+	s.onResizeFn = s.onResize
+	return s, nil
+}
+
+// Shared returns true if the stream shares its file status flags with the
+// standard stream, which is then non-blocking until the stream is closed.
+func (s *StdStream) Shared() bool {
+	return s.shared
+}
+
+// IsTerminal returns true if the stream is a terminal.
+func (s *StdStream) IsTerminal() bool {
+	return isTerminal(s.RawFd())
+}
+
+// MakeRaw puts the terminal in raw mode: input is available byte by byte,
+// without being echoed or interpreted, and output is not processed. Close
+// restores the previous mode.
+func (s *StdStream) MakeRaw() error {
+	termios, err := makeRaw(s.RawFd())
+	if err != nil {
+		return err
+	}
+	if s.termios == nil {
+		s.termios = termios
+	}
+	return nil
+}
+
+// Size returns the size of the terminal.
+func (s *StdStream) Size() (rows, cols uint16, err error) {
+	return terminalSize(s.RawFd())
+}
+
+// AsyncResize invokes the callback with the new size of the terminal once it
+// is resized. Resizes which happen while no callback is pending are coalesced
+// and reported to the next one.
+func (s *StdStream) AsyncResize(cb func(err error, rows, cols uint16)) {
+	if s.Closed() {
+		cb(sonicerrors.ErrCancelled, 0, 0)
+		return
+	}
+	s.resizeCb = cb
+
+	if s.resizeCh == nil {
+		// The size changes are signalled to the process with SIGWINCH, which
+		// the runtime delivers on a channel.
+		s.resizeCh = make(chan os.Signal, 1)
+		signal.Notify(s.resizeCh, syscall.SIGWINCH)
+		go func(ch chan os.Signal) {
+			for range ch {
+				_ = s.ioc.Post(s.onResizeFn)
+			}
+		}(s.resizeCh)
+	}
+
+	if s.resizeQueue {
+		s.resizeQueue = false
+		s.onResize()
+	}
+}
+
+func (s *StdStream) onResize() {
+	if s.Closed() {
+		return
+	}
+
+	cb := s.resizeCb
+	if cb == nil {
+		s.resizeQueue = true
+		return
+	}
+	s.resizeCb = nil
+
+	rows, cols, err := s.Size()
+	s.ioc.Dispatched++
+	cb(err, rows, cols)
+	s.ioc.Dispatched--
+}
+
+// Close restores the terminal mode and the file status flags of the standard
+// stream and closes the stream's descriptor. The standard stream stays open.
+// A pending AsyncResize completes with sonicerrors.ErrCancelled.
+func (s *StdStream) Close() error {
+	if s.Closed() {
+		return s.file.Close()
+	}
+
+	var err error
+	if s.termios != nil {
+		err = setTermios(s.RawFd(), s.termios)
+	}
+	if s.shared {
+		if _, ferr := unix.FcntlInt(uintptr(s.RawFd()), unix.F_SETFL, s.flags); ferr != nil && err == nil {
+			err = os.NewSyscallError("fcntl", ferr)
+		}
+	}
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+
+	if s.resizeCh != nil {
+		signal.Stop(s.resizeCh)
+		close(s.resizeCh)
+	}
+	if cb := s.resizeCb; cb != nil {
+		s.resizeCb = nil
+		cb(sonicerrors.ErrCancelled, 0, 0)
+	}
+	return err
+}
@@ -0,0 +1,37 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package sonic
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reopenStd opens a new file description of the controlling terminal if the
+// standard file descriptor refers to it.
+func reopenStd(stdFd, flags int) (int, error) {
+	if !isTerminal(stdFd) {
+		return -1, errors.New("sonic: only the controlling terminal is reopened")
+	}
+
+	fd, err := unix.Open(
+		"/dev/tty",
+		flags&(unix.O_ACCMODE|unix.O_APPEND)|unix.O_NONBLOCK|unix.O_NOCTTY|unix.O_CLOEXEC,
+		0,
+	)
+	if err != nil {
+		return -1, os.NewSyscallError("open", err)
+	}
+
+	var std, tty unix.Stat_t
+	if err := unix.Fstat(stdFd, &std); err == nil {
+		err = unix.Fstat(fd, &tty)
+	}
+	if err != nil || std.Rdev != tty.Rdev {
+		_ = unix.Close(fd)
+		return -1, errors.New("sonic: not the controlling terminal")
+	}
+	return fd, nil
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package sonic
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// reopenStd opens a new file description of what the standard file descriptor
+// refers to, through /proc. A regular file is not reopened, as the new
+// description would not share its offset. Sockets cannot be reopened.
+func reopenStd(stdFd, flags int) (int, error) {
+	var stat unix.Stat_t
+	if err := unix.Fstat(stdFd, &stat); err != nil {
+		return -1, os.NewSyscallError("fstat", err)
+	}
+	if stat.Mode&unix.S_IFMT == unix.S_IFREG {
+		return -1, errors.New("sonic: regular files are not reopened")
+	}
+
+	// O_NONBLOCK keeps the open of a FIFO without a peer from blocking.
+	fd, err := unix.Open(
+		"/proc/self/fd/"+strconv.Itoa(stdFd),
+		flags&(unix.O_ACCMODE|unix.O_APPEND)|unix.O_NONBLOCK|unix.O_NOCTTY|unix.O_CLOEXEC,
+		0,
+	)
+	if err != nil {
+		return -1, os.NewSyscallError("open", err)
+	}
+	return fd, nil
+}
@@ -0,0 +1,79 @@
+//go:build linux
+
+package sonic
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestStdStreamPrivateDescription(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	pty, err := OpenPTY(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pty.Close()
+	if err := pty.MakeRaw(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	for _, test := range []struct {
+		name  string
+		fd    int
+		write func([]byte) (int, error)
+	}{
+		{"pipe", int(r.Fd()), w.Write},
+		{"terminal", int(pty.Slave().Fd()), pty.Write},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			before, err := unix.FcntlInt(uintptr(test.fd), unix.F_GETFL, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := newStdStream(ioc, test.fd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			if s.Shared() {
+				t.Fatal("expected a private file description")
+			}
+
+			flags, err := unix.FcntlInt(uintptr(test.fd), unix.F_GETFL, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if flags != before {
+				t.Fatalf("expected flags=%x got=%x", before, flags)
+			}
+
+			if _, err := test.write([]byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			b := make([]byte, 5)
+			done := make(chan error, 1)
+			s.AsyncReadAll(b, func(err error, _ int) {
+				done <- err
+			})
+			if err := runUntilRecv(t, ioc, done); err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "hello" {
+				t.Fatalf("expected=hello got=%q", b)
+			}
+		})
+	}
+}
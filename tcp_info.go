@@ -0,0 +1,172 @@
+package sonic
+
+import (
+	"fmt"
+	"time"
+)
+
+// TCPInfo holds the kernel's statistics of a TCP connection, as returned by
+// getsockopt(TCP_INFO). See TCPInfoer.
+//
+// Fields which are not reported by the running kernel are left zero.
+type TCPInfo struct {
+	// State is the TCP state of the connection, e.g. 1 for ESTABLISHED.
+	State uint8
+
+	// RTT is the smoothed round-trip time.
+	RTT time.Duration
+
+	// RTTVar is the variance of the round-trip time.
+	RTTVar time.Duration
+
+	// MinRTT is the minimum round-trip time observed on the connection.
+	MinRTT time.Duration
+
+	// RTO is the current retransmission timeout.
+	RTO time.Duration
+
+	// Retransmits is the number of consecutive retransmits of the oldest
+	// unacknowledged segment.
+	Retransmits uint8
+
+	// TotalRetransmits is the number of segments retransmitted over the
+	// lifetime of the connection.
+	TotalRetransmits uint32
+
+	// Lost is the number of segments currently deemed lost.
+	Lost uint32
+
+	// Unacked is the number of segments sent but not yet acknowledged.
+	Unacked uint32
+
+	// NotSentBytes is the number of bytes in the send queue that have not yet
+	// been sent.
+	NotSentBytes uint32
+
+	// SndCwnd is the congestion window, in segments.
+	SndCwnd uint32
+
+	// SndSsthresh is the slow-start threshold, in segments.
+	SndSsthresh uint32
+
+	// SndMSS and RcvMSS are the send and receive maximum segment sizes.
+	SndMSS uint32
+	RcvMSS uint32
+
+	// SndWnd is the peer's advertised receive window, RcvWnd is ours, in
+	// bytes.
+	SndWnd uint32
+	RcvWnd uint32
+
+	// DeliveryRate is the most recent goodput measurement, in bytes per
+	// second.
+	DeliveryRate uint64
+
+	// PacingRate is the current pacing rate, in bytes per second.
+	PacingRate uint64
+
+	BytesSent     uint64
+	BytesRetrans  uint64
+	BytesAcked    uint64
+	BytesReceived uint64
+
+	SegsOut uint32
+	SegsIn  uint32
+}
+
+// TCPInfoSampler periodically samples the TCPInfo of a Conn with a Timer and
+// reports the samples which differ from the previous one.
+type TCPInfoSampler struct {
+	conn     Conn
+	timer    *Timer
+	interval time.Duration
+	cb       func(err error, prev, cur *TCPInfo)
+
+	prev    TCPInfo
+	cur     TCPInfo
+	sampled bool
+}
+
+// NewTCPInfoSampler creates a sampler which calls conn.TCPInfo() once per
+// interval after Start is called. Sampling fails if conn does not implement
+// TCPInfoer.
+//
+// The callback is invoked on the IO with the previous and the current sample
+// each time the current sample differs from the previous one. The first
+// sample is always reported, with prev zeroed. If sampling fails, the
+// callback is invoked with the error and the sampler stops.
+//
+// The samples passed to the callback are owned by the sampler and are only
+// valid until the callback returns.
+func NewTCPInfoSampler(
+	ioc *IO,
+	conn Conn,
+	interval time.Duration,
+	cb func(err error, prev, cur *TCPInfo),
+) (*TCPInfoSampler, error) {
+	timer, err := NewTimer(ioc)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &TCPInfoSampler{
+		conn:     conn,
+		timer:    timer,
+		interval: interval,
+		cb:       cb,
+	}
+	return s, nil
+}
+
+// Start samples immediately and then once per interval.
+func (s *TCPInfoSampler) Start() error {
+	if !s.sample() {
+		return nil
+	}
+	return s.timer.ScheduleRepeating(s.interval, func() {
+		s.sample()
+	})
+}
+
+// sample takes a sample and reports it if it changed. It returns false if
+// sampling failed, in which case the sampler is stopped.
+func (s *TCPInfoSampler) sample() bool {
+	info, err := connTCPInfo(s.conn)
+	if err != nil {
+		_ = s.timer.Cancel()
+		s.cb(err, &s.prev, &s.cur)
+		return false
+	}
+
+	if !s.sampled || info != s.cur {
+		s.prev = s.cur
+		s.cur = info
+		s.sampled = true
+		s.cb(nil, &s.prev, &s.cur)
+	}
+	return true
+}
+
+// Stop stops sampling. The sampler can be started again.
+func (s *TCPInfoSampler) Stop() error {
+	return s.timer.Cancel()
+}
+
+// Last returns the last sample taken. It is zeroed if no samples were taken.
+func (s *TCPInfoSampler) Last() TCPInfo {
+	return s.cur
+}
+
+// Close stops sampling and releases the sampler's timer. The sampler cannot be
+// used after Close.
+func (s *TCPInfoSampler) Close() error {
+	return s.timer.Close()
+}
+
+// connTCPInfo returns the TCPInfo of conn if it implements TCPInfoer.
+func connTCPInfo(conn Conn) (TCPInfo, error) {
+	if c, ok := conn.(TCPInfoer); ok {
+		return c.TCPInfo()
+	}
+	return TCPInfo{}, fmt.Errorf("%T does not report TCP_INFO", conn)
+}
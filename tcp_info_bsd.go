@@ -0,0 +1,11 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package sonic
+
+import (
+	"fmt"
+)
+
+func getTCPInfo(fd int) (TCPInfo, error) {
+	return TCPInfo{}, fmt.Errorf("TCP_INFO is not yet supported on BSD")
+}
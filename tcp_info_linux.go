@@ -0,0 +1,67 @@
+//go:build linux
+
+package sonic
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func getTCPInfo(fd int) (TCPInfo, error) {
+	var (
+		raw unix.TCPInfo
+		n   = uint32(unsafe.Sizeof(raw))
+	)
+
+	// We do not use unix.GetsockoptTCPInfo as that allocates. Older kernels
+	// fill only a prefix of the struct, in which case the rest stays zero.
+
+	/* #nosec G103 -- the use of unsafe has been audited */
+	_, _, errno := syscall.Syscall6(
+		uintptr(syscall.SYS_GETSOCKOPT),
+		uintptr(fd),
+		uintptr(syscall.IPPROTO_TCP),
+		uintptr(syscall.TCP_INFO),
+		uintptr(unsafe.Pointer(&raw)),
+		uintptr(unsafe.Pointer(&n)),
+		0,
+	)
+	if errno != 0 {
+		return TCPInfo{}, os.NewSyscallError("getsockopt(TCP_INFO)", errno)
+	}
+
+	us := func(v uint32) time.Duration {
+		return time.Duration(v) * time.Microsecond
+	}
+
+	return TCPInfo{
+		State:            raw.State,
+		RTT:              us(raw.Rtt),
+		RTTVar:           us(raw.Rttvar),
+		MinRTT:           us(raw.Min_rtt),
+		RTO:              us(raw.Rto),
+		Retransmits:      raw.Retransmits,
+		TotalRetransmits: raw.Total_retrans,
+		Lost:             raw.Lost,
+		Unacked:          raw.Unacked,
+		NotSentBytes:     raw.Notsent_bytes,
+		SndCwnd:          raw.Snd_cwnd,
+		SndSsthresh:      raw.Snd_ssthresh,
+		SndMSS:           raw.Snd_mss,
+		RcvMSS:           raw.Rcv_mss,
+		SndWnd:           raw.Snd_wnd,
+		RcvWnd:           raw.Rcv_wnd,
+		DeliveryRate:     raw.Delivery_rate,
+		PacingRate:       raw.Pacing_rate,
+		BytesSent:        raw.Bytes_sent,
+		BytesRetrans:     raw.Bytes_retrans,
+		BytesAcked:       raw.Bytes_acked,
+		BytesReceived:    raw.Bytes_received,
+		SegsOut:          raw.Segs_out,
+		SegsIn:           raw.Segs_in,
+	}, nil
+}
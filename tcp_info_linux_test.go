@@ -0,0 +1,204 @@
+//go:build linux
+
+package sonic
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnTCPInfo(t *testing.T) {
+	mark := make(chan struct{}, 1)
+	defer func() { <-mark }()
+	go func() {
+		ln, err := net.Listen("tcp", "localhost:10200")
+		if err != nil {
+			panic(err)
+		}
+		defer func() {
+			ln.Close()
+			mark <- struct{}{}
+		}()
+		mark <- struct{}{}
+
+		conn, err := ln.Accept()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		b := make([]byte, 5)
+		if _, err := conn.Read(b); err != nil {
+			panic(err)
+		}
+		<-mark
+	}()
+	<-mark
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", "localhost:10200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	var info TCPInfo
+	for i := 0; i < 100; i++ {
+		info, err = conn.(TCPInfoer).TCPInfo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.BytesAcked > 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if info.State != 1 /* ESTABLISHED */ {
+		t.Fatalf("expected an established connection state=%d", info.State)
+	}
+	if info.RTT <= 0 {
+		t.Fatal("expected a positive round-trip time")
+	}
+	if info.SndCwnd == 0 {
+		t.Fatal("expected a non-zero congestion window")
+	}
+	if info.BytesSent != 5 {
+		t.Fatalf("expected 5 bytes sent, got %d", info.BytesSent)
+	}
+
+	mark <- struct{}{}
+}
+
+func TestConnTCPInfoUDP(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "udp", "localhost:10201")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.(TCPInfoer).TCPInfo(); err == nil {
+		t.Fatal("TCPInfo should fail on a UDP connection")
+	}
+}
+
+func TestTCPInfoSampler(t *testing.T) {
+	mark := make(chan struct{}, 1)
+	defer func() { <-mark }()
+	go func() {
+		ln, err := net.Listen("tcp", "localhost:10202")
+		if err != nil {
+			panic(err)
+		}
+		defer func() {
+			ln.Close()
+			mark <- struct{}{}
+		}()
+		mark <- struct{}{}
+
+		conn, err := ln.Accept()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		<-mark
+	}()
+	<-mark
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", "localhost:10202")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var (
+		reported int
+		sent     uint64
+	)
+	sampler, err := NewTCPInfoSampler(
+		ioc, conn, time.Millisecond,
+		func(err error, prev, cur *TCPInfo) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reported > 0 && *prev == *cur {
+				t.Fatal("sampler reported an unchanged sample")
+			}
+			reported++
+			sent = cur.BytesSent
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sampler.Close()
+
+	if err := sampler.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if reported != 1 {
+		t.Fatal("sampler should report the first sample immediately")
+	}
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && sent != 5 {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if sent != 5 {
+		t.Fatal("sampler did not report the write")
+	}
+	if sampler.Last().BytesSent != 5 {
+		t.Fatal("sampler's last sample is not the most recent")
+	}
+
+	if err := sampler.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	mark <- struct{}{}
+}
+
+// connWrapper implements Conn but not TCPInfoer.
+type connWrapper struct {
+	Conn
+}
+
+func TestTCPInfoSamplerWithoutTCPInfoer(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	var samplingErr error
+	sampler, err := NewTCPInfoSampler(
+		ioc, connWrapper{}, time.Millisecond,
+		func(err error, _, _ *TCPInfo) {
+			samplingErr = err
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sampler.Close()
+
+	if err := sampler.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if samplingErr == nil {
+		t.Fatal("sampling should fail on a connection without TCPInfo")
+	}
+}
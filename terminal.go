@@ -0,0 +1,59 @@
+package sonic
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func isTerminal(fd int) bool {
+	_, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	return err == nil
+}
+
+// makeRaw puts the terminal in raw mode, like cfmakeraw, and returns its
+// previous mode.
+func makeRaw(fd int) (*unix.Termios, error) {
+	termios, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, os.NewSyscallError("tcgetattr", err)
+	}
+	prev := *termios
+
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP |
+		unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+
+	if err := setTermios(fd, termios); err != nil {
+		return nil, err
+	}
+	return &prev, nil
+}
+
+func setTermios(fd int, termios *unix.Termios) error {
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, termios); err != nil {
+		return os.NewSyscallError("tcsetattr", err)
+	}
+	return nil
+}
+
+func terminalSize(fd int) (rows, cols uint16, err error) {
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, os.NewSyscallError("ioctl", err)
+	}
+	return ws.Row, ws.Col, nil
+}
+
+func setTerminalSize(fd int, rows, cols uint16) error {
+	ws := &unix.Winsize{Row: rows, Col: cols}
+	if err := unix.IoctlSetWinsize(fd, unix.TIOCSWINSZ, ws); err != nil {
+		return os.NewSyscallError("ioctl", err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package sonic
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ioctlGetTermios = unix.TIOCGETA
+	ioctlSetTermios = unix.TIOCSETA
+)
+
+// openPTY opens the master of a new pseudo-terminal, unlocks its slave and
+// returns the path of the slave.
+func openPTY() (master int, slaveName string, err error) {
+	master, err = unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, "", os.NewSyscallError("open", err)
+	}
+
+	if err := unix.IoctlSetInt(master, unix.TIOCPTYGRANT, 0); err != nil {
+		_ = unix.Close(master)
+		return -1, "", os.NewSyscallError("grantpt", err)
+	}
+	if err := unix.IoctlSetInt(master, unix.TIOCPTYUNLK, 0); err != nil {
+		_ = unix.Close(master)
+		return -1, "", os.NewSyscallError("unlockpt", err)
+	}
+
+	var name [128]byte
+	/* #nosec G103 -- the use of unsafe has been audited */
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		uintptr(master),
+		uintptr(unix.TIOCPTYGNAME),
+		uintptr(unsafe.Pointer(&name[0])),
+	)
+	if errno != 0 {
+		_ = unix.Close(master)
+		return -1, "", os.NewSyscallError("ptsname", errno)
+	}
+	if i := bytes.IndexByte(name[:], 0); i >= 0 {
+		return master, string(name[:i]), nil
+	}
+	return master, string(name[:]), nil
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package sonic
+
+import (
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ioctlGetTermios = unix.TCGETS
+	ioctlSetTermios = unix.TCSETS
+)
+
+// openPTY opens the master of a new pseudo-terminal, unlocks its slave and
+// returns the path of the slave.
+func openPTY() (master int, slaveName string, err error) {
+	master, err = unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, "", os.NewSyscallError("open", err)
+	}
+
+	if err := unix.IoctlSetPointerInt(master, unix.TIOCSPTLCK, 0); err != nil {
+		_ = unix.Close(master)
+		return -1, "", os.NewSyscallError("unlockpt", err)
+	}
+	n, err := unix.IoctlGetUint32(master, unix.TIOCGPTN)
+	if err != nil {
+		_ = unix.Close(master)
+		return -1, "", os.NewSyscallError("ptsname", err)
+	}
+	return master, "/dev/pts/" + strconv.FormatUint(uint64(n), 10), nil
+}
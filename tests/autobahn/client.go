@@ -13,6 +13,7 @@ var (
 	addr     = flag.String("addr", "ws://localhost:9001", "server address")
 	testCase = flag.Int("case", -1, "autobahn test case to run")
 	utf8     = flag.Bool("utf8", false, "if true, payloads of text frames are utf8 validated")
+	deflate  = flag.Bool("deflate", false, "if true, permessage-deflate is offered to the server")
 )
 
 func main() {
@@ -95,6 +96,12 @@ func runTest(i int) {
 		}
 	}
 
+	if *deflate {
+		if err := s.SetDeflateOptions(&websocket.DeflateOptions{}); err != nil {
+			panic(err)
+		}
+	}
+
 	done := false
 	s.AsyncHandshake(fmt.Sprintf("%s/runCase?case=%d&agent=sonic", *addr, i), func(err error) {
 		if err != nil {
@@ -0,0 +1,984 @@
+package sonic
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"time"
+
+	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+var (
+	_ Conn       = &TLSConn{}
+	_ TCPInfoer  = &TLSConn{}
+	_ HalfCloser = &TLSConn{}
+)
+
+// The maximum size of a TLS record on the wire: 16KB of plaintext plus the
+// record header and the largest expansion allowed by TLS 1.2.
+const tlsMaxRecordSize = 5 + 16384 + 2048
+
+// errTLSWouldBlock is returned by a tlsTransport when it has no more ciphertext
+// to give to crypto/tls. crypto/tls does not treat temporary errors as fatal,
+// so a read can be retried once more ciphertext has been read from the
+// connection.
+var errTLSWouldBlock net.Error = tlsWouldBlock{}
+
+type tlsWouldBlock struct{}
+
+func (tlsWouldBlock) Error() string   { return sonicerrors.ErrWouldBlock.Error() }
+func (tlsWouldBlock) Timeout() bool   { return true }
+func (tlsWouldBlock) Temporary() bool { return true }
+
+// tlsTransport is the net.Conn on top of which crypto/tls runs. It never
+// touches the file descriptor: ciphertext is read into `in` by the IO and
+// ciphertext produced by crypto/tls is buffered in `out` until the IO writes
+// it. It does not reference the underlying Conn, whose callbacks reference the
+// TLSConn, see tlsHandshakeGuard.
+type tlsTransport struct {
+	localAddr  net.Addr
+	remoteAddr net.Addr
+
+	in  *ByteBuffer
+	out *ByteBuffer
+
+	// Set once reading from the connection failed. Returned by Read once all
+	// buffered ciphertext is consumed.
+	err error
+
+	// The number of bytes of the record being read which are not yet given to
+	// crypto/tls. Read never returns bytes of more than one record, so that
+	// records can be counted.
+	recordLeft int
+
+	// Non-nil if the connection may be offloaded to the kernel.
+	keys *tlsKeyTracker
+
+	// Non-nil while the handshake runs. crypto/tls only exposes a blocking
+	// handshake, so it runs as a coroutine: Read yields to the IO when it
+	// runs out of ciphertext and blocks until it is resumed. Only one of the
+	// IO and the handshake runs at any time. The handshake fails once resume
+	// is closed.
+	yield  chan bool // true if the handshake is done
+	resume chan struct{}
+
+	// The result of the handshake, set before yielding true.
+	handshakeErr error
+}
+
+var _ net.Conn = &tlsTransport{}
+
+func (t *tlsTransport) Read(b []byte) (int, error) {
+	for {
+		t.in.Commit(t.in.WriteLen())
+
+		available := t.in.ReadLen()
+		first := false
+		if t.recordLeft == 0 && available >= tlsRecordHeaderLen {
+			first = true
+			t.recordLeft = tlsRecordHeaderLen +
+				int(binary.BigEndian.Uint16(t.in.Data()[3:tlsRecordHeaderLen]))
+		}
+		if t.recordLeft > 0 && available > 0 {
+			if len(b) > t.recordLeft {
+				b = b[:t.recordLeft]
+			}
+			n, err := t.in.Read(b)
+			t.recordLeft -= n
+			if t.keys != nil {
+				t.keys.rx.trackReceived(b[:n], first, t.recordLeft == 0)
+			}
+			return n, err
+		}
+
+		if t.err != nil {
+			if available > 0 {
+				// An incomplete record header.
+				return t.in.Read(b)
+			}
+			return 0, t.err
+		}
+		if t.yield == nil {
+			return 0, errTLSWouldBlock
+		}
+		t.yield <- false
+		if _, ok := <-t.resume; !ok {
+			return 0, net.ErrClosed
+		}
+	}
+}
+
+func (t *tlsTransport) Write(b []byte) (int, error) {
+	if t.keys != nil {
+		// crypto/tls writes whole records.
+		for record := b; len(record) >= tlsRecordHeaderLen; {
+			n := tlsRecordHeaderLen +
+				int(binary.BigEndian.Uint16(record[3:tlsRecordHeaderLen]))
+			if n > len(record) {
+				break
+			}
+			t.keys.tx.track(record[:n])
+			record = record[n:]
+		}
+	}
+	return t.out.Write(b)
+}
+
+func (t *tlsTransport) Close() error {
+	return nil
+}
+
+func (t *tlsTransport) LocalAddr() net.Addr {
+	return t.localAddr
+}
+
+func (t *tlsTransport) RemoteAddr() net.Addr {
+	return t.remoteAddr
+}
+
+func (t *tlsTransport) SetDeadline(time.Time) error      { return nil }
+func (t *tlsTransport) SetReadDeadline(time.Time) error  { return nil }
+func (t *tlsTransport) SetWriteDeadline(time.Time) error { return nil }
+
+// tlsHandshakeGuard releases the goroutine of a handshake when the TLSConn
+// running it is garbage collected. It is only referenced by the TLSConn, so
+// that it is not part of the reference cycles through the TLSConn's callbacks
+// which would keep its finalizer from running.
+type tlsHandshakeGuard struct {
+	resume chan struct{}
+}
+
+func newTLSHandshakeGuard(resume chan struct{}) *tlsHandshakeGuard {
+	g := &tlsHandshakeGuard{resume: resume}
+	runtime.SetFinalizer(g, func(g *tlsHandshakeGuard) {
+		close(g.resume)
+	})
+	return g
+}
+
+// done disarms the guard once the handshake's goroutine exited.
+func (g *tlsHandshakeGuard) done() {
+	runtime.SetFinalizer(g, nil)
+}
+
+// TLSConn is a TLS connection driven by the IO.
+//
+// Records are read from the underlying Conn into a ByteBuffer and decrypted
+// with crypto/tls. Plaintext which is decrypted but not yet delivered is
+// handed to the next read without waiting for the file descriptor to become
+// readable.
+//
+// AsyncHandshake must complete successfully before reading or writing.
+//
+// The connection can be offloaded to the kernel once the handshake completes,
+// see SetKernelTLS.
+type TLSConn struct {
+	ioc       *IO
+	conn      Conn
+	config    *tls.Config
+	isClient  bool
+	tls       *tls.Conn
+	transport *tlsTransport
+
+	handshakeCb    func(error)
+	handshakeErr   error
+	handshakeDone  bool
+	handshakeGuard *tlsHandshakeGuard
+
+	kernelTLS    bool
+	kernelTLSErr error
+	kernelTx     bool
+	kernelRx     bool
+	// Plaintext decrypted in user space before offloading reads to the
+	// kernel. It is delivered before anything read from the kernel.
+	pending *ByteBuffer
+	oob     []byte
+
+	// Only one read and one write can be pending at any time.
+	readBuf        []byte
+	readAll        bool
+	readSoFar      int
+	readCb         AsyncCallback
+	writeCb        AsyncCallback
+	writeN         int
+	flushing       bool
+	flushErr       error
+	shutdownCb     func(error)
+	closed         bool
+	onRawReadFn    AsyncCallback
+	onKernelReadFn AsyncCallback
+	onFlushFn      AsyncCallback
+	onKernelWrFn   AsyncCallback
+	onHsReadFn     AsyncCallback
+	onHsFlushFn    AsyncCallback
+	dispatchReadN  func()
+}
+
+func newTLSConn(ioc *IO, conn Conn, config *tls.Config, isClient bool) *TLSConn {
+	c := &TLSConn{
+		ioc:      ioc,
+		conn:     conn,
+		config:   config,
+		isClient: isClient,
+		transport: &tlsTransport{
+			localAddr:  conn.LocalAddr(),
+			remoteAddr: conn.RemoteAddr(),
+			in:         NewByteBuffer(),
+			out:        NewByteBuffer(),
+		},
+	}
+	c.onRawReadFn = c.onRawRead
+	c.onKernelReadFn = c.onKernelRead
+	c.onFlushFn = c.onFlush
+	c.onKernelWrFn = c.onKernelWrite
+	c.onHsReadFn = c.onHandshakeRead
+	c.onHsFlushFn = c.onHandshakeFlush
+	c.dispatchReadN = c.asyncReadNow
+	return c
+}
+
+// NewTLSClient returns a TLSConn in the client role on top of conn. The config
+// must set either ServerName or InsecureSkipVerify.
+func NewTLSClient(ioc *IO, conn Conn, config *tls.Config) *TLSConn {
+	return newTLSConn(ioc, conn, config, true)
+}
+
+// NewTLSServer returns a TLSConn in the server role on top of conn. The config
+// must contain at least one certificate or set GetCertificate.
+func NewTLSServer(ioc *IO, conn Conn, config *tls.Config) *TLSConn {
+	return newTLSConn(ioc, conn, config, false)
+}
+
+// DialTLS connects to the given address and returns a TLSConn in the client
+// role. The handshake is not performed; call AsyncHandshake.
+//
+// If config.ServerName is empty, it is inferred from addr.
+func DialTLS(
+	ioc *IO,
+	network, addr string,
+	config *tls.Config,
+	opts ...sonicopts.Option,
+) (*TLSConn, error) {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+
+	conn, err := Dial(ioc, network, addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewTLSClient(ioc, conn, config), nil
+}
+
+// AsyncHandshake performs the TLS handshake asynchronously. The callback is
+// invoked once the handshake completes or fails.
+//
+// The handshake messages are exchanged through the IO. crypto/tls runs in a
+// helper goroutine which is resumed by the IO each time more ciphertext is
+// read; the callback is always invoked from the IO's goroutine. This costs a
+// goroutine and two channel operations per handshake flight. The goroutine
+// exits when the handshake completes or fails, which Close makes happen; the
+// goroutine of a TLSConn which is dropped mid-handshake without Close exits
+// once the TLSConn is garbage collected.
+func (c *TLSConn) AsyncHandshake(cb func(error)) {
+	if c.handshakeDone {
+		cb(c.handshakeErr)
+		return
+	}
+	if c.handshakeCb != nil || c.closed {
+		cb(sonicerrors.ErrCancelled)
+		return
+	}
+
+	config := c.config
+	if c.kernelTLS {
+		// The keys are needed to offload the connection to the kernel.
+		if config == nil {
+			config = &tls.Config{}
+		}
+		config = config.Clone()
+		c.transport.keys = &tlsKeyTracker{
+			isClient: c.isClient,
+			keyLog:   config.KeyLogWriter,
+		}
+		config.KeyLogWriter = c.transport.keys
+	}
+	if c.isClient {
+		c.tls = tls.Client(c.transport, config)
+	} else {
+		c.tls = tls.Server(c.transport, config)
+	}
+
+	c.handshakeCb = cb
+	// The last yield does not block, so that the goroutine can exit once
+	// resume is closed even if the IO is gone.
+	t := c.transport
+	t.yield = make(chan bool, 1)
+	t.resume = make(chan struct{})
+	c.handshakeGuard = newTLSHandshakeGuard(t.resume)
+
+	// The goroutine must not reference c, see tlsHandshakeGuard.
+	conn := c.tls
+	go func() {
+		if _, ok := <-t.resume; ok {
+			t.handshakeErr = conn.Handshake()
+		} else {
+			t.handshakeErr = net.ErrClosed
+		}
+		t.yield <- true
+	}()
+
+	c.stepHandshake()
+}
+
+// stepHandshake resumes the handshake until it needs more ciphertext or
+// completes, and then flushes what it wrote.
+func (c *TLSConn) stepHandshake() {
+	c.transport.resume <- struct{}{}
+	done := <-c.transport.yield
+	if done {
+		c.transport.yield = nil
+		c.transport.resume = nil
+		c.handshakeGuard.done()
+		c.handshakeGuard = nil
+		c.handshakeErr = c.transport.handshakeErr
+		c.handshakeDone = true
+	}
+
+	if c.transport.out.WriteLen() > 0 {
+		c.transport.out.Commit(c.transport.out.WriteLen())
+		c.conn.AsyncWriteAll(c.transport.out.Data(), c.onHsFlushFn)
+	} else {
+		c.onHandshakeFlush(nil, 0)
+	}
+}
+
+func (c *TLSConn) onHandshakeFlush(err error, n int) {
+	c.transport.out.Consume(n)
+
+	if c.handshakeDone {
+		if err != nil && c.handshakeErr == nil {
+			c.handshakeErr = err
+		}
+		if c.handshakeErr == nil && c.kernelTLS {
+			c.kernelTLSErr = c.offload()
+		}
+		cb := c.handshakeCb
+		c.handshakeCb = nil
+		cb(c.handshakeErr)
+		return
+	}
+
+	if err == nil && c.closed {
+		err = sonicerrors.ErrCancelled
+	}
+	if err != nil {
+		// Make the handshake fail on its next read.
+		c.transport.err = err
+		c.stepHandshake()
+		return
+	}
+
+	c.transport.in.Reserve(tlsMaxRecordSize)
+	c.transport.in.AsyncReadFrom(c.conn, c.onHsReadFn)
+}
+
+func (c *TLSConn) onHandshakeRead(err error, _ int) {
+	if err != nil {
+		c.transport.err = err
+	}
+	c.stepHandshake()
+}
+
+// HandshakeComplete returns true if the handshake completed successfully.
+func (c *TLSConn) HandshakeComplete() bool {
+	return c.handshakeDone && c.handshakeErr == nil
+}
+
+// ConnectionState returns the state of the connection: negotiated version,
+// cipher suite, ALPN protocol, SNI server name, peer certificates etc. It
+// returns the zero value until the handshake completes.
+func (c *TLSConn) ConnectionState() tls.ConnectionState {
+	if !c.handshakeDone {
+		return tls.ConnectionState{}
+	}
+	return c.tls.ConnectionState()
+}
+
+// SetKernelTLS makes the connection try to offload encryption and decryption
+// to the kernel once the handshake completes. It must be called before
+// AsyncHandshake.
+//
+// Once offloaded, reads and writes carry plaintext between the caller and the
+// socket, and the socket itself can be used for zero copy transmission like
+// sendfile. Only TLS 1.3 with AES-GCM is supported. The connection stays in
+// user space if the kernel does not support TLS (the tls module is not
+// loaded) or the negotiated version or cipher suite is not supported; see
+// KernelTLS and KernelTLSErr.
+//
+// A peer which rotates its keys with a KeyUpdate makes reads fail once they
+// are offloaded.
+func (c *TLSConn) SetKernelTLS(on bool) {
+	c.kernelTLS = on
+}
+
+// KernelTLS returns whether writes (tx) and reads (rx) are offloaded to the
+// kernel.
+func (c *TLSConn) KernelTLS() (tx, rx bool) {
+	return c.kernelTx, c.kernelRx
+}
+
+// KernelTLSErr returns the reason the connection was not fully offloaded to
+// the kernel, if SetKernelTLS was called.
+func (c *TLSConn) KernelTLSErr() error {
+	return c.kernelTLSErr
+}
+
+// offload installs the keys in the kernel. What the kernel cannot see - the
+// records already read from the socket - is decrypted beforehand.
+func (c *TLSConn) offload() error {
+	keys := c.transport.keys
+	defer func() {
+		c.transport.keys = nil
+	}()
+
+	if c.pending == nil {
+		c.pending = NewByteBuffer()
+	}
+	for {
+		var err error
+		c.pending.Reserve(tlsMaxRecordSize)
+		c.pending.Claim(func(b []byte) int {
+			n, rerr := c.tls.Read(b)
+			err = rerr
+			return n
+		})
+		c.pending.Commit(c.pending.WriteLen())
+		if err == errTLSWouldBlock {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	t := c.transport
+	if t.out.WriteLen() > 0 {
+		// crypto/tls answered something while reading.
+		c.flush()
+		return errors.New("kernel TLS: user space TLS has pending writes")
+	}
+	if t.in.ReadLen()+t.in.WriteLen() > 0 || t.err != nil {
+		return errors.New("kernel TLS: a partial record is buffered in user space")
+	}
+
+	tx, rx, err := keys.kernelKeys(c.tls.ConnectionState())
+	if err != nil {
+		return err
+	}
+
+	c.kernelTx, c.kernelRx, err = enableKernelTLS(c.conn.RawFd(), tx, rx)
+	if c.kernelRx {
+		c.oob = make([]byte, kernelTLSRecordOOBSpace)
+	}
+	return err
+}
+
+// ServerName returns the server name requested by the client through SNI, or
+// the server name the client verified.
+func (c *TLSConn) ServerName() string {
+	return c.ConnectionState().ServerName
+}
+
+// NegotiatedProtocol returns the application protocol negotiated with ALPN.
+func (c *TLSConn) NegotiatedProtocol() string {
+	return c.ConnectionState().NegotiatedProtocol
+}
+
+// NextLayer returns the underlying Conn which carries the ciphertext.
+func (c *TLSConn) NextLayer() Conn {
+	return c.conn
+}
+
+// Read reads plaintext into b. It returns sonicerrors.ErrWouldBlock if no
+// plaintext is buffered and no complete record can be read without blocking.
+func (c *TLSConn) Read(b []byte) (int, error) {
+	if !c.HandshakeComplete() {
+		return 0, sonicerrors.ErrHandshakeIncomplete
+	}
+
+	if c.kernelRx {
+		return c.readKernel(b)
+	}
+
+	for {
+		n, err := c.readPlaintext(b)
+		if err != errTLSWouldBlock {
+			return n, err
+		}
+
+		c.transport.in.Reserve(tlsMaxRecordSize)
+		if _, err := c.transport.in.ReadFrom(c.conn); err != nil {
+			if err != sonicerrors.ErrWouldBlock {
+				c.transport.err = err
+				continue
+			}
+			return 0, err
+		}
+	}
+}
+
+// readPlaintext reads plaintext decrypted from the ciphertext buffered so far
+// and flushes whatever crypto/tls wrote while doing so, like KeyUpdate
+// responses and alerts.
+func (c *TLSConn) readPlaintext(b []byte) (int, error) {
+	if c.pending != nil && c.pending.ReadLen() > 0 {
+		return c.pending.Read(b)
+	}
+
+	n, err := c.tls.Read(b)
+	if !c.flushing && c.transport.out.WriteLen() > 0 {
+		c.flush()
+	}
+	if n > 0 && err == errTLSWouldBlock {
+		err = nil
+	}
+	return n, err
+}
+
+// readKernel reads plaintext from a socket with kernel TLS receive offload.
+// Records which are not application data are handled in place.
+func (c *TLSConn) readKernel(b []byte) (int, error) {
+	if c.pending.ReadLen() > 0 {
+		return c.pending.Read(b)
+	}
+
+	for {
+		n, err := c.conn.Read(b)
+		if !isKernelTLSRecordError(err) {
+			return n, err
+		}
+		if err := c.readKernelRecord(b); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// readKernelRecord reads and handles a record which is not application data
+// from a socket with kernel TLS receive offload.
+func (c *TLSConn) readKernelRecord(b []byte) error {
+	typ, n, err := recvKernelTLSRecord(c.conn.RawFd(), b, c.oob)
+	if err != nil {
+		return err
+	}
+
+	switch typ {
+	case tlsRecordTypeAlert:
+		if n >= 2 && b[1] == 0 /* close_notify */ {
+			return io.EOF
+		}
+		if n >= 2 {
+			return fmt.Errorf("tls: received alert %d", b[1])
+		}
+		return errors.New("tls: received a malformed alert")
+	case tlsRecordTypeHandshake:
+		// Session tickets are not used after the handshake.
+		if n > 0 && b[0] == tlsHandshakeNewSessionTicket {
+			return nil
+		}
+		return errors.New("tls: post-handshake message not supported with kernel TLS")
+	default:
+		return fmt.Errorf("tls: unexpected record type %d", typ)
+	}
+}
+
+// Write encrypts and writes b. It never blocks: the ciphertext which cannot be
+// written immediately is written by the IO once the connection becomes
+// writable. The returned count is the number of plaintext bytes consumed.
+func (c *TLSConn) Write(b []byte) (int, error) {
+	if !c.HandshakeComplete() {
+		return 0, sonicerrors.ErrHandshakeIncomplete
+	}
+	if c.kernelTx {
+		return c.conn.Write(b)
+	}
+
+	if err := c.flushErr; err != nil {
+		return 0, err
+	}
+
+	n, err := c.tls.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, c.writeBuffered()
+}
+
+// writeBuffered writes the buffered ciphertext without blocking. What the
+// connection cannot take now is flushed by the IO once it becomes writable; if
+// that fails, the error is returned by the next write.
+func (c *TLSConn) writeBuffered() error {
+	if c.flushing {
+		// The ciphertext is written after the pending flush.
+		return nil
+	}
+
+	out := c.transport.out
+	out.Commit(out.WriteLen())
+	if out.ReadLen() == 0 {
+		return nil
+	}
+	n, err := c.conn.Write(out.Data())
+	out.Consume(n)
+	if err != nil && err != sonicerrors.ErrWouldBlock {
+		return err
+	}
+	if out.ReadLen() > 0 {
+		c.flush()
+	}
+	return nil
+}
+
+func (c *TLSConn) AsyncRead(b []byte, cb AsyncCallback) {
+	c.asyncRead(b, false, cb)
+}
+
+func (c *TLSConn) AsyncReadAll(b []byte, cb AsyncCallback) {
+	c.asyncRead(b, true, cb)
+}
+
+func (c *TLSConn) asyncRead(b []byte, readAll bool, cb AsyncCallback) {
+	if !c.HandshakeComplete() {
+		cb(sonicerrors.ErrHandshakeIncomplete, 0)
+		return
+	}
+
+	c.readBuf = b
+	c.readAll = readAll
+	c.readSoFar = 0
+	c.readCb = cb
+
+	if c.ioc.Dispatched < MaxCallbackDispatch {
+		c.asyncReadNow()
+	} else if err := c.ioc.Post(c.dispatchReadN); err != nil {
+		c.completeRead(err)
+	}
+}
+
+func (c *TLSConn) asyncReadNow() {
+	if c.kernelRx {
+		c.asyncReadKernel()
+		return
+	}
+
+	for {
+		n, err := c.readPlaintext(c.readBuf[c.readSoFar:])
+		c.readSoFar += n
+
+		if err == errTLSWouldBlock {
+			c.transport.in.Reserve(tlsMaxRecordSize)
+			c.transport.in.AsyncReadFrom(c.conn, c.onRawReadFn)
+			return
+		}
+		if err != nil || !c.readAll || c.readSoFar == len(c.readBuf) {
+			c.completeRead(err)
+			return
+		}
+	}
+}
+
+func (c *TLSConn) asyncReadKernel() {
+	if c.pending.ReadLen() > 0 {
+		n, err := c.pending.Read(c.readBuf[c.readSoFar:])
+		c.readSoFar += n
+		if err != nil || !c.readAll || c.readSoFar == len(c.readBuf) {
+			c.completeRead(err)
+			return
+		}
+	}
+
+	if c.readAll {
+		c.conn.AsyncReadAll(c.readBuf[c.readSoFar:], c.onKernelReadFn)
+	} else {
+		c.conn.AsyncRead(c.readBuf[c.readSoFar:], c.onKernelReadFn)
+	}
+}
+
+func (c *TLSConn) onKernelRead(err error, n int) {
+	c.readSoFar += n
+	if isKernelTLSRecordError(err) {
+		if err = c.readKernelRecord(c.readBuf[c.readSoFar:]); err == nil {
+			c.asyncReadKernel()
+			return
+		}
+	}
+	c.completeRead(err)
+}
+
+func (c *TLSConn) onRawRead(err error, _ int) {
+	if err == sonicerrors.ErrCancelled {
+		c.completeRead(err)
+		return
+	}
+	if err != nil {
+		// Deliver the buffered plaintext before the error.
+		c.transport.err = err
+	}
+	c.asyncReadNow()
+}
+
+func (c *TLSConn) completeRead(err error) {
+	cb := c.readCb
+	c.readCb = nil
+	c.readBuf = nil
+
+	c.ioc.Dispatched++
+	cb(err, c.readSoFar)
+	c.ioc.Dispatched--
+}
+
+func (c *TLSConn) AsyncWrite(b []byte, cb AsyncCallback) {
+	c.asyncWrite(b, cb)
+}
+
+// AsyncWriteAll is the same as AsyncWrite: a write completes only once all
+// ciphertext has been written.
+func (c *TLSConn) AsyncWriteAll(b []byte, cb AsyncCallback) {
+	c.asyncWrite(b, cb)
+}
+
+func (c *TLSConn) asyncWrite(b []byte, cb AsyncCallback) {
+	if !c.HandshakeComplete() {
+		cb(sonicerrors.ErrHandshakeIncomplete, 0)
+		return
+	}
+
+	if c.kernelTx {
+		c.writeCb = cb
+		c.conn.AsyncWriteAll(b, c.onKernelWrFn)
+		return
+	}
+
+	if err := c.flushErr; err != nil {
+		cb(err, 0)
+		return
+	}
+
+	n, err := c.tls.Write(b)
+	if err != nil {
+		cb(err, n)
+		return
+	}
+
+	c.writeN = n
+	c.writeCb = cb
+	if !c.flushing {
+		c.flush()
+	}
+}
+
+// flush writes all buffered ciphertext. The pending write, if any, completes
+// once everything is written.
+func (c *TLSConn) flush() {
+	out := c.transport.out
+	out.Commit(out.WriteLen())
+	if out.ReadLen() == 0 {
+		c.onFlush(nil, 0)
+		return
+	}
+
+	c.flushing = true
+	c.conn.AsyncWriteAll(out.Data(), c.onFlushFn)
+}
+
+func (c *TLSConn) onFlush(err error, n int) {
+	c.flushing = false
+	c.transport.out.Consume(n)
+
+	if err == nil && c.transport.out.WriteLen() > 0 {
+		// More ciphertext was buffered while flushing.
+		c.flush()
+		return
+	}
+
+	if cb := c.writeCb; cb != nil {
+		c.writeCb = nil
+		if err != nil {
+			cb(err, 0)
+		} else {
+			cb(nil, c.writeN)
+		}
+	} else if err != nil {
+		// The flush started by Write failed.
+		c.flushErr = err
+	}
+	c.maybeShutdown()
+}
+
+func (c *TLSConn) onKernelWrite(err error, n int) {
+	cb := c.writeCb
+	c.writeCb = nil
+	cb(err, n)
+	c.maybeShutdown()
+}
+
+// CloseRead shuts down the reading side of the underlying connection.
+func (c *TLSConn) CloseRead() error {
+	hc, err := connHalfCloser(c.conn)
+	if err != nil {
+		return err
+	}
+	return hc.CloseRead()
+}
+
+// CloseWrite sends a close_notify alert and shuts down the writing side of the
+// underlying connection. It returns sonicerrors.ErrWouldBlock if a write is
+// pending or the alert cannot be written without blocking, in which case the
+// alert stays buffered and AsyncShutdown should be used.
+func (c *TLSConn) CloseWrite() error {
+	if !c.HandshakeComplete() {
+		return sonicerrors.ErrHandshakeIncomplete
+	}
+	hc, err := connHalfCloser(c.conn)
+	if err != nil {
+		return err
+	}
+	if c.writeCb != nil || c.flushing {
+		return sonicerrors.ErrWouldBlock
+	}
+
+	if err := c.closeNotify(); err != nil {
+		return err
+	}
+	if err := c.writeBuffered(); err != nil {
+		return err
+	}
+	if c.flushing {
+		return sonicerrors.ErrWouldBlock
+	}
+	return hc.CloseWrite()
+}
+
+// AsyncShutdown sends a close_notify alert once the pending write completes and
+// then shuts down the writing side of the underlying connection. Reads are
+// still possible until the peer closes its side, after which they return
+// io.EOF.
+func (c *TLSConn) AsyncShutdown(cb func(error)) {
+	if !c.HandshakeComplete() {
+		cb(sonicerrors.ErrHandshakeIncomplete)
+		return
+	}
+	if _, err := connHalfCloser(c.conn); err != nil {
+		cb(err)
+		return
+	}
+
+	c.shutdownCb = cb
+	c.maybeShutdown()
+}
+
+// maybeShutdown shuts down the writing side if AsyncShutdown was called and no
+// write is pending.
+func (c *TLSConn) maybeShutdown() {
+	if c.shutdownCb == nil || c.writeCb != nil || c.flushing {
+		return
+	}
+	cb := c.shutdownCb
+	c.shutdownCb = nil
+
+	if err := c.closeNotify(); err != nil {
+		cb(err)
+		return
+	}
+	if !c.kernelTx {
+		// The underlying connection waits for the alert to be flushed before
+		// shutting down.
+		c.flush()
+	}
+	c.conn.(HalfCloser).AsyncShutdown(cb)
+}
+
+// closeNotify sends a close_notify alert. Without kernel TLS the alert is
+// buffered in the transport until the next flush.
+func (c *TLSConn) closeNotify() error {
+	if c.kernelTx {
+		return sendKernelTLSAlert(c.conn.RawFd(), 1 /* warning */, 0 /* close_notify */)
+	}
+	return c.tls.CloseWrite()
+}
+
+// Cancel cancels the pending reads and writes. They complete with
+// sonicerrors.ErrCancelled.
+func (c *TLSConn) Cancel() {
+	c.conn.Cancel()
+}
+
+// Close sends a close_notify alert, if the handshake completed, and closes the
+// underlying connection. The alert is sent on a best-effort basis.
+func (c *TLSConn) Close() error {
+	if c.closed {
+		return io.EOF
+	}
+	c.closed = true
+
+	if c.HandshakeComplete() {
+		_ = c.closeNotify()
+		out := c.transport.out
+		out.Commit(out.WriteLen())
+		if !c.flushing && out.ReadLen() > 0 {
+			_, _ = c.conn.Write(out.Data())
+		}
+	}
+
+	// This completes the pending operations, including an ongoing handshake.
+	c.conn.Cancel()
+	return c.conn.Close()
+}
+
+// Closed returns true if Close has been called.
+func (c *TLSConn) Closed() bool {
+	return c.closed
+}
+
+func (c *TLSConn) RawFd() int {
+	return c.conn.RawFd()
+}
+
+func (c *TLSConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *TLSConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *TLSConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *TLSConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *TLSConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// TCPInfo returns the kernel's statistics of the underlying TCP connection.
+func (c *TLSConn) TCPInfo() (TCPInfo, error) {
+	return connTCPInfo(c.conn)
+}
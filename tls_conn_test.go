@@ -0,0 +1,531 @@
+package sonic
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+// newTestCertificate returns a self-signed certificate for localhost and a
+// pool which trusts it.
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func runUntil(t *testing.T, ioc *IO, done *bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !*done {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+}
+
+func TestTLSConnClient(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		ln, err := tls.Listen("tcp", "localhost:0", &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"sonic"},
+			MinVersion:   version,
+			MaxVersion:   version,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+
+		ioc := MustIO()
+
+		conn, err := DialTLS(ioc, "tcp", ln.Addr().String(), &tls.Config{
+			RootCAs:    pool,
+			NextProtos: []string{"sonic"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var (
+			done bool
+			b    = make([]byte, 5)
+		)
+		conn.AsyncHandshake(func(err error) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.AsyncWriteAll([]byte("hello"), func(err error, n int) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != 5 {
+					t.Fatalf("expected to write 5 bytes, wrote %d", n)
+				}
+				conn.AsyncReadAll(b, func(err error, n int) {
+					if err != nil {
+						t.Fatal(err)
+					}
+					done = true
+				})
+			})
+		})
+		runUntil(t, ioc, &done)
+
+		if string(b) != "hello" {
+			t.Fatalf("unexpected echo=%s", string(b))
+		}
+		state := conn.ConnectionState()
+		if state.Version != version {
+			t.Fatalf("expected version=%x got=%x", version, state.Version)
+		}
+		if state.NegotiatedProtocol != "sonic" {
+			t.Fatalf("unexpected ALPN=%s", state.NegotiatedProtocol)
+		}
+
+		conn.Close()
+		ioc.Close()
+		ln.Close()
+	}
+}
+
+func TestTLSConnBufferedPlaintext(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+
+	ln, err := tls.Listen("tcp", "localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// A single large write is sent as a few full records, each holding much
+	// more plaintext than a single client read. Nothing else is sent after
+	// it, so the file descriptor does not become readable again.
+	const size = 64 * 1024
+	release := make(chan struct{})
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write(make([]byte, size))
+		<-release
+	}()
+	defer close(release)
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := DialTLS(ioc, "tcp", ln.Addr().String(), &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var (
+		done  bool
+		total int
+		b     = make([]byte, 1024)
+	)
+	var onRead AsyncCallback
+	onRead = func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += n
+		if total == size {
+			done = true
+		} else {
+			conn.AsyncRead(b, onRead)
+		}
+	}
+	conn.AsyncHandshake(func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.AsyncRead(b, onRead)
+	})
+	runUntil(t, ioc, &done)
+}
+
+func TestTLSConnServer(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "tcp", "localhost:10230", sonicopts.Nonblocking(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	clientErr := make(chan error, 1)
+	go func() {
+		conn, err := tls.Dial("tcp", "localhost:10230", &tls.Config{
+			RootCAs:    pool,
+			ServerName: "localhost",
+		})
+		if err != nil {
+			clientErr <- err
+			return
+		}
+		defer conn.Close()
+
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			clientErr <- err
+			return
+		}
+		b := make([]byte, 5)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			clientErr <- err
+			return
+		}
+		if string(b) != "hello" {
+			clientErr <- io.ErrUnexpectedEOF
+			return
+		}
+		clientErr <- nil
+	}()
+
+	var (
+		done bool
+		b    = make([]byte, 5)
+	)
+	ln.AsyncAccept(func(err error, c Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := NewTLSServer(ioc, c, &tls.Config{Certificates: []tls.Certificate{cert}})
+		conn.AsyncHandshake(func(err error) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.AsyncReadAll(b, func(err error, _ int) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				conn.AsyncWriteAll(b, func(err error, _ int) {
+					if err != nil {
+						t.Fatal(err)
+					}
+					done = true
+				})
+			})
+		})
+	})
+	runUntil(t, ioc, &done)
+
+	if err := <-clientErr; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSConnHandshakeFailure(t *testing.T) {
+	cert, _ := newTestCertificate(t)
+
+	ln, err := tls.Listen("tcp", "localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.(*tls.Conn).Handshake()
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	// The certificate is not trusted.
+	conn, err := DialTLS(ioc, "tcp", ln.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var done bool
+	conn.AsyncHandshake(func(err error) {
+		if err == nil {
+			t.Fatal("expected the handshake to fail")
+		}
+		done = true
+	})
+	runUntil(t, ioc, &done)
+
+	if conn.HandshakeComplete() {
+		t.Fatal("handshake should not be complete")
+	}
+	conn.AsyncRead(make([]byte, 1), func(err error, _ int) {
+		if err == nil {
+			t.Fatal("expected read to fail")
+		}
+	})
+}
+
+func TestTLSConnAsyncShutdown(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+
+	ln, err := tls.Listen("tcp", "localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The server drains the client until it receives close_notify, replies and
+	// closes.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		_, _ = conn.Write([]byte(fmt.Sprint(len(b))))
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := DialTLS(ioc, "tcp", ln.Addr().String(), &tls.Config{
+		RootCAs: pool,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	payload := make([]byte, 1024*1024)
+
+	var (
+		wrote, done bool
+		readErr     error
+		reply       []byte
+		b           = make([]byte, 128)
+	)
+	var onRead AsyncCallback
+	onRead = func(err error, n int) {
+		reply = append(reply, b[:n]...)
+		if err != nil {
+			readErr = err
+			done = true
+			return
+		}
+		conn.AsyncRead(b, onRead)
+	}
+	conn.AsyncHandshake(func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.AsyncWriteAll(payload, func(err error, _ int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			wrote = true
+		})
+		conn.AsyncShutdown(func(err error) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !wrote {
+				t.Fatal("shut down before the write completed")
+			}
+			conn.AsyncRead(b, onRead)
+		})
+	})
+	runUntil(t, ioc, &done)
+
+	if readErr != io.EOF {
+		t.Fatalf("expected io.EOF got=%v", readErr)
+	}
+	if string(reply) != fmt.Sprint(len(payload)) {
+		t.Fatalf("unexpected reply=%s", string(reply))
+	}
+}
+
+func TestTLSConnWriteDoesNotBlock(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+
+	ln, err := tls.Listen("tcp", "localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The server reads nothing until start is closed, and then drains the
+	// client until it receives close_notify.
+	var (
+		start = make(chan struct{})
+		read  = make(chan int, 1)
+	)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if err := conn.(*tls.Conn).Handshake(); err != nil {
+			return
+		}
+		<-start
+		b, _ := io.ReadAll(conn)
+		read <- len(b)
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := DialTLS(ioc, "tcp", ln.Addr().String(), &tls.Config{
+		RootCAs: pool,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var done bool
+	conn.AsyncHandshake(func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		done = true
+	})
+	runUntil(t, ioc, &done)
+
+	// More than the socket buffers can hold.
+	payload := make([]byte, 16*1024*1024)
+	n, err := conn.Write(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(payload) {
+		t.Fatalf("expected to write %d bytes, wrote %d", len(payload), n)
+	}
+
+	if err := conn.CloseWrite(); err != sonicerrors.ErrWouldBlock {
+		t.Fatalf("expected ErrWouldBlock got=%v", err)
+	}
+
+	close(start)
+	done = false
+	conn.AsyncShutdown(func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		done = true
+	})
+	runUntil(t, ioc, &done)
+
+	select {
+	case n := <-read:
+		if n != len(payload) {
+			t.Fatalf("expected the server to read %d bytes, read %d", len(payload), n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+}
+
+func TestTLSConnAbandonedHandshake(t *testing.T) {
+	// The server never answers the handshake.
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	before := runtime.NumGoroutine()
+
+	func() {
+		ioc := MustIO()
+		defer ioc.Close()
+
+		conn, err := DialTLS(ioc, "tcp", ln.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.AsyncHandshake(func(error) {
+			t.Fatal("the handshake should not complete")
+		})
+		for i := 0; i < 10; i++ {
+			_ = ioc.RunOneFor(time.Millisecond)
+		}
+		if runtime.NumGoroutine() <= before {
+			t.Fatal("expected the handshake to run in a goroutine")
+		}
+	}()
+
+	// The TLSConn is dropped without Close.
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatal("the handshake's goroutine leaked")
+		}
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+}
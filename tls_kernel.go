@@ -0,0 +1,242 @@
+package sonic
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+)
+
+const (
+	tlsRecordHeaderLen = 5
+
+	tlsRecordTypeAlert           = 21
+	tlsRecordTypeHandshake       = 22
+	tlsRecordTypeApplicationData = 23
+
+	tlsHandshakeNewSessionTicket = 4
+)
+
+var errKernelTLSUnsupportedCipher = errors.New(
+	"kernel TLS supports only TLS 1.3 with AES-GCM")
+
+// kernelTLSKeys are the keys of one direction of a connection, in the form
+// the kernel expects them.
+type kernelTLSKeys struct {
+	cipherSuite uint16
+	key         []byte
+	iv          []byte // 12 bytes: the 4 byte salt followed by the 8 byte IV
+	seq         uint64 // the sequence number of the next record
+}
+
+// tlsTrafficKeys tracks the application traffic keys of one direction of a
+// TLS 1.3 connection and the number of records protected with them.
+//
+// crypto/tls does not expose its keys or sequence numbers. The keys are
+// derived from the traffic secrets which crypto/tls writes to the key log.
+// The sequence number is found by trying to open each record which goes
+// through the transport with the application keys: the ones which open are
+// protected by them. Records protected by the handshake keys do not open.
+type tlsTrafficKeys struct {
+	secret []byte
+	aead   cipher.AEAD
+	key    []byte
+	iv     [12]byte
+	seq    uint64
+
+	record  []byte // the record being received, if it is received piecewise
+	scratch []byte
+}
+
+func (k *tlsTrafficKeys) setSecret(secret []byte) error {
+	var (
+		h      func() hash.Hash
+		keyLen int
+	)
+	switch len(secret) {
+	case sha256.Size:
+		h, keyLen = sha256.New, 16
+	case sha512.Size384:
+		h, keyLen = sha512.New384, 32
+	default:
+		return errKernelTLSUnsupportedCipher
+	}
+
+	key, err := hkdfExpandLabel(h, secret, "key", keyLen)
+	if err != nil {
+		return err
+	}
+	iv, err := hkdfExpandLabel(h, secret, "iv", len(k.iv))
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	k.secret = secret
+	k.key = key
+	copy(k.iv[:], iv)
+	k.aead = aead
+	k.seq = 0
+	return nil
+}
+
+// hkdfExpandLabel implements HKDF-Expand-Label from RFC 8446 with an empty
+// context.
+func hkdfExpandLabel(
+	h func() hash.Hash,
+	secret []byte,
+	label string,
+	n int,
+) ([]byte, error) {
+	info := make([]byte, 0, 4+6+len(label))
+	info = binary.BigEndian.AppendUint16(info, uint16(n))
+	info = append(info, byte(6+len(label)))
+	info = append(info, "tls13 "...)
+	info = append(info, label...)
+	info = append(info, 0)
+	return hkdf.Expand(h, secret, string(info), n)
+}
+
+// track accounts for a complete record.
+func (k *tlsTrafficKeys) track(record []byte) {
+	if k.aead == nil ||
+		len(record) < tlsRecordHeaderLen+k.aead.Overhead() ||
+		record[0] != tlsRecordTypeApplicationData {
+		return
+	}
+
+	var nonce [12]byte
+	copy(nonce[:], k.iv[:])
+	for i := 0; i < 8; i++ {
+		nonce[4+i] ^= byte(k.seq >> (56 - 8*i))
+	}
+
+	_, err := k.aead.Open(
+		k.scratch[:0], nonce[:],
+		record[tlsRecordHeaderLen:], record[:tlsRecordHeaderLen])
+	if err == nil {
+		k.seq++
+	}
+}
+
+// trackReceived accounts for a piece of a received record. last is true if
+// the piece completes the record.
+func (k *tlsTrafficKeys) trackReceived(b []byte, first, last bool) {
+	if k.aead == nil {
+		return
+	}
+	if first && last {
+		k.track(b)
+		return
+	}
+	if first {
+		k.record = k.record[:0]
+	}
+	k.record = append(k.record, b...)
+	if last {
+		k.track(k.record)
+	}
+}
+
+func (k *tlsTrafficKeys) kernelKeys(cipherSuite uint16) *kernelTLSKeys {
+	return &kernelTLSKeys{
+		cipherSuite: cipherSuite,
+		key:         k.key,
+		iv:          k.iv[:],
+		seq:         k.seq,
+	}
+}
+
+// tlsKeyTracker is installed as the KeyLogWriter of connections which want to
+// offload TLS to the kernel.
+type tlsKeyTracker struct {
+	isClient bool
+	keyLog   io.Writer // the user's KeyLogWriter, if any
+
+	tx tlsTrafficKeys
+	rx tlsTrafficKeys
+	// The first error encountered while deriving the keys.
+	err error
+}
+
+var _ io.Writer = &tlsKeyTracker{}
+
+var (
+	keyLogClientTraffic = []byte("CLIENT_TRAFFIC_SECRET_0")
+	keyLogServerTraffic = []byte("SERVER_TRAFFIC_SECRET_0")
+)
+
+// Write parses one line of the NSS key log format:
+// <label> <client random> <secret>.
+func (t *tlsKeyTracker) Write(line []byte) (int, error) {
+	if t.keyLog != nil {
+		if _, err := t.keyLog.Write(line); err != nil {
+			return 0, err
+		}
+	}
+
+	fields := bytes.Fields(line)
+	if len(fields) != 3 {
+		return len(line), nil
+	}
+
+	var keys *tlsTrafficKeys
+	switch {
+	case bytes.Equal(fields[0], keyLogClientTraffic):
+		keys = &t.rx
+		if t.isClient {
+			keys = &t.tx
+		}
+	case bytes.Equal(fields[0], keyLogServerTraffic):
+		keys = &t.tx
+		if t.isClient {
+			keys = &t.rx
+		}
+	default:
+		return len(line), nil
+	}
+
+	secret := make([]byte, hex.DecodedLen(len(fields[2])))
+	if _, err := hex.Decode(secret, fields[2]); err != nil {
+		t.err = err
+	} else if err := keys.setSecret(secret); err != nil && t.err == nil {
+		t.err = err
+	}
+	return len(line), nil
+}
+
+// kernelKeys returns the keys to install in the kernel for both directions.
+func (t *tlsKeyTracker) kernelKeys(
+	state tls.ConnectionState,
+) (tx, rx *kernelTLSKeys, err error) {
+	if t.err != nil {
+		return nil, nil, t.err
+	}
+	if state.Version != tls.VersionTLS13 {
+		return nil, nil, errKernelTLSUnsupportedCipher
+	}
+	switch state.CipherSuite {
+	case tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384:
+	default:
+		return nil, nil, errKernelTLSUnsupportedCipher
+	}
+	if t.tx.aead == nil || t.rx.aead == nil {
+		return nil, nil, errKernelTLSUnsupportedCipher
+	}
+	return t.tx.kernelKeys(state.CipherSuite), t.rx.kernelKeys(state.CipherSuite), nil
+}
@@ -0,0 +1,28 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package sonic
+
+import (
+	"fmt"
+)
+
+var errKernelTLSNotSupported = fmt.Errorf(
+	"kernel TLS is not yet supported on BSD")
+
+func enableKernelTLS(fd int, txKeys, rxKeys *kernelTLSKeys) (tx, rx bool, err error) {
+	return false, false, errKernelTLSNotSupported
+}
+
+func isKernelTLSRecordError(err error) bool {
+	return false
+}
+
+func recvKernelTLSRecord(fd int, b, oob []byte) (typ byte, n int, err error) {
+	return 0, 0, errKernelTLSNotSupported
+}
+
+var kernelTLSRecordOOBSpace = 0
+
+func sendKernelTLSAlert(fd int, level, description byte) error {
+	return errKernelTLSNotSupported
+}
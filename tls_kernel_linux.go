@@ -0,0 +1,116 @@
+//go:build linux
+
+package sonic
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Defined in include/uapi/linux/tls.h
+const (
+	kernelTLSTx = 1
+	kernelTLSRx = 2
+
+	kernelTLSSetRecordType = 1
+	kernelTLSGetRecordType = 2
+
+	kernelTLSVersion13       = 0x0304
+	kernelTLSCipherAESGCM128 = 51
+	kernelTLSCipherAESGCM256 = 52
+)
+
+// enableKernelTLS attaches the TLS upper layer protocol to the socket and
+// installs the keys of each direction. The transmit direction is installed
+// first. The returned booleans report which directions are offloaded, even if
+// an error occurred.
+func enableKernelTLS(fd int, txKeys, rxKeys *kernelTLSKeys) (tx, rx bool, err error) {
+	if err := unix.SetsockoptString(fd, unix.SOL_TCP, unix.TCP_ULP, "tls"); err != nil {
+		return false, false, os.NewSyscallError("setsockopt(TCP_ULP)", err)
+	}
+	if err := setKernelTLSKeys(fd, kernelTLSTx, txKeys); err != nil {
+		return false, false, os.NewSyscallError("setsockopt(TLS_TX)", err)
+	}
+	if err := setKernelTLSKeys(fd, kernelTLSRx, rxKeys); err != nil {
+		return true, false, os.NewSyscallError("setsockopt(TLS_RX)", err)
+	}
+	return true, true, nil
+}
+
+// setKernelTLSKeys installs keys in the given direction. The layout is the
+// one of struct tls12_crypto_info_aes_gcm_{128,256}: the generic crypto info
+// (version and cipher), the IV, the key, the salt and the record sequence
+// number.
+func setKernelTLSKeys(fd int, direction int, keys *kernelTLSKeys) error {
+	var cipherType uint16
+	switch keys.cipherSuite {
+	case tls.TLS_AES_128_GCM_SHA256:
+		cipherType = kernelTLSCipherAESGCM128
+	case tls.TLS_AES_256_GCM_SHA384:
+		cipherType = kernelTLSCipherAESGCM256
+	default:
+		return errKernelTLSUnsupportedCipher
+	}
+
+	b := make([]byte, 0, 4+8+len(keys.key)+4+8)
+	b = binary.NativeEndian.AppendUint16(b, kernelTLSVersion13)
+	b = binary.NativeEndian.AppendUint16(b, cipherType)
+	b = append(b, keys.iv[4:12]...)
+	b = append(b, keys.key...)
+	b = append(b, keys.iv[:4]...)
+	b = binary.BigEndian.AppendUint64(b, keys.seq)
+
+	return unix.SetsockoptString(fd, unix.SOL_TLS, direction, string(b))
+}
+
+// isKernelTLSRecordError returns true if a read failed because the next
+// record is not application data. Such records must be read with
+// recvKernelTLSRecord.
+func isKernelTLSRecordError(err error) bool {
+	return err == syscall.EIO
+}
+
+// recvKernelTLSRecord reads the next record from a socket with kernel TLS
+// receive offload and returns its type.
+func recvKernelTLSRecord(fd int, b, oob []byte) (typ byte, n int, err error) {
+	n, oobn, _, _, err := unix.Recvmsg(fd, b, oob, 0)
+	if err != nil {
+		return 0, 0, os.NewSyscallError("recvmsg", err)
+	}
+
+	typ = tlsRecordTypeApplicationData
+	cmsgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return 0, n, err
+	}
+	for _, cmsg := range cmsgs {
+		if cmsg.Header.Level == unix.SOL_TLS &&
+			cmsg.Header.Type == kernelTLSGetRecordType &&
+			len(cmsg.Data) > 0 {
+			typ = cmsg.Data[0]
+		}
+	}
+	return typ, n, nil
+}
+
+// kernelTLSRecordOOBSpace is the size of the out-of-band buffer needed by
+// recvKernelTLSRecord.
+var kernelTLSRecordOOBSpace = unix.CmsgSpace(1)
+
+// sendKernelTLSAlert sends an alert on a socket with kernel TLS transmit
+// offload.
+func sendKernelTLSAlert(fd int, level, description byte) error {
+	oob, data := appendControlMessage(
+		nil, unix.SOL_TLS, kernelTLSSetRecordType, 1)
+	data[0] = tlsRecordTypeAlert
+
+	if err := unix.Sendmsg(fd, []byte{level, description}, oob, nil, 0); err != nil {
+		return fmt.Errorf("could not send alert: %w", os.NewSyscallError("sendmsg", err))
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+//go:build linux
+
+package sonic
+
+import (
+	"crypto/tls"
+	"io"
+	"testing"
+)
+
+// The tests below pass whether or not the kernel supports TLS: connections
+// fall back to user space TLS if it does not.
+
+func TestTLSConnKernelTLSClient(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+
+	ln, err := tls.Listen("tcp", "localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The server sends a session ticket after the handshake, which a client
+	// with kernel TLS receive offload must skip.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := DialTLS(ioc, "tcp", ln.Addr().String(), &tls.Config{
+		RootCAs:            pool,
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetKernelTLS(true)
+
+	echoKernelTLS(t, ioc, conn)
+}
+
+func TestTLSConnKernelTLSServer(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := ListenTLS(ioc, "tcp", "localhost:10232", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ln.SetKernelTLS(true)
+
+	// The server sends a session ticket after the handshake, which shifts the
+	// sequence number of the records it sends.
+	go func() {
+		conn, err := tls.Dial("tcp", "localhost:10232", &tls.Config{
+			RootCAs:            pool,
+			ServerName:         "localhost",
+			ClientSessionCache: tls.NewLRUClientSessionCache(1),
+		})
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	var (
+		conn *TLSConn
+		done bool
+	)
+	ln.AsyncAccept(func(err error, c Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn = c.(*TLSConn)
+		done = true
+	})
+	runUntil(t, ioc, &done)
+	defer conn.Close()
+
+	echoKernelTLS(t, ioc, conn)
+}
+
+// echoKernelTLS writes to a peer which echoes back and checks that the echo
+// is received, whether or not the connection is offloaded to the kernel.
+func echoKernelTLS(t *testing.T, ioc *IO, conn *TLSConn) {
+	var (
+		done bool
+		b    = make([]byte, 5)
+	)
+	echo := func() {
+		conn.AsyncWriteAll([]byte("hello"), func(err error, _ int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.AsyncReadAll(b, func(err error, _ int) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				done = true
+			})
+		})
+	}
+	if conn.HandshakeComplete() {
+		echo()
+	} else {
+		conn.AsyncHandshake(func(err error) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			echo()
+		})
+	}
+	runUntil(t, ioc, &done)
+
+	if string(b) != "hello" {
+		t.Fatalf("unexpected echo=%s", string(b))
+	}
+
+	tx, rx := conn.KernelTLS()
+	if err := conn.KernelTLSErr(); err != nil {
+		t.Logf("fell back to user space TLS tx=%v rx=%v err=%v", tx, rx, err)
+	} else if !tx || !rx {
+		t.Fatal("expected both directions to be offloaded")
+	}
+}
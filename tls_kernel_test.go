@@ -0,0 +1,74 @@
+package sonic
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+func TestTLSTrafficKeysDerivation(t *testing.T) {
+	// RFC 8448, section 3: the client's application traffic keys.
+	secret, _ := hex.DecodeString(
+		"9e40646ce79a7f9dc05af8889bce6552875afa0b06df0087f792ebb7c17504a5")
+	expectedKey, _ := hex.DecodeString("17422dda596ed5d9acd890e3c63f5051")
+	expectedIV, _ := hex.DecodeString("5b78923dee08579033e523d9")
+
+	var keys tlsTrafficKeys
+	if err := keys.setSecret(secret); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(keys.key, expectedKey) {
+		t.Fatalf("unexpected key=%x", keys.key)
+	}
+	if !bytes.Equal(keys.iv[:], expectedIV) {
+		t.Fatalf("unexpected iv=%x", keys.iv)
+	}
+}
+
+func TestTLSTrafficKeysTracking(t *testing.T) {
+	var (
+		keys  tlsTrafficKeys
+		other tlsTrafficKeys
+	)
+	if err := keys.setSecret(bytes.Repeat([]byte{1}, sha256.Size)); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.setSecret(bytes.Repeat([]byte{2}, sha256.Size)); err != nil {
+		t.Fatal(err)
+	}
+
+	seal := func(k *tlsTrafficKeys, seq uint64, payload []byte) []byte {
+		var nonce [12]byte
+		copy(nonce[:], k.iv[:])
+		for i := 0; i < 8; i++ {
+			nonce[4+i] ^= byte(seq >> (56 - 8*i))
+		}
+		hdr := []byte{tlsRecordTypeApplicationData, 3, 3, 0, 0}
+		binary.BigEndian.PutUint16(hdr[3:], uint16(len(payload)+k.aead.Overhead()))
+		return k.aead.Seal(hdr, nonce[:], payload, hdr)
+	}
+
+	// A record protected by other keys, like the handshake keys, is not
+	// counted.
+	keys.track(seal(&other, 0, []byte("finished")))
+	if keys.seq != 0 {
+		t.Fatalf("expected seq=0 got=%d", keys.seq)
+	}
+
+	keys.track(seal(&keys, 0, []byte("ticket")))
+	keys.track(seal(&keys, 1, []byte("ticket")))
+	if keys.seq != 2 {
+		t.Fatalf("expected seq=2 got=%d", keys.seq)
+	}
+
+	// A record received piecewise.
+	record := seal(&keys, 2, []byte("hello, sonic!"))
+	keys.trackReceived(record[:5], true, false)
+	keys.trackReceived(record[5:9], false, false)
+	keys.trackReceived(record[9:], false, true)
+	if keys.seq != 3 {
+		t.Fatalf("expected seq=3 got=%d", keys.seq)
+	}
+}
@@ -0,0 +1,277 @@
+package sonic
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+var _ Listener = &TLSListener{}
+
+// DefaultTLSHandshakeTimeout is the time a client has to complete the TLS
+// handshake on a TLSListener, if no other timeout is set.
+const DefaultTLSHandshakeTimeout = 10 * time.Second
+
+// DefaultTLSMaxPending is the number of clients a TLSListener handshakes with
+// or holds for AsyncAccept at any time, if no other limit is set.
+const DefaultTLSMaxPending = 128
+
+// TLSListener is a Listener whose connections are TLSConns in the server role.
+//
+// AsyncAccept completes only once the TLS handshake of a client has completed.
+// Once AsyncAccept is first called, clients are accepted and their handshakes
+// run concurrently, driven by the IO, regardless of the pending AsyncAccept
+// calls, so a slow client does not delay the others. Clients which complete the
+// handshake are queued until AsyncAccept is called. A client which does not
+// complete the handshake within the handshake timeout is disconnected.
+type TLSListener struct {
+	ioc    *IO
+	ln     Listener
+	config *tls.Config
+
+	handshakeTimeout time.Duration
+	maxPending       int
+	kernelTLS        bool
+	onHandshakeError func(err error, remoteAddr net.Addr)
+
+	// Callbacks of AsyncAccept calls waiting for a handshake to complete, in
+	// order.
+	waiters     []AcceptCallback
+	handshaking map[*TLSConn]*Timer
+	// Clients which completed the handshake, in order.
+	ready     []*TLSConn
+	accepting bool
+	closed    bool
+
+	onAcceptFn AcceptCallback
+	deliverFn  func()
+}
+
+// ListenTLS creates a TLSListener that listens for new TLS connections on the
+// local address. The listening socket is always non-blocking.
+func ListenTLS(
+	ioc *IO,
+	network, addr string,
+	config *tls.Config,
+	opts ...sonicopts.Option,
+) (*TLSListener, error) {
+	opts = append(opts, sonicopts.Nonblocking(true))
+	ln, err := Listen(ioc, network, addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &TLSListener{
+		ioc:              ioc,
+		ln:               ln,
+		config:           config,
+		handshakeTimeout: DefaultTLSHandshakeTimeout,
+		maxPending:       DefaultTLSMaxPending,
+		handshaking:      make(map[*TLSConn]*Timer),
+	}
+	l.onAcceptFn = l.onAccept
+	l.deliverFn = l.deliver
+	return l, nil
+}
+
+// SetHandshakeTimeout sets the time a client has to complete the handshake.
+// A timeout of 0 disables it.
+func (l *TLSListener) SetHandshakeTimeout(timeout time.Duration) {
+	l.handshakeTimeout = timeout
+}
+
+// SetMaxPending sets the number of clients which can be in the handshake or
+// waiting for AsyncAccept at any time. No more clients are accepted until one
+// of them fails its handshake or is returned by AsyncAccept.
+func (l *TLSListener) SetMaxPending(n int) {
+	l.maxPending = n
+}
+
+// SetKernelTLS makes the accepted connections try to offload TLS to the
+// kernel once their handshake completes. See TLSConn.SetKernelTLS.
+func (l *TLSListener) SetKernelTLS(on bool) {
+	l.kernelTLS = on
+}
+
+// SetOnHandshakeError sets a hook invoked each time a client fails to
+// complete the handshake. The client is disconnected afterwards. A client
+// which times out fails with sonicerrors.ErrTimeout.
+func (l *TLSListener) SetOnHandshakeError(fn func(err error, remoteAddr net.Addr)) {
+	l.onHandshakeError = fn
+}
+
+// Accept accepts the next connection without performing the TLS handshake. It
+// returns sonicerrors.ErrWouldBlock if there is no connection to accept. The
+// caller must call AsyncHandshake on the returned *TLSConn.
+func (l *TLSListener) Accept() (Conn, error) {
+	conn, err := l.ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.newConn(conn), nil
+}
+
+func (l *TLSListener) newConn(conn Conn) *TLSConn {
+	c := NewTLSServer(l.ioc, conn, l.config)
+	c.SetKernelTLS(l.kernelTLS)
+	return c
+}
+
+// AsyncAccept invokes the callback with the next client which completed the
+// TLS handshake. The returned Conn is a *TLSConn.
+func (l *TLSListener) AsyncAccept(cb AcceptCallback) {
+	if l.closed {
+		cb(sonicerrors.ErrCancelled, nil)
+		return
+	}
+
+	l.waiters = append(l.waiters, cb)
+	if len(l.ready) > 0 && l.ioc.Dispatched >= MaxCallbackDispatch {
+		if err := l.ioc.Post(l.deliverFn); err == nil {
+			return
+		}
+	}
+	l.deliver()
+}
+
+// deliver hands the clients which completed the handshake to the AsyncAccept
+// calls waiting for them, in order, and then accepts more clients if there is
+// room for them.
+func (l *TLSListener) deliver() {
+	for len(l.ready) > 0 && len(l.waiters) > 0 && !l.closed {
+		conn := l.ready[0]
+		l.ready[0] = nil
+		l.ready = l.ready[1:]
+		cb := l.waiters[0]
+		l.waiters = l.waiters[1:]
+
+		l.ioc.Dispatched++
+		cb(nil, conn)
+		l.ioc.Dispatched--
+	}
+	l.acceptMore()
+}
+
+// acceptMore accepts clients as long as fewer than the maximum are in the
+// handshake or waiting for AsyncAccept.
+func (l *TLSListener) acceptMore() {
+	if !l.accepting && !l.closed &&
+		len(l.handshaking)+len(l.ready) < l.maxPending {
+		l.accepting = true
+		l.ln.AsyncAccept(l.onAcceptFn)
+	}
+}
+
+func (l *TLSListener) onAccept(err error, conn Conn) {
+	l.accepting = false
+
+	if err != nil {
+		if !l.closed && len(l.waiters) > 0 {
+			cb := l.waiters[0]
+			l.waiters = l.waiters[1:]
+			cb(err, nil)
+		}
+		return
+	}
+	if l.closed {
+		_ = conn.Close()
+		return
+	}
+
+	l.handshake(l.newConn(conn))
+	l.acceptMore()
+}
+
+func (l *TLSListener) handshake(conn *TLSConn) {
+	var (
+		timer    *Timer
+		timedOut bool
+	)
+	if l.handshakeTimeout > 0 {
+		var err error
+		timer, err = NewTimer(l.ioc)
+		if err != nil {
+			l.handshakeFailed(conn, err)
+			return
+		}
+		err = timer.ScheduleOnce(l.handshakeTimeout, func() {
+			timedOut = true
+			_ = conn.Close()
+		})
+		if err != nil {
+			_ = timer.Close()
+			l.handshakeFailed(conn, err)
+			return
+		}
+	}
+	l.handshaking[conn] = timer
+
+	conn.AsyncHandshake(func(err error) {
+		delete(l.handshaking, conn)
+		if timer != nil {
+			_ = timer.Close()
+		}
+
+		if timedOut {
+			err = sonicerrors.ErrTimeout
+		}
+		if err != nil {
+			l.handshakeFailed(conn, err)
+			l.acceptMore()
+			return
+		}
+
+		if l.closed {
+			_ = conn.Close()
+			return
+		}
+		l.ready = append(l.ready, conn)
+		l.deliver()
+	})
+}
+
+func (l *TLSListener) handshakeFailed(conn *TLSConn, err error) {
+	remoteAddr := conn.RemoteAddr()
+	_ = conn.Close()
+	if l.onHandshakeError != nil && !l.closed {
+		l.onHandshakeError(err, remoteAddr)
+	}
+}
+
+// Close closes the listener and disconnects the clients which were not
+// returned by AsyncAccept yet. Pending AsyncAccept calls fail with
+// sonicerrors.ErrCancelled.
+func (l *TLSListener) Close() error {
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+
+	for conn := range l.handshaking {
+		_ = conn.Close()
+	}
+	for _, conn := range l.ready {
+		_ = conn.Close()
+	}
+	l.ready = nil
+
+	waiters := l.waiters
+	l.waiters = nil
+	for _, cb := range waiters {
+		cb(sonicerrors.ErrCancelled, nil)
+	}
+
+	return l.ln.Close()
+}
+
+// Addr returns the listener's network address.
+func (l *TLSListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+func (l *TLSListener) RawFd() int {
+	return l.ln.RawFd()
+}
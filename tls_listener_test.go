@@ -0,0 +1,199 @@
+package sonic
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+func TestTLSListener(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := ListenTLS(ioc, "tcp", "localhost:10231", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"sonic"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var handshakeErrs []error
+	ln.SetHandshakeTimeout(50 * time.Millisecond)
+	ln.SetOnHandshakeError(func(err error, _ net.Addr) {
+		handshakeErrs = append(handshakeErrs, err)
+	})
+
+	// The first client connects but never starts the handshake. It must not
+	// prevent the second client from being accepted.
+	idle, err := net.Dial("tcp", "localhost:10231")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	clientErr := make(chan error, 1)
+	go func() {
+		conn, err := tls.Dial("tcp", "localhost:10231", &tls.Config{
+			RootCAs:    pool,
+			ServerName: "localhost",
+			NextProtos: []string{"sonic"},
+		})
+		if err != nil {
+			clientErr <- err
+			return
+		}
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("hello"))
+		clientErr <- err
+	}()
+
+	var (
+		accepted *TLSConn
+		b        = make([]byte, 5)
+		done     bool
+	)
+	ln.AsyncAccept(func(err error, conn Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted = conn.(*TLSConn)
+		accepted.AsyncReadAll(b, func(err error, _ int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			done = true
+		})
+	})
+	ln.AsyncAccept(func(err error, _ Conn) {
+		if err != sonicerrors.ErrCancelled {
+			t.Fatalf("expected ErrCancelled after close, got %v", err)
+		}
+	})
+	runUntil(t, ioc, &done)
+	defer accepted.Close()
+
+	if err := <-clientErr; err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("unexpected message=%s", string(b))
+	}
+	if accepted.ServerName() != "localhost" {
+		t.Fatalf("unexpected SNI=%s", accepted.ServerName())
+	}
+	if accepted.NegotiatedProtocol() != "sonic" {
+		t.Fatalf("unexpected ALPN=%s", accepted.NegotiatedProtocol())
+	}
+
+	// The idle client times out.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(handshakeErrs) == 0 && time.Now().Before(deadline) {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if len(handshakeErrs) != 1 || handshakeErrs[0] != sonicerrors.ErrTimeout {
+		t.Fatalf("expected a single handshake timeout, got %v", handshakeErrs)
+	}
+	if _, err := idle.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the idle client to be disconnected, got %v", err)
+	}
+}
+
+func TestTLSListenerStalledClient(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := ListenTLS(ioc, "tcp", "localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	sockAddr, err := internal.SocketAddress(ln.RawFd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := sockAddr.String()
+
+	// The first client never starts the handshake, and does not time out
+	// during the test.
+	stalled, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+
+	dial := func() <-chan error {
+		clientErr := make(chan error, 1)
+		go func() {
+			conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool})
+			if err != nil {
+				clientErr <- err
+				return
+			}
+			defer conn.Close()
+			// Wait for the server to close the connection.
+			_, err = conn.Read(make([]byte, 1))
+			if err == io.EOF {
+				err = nil
+			}
+			clientErr <- err
+		}()
+		return clientErr
+	}
+
+	// A single AsyncAccept returns the second client.
+	var accepted Conn
+	dial()
+	ln.AsyncAccept(func(err error, conn Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted = conn
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for accepted == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the stalled client blocked the others")
+		}
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	_ = accepted.Close()
+
+	// A client which completes the handshake while no AsyncAccept is pending
+	// is queued.
+	clientErr := dial()
+	for len(ln.ready) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the third client was not accepted")
+		}
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	accepted = nil
+	ln.AsyncAccept(func(err error, conn Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted = conn
+	})
+	if accepted == nil {
+		t.Fatal("expected the queued client to be returned immediately")
+	}
+	_ = accepted.Close()
+
+	if err := <-clientErr; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,572 @@
+package sonic
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+var _ PacketConn = &UDPPeerConn{}
+
+const (
+	// DefaultUDPBacklog is the number of peers a UDPListener holds until they
+	// are accepted, if no other size is set.
+	DefaultUDPBacklog = 128
+
+	// DefaultUDPPeerQueueSize is the number of datagrams a UDPPeerConn holds
+	// until they are read, if no other size is set.
+	DefaultUDPPeerQueueSize = 64
+
+	maxUDPDatagramSize = 65535
+)
+
+type udpWrite struct {
+	b  []byte
+	to syscall.Sockaddr
+	cb AsyncWriteCallbackPacket
+}
+
+// UDPListener makes a UDP socket connection-oriented: it demultiplexes the
+// datagrams it receives by source address and AsyncAccept returns a
+// UDPPeerConn for each new peer, through which the datagrams of that peer are
+// read and to which replies are written.
+//
+// All peers share the listener's socket. The listener reads from it as long as
+// it is open, so the IO must run for peers to receive anything.
+//
+// Datagrams from new peers are dropped while the backlog of peers waiting to
+// be accepted is full. Datagrams of a peer are dropped while its queue is full.
+type UDPListener struct {
+	ioc  *IO
+	slot internal.Slot
+	addr net.Addr
+
+	peers    map[netip.AddrPort]*UDPPeerConn
+	backlog  []*UDPPeerConn
+	acceptCb AcceptPacketCallback
+
+	maxBacklog  int
+	queueSize   int
+	idleTimeout time.Duration
+	idleTimer   *Timer
+
+	readBuf []byte
+	writes  []udpWrite
+	closed  bool
+
+	dispatchAcceptFn func()
+}
+
+// ListenUDP creates a UDPListener bound to the local address.
+func ListenUDP(
+	ioc *IO,
+	network, addr string,
+	opts ...sonicopts.Option,
+) (*UDPListener, error) {
+	fd, localAddr, err := internal.ListenUDP(network, addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+	if sa, err := syscall.Getsockname(fd); err == nil {
+		localAddr = internal.FromSockaddrUDP(sa, &net.UDPAddr{})
+	}
+
+	l := &UDPListener{
+		ioc:        ioc,
+		slot:       internal.Slot{Fd: fd},
+		addr:       localAddr,
+		peers:      make(map[netip.AddrPort]*UDPPeerConn),
+		maxBacklog: DefaultUDPBacklog,
+		queueSize:  DefaultUDPPeerQueueSize,
+		readBuf:    make([]byte, maxUDPDatagramSize),
+	}
+	l.dispatchAcceptFn = l.dispatchAccept
+	l.slot.Set(internal.ReadEvent, l.onReadable)
+	l.slot.Set(internal.WriteEvent, l.onWritable)
+
+	if err := l.scheduleRead(); err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+	return l, nil
+}
+
+// SetBacklog sets the number of new peers held until they are accepted.
+func (l *UDPListener) SetBacklog(n int) {
+	l.maxBacklog = n
+}
+
+// SetPeerQueueSize sets the number of datagrams each peer holds until they are
+// read. It applies to the peers seen afterwards.
+func (l *UDPListener) SetPeerQueueSize(n int) {
+	l.queueSize = n
+}
+
+// SetIdleTimeout sets the time after which a peer from which nothing is
+// received is closed: its pending read fails with sonicerrors.ErrTimeout. Idle
+// peers are looked for every quarter of the timeout, so a peer may be closed
+// up to that much later. A timeout of 0, the default, disables it.
+func (l *UDPListener) SetIdleTimeout(timeout time.Duration) error {
+	l.idleTimeout = timeout
+	if l.idleTimer == nil {
+		if timeout <= 0 {
+			return nil
+		}
+		timer, err := NewTimer(l.ioc)
+		if err != nil {
+			return err
+		}
+		l.idleTimer = timer
+	}
+
+	_ = l.idleTimer.Cancel()
+	if timeout <= 0 {
+		return nil
+	}
+	return l.idleTimer.ScheduleRepeating(timeout/4, l.closeIdle)
+}
+
+func (l *UDPListener) closeIdle() {
+	now := time.Now()
+	for _, p := range l.peers {
+		if now.Sub(p.lastActivity) >= l.idleTimeout {
+			p.closeWith(sonicerrors.ErrTimeout)
+		}
+	}
+}
+
+// Accept returns the next new peer. It returns sonicerrors.ErrWouldBlock if
+// there is none.
+func (l *UDPListener) Accept() (PacketConn, error) {
+	if l.closed {
+		return nil, io.EOF
+	}
+	if len(l.backlog) == 0 {
+		return nil, sonicerrors.ErrWouldBlock
+	}
+	return l.popBacklog(), nil
+}
+
+// AsyncAccept invokes the callback with the next new peer, once its first
+// datagram is received. The returned PacketConn is a *UDPPeerConn. Only one
+// AsyncAccept can be pending at any time.
+func (l *UDPListener) AsyncAccept(cb AcceptPacketCallback) {
+	if l.closed {
+		cb(sonicerrors.ErrCancelled, nil)
+		return
+	}
+
+	l.acceptCb = cb
+	if len(l.backlog) > 0 {
+		if l.ioc.Dispatched < MaxCallbackDispatch {
+			l.dispatchAccept()
+		} else if err := l.ioc.Post(l.dispatchAcceptFn); err != nil {
+			l.acceptCb = nil
+			cb(err, nil)
+		}
+	}
+}
+
+func (l *UDPListener) dispatchAccept() {
+	cb := l.acceptCb
+	if cb == nil || len(l.backlog) == 0 {
+		return
+	}
+	l.acceptCb = nil
+
+	l.ioc.Dispatched++
+	cb(nil, l.popBacklog())
+	l.ioc.Dispatched--
+}
+
+func (l *UDPListener) popBacklog() *UDPPeerConn {
+	p := l.backlog[0]
+	l.backlog[0] = nil
+	l.backlog = l.backlog[1:]
+	return p
+}
+
+func (l *UDPListener) scheduleRead() error {
+	if err := l.ioc.SetRead(&l.slot); err != nil {
+		return err
+	}
+	l.ioc.Register(&l.slot)
+	return nil
+}
+
+func (l *UDPListener) scheduleWrite() error {
+	if err := l.ioc.SetWrite(&l.slot); err != nil {
+		return err
+	}
+	l.ioc.Register(&l.slot)
+	return nil
+}
+
+// deregister releases the slot once neither reads nor writes are scheduled.
+func (l *UDPListener) deregister() {
+	if l.slot.Events == 0 {
+		l.ioc.Deregister(&l.slot)
+	}
+}
+
+// onReadable reads and demultiplexes datagrams until the socket would block.
+func (l *UDPListener) onReadable(err error) {
+	l.deregister()
+	if l.closed {
+		return
+	}
+	if err != nil {
+		// Reads on the shared socket are not cancelled by the peers.
+		_ = l.scheduleRead()
+		return
+	}
+
+	for !l.closed {
+		n, from, err := syscall.Recvfrom(l.slot.Fd, l.readBuf, 0)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK {
+				break
+			}
+			if err == syscall.EINTR || err == syscall.ECONNREFUSED {
+				continue
+			}
+			break
+		}
+		l.demux(from, l.readBuf[:n])
+	}
+
+	if !l.closed {
+		_ = l.scheduleRead()
+	}
+}
+
+func (l *UDPListener) demux(from syscall.Sockaddr, b []byte) {
+	var key netip.AddrPort
+	switch sa := from.(type) {
+	case *syscall.SockaddrInet4:
+		key = netip.AddrPortFrom(netip.AddrFrom4(sa.Addr), uint16(sa.Port))
+	case *syscall.SockaddrInet6:
+		key = netip.AddrPortFrom(netip.AddrFrom16(sa.Addr), uint16(sa.Port))
+	default:
+		return
+	}
+
+	if p, ok := l.peers[key]; ok {
+		p.deliver(b)
+		return
+	}
+	if len(l.backlog) >= l.maxBacklog {
+		return
+	}
+
+	p := newUDPPeerConn(l, key, from)
+	l.peers[key] = p
+	l.backlog = append(l.backlog, p)
+	p.deliver(b)
+	l.dispatchAccept()
+}
+
+func (l *UDPListener) writeTo(b []byte, to syscall.Sockaddr) error {
+	err := syscall.Sendto(l.slot.Fd, b, 0, to)
+	if err == syscall.EWOULDBLOCK || err == syscall.EAGAIN {
+		return sonicerrors.ErrWouldBlock
+	}
+	return err
+}
+
+// asyncWriteTo writes the datagrams of all peers in order. If the socket would
+// block, they are queued until it becomes writable.
+func (l *UDPListener) asyncWriteTo(b []byte, to syscall.Sockaddr, cb AsyncWriteCallbackPacket) {
+	if l.closed {
+		cb(io.EOF)
+		return
+	}
+
+	if len(l.writes) == 0 {
+		if err := l.writeTo(b, to); err != sonicerrors.ErrWouldBlock {
+			l.ioc.Dispatched++
+			cb(err)
+			l.ioc.Dispatched--
+			return
+		}
+	}
+
+	l.writes = append(l.writes, udpWrite{b: b, to: to, cb: cb})
+	if len(l.writes) == 1 {
+		if err := l.scheduleWrite(); err != nil {
+			l.failWrites(err)
+		}
+	}
+}
+
+func (l *UDPListener) onWritable(err error) {
+	l.deregister()
+	if err != nil {
+		l.failWrites(err)
+		return
+	}
+
+	for len(l.writes) > 0 {
+		w := l.writes[0]
+		err := l.writeTo(w.b, w.to)
+		if err == sonicerrors.ErrWouldBlock {
+			if err := l.scheduleWrite(); err != nil {
+				l.failWrites(err)
+			}
+			return
+		}
+		l.writes[0] = udpWrite{}
+		l.writes = l.writes[1:]
+		w.cb(err)
+	}
+}
+
+func (l *UDPListener) failWrites(err error) {
+	writes := l.writes
+	l.writes = nil
+	for _, w := range writes {
+		w.cb(err)
+	}
+}
+
+// Peers returns the number of open peers, accepted or not.
+func (l *UDPListener) Peers() int {
+	return len(l.peers)
+}
+
+// Close closes the listener and all its peers. Their pending reads and writes
+// fail with sonicerrors.ErrCancelled.
+func (l *UDPListener) Close() error {
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+
+	if l.idleTimer != nil {
+		_ = l.idleTimer.Close()
+	}
+	for _, p := range l.peers {
+		p.closeWith(sonicerrors.ErrCancelled)
+	}
+	l.backlog = nil
+
+	_ = l.ioc.UnsetReadWrite(&l.slot)
+	l.ioc.Deregister(&l.slot)
+	err := syscall.Close(l.slot.Fd)
+
+	l.failWrites(sonicerrors.ErrCancelled)
+	if cb := l.acceptCb; cb != nil {
+		l.acceptCb = nil
+		cb(sonicerrors.ErrCancelled, nil)
+	}
+	return err
+}
+
+// Addr returns the listener's network address.
+func (l *UDPListener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *UDPListener) RawFd() int {
+	return l.slot.Fd
+}
+
+// UDPPeerConn is the PacketConn of a single peer of a UDPListener. It reads
+// only the datagrams of that peer and writes to it by default.
+type UDPPeerConn struct {
+	ln       *UDPListener
+	key      netip.AddrPort
+	addr     *net.UDPAddr
+	sockaddr syscall.Sockaddr
+
+	// Datagrams received but not yet read, and buffers to reuse for them.
+	queue [][]byte
+	free  [][]byte
+
+	readBuf []byte
+	readCb  AsyncReadCallbackPacket
+
+	lastActivity time.Time
+	closed       bool
+
+	dispatchReadFn func()
+}
+
+func newUDPPeerConn(l *UDPListener, key netip.AddrPort, sa syscall.Sockaddr) *UDPPeerConn {
+	p := &UDPPeerConn{
+		ln:       l,
+		key:      key,
+		addr:     net.UDPAddrFromAddrPort(key),
+		sockaddr: sa,
+	}
+	p.dispatchReadFn = p.dispatchRead
+	return p
+}
+
+// deliver hands a received datagram to the pending read or queues it.
+func (p *UDPPeerConn) deliver(b []byte) {
+	p.lastActivity = time.Now()
+
+	if p.readCb != nil && len(p.queue) == 0 {
+		cb := p.readCb
+		p.readCb = nil
+		n := copy(p.readBuf, b)
+		p.readBuf = nil
+		cb(nil, n, p.addr)
+		return
+	}
+
+	if len(p.queue) >= p.ln.queueSize {
+		return
+	}
+	var d []byte
+	if n := len(p.free); n > 0 {
+		d = p.free[n-1][:0]
+		p.free = p.free[:n-1]
+	}
+	p.queue = append(p.queue, append(d, b...))
+}
+
+// ReadFrom reads the next datagram of the peer. It returns
+// sonicerrors.ErrWouldBlock if none is queued. A datagram larger than b is
+// truncated.
+func (p *UDPPeerConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if p.closed {
+		return 0, nil, io.EOF
+	}
+	if len(p.queue) == 0 {
+		return 0, nil, sonicerrors.ErrWouldBlock
+	}
+
+	d := p.queue[0]
+	p.queue[0] = nil
+	p.queue = p.queue[1:]
+	n := copy(b, d)
+	p.free = append(p.free, d)
+	return n, p.addr, nil
+}
+
+// AsyncReadFrom reads the next datagram of the peer. A datagram larger than b
+// is truncated.
+func (p *UDPPeerConn) AsyncReadFrom(b []byte, cb AsyncReadCallbackPacket) {
+	if p.closed {
+		cb(io.EOF, 0, nil)
+		return
+	}
+
+	p.readBuf = b
+	p.readCb = cb
+	if len(p.queue) > 0 {
+		if p.ln.ioc.Dispatched < MaxCallbackDispatch {
+			p.dispatchRead()
+		} else if err := p.ln.ioc.Post(p.dispatchReadFn); err != nil {
+			p.readCb = nil
+			cb(err, 0, nil)
+		}
+	}
+}
+
+// AsyncReadAllFrom is the same as AsyncReadFrom: datagrams are read whole.
+func (p *UDPPeerConn) AsyncReadAllFrom(b []byte, cb AsyncReadCallbackPacket) {
+	p.AsyncReadFrom(b, cb)
+}
+
+func (p *UDPPeerConn) dispatchRead() {
+	cb := p.readCb
+	if cb == nil || len(p.queue) == 0 {
+		return
+	}
+	p.readCb = nil
+
+	n, addr, err := p.ReadFrom(p.readBuf)
+	p.readBuf = nil
+
+	p.ln.ioc.Dispatched++
+	cb(err, n, addr)
+	p.ln.ioc.Dispatched--
+}
+
+// WriteTo writes a datagram to the given address or, if nil, to the peer.
+func (p *UDPPeerConn) WriteTo(b []byte, to net.Addr) error {
+	if p.closed {
+		return io.EOF
+	}
+	return p.ln.writeTo(b, p.sockaddrOf(to))
+}
+
+// AsyncWriteTo writes a datagram to the given address or, if nil, to the peer.
+func (p *UDPPeerConn) AsyncWriteTo(b []byte, to net.Addr, cb AsyncWriteCallbackPacket) {
+	if p.closed {
+		cb(io.EOF)
+		return
+	}
+	p.ln.asyncWriteTo(b, p.sockaddrOf(to), cb)
+}
+
+func (p *UDPPeerConn) sockaddrOf(to net.Addr) syscall.Sockaddr {
+	if to == nil {
+		return p.sockaddr
+	}
+	return internal.ToSockaddr(to)
+}
+
+// Close closes the peer. Its pending read fails with sonicerrors.ErrCancelled.
+// A new UDPPeerConn is accepted if the peer sends again.
+func (p *UDPPeerConn) Close() error {
+	p.closeWith(sonicerrors.ErrCancelled)
+	return nil
+}
+
+func (p *UDPPeerConn) closeWith(err error) {
+	if p.closed {
+		return
+	}
+	p.closed = true
+	p.queue = nil
+	p.free = nil
+
+	l := p.ln
+	delete(l.peers, p.key)
+	for i, q := range l.backlog {
+		if q == p {
+			l.backlog = append(l.backlog[:i], l.backlog[i+1:]...)
+			break
+		}
+	}
+
+	if cb := p.readCb; cb != nil {
+		p.readCb = nil
+		p.readBuf = nil
+		cb(err, 0, nil)
+	}
+}
+
+func (p *UDPPeerConn) Closed() bool {
+	return p.closed
+}
+
+// LocalAddr returns the address of the listener.
+func (p *UDPPeerConn) LocalAddr() net.Addr {
+	return p.ln.addr
+}
+
+// RemoteAddr returns the address of the peer.
+func (p *UDPPeerConn) RemoteAddr() net.Addr {
+	return p.addr
+}
+
+// RawFd returns the file descriptor of the listener's socket, which is shared
+// by all peers.
+func (p *UDPPeerConn) RawFd() int {
+	return p.ln.slot.Fd
+}
@@ -0,0 +1,196 @@
+package sonic
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+func TestUDPListenerDemux(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := ListenUDP(ioc, "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// Each peer echoes back what it reads, prefixed by its index.
+	var peers []*UDPPeerConn
+	var onAccept AcceptPacketCallback
+	onAccept = func(err error, conn PacketConn) {
+		if err != nil {
+			return
+		}
+		p := conn.(*UDPPeerConn)
+		peers = append(peers, p)
+		index := byte('0' + len(peers) - 1)
+
+		b := make([]byte, 128)
+		var onRead AsyncReadCallbackPacket
+		onRead = func(err error, n int, from net.Addr) {
+			if err != nil {
+				return
+			}
+			if from.String() != p.RemoteAddr().String() {
+				t.Errorf("unexpected source=%s peer=%s", from, p.RemoteAddr())
+			}
+			reply := append([]byte{index}, b[:n]...)
+			p.AsyncWriteTo(reply, nil, func(err error) {
+				if err != nil {
+					t.Error(err)
+				}
+			})
+			p.AsyncReadFrom(b, onRead)
+		}
+		p.AsyncReadFrom(b, onRead)
+		ln.AsyncAccept(onAccept)
+	}
+	ln.AsyncAccept(onAccept)
+
+	var clients []*net.UDPConn
+	for i := 0; i < 2; i++ {
+		c, err := net.DialUDP("udp", nil, ln.Addr().(*net.UDPAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		clients = append(clients, c)
+	}
+
+	b := make([]byte, 128)
+	for round := 0; round < 3; round++ {
+		for i, c := range clients {
+			if _, err := c.Write([]byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+
+			done := make(chan string, 1)
+			go func() {
+				_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+				n, err := c.Read(b)
+				if err != nil {
+					done <- err.Error()
+					return
+				}
+				done <- string(b[:n])
+			}()
+			reply := runUntilRecv(t, ioc, done)
+			if expected := string(rune('0'+i)) + "hello"; reply != expected {
+				t.Fatalf("expected=%s got=%s", expected, reply)
+			}
+		}
+	}
+
+	if len(peers) != 2 || ln.Peers() != 2 {
+		t.Fatalf("expected 2 peers got=%d", len(peers))
+	}
+}
+
+func TestUDPListenerQueue(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := ListenUDP(ioc, "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ln.SetPeerQueueSize(2)
+
+	c, err := net.DialUDP("udp", nil, ln.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, msg := range []string{"a", "b", "c"} {
+		if _, err := c.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var peer PacketConn
+	deadline := time.Now().Add(5 * time.Second)
+	for peer == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		_ = ioc.RunOneFor(time.Millisecond)
+		peer, _ = ln.Accept()
+	}
+	// Let the remaining datagrams arrive.
+	for i := 0; i < 10; i++ {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	// The third datagram is dropped as the queue holds two.
+	b := make([]byte, 128)
+	for _, msg := range []string{"a", "b"} {
+		n, _, err := peer.ReadFrom(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b[:n]) != msg {
+			t.Fatalf("expected=%s got=%s", msg, string(b[:n]))
+		}
+	}
+	if _, _, err := peer.ReadFrom(b); err != sonicerrors.ErrWouldBlock {
+		t.Fatalf("expected ErrWouldBlock got=%v", err)
+	}
+}
+
+func TestUDPListenerIdleTimeout(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := ListenUDP(ioc, "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if err := ln.SetIdleTimeout(100 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := net.DialUDP("udp", nil, ln.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	ln.AsyncAccept(func(err error, conn PacketConn) {
+		if err != nil {
+			done <- err
+			return
+		}
+		b := make([]byte, 128)
+		conn.AsyncReadFrom(b, func(err error, n int, _ net.Addr) {
+			if err != nil {
+				done <- err
+				return
+			}
+			// The peer sends nothing else.
+			conn.AsyncReadFrom(b, func(err error, _ int, _ net.Addr) {
+				done <- err
+			})
+		})
+	})
+
+	start := time.Now()
+	if err := runUntilRecv(t, ioc, done); err != sonicerrors.ErrTimeout {
+		t.Fatalf("expected a timeout got=%v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("timed out too early after %s", elapsed)
+	}
+	if ln.Peers() != 0 {
+		t.Fatalf("expected no peers got=%d", ln.Peers())
+	}
+}
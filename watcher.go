@@ -0,0 +1,84 @@
+package sonic
+
+import (
+	"errors"
+	"strings"
+)
+
+// WatchOp is a set of changes to a file, see Watcher.
+type WatchOp uint32
+
+const (
+	// WatchCreate is a file created in a watched directory.
+	WatchCreate WatchOp = 1 << iota
+
+	// WatchWrite is a write to a file.
+	WatchWrite
+
+	// WatchCloseWrite is a file opened for writing being closed. It is the
+	// change to wait for before reloading a file, as the file can be written
+	// with many writes.
+	WatchCloseWrite
+
+	// WatchRemove is a file removed from a watched directory, or a watched file
+	// being removed.
+	WatchRemove
+
+	// WatchRename is a file moved out of or into a watched directory, or a
+	// watched file being moved. The two events of a move within watched
+	// directories have the same cookie.
+	WatchRename
+
+	// WatchChmod is a change of the metadata of a file, like its permissions.
+	WatchChmod
+
+	// WatchAll is all the changes.
+	WatchAll = WatchCreate | WatchWrite | WatchCloseWrite | WatchRemove | WatchRename | WatchChmod
+)
+
+func (op WatchOp) String() string {
+	var names []string
+	for _, o := range []struct {
+		op   WatchOp
+		name string
+	}{
+		{WatchCreate, "create"},
+		{WatchWrite, "write"},
+		{WatchCloseWrite, "close_write"},
+		{WatchRemove, "remove"},
+		{WatchRename, "rename"},
+		{WatchChmod, "chmod"},
+	} {
+		if op&o.op != 0 {
+			names = append(names, o.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// WatchEvent is a change to a file.
+type WatchEvent struct {
+	// Path is the path of the file which changed: a watched path, or a path in
+	// a watched directory.
+	Path string
+
+	// Op is the change.
+	Op WatchOp
+
+	// IsDir is true if the file is a directory.
+	IsDir bool
+
+	// Cookie relates the two events of a rename.
+	Cookie uint32
+}
+
+// ErrWatchOverflow is reported by Watcher.AsyncNextEvent when events were
+// dropped because they were not read fast enough. The watched files must then
+// be rescanned. The Watcher can be used afterwards.
+var ErrWatchOverflow = errors.New("watch events overflowed")
+
+// AsyncWatchCallback is invoked with the next event of a Watcher.
+type AsyncWatchCallback func(err error, ev WatchEvent)
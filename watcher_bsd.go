@@ -0,0 +1,35 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package sonic
+
+import "fmt"
+
+var errWatcherNotSupported = fmt.Errorf("file watching is not yet supported on BSD")
+
+// Watcher notifies the changes to files. It is not yet supported on BSD.
+type Watcher struct{}
+
+// NewWatcher fails on BSD.
+func NewWatcher(ioc *IO) (*Watcher, error) {
+	return nil, errWatcherNotSupported
+}
+
+func (w *Watcher) Add(path string, ops WatchOp) error {
+	return errWatcherNotSupported
+}
+
+func (w *Watcher) AddRecursive(path string, ops WatchOp) error {
+	return errWatcherNotSupported
+}
+
+func (w *Watcher) Remove(path string) error {
+	return errWatcherNotSupported
+}
+
+func (w *Watcher) AsyncNextEvent(cb AsyncWatchCallback) {
+	cb(errWatcherNotSupported, WatchEvent{})
+}
+
+func (w *Watcher) Close() error {
+	return errWatcherNotSupported
+}
@@ -0,0 +1,283 @@
+//go:build linux
+
+package sonic
+
+import (
+	"encoding/binary"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Watcher notifies the changes to files, using inotify.
+//
+// The inotify descriptor is read by the IO, so the events are delivered on the
+// same goroutine as the rest of the IO's handlers. Events are delivered one at
+// a time, by AsyncNextEvent.
+//
+// A Watcher is not safe for concurrent use: it must be used from the goroutine
+// running its IO.
+type Watcher struct {
+	ioc *IO
+	f   *file
+
+	buf      []byte
+	pos, end int // the events read but not yet delivered are buf[pos:end]
+
+	watches map[int]*watch // by watch descriptor
+	paths   map[string]int // watch descriptors by path
+
+	cb       AsyncWatchCallback
+	onReadFn AsyncCallback
+}
+
+type watch struct {
+	path      string
+	ops       WatchOp
+	recursive bool
+}
+
+// watcherBufferSize fits many events with long names, and at least one event
+// with a name of NAME_MAX bytes.
+const watcherBufferSize = 64 * 1024
+
+// NewWatcher creates a Watcher.
+func NewWatcher(ioc *IO) (*Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	w := &Watcher{
+		ioc:     ioc,
+		f:       newFile(ioc, fd),
+		buf:     make([]byte, watcherBufferSize),
+		watches: make(map[int]*watch),
+		paths:   make(map[string]int),
+	}
+	w.onReadFn = w.onRead
+	return w, nil
+}
+
+// Add watches the given changes to the file or directory at path. If path is a
+// directory, the changes to the files in it are watched too, but not those to
+// the files of its subdirectories. Adding a path again replaces the changes
+// watched.
+func (w *Watcher) Add(path string, ops WatchOp) error {
+	return w.add(filepath.Clean(path), ops, false)
+}
+
+// AddRecursive watches the given changes to the directory at path and to all
+// the files under it. The directories created under it afterwards are watched
+// as they are created; files created in them before they are watched are not
+// reported.
+func (w *Watcher) AddRecursive(path string, ops WatchOp) error {
+	return filepath.WalkDir(filepath.Clean(path), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		return w.add(p, ops, true)
+	})
+}
+
+func (w *Watcher) add(path string, ops WatchOp, recursive bool) error {
+	mask := watchMask(ops)
+	if recursive {
+		// To watch the new subdirectories.
+		mask |= unix.IN_CREATE | unix.IN_MOVED_TO
+	}
+
+	wd, err := unix.InotifyAddWatch(w.f.RawFd(), path, mask)
+	if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+	}
+
+	// The same file can be added through different paths: inotify returns
+	// its existing watch descriptor, so the previous path is forgotten.
+	if prev, ok := w.watches[wd]; ok && prev.path != path {
+		delete(w.paths, prev.path)
+	}
+	w.watches[wd] = &watch{path: path, ops: ops, recursive: recursive}
+	w.paths[path] = wd
+	return nil
+}
+
+// Remove stops watching the file or directory at path. If it was added with
+// AddRecursive, the files under it are not watched anymore either.
+func (w *Watcher) Remove(path string) error {
+	path = filepath.Clean(path)
+	wd, ok := w.paths[path]
+	if !ok {
+		return &os.PathError{Op: "inotify_rm_watch", Path: path, Err: unix.EINVAL}
+	}
+
+	if w.watches[wd].recursive {
+		prefix := path + string(filepath.Separator)
+		for p, sub := range w.paths {
+			if strings.HasPrefix(p, prefix) && w.watches[sub].recursive {
+				w.remove(sub)
+			}
+		}
+	}
+	return w.remove(wd)
+}
+
+func (w *Watcher) remove(wd int) error {
+	wt := w.watches[wd]
+	delete(w.watches, wd)
+	delete(w.paths, wt.path)
+
+	if _, err := unix.InotifyRmWatch(w.f.RawFd(), uint32(wd)); err != nil {
+		// The watch is already gone if the file was removed.
+		if err == unix.EINVAL {
+			return nil
+		}
+		return &os.PathError{Op: "inotify_rm_watch", Path: wt.path, Err: err}
+	}
+	return nil
+}
+
+// AsyncNextEvent invokes the callback with the next event. The error is
+// ErrWatchOverflow if events were dropped, and the read error otherwise.
+func (w *Watcher) AsyncNextEvent(cb AsyncWatchCallback) {
+	if ev, ok, err := w.next(); ok || err != nil {
+		w.ioc.Dispatched++
+		cb(err, ev)
+		w.ioc.Dispatched--
+		return
+	}
+
+	w.cb = cb
+	w.f.AsyncRead(w.buf, w.onReadFn)
+}
+
+func (w *Watcher) onRead(err error, n int) {
+	cb := w.cb
+	if err != nil {
+		w.cb = nil
+		cb(err, WatchEvent{})
+		return
+	}
+
+	w.pos, w.end = 0, n
+	ev, ok, err := w.next()
+	if !ok && err == nil {
+		// All the events were internal, like those of removed watches.
+		w.f.AsyncRead(w.buf, w.onReadFn)
+		return
+	}
+	w.cb = nil
+	cb(err, ev)
+}
+
+// next parses the read events until one is to be delivered.
+func (w *Watcher) next() (ev WatchEvent, ok bool, err error) {
+	for w.end-w.pos >= unix.SizeofInotifyEvent {
+		b := w.buf[w.pos:w.end]
+		wd := int(int32(binary.NativeEndian.Uint32(b[0:])))
+		mask := binary.NativeEndian.Uint32(b[4:])
+		cookie := binary.NativeEndian.Uint32(b[8:])
+		nameLen := int(binary.NativeEndian.Uint32(b[12:]))
+
+		size := unix.SizeofInotifyEvent + nameLen
+		if size > len(b) {
+			// A read returns whole events, so this does not happen.
+			w.pos = w.end
+			break
+		}
+		name := strings.TrimRight(string(b[unix.SizeofInotifyEvent:size]), "\x00")
+		w.pos += size
+
+		if mask&unix.IN_Q_OVERFLOW != 0 {
+			return WatchEvent{}, false, ErrWatchOverflow
+		}
+
+		wt, known := w.watches[wd]
+		if !known {
+			// An event queued before its watch was removed.
+			continue
+		}
+		if mask&unix.IN_IGNORED != 0 {
+			// The watch was removed, explicitly or with its file.
+			delete(w.watches, wd)
+			delete(w.paths, wt.path)
+			continue
+		}
+
+		path := wt.path
+		if name != "" {
+			path = filepath.Join(wt.path, name)
+		}
+		isDir := mask&unix.IN_ISDIR != 0
+
+		if wt.recursive && isDir && mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+			// The directory may be gone already, in which case there is
+			// nothing to watch.
+			_ = w.AddRecursive(path, wt.ops)
+		}
+
+		op := watchOp(mask) & wt.ops
+		if op == 0 {
+			continue
+		}
+		return WatchEvent{Path: path, Op: op, IsDir: isDir, Cookie: cookie}, true, nil
+	}
+	return WatchEvent{}, false, nil
+}
+
+func watchMask(ops WatchOp) (mask uint32) {
+	if ops&WatchCreate != 0 {
+		mask |= unix.IN_CREATE
+	}
+	if ops&WatchWrite != 0 {
+		mask |= unix.IN_MODIFY
+	}
+	if ops&WatchCloseWrite != 0 {
+		mask |= unix.IN_CLOSE_WRITE
+	}
+	if ops&WatchRemove != 0 {
+		mask |= unix.IN_DELETE | unix.IN_DELETE_SELF
+	}
+	if ops&WatchRename != 0 {
+		mask |= unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_MOVE_SELF
+	}
+	if ops&WatchChmod != 0 {
+		mask |= unix.IN_ATTRIB
+	}
+	return mask
+}
+
+func watchOp(mask uint32) (op WatchOp) {
+	if mask&unix.IN_CREATE != 0 {
+		op |= WatchCreate
+	}
+	if mask&unix.IN_MODIFY != 0 {
+		op |= WatchWrite
+	}
+	if mask&unix.IN_CLOSE_WRITE != 0 {
+		op |= WatchCloseWrite
+	}
+	if mask&(unix.IN_DELETE|unix.IN_DELETE_SELF) != 0 {
+		op |= WatchRemove
+	}
+	if mask&(unix.IN_MOVED_FROM|unix.IN_MOVED_TO|unix.IN_MOVE_SELF) != 0 {
+		op |= WatchRename
+	}
+	if mask&unix.IN_ATTRIB != 0 {
+		op |= WatchChmod
+	}
+	return op
+}
+
+// Close closes the Watcher. A pending AsyncNextEvent completes with
+// sonicerrors.ErrCancelled.
+func (w *Watcher) Close() error {
+	w.f.Cancel()
+	return w.f.Close()
+}
@@ -0,0 +1,163 @@
+package sonic
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/talostrading/sonic/sonicerrors"
+	"golang.org/x/sys/unix"
+)
+
+// nextWatchEvent runs the IO until the watcher reports an event.
+func nextWatchEvent(t *testing.T, ioc *IO, w *Watcher) WatchEvent {
+	type result struct {
+		err error
+		ev  WatchEvent
+	}
+	ch := make(chan result, 1)
+	w.AsyncNextEvent(func(err error, ev WatchEvent) {
+		ch <- result{err, ev}
+	})
+	r := runUntilRecv(t, ioc, ch)
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	return r.ev
+}
+
+func TestWatcher(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	w, err := NewWatcher(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	dir := t.TempDir()
+	if err := w.Add(dir, WatchCreate|WatchCloseWrite|WatchRemove); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "config")
+	if err := os.WriteFile(path, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes are not watched.
+	for _, op := range []WatchOp{WatchCreate, WatchCloseWrite, WatchRemove} {
+		ev := nextWatchEvent(t, ioc, w)
+		if ev.Path != path || ev.Op != op || ev.IsDir {
+			t.Fatalf("expected %s of %s got=%+v", op, path, ev)
+		}
+	}
+}
+
+func TestWatcherRecursive(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	w, err := NewWatcher(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "a"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddRecursive(dir, WatchCreate); err != nil {
+		t.Fatal(err)
+	}
+
+	// An existing subdirectory is watched.
+	path := filepath.Join(dir, "a", "file")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextWatchEvent(t, ioc, w); ev.Path != path || ev.Op != WatchCreate {
+		t.Fatalf("expected the creation of %s got=%+v", path, ev)
+	}
+
+	// A new subdirectory is watched once its creation is read.
+	sub := filepath.Join(dir, "b")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextWatchEvent(t, ioc, w); ev.Path != sub || !ev.IsDir {
+		t.Fatalf("expected the creation of %s got=%+v", sub, ev)
+	}
+	path = filepath.Join(sub, "file")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextWatchEvent(t, ioc, w); ev.Path != path || ev.Op != WatchCreate {
+		t.Fatalf("expected the creation of %s got=%+v", path, ev)
+	}
+
+	// Removing the root stops watching the subdirectories.
+	if err := w.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.watches) != 0 || len(w.paths) != 0 {
+		t.Fatalf("expected no watches got=%v", w.paths)
+	}
+}
+
+func TestWatcherOverflow(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	w, err := NewWatcher(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// Simulate a read overflow event, which inotify queues with wd -1.
+	b := w.buf[:unix.SizeofInotifyEvent]
+	binary.NativeEndian.PutUint32(b[0:], ^uint32(0))
+	binary.NativeEndian.PutUint32(b[4:], unix.IN_Q_OVERFLOW)
+	binary.NativeEndian.PutUint32(b[8:], 0)
+	binary.NativeEndian.PutUint32(b[12:], 0)
+	w.pos, w.end = 0, len(b)
+
+	var got error
+	w.AsyncNextEvent(func(err error, _ WatchEvent) {
+		got = err
+	})
+	if got != ErrWatchOverflow {
+		t.Fatalf("expected ErrWatchOverflow got=%v", got)
+	}
+}
+
+func TestWatcherClose(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	w, err := NewWatcher(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add(t.TempDir(), WatchAll); err != nil {
+		t.Fatal(err)
+	}
+
+	var got error
+	w.AsyncNextEvent(func(err error, _ WatchEvent) {
+		got = err
+	})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got != sonicerrors.ErrCancelled {
+		t.Fatalf("expected ErrCancelled got=%v", got)
+	}
+}